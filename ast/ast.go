@@ -0,0 +1,131 @@
+// Package ast is the syntax tree of a Makefile, as written rather than as
+// evaluated. Every node records where it is in the source, and comments and
+// blank lines are kept, so formatters, linters and editor tooling can be
+// built on it.
+package ast
+
+import "fmt"
+
+// Pos is a position in a Makefile. Lines and columns start at 1, columns
+// count bytes.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Range is the part of the source a node covers. To is the position just
+// after the node.
+type Range struct {
+	From, To Pos
+}
+
+// Pos returns the position of the first byte of the node
+func (r Range) Pos() Pos { return r.From }
+
+// End returns the position just after the node
+func (r Range) End() Pos { return r.To }
+
+// Node is implemented by every node of the tree
+type Node interface {
+	Pos() Pos
+	End() Pos
+}
+
+// File is a parsed Makefile. Nodes are in source order.
+type File struct {
+	Name  string
+	Nodes []Node
+}
+
+// Word is a piece of text, such as a target or a variable value, with its
+// position
+type Word struct {
+	Range
+	Text string
+}
+
+// Comment is a comment on a line of its own or at the end of a line. Text
+// includes the leading '#'.
+type Comment struct {
+	Range
+	Text string
+}
+
+// BlankLine is an empty or whitespace only line
+type BlankLine struct {
+	Range
+}
+
+// Assignment is a variable definition such as "CC := gcc"
+type Assignment struct {
+	Range
+	Prefix  string // "export" or "override", if given
+	Name    Word
+	Op      string // "=", ":=", "::=", "?=", "+=" or "!="
+	Value   Word
+	Comment *Comment // trailing comment, nil if there is none
+	// Source is the assignment as written if it is continued on more lines
+	// with '\', which it is printed as. The other fields hold the lines
+	// joined into one, like make joins them.
+	Source string
+}
+
+// Rule is a rule header with the recipe that follows it
+type Rule struct {
+	Range
+	Targets []Word
+	Colon   string // ":" or "::"
+	Prereqs []Word
+	Comment *Comment // trailing comment, a "## text" one documents the rule
+	// Source is the rule header as written if it is continued on more
+	// lines with '\', see Assignment.Source
+	Source string
+	// Body holds the recipe lines, and the blank lines and comments
+	// between them
+	Body []Node
+}
+
+// Recipe returns the recipe lines of the rule
+func (r *Rule) Recipe() []*RecipeLine {
+	var lines []*RecipeLine
+	for _, n := range r.Body {
+		if line, ok := n.(*RecipeLine); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// RecipeLine is a tab indented line, or one starting with the character
+// .RECIPEPREFIX is set to. Lines outside of a rule appear at the top level
+// of the file. A line continued with '\' holds the lines that continue it,
+// as written, since the shell gets them that way.
+type RecipeLine struct {
+	Range
+	Prefix string // the recipe prefix, empty for a tab
+	Text   string // without the prefix
+}
+
+// Directive is a line starting with a make directive, e.g. "include a.mk"
+// or "ifdef DEBUG"
+type Directive struct {
+	Range
+	Name    string
+	Args    Word
+	Comment *Comment
+	// Source is the directive as written if it is continued on more lines
+	// with '\', or for a define the lines up to its endef, see
+	// Assignment.Source. Args only hold the first line of a define.
+	Source string
+}
+
+// BadLine is a line that is neither of the above
+type BadLine struct {
+	Range
+	Text string
+}
@@ -0,0 +1,129 @@
+package ast
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Format rewrites the tree into its canonical form, in place:
+//
+//   - space indented lines after a rule become recipe lines, indented with
+//     a tab or the .RECIPEPREFIX in effect
+//   - the targets listed in .PHONY are sorted and listed once
+//   - trailing white space is removed from recipe lines and comments
+//   - runs of blank lines become a single one, and the file neither starts
+//     nor ends with one
+//
+// Lines continued with '\' and define bodies are left as they are written,
+// as changing their layout could change what they mean.
+//
+// Printing the tree with Config.AlignComments set completes the layout.
+func Format(f *File) {
+	var nodes []Node
+	var rule *Rule
+	// between are the blank lines and comments since the end of rule
+	var between []Node
+	prefix := ""
+
+	for _, n := range f.Nodes {
+		switch n := n.(type) {
+		case *BlankLine, *Comment:
+			if rule != nil {
+				between = append(between, n)
+				continue
+			}
+		case *BadLine:
+			if rule != nil && strings.TrimLeftFunc(n.Text, unicode.IsSpace) != n.Text && !strings.Contains(n.Text, "\n") {
+				rule.Body = append(rule.Body, between...)
+				rule.Body = append(rule.Body, &RecipeLine{Range: n.Range, Prefix: prefix, Text: strings.TrimSpace(n.Text)})
+				between = nil
+				continue
+			}
+		}
+
+		nodes = append(nodes, between...)
+		between, rule = nil, nil
+		switch n := n.(type) {
+		case *Rule:
+			rule = n
+			trimComment(n.Comment)
+			if len(n.Targets) == 1 && n.Targets[0].Text == ".PHONY" && n.Source == "" {
+				n.Prereqs = sortedUnique(n.Prereqs)
+			}
+		case *Directive:
+			trimComment(n.Comment)
+		case *Assignment:
+			trimComment(n.Comment)
+			if n.Name.Text == ".RECIPEPREFIX" {
+				prefix = n.Value.Text
+				if prefix != "" {
+					prefix = prefix[:1]
+				}
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	nodes = append(nodes, between...)
+
+	for _, n := range nodes {
+		if r, ok := n.(*Rule); ok {
+			r.Body = formatBody(r.Body)
+		}
+	}
+	f.Nodes = trimBlankLines(formatBody(nodes))
+}
+
+// formatBody trims trailing white space and collapses blank lines
+func formatBody(nodes []Node) []Node {
+	var out []Node
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *BlankLine:
+			if len(out) > 0 {
+				if _, blank := out[len(out)-1].(*BlankLine); blank {
+					continue
+				}
+			}
+		case *RecipeLine:
+			n.Text = strings.TrimRightFunc(n.Text, unicode.IsSpace)
+		case *Comment:
+			trimComment(n)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func trimComment(c *Comment) {
+	if c != nil {
+		c.Text = strings.TrimRightFunc(c.Text, unicode.IsSpace)
+	}
+}
+
+func trimBlankLines(nodes []Node) []Node {
+	for len(nodes) > 0 {
+		if _, blank := nodes[0].(*BlankLine); !blank {
+			break
+		}
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 {
+		if _, blank := nodes[len(nodes)-1].(*BlankLine); !blank {
+			break
+		}
+		nodes = nodes[:len(nodes)-1]
+	}
+	return nodes
+}
+
+func sortedUnique(words []Word) []Word {
+	sort.SliceStable(words, func(i, j int) bool { return words[i].Text < words[j].Text })
+	var out []Word
+	for _, w := range words {
+		if len(out) == 0 || out[len(out)-1].Text != w.Text {
+			out = append(out, w)
+		}
+	}
+	return out
+}
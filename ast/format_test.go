@@ -0,0 +1,50 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func format(t *testing.T, src string) string {
+	t.Helper()
+	f, err := Parse(strings.NewReader(src), "Makefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Format(f)
+	var b strings.Builder
+	if err := (&Config{AlignComments: true}).Fprint(&b, f); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestFormatKeepsWrittenLayout(t *testing.T) {
+	tests := map[string]string{
+		"continued prerequisites": "all: a \\\n   b\n\techo $^\n",
+		"continued assignment":    "SRCS = a.c \\\n\tb.c \\\n    c.c\n",
+		"continued recipe line":   "all:\n\techo a \\\n\t  b\n",
+		"continued .PHONY":        ".PHONY: test \\\n  build\n",
+		"define":                  "define BODY\n  indented\n\ttabbed\nendef\n\nall:\n\techo hi\n",
+		"nested define":           "define OUTER\ndefine INNER\n  x\nendef\n  y\nendef\n",
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			once := format(t, src)
+			if once != src {
+				t.Errorf("formatted\n%s\nas\n%s", src, once)
+			}
+			if twice := format(t, once); twice != once {
+				t.Errorf("formatting again changed\n%s\nto\n%s", once, twice)
+			}
+		})
+	}
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	src := "\n\nCC:=gcc\n.PHONY: test build test\nall: a \\\n   b   ## Build it   \n    echo $(CC)  \n\n\n\ntest:  ## Test\n\tgo test\n"
+	once := format(t, src)
+	if twice := format(t, once); twice != once {
+		t.Errorf("formatting again changed\n%s\nto\n%s", once, twice)
+	}
+}
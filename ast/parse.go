@@ -0,0 +1,346 @@
+package ast
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// directives are the make keywords a line can start with. export and
+// override followed by an assignment are parsed as an Assignment. import and
+// datafile are smmake's own, see smmake.Importers.
+var directives = map[string]bool{
+	"include": true, "-include": true, "sinclude": true,
+	"ifeq": true, "ifneq": true, "ifdef": true, "ifndef": true, "else": true, "endif": true,
+	"define": true, "endef": true, "undefine": true,
+	"export": true, "unexport": true, "override": true, "vpath": true,
+	"import": true, "datafile": true,
+}
+
+// DefaultMaxLineLength is the longest line Parse accepts, in bytes. Lines of
+// generated Makefiles that list thousands of prerequisites fit easily.
+const DefaultMaxLineLength = 16 << 20
+
+// Parse reads the syntax tree of a Makefile from r. Positions refer to
+// filename.
+func Parse(r io.Reader, filename string) (*File, error) {
+	return ParseMaxLineLength(r, filename, 0)
+}
+
+// ParseMaxLineLength is Parse with lines of up to maxLineLength bytes,
+// DefaultMaxLineLength if it is 0. A longer line fails the parse with its
+// line number.
+func ParseMaxLineLength(r io.Reader, filename string, maxLineLength int) (*File, error) {
+	if maxLineLength <= 0 {
+		maxLineLength = DefaultMaxLineLength
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filename, err)
+	}
+	if data, err = Source(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, min(64<<10, maxLineLength+1)), maxLineLength+1)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return nil, fmt.Errorf("%s:%d: line is longer than the limit of %d bytes", filename, len(lines)+1, maxLineLength)
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	p := &parser{file: &File{Name: filename}}
+	for i := 0; i < len(lines); i++ {
+		p.line = i + 1
+		last := i
+		if isDefine(lines[i]) {
+			last = endOfDefine(lines, i)
+		} else {
+			for last+1 < len(lines) && continues(lines[last]) {
+				last++
+			}
+		}
+		if last == i {
+			p.parseLine(lines[i], "", p.pos(len(lines[i])))
+			continue
+		}
+		end := Pos{Filename: filename, Line: last + 1, Column: len(lines[last]) + 1}
+		text := lines[i]
+		if !isDefine(text) {
+			text = joinContinued(lines[i : last+1])
+		}
+		p.parseLine(text, strings.Join(lines[i:last+1], "\n"), end)
+		i = last
+	}
+	p.endRule()
+	return p.file, nil
+}
+
+// continues reports whether a line ends in a backslash that continues it
+// on the next line, rather than in an escaped one
+func continues(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// joinContinued joins the lines of a continued line like make: the
+// backslash, the newline and the blanks around them become a single space
+func joinContinued(lines []string) string {
+	parts := make([]string, len(lines))
+	for i, line := range lines {
+		if i < len(lines)-1 {
+			line = strings.TrimRightFunc(strings.TrimSuffix(line, `\`), unicode.IsSpace)
+		}
+		if i > 0 {
+			line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		}
+		parts[i] = line
+	}
+	return strings.Join(parts, " ")
+}
+
+// isDefine reports whether a line starts a define, possibly after export
+// or override
+func isDefine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) > 1 && (fields[0] == "export" || fields[0] == "override") {
+		fields = fields[1:]
+	}
+	return len(fields) > 0 && fields[0] == "define"
+}
+
+// endOfDefine returns the index of the endef line of the define that
+// starts on lines[start], counting nested ones, or of the last line if
+// there is none
+func endOfDefine(lines []string, start int) int {
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		switch {
+		case isDefine(lines[i]):
+			depth++
+		case firstField(lines[i]) == "endef":
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(lines) - 1
+}
+
+func firstField(line string) string {
+	if fields := strings.Fields(line); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+type parser struct {
+	file *File
+	line int
+	// rule is the rule whose recipe is being read
+	rule *Rule
+	// pending are the blank lines and comments after the last recipe line
+	// of rule, they go into its body if another recipe line follows
+	pending []Node
+	// prefix starts a recipe line, empty for a tab
+	prefix string
+}
+
+func (p *parser) pos(column int) Pos {
+	return Pos{Filename: p.file.Name, Line: p.line, Column: column + 1}
+}
+
+// span returns the range of line[from:to]
+func (p *parser) span(from, to int) Range {
+	return Range{From: p.pos(from), To: p.pos(to)}
+}
+
+// word returns the trimmed text of line[from:to] with its range
+func (p *parser) word(line string, from, to int) Word {
+	text := line[from:to]
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	from += len(text) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	return Word{Range: p.span(from, from+len(trimmed)), Text: trimmed}
+}
+
+// words splits line[from:to] at white space
+func (p *parser) words(line string, from, to int) []Word {
+	var words []Word
+	start := -1
+	for i := from; i <= to; i++ {
+		// a blank escaped as "\ " is part of the word
+		space := i == to || (line[i] == ' ' || line[i] == '\t') && (i == from || line[i-1] != '\\')
+		switch {
+		case space && start >= 0:
+			words = append(words, Word{Range: p.span(start, i), Text: line[start:i]})
+			start = -1
+		case !space && start < 0:
+			start = i
+		}
+	}
+	return words
+}
+
+func (p *parser) add(n Node) {
+	p.endRule()
+	p.file.Nodes = append(p.file.Nodes, n)
+}
+
+// endRule ends the recipe of the current rule
+func (p *parser) endRule() {
+	p.file.Nodes = append(p.file.Nodes, p.pending...)
+	p.pending = nil
+	p.rule = nil
+}
+
+// parseLine parses a line that ends at end. A line continued with '\',
+// or a define up to its endef, is given joined into one and as written in
+// source, which such nodes keep to print. For a recipe line the source is
+// the text, as the shell gets the continued lines as they are written.
+func (p *parser) parseLine(line, source string, end Pos) {
+	whole := Range{From: p.pos(0), To: end}
+
+	if strings.TrimSpace(line) == "" {
+		p.addBetweenRecipeLines(&BlankLine{Range: whole})
+		return
+	}
+	if prefix := p.recipePrefix(); strings.HasPrefix(line, prefix) {
+		if source != "" {
+			line = source
+		}
+		recipe := &RecipeLine{Range: whole, Prefix: p.prefix, Text: line[len(prefix):]}
+		if p.rule == nil {
+			p.add(recipe)
+			return
+		}
+		p.rule.Body = append(p.rule.Body, p.pending...)
+		p.rule.Body = append(p.rule.Body, recipe)
+		p.rule.To = whole.To
+		p.pending = nil
+		return
+	}
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		if source != "" {
+			line = source
+		}
+		idx := strings.Index(line, "#")
+		p.addBetweenRecipeLines(&Comment{Range: Range{From: p.pos(idx), To: end}, Text: line[idx:]})
+		return
+	}
+
+	// Anything else may end in a comment
+	stop := len(line)
+	var comment *Comment
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		comment = &Comment{Range: Range{From: p.pos(idx), To: end}, Text: line[idx:]}
+		stop = idx
+	}
+
+	fields := strings.Fields(line[:stop])
+	if len(fields) > 0 && directives[fields[0]] {
+		after := strings.Index(line, fields[0]) + len(fields[0])
+		// export and override may precede an assignment
+		if fields[0] == "export" || fields[0] == "override" {
+			if a := p.assignment(line, after, stop); a != nil {
+				a.Range, a.Prefix, a.Comment, a.Source = whole, fields[0], comment, source
+				p.add(a)
+				return
+			}
+		}
+		// Unless the keyword is itself the target or variable name
+		if rest := strings.TrimSpace(line[after:stop]); !strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, "=") {
+			p.add(&Directive{Range: whole, Name: fields[0], Args: p.word(line, after, stop), Comment: comment, Source: source})
+			return
+		}
+	}
+
+	if a := p.assignment(line, 0, stop); a != nil {
+		a.Range, a.Comment, a.Source = whole, comment, source
+		if a.Name.Text == ".RECIPEPREFIX" {
+			p.prefix = a.Value.Text
+			if p.prefix != "" {
+				p.prefix = p.prefix[:1]
+			}
+		}
+		p.add(a)
+		return
+	}
+
+	if colon := strings.Index(line[:stop], ":"); colon >= 0 {
+		rule := &Rule{Range: whole, Colon: ":", Comment: comment, Source: source}
+		rule.Targets = p.words(line, 0, colon)
+		rest := colon + 1
+		if strings.HasPrefix(line[rest:stop], ":") {
+			rule.Colon = "::"
+			rest++
+		}
+		rule.Prereqs = p.words(line, rest, stop)
+		p.add(rule)
+		p.rule = rule
+		return
+	}
+
+	if source != "" {
+		line = source
+	}
+	p.add(&BadLine{Range: whole, Text: line})
+}
+
+func (p *parser) recipePrefix() string {
+	if p.prefix == "" {
+		return "\t"
+	}
+	return p.prefix
+}
+
+// addBetweenRecipeLines adds a blank line or comment, which belongs to the
+// current rule if more recipe lines follow
+func (p *parser) addBetweenRecipeLines(n Node) {
+	if p.rule != nil {
+		p.pending = append(p.pending, n)
+		return
+	}
+	p.add(n)
+}
+
+// assignment parses line[from:to] as a variable assignment, or returns nil
+// if it is not one. An '=' before the first ':' makes an assignment, as do
+// the ":=" and "::=" operators.
+func (p *parser) assignment(line string, from, to int) *Assignment {
+	text := line[from:to]
+	eq := strings.Index(text, "=")
+	if eq < 0 {
+		return nil
+	}
+	opStart := eq
+	if colon := strings.Index(text, ":"); colon >= 0 && colon < eq {
+		switch {
+		case strings.HasSuffix(text[:eq], "::") && colon == eq-2:
+			opStart = eq - 2
+		case colon == eq-1:
+			opStart = eq - 1
+		default:
+			return nil // a rule with an '=' in its prerequisites
+		}
+	} else if eq > 0 && strings.ContainsRune("?+!", rune(text[eq-1])) {
+		opStart = eq - 1
+	}
+	name := p.word(line, from, from+opStart)
+	if name.Text == "" {
+		return nil
+	}
+	return &Assignment{
+		Name:  name,
+		Op:    text[opStart : eq+1],
+		Value: p.word(line, from+eq+1, to),
+	}
+}
@@ -0,0 +1,198 @@
+package ast
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Config controls how a tree is printed
+type Config struct {
+	// AlignComments lines up the trailing comments of the rule headers in
+	// each "##@ Section", so their "## text" descriptions form a column
+	AlignComments bool
+}
+
+// Fprint writes the tree as Makefile text. Every node is written from its
+// fields, so trees built or changed in code print the same way as parsed
+// ones: single spaces around assignment operators and after the rule
+// colon, recipe lines indented with a tab, comments and blank lines where
+// they are in the tree. Positions are ignored. Nodes with a Source, which
+// span several lines in the source, are written as they were.
+func Fprint(w io.Writer, f *File) error {
+	return (&Config{}).Fprint(w, f)
+}
+
+// Fprint writes the tree as Makefile text, laid out as configured
+func (c *Config) Fprint(w io.Writer, f *File) error {
+	p := &printer{w: bufio.NewWriter(w), columns: make(map[*Rule]int)}
+	if c.AlignComments {
+		p.alignComments(f)
+	}
+	for _, n := range f.Nodes {
+		p.node(n)
+	}
+	return p.w.Flush()
+}
+
+// String returns the tree as Makefile text
+func (f *File) String() string {
+	var b strings.Builder
+	Fprint(&b, f)
+	return b.String()
+}
+
+type printer struct {
+	w *bufio.Writer
+	// columns is the width rule headers are padded to before their comment
+	columns map[*Rule]int
+}
+
+// alignComments pads the headers of the commented rules in each section to
+// the longest of them
+func (p *printer) alignComments(f *File) {
+	var group []*Rule
+	flush := func() {
+		width := 0
+		for _, r := range group {
+			width = max(width, len(ruleHeader(r)))
+		}
+		for _, r := range group {
+			p.columns[r] = width
+		}
+		group = nil
+	}
+	for _, n := range f.Nodes {
+		switch n := n.(type) {
+		case *Comment:
+			if strings.HasPrefix(n.Text, "##@") {
+				flush()
+			}
+		case *Rule:
+			if n.Comment != nil {
+				group = append(group, n)
+			}
+		}
+	}
+	flush()
+}
+
+func (p *printer) node(n Node) {
+	switch n := n.(type) {
+	case *BlankLine:
+		p.w.WriteString("\n")
+	case *Comment:
+		p.w.WriteString(n.Text + "\n")
+	case *Assignment:
+		if n.Source != "" {
+			p.w.WriteString(n.Source + "\n")
+			return
+		}
+		line := n.Name.Text + " " + n.Op
+		if n.Prefix != "" {
+			line = n.Prefix + " " + line
+		}
+		if n.Value.Text != "" {
+			line += " " + n.Value.Text
+		}
+		p.line(line, 0, n.Comment)
+	case *Rule:
+		if n.Source != "" {
+			p.w.WriteString(n.Source + "\n")
+		} else {
+			p.line(ruleHeader(n), p.columns[n], n.Comment)
+		}
+		for _, b := range n.Body {
+			p.node(b)
+		}
+	case *RecipeLine:
+		prefix := n.Prefix
+		if prefix == "" {
+			prefix = "\t"
+		}
+		p.w.WriteString(prefix + n.Text + "\n")
+	case *Directive:
+		if n.Source != "" {
+			p.w.WriteString(n.Source + "\n")
+			return
+		}
+		line := n.Name
+		if n.Args.Text != "" {
+			line += " " + n.Args.Text
+		}
+		p.line(line, 0, n.Comment)
+	case *BadLine:
+		p.w.WriteString(n.Text + "\n")
+	}
+}
+
+// line writes a line with its trailing comment, padding the line to width
+func (p *printer) line(line string, width int, comment *Comment) {
+	if comment != nil {
+		line += strings.Repeat(" ", max(width-len(line), 0)) + " " + comment.Text
+	}
+	p.w.WriteString(line + "\n")
+}
+
+func ruleHeader(r *Rule) string {
+	line := joinWords(r.Targets) + colon(r.Colon)
+	if len(r.Prereqs) > 0 {
+		line += " " + joinWords(r.Prereqs)
+	}
+	return line
+}
+
+func colon(c string) string {
+	if c == "" {
+		return ":"
+	}
+	return c
+}
+
+func joinWords(words []Word) string {
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+// Words turns strings into words without positions, for building trees in
+// code
+func Words(texts ...string) []Word {
+	words := make([]Word, len(texts))
+	for i, t := range texts {
+		words[i] = Word{Text: t}
+	}
+	return words
+}
+
+// AddRule appends a rule to the end of the file, separated from what comes
+// before it by a blank line, and returns it
+func (f *File) AddRule(targets, prereqs []string, recipe ...string) *Rule {
+	rule := &Rule{Targets: Words(targets...), Colon: ":", Prereqs: Words(prereqs...)}
+	for _, line := range recipe {
+		rule.Body = append(rule.Body, &RecipeLine{Text: line})
+	}
+	if len(f.Nodes) > 0 {
+		if _, blank := f.Nodes[len(f.Nodes)-1].(*BlankLine); !blank {
+			f.Nodes = append(f.Nodes, &BlankLine{})
+		}
+	}
+	f.Nodes = append(f.Nodes, rule)
+	return rule
+}
+
+// AddAssignment adds a variable assignment after the last assignment of the
+// file, or at its start if there is none, and returns it
+func (f *File) AddAssignment(name, op, value string) *Assignment {
+	a := &Assignment{Name: Word{Text: name}, Op: op, Value: Word{Text: value}}
+	at := 0
+	for i, n := range f.Nodes {
+		if _, ok := n.(*Assignment); ok {
+			at = i + 1
+		}
+	}
+	f.Nodes = append(f.Nodes[:at], append([]Node{a}, f.Nodes[at:]...)...)
+	return a
+}
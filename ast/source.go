@@ -0,0 +1,32 @@
+package ast
+
+import (
+	"bytes"
+	"errors"
+)
+
+// utf8BOM is the byte order mark some Windows editors start UTF-8 files with
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ErrUTF16 is returned for build files saved as UTF-16, which is what
+// "Unicode" means to some Windows editors
+var ErrUTF16 = errors.New("the file is encoded as UTF-16, save it as UTF-8")
+
+// Source prepares the content of a build file for parsing: a UTF-8 byte
+// order mark is dropped and \r\n line endings become \n, so files edited on
+// Windows don't leave a '\r' at the end of target names and recipe lines.
+// UTF-16 files, with or without a byte order mark, are rejected.
+func Source(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return nil, ErrUTF16
+	case len(data) >= 2 && (data[0] == 0) != (data[1] == 0):
+		// the high byte of an ASCII character in UTF-16
+		return nil, ErrUTF16
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if bytes.IndexByte(data, '\r') >= 0 {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+	return data, nil
+}
@@ -0,0 +1,139 @@
+package smmake
+
+import (
+	"path"
+	"strings"
+)
+
+// automatic are the values of make's automatic variables for the recipe of
+// a target
+type automatic struct {
+	target string
+	// prereqs are the prerequisites as the rule lists them, duplicates
+	// included, newer the ones $? names
+	prereqs []string
+	newer   []string
+	stem    string
+	// quote quotes a name for the shell the recipe runs through, nil
+	// leaves names as they are
+	quote func(string) string
+}
+
+// value returns what the automatic variable name, isAutomatic, expands to
+func (a *automatic) value(name string) string {
+	var names []string
+	switch name[0] {
+	case '@':
+		names = []string{a.target}
+	case '<':
+		if len(a.prereqs) > 0 {
+			names = a.prereqs[:1]
+		}
+	case '^':
+		names = uniqueDeps(a.prereqs)
+	case '+':
+		names = a.prereqs
+	case '?':
+		names = a.newer
+	case '*':
+		if a.stem != "" {
+			names = []string{a.stem}
+		}
+	}
+	// $% names archive members and $| order-only prerequisites, which
+	// smmake has neither of
+	words := make([]string, len(names))
+	for i, n := range names {
+		switch {
+		case len(name) == 2 && name[1] == 'D':
+			n = path.Dir(n)
+		case len(name) == 2 && name[1] == 'F':
+			n = path.Base(n)
+		}
+		if a.quote != nil {
+			n = a.quote(n)
+		}
+		words[i] = n
+	}
+	return strings.Join(words, " ")
+}
+
+// expandRecipe expands a recipe line the parser expanded before: it fills
+// in the automatic variables, $@ as well as $(@D), and turns "$$" into "$".
+// The other references name variables that were undefined when the line
+// was parsed; they are kept as they are, so the shell sees $HOME, and
+// returned.
+func expandRecipe(line string, auto *automatic) (string, []string) {
+	if strings.IndexByte(line, '$') < 0 {
+		return line, nil
+	}
+	var b strings.Builder
+	var left []string
+	for i := 0; i < len(line); i++ {
+		if line[i] != '$' || i+1 == len(line) {
+			b.WriteByte(line[i])
+			continue
+		}
+		name, end := line[i+1:i+2], i+2
+		switch line[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case '(', '{':
+			close := closingParen(line, i+1)
+			if close < 0 {
+				b.WriteString(line[i:])
+				i = len(line)
+				continue
+			}
+			name, end = line[i+2:close], close+1
+		}
+		if isAutomatic(name) {
+			b.WriteString(auto.value(name))
+		} else {
+			b.WriteString(line[i:end])
+			left = append(left, line[i:end])
+		}
+		i = end - 1
+	}
+	return b.String(), left
+}
+
+// recipeQuote returns how the automatic variables of a recipe line of
+// target quote names: for a POSIX shell, including the smmake: commands,
+// for cmd or PowerShell, or not at all for lines that are split at white
+// space instead, which can't hold a name with a blank
+func (m *Makefile) recipeQuote(target *Target, targetName string, cmd Command) func(string) string {
+	if isBuiltin(cmd) {
+		return shellQuote
+	}
+	shell, _ := m.shellFor(target)
+	if shell == "" || m.isWasm(targetName) {
+		return nil
+	}
+	switch shellName(shell) {
+	case "cmd":
+		return cmdQuote
+	case "powershell", "pwsh":
+		return powerShellQuote
+	}
+	return shellQuote
+}
+
+// cmdQuote quotes a word for cmd, if it needs quotes. cmd has no way to
+// escape a '"', which Windows doesn't allow in file names anyway.
+func cmdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t&|<>()^%!,;=") {
+		return s
+	}
+	return `"` + s + `"`
+}
+
+// powerShellQuote quotes a word for PowerShell, if it needs quotes
+func powerShellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./\\:+=") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,44 @@
+package smmake
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// largeMakefile is a synthetic Makefile of n compile rules sharing
+// recursive variables, the shape of a big generated build
+func largeMakefile(n int) string {
+	var b strings.Builder
+	b.WriteString("CC = cc\nOPT = -O2\nWARN = -Wall -Wextra\nDEFS = -DNDEBUG -DVERSION=1\n")
+	b.WriteString("CFLAGS = $(OPT) $(WARN) $(DEFS) -Iinclude\nHDRS := include/a.h include/b.h\n\n")
+	b.WriteString("all: app\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "obj/f%d.o: src/f%d.c $(HDRS)\n\t$(CC) $(CFLAGS) -c src/f%d.c -o obj/f%d.o\n\n", i, i, i, i)
+	}
+	return b.String()
+}
+
+func BenchmarkParseLarge(b *testing.B) {
+	src := largeMakefile(2000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(strings.NewReader(src), "Makefile"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExpand(b *testing.B) {
+	m, err := Parse(strings.NewReader(largeMakefile(2000)), "Makefile")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Expand("$(CC) $(CFLAGS) -c src/f1.c -o obj/f1.o"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package smmake
+
+import "strings"
+
+// TargetBuilder adds prerequisites and recipe lines to a target, for builds
+// constructed in code rather than parsed from a Makefile:
+//
+//	mf := smmake.NewMakefile()
+//	mf.Target("generate").Cmd("go generate ./...")
+//	mf.Target("build").Deps("generate").Cmd("go build ./...")
+//	err := mf.ExecuteTarget("build")
+type TargetBuilder struct {
+	m *Makefile
+	t *Target
+}
+
+// Target returns a builder for the named target, creating the target if it
+// doesn't exist yet. A name with a single '%' makes a pattern rule.
+func (m *Makefile) Target(name string) *TargetBuilder {
+	name = normalizeName(name)
+	t := m.Targets[name]
+	if t == nil {
+		t = &Target{Name: name, Commands: make([]Command, 0), Dependencies: make([]string, 0)}
+		setPattern(t)
+		m.setTarget(name, t)
+	}
+	return &TargetBuilder{m: m, t: t}
+}
+
+// Var defines a variable, used by the recipe lines added after it
+func (m *Makefile) Var(name, value string) *Makefile {
+	m.setVariable(&Variable{Name: name, Value: value, Origin: OriginBuilder})
+	return m
+}
+
+// Deps adds prerequisites to the target
+func (b *TargetBuilder) Deps(names ...string) *TargetBuilder {
+	for _, name := range names {
+		b.t.Dependencies = append(b.t.Dependencies, normalizeName(name))
+	}
+	return b
+}
+
+// Cmd adds a recipe line. Like in a Makefile, a leading '@' keeps the line
+// from being echoed, and variables are expanded when the line is added.
+func (b *TargetBuilder) Cmd(line string) *TargetBuilder {
+	silent := strings.HasPrefix(line, "@")
+	line = strings.TrimSpace(strings.TrimPrefix(line, "@"))
+	b.t.Commands = append(b.t.Commands, Command{Cmd: b.m.expandVariables(line), Silent: silent})
+	return b
+}
+
+// Describe sets the description 'smmake help' and 'smmake list' show
+func (b *TargetBuilder) Describe(text string) *TargetBuilder {
+	b.t.Description = text
+	return b
+}
+
+// Shell runs the recipe of the target through shell, see Makefile.Shell
+func (b *TargetBuilder) Shell(shell string) *TargetBuilder {
+	b.t.Shell = shell
+	return b
+}
+
+// Restat lists the target in .RESTAT, so the targets depending on it are
+// pruned when its recipe leaves its file as it was
+func (b *TargetBuilder) Restat() *TargetBuilder {
+	restat := b.m.Target(".RESTAT")
+	for _, dep := range restat.t.Dependencies {
+		if dep == b.t.Name {
+			return b
+		}
+	}
+	restat.Deps(b.t.Name)
+	return b
+}
+
+// Phony lists the target in .PHONY
+func (b *TargetBuilder) Phony() *TargetBuilder {
+	phony := b.m.Target(".PHONY")
+	for _, dep := range phony.t.Dependencies {
+		if dep == b.t.Name {
+			return b
+		}
+	}
+	phony.Deps(b.t.Name)
+	return b
+}
+
+// Build returns the target being built
+func (b *TargetBuilder) Build() *Target {
+	return b.t
+}
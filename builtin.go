@@ -0,0 +1,315 @@
+package smmake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// builtinPrefix starts the recipe lines smmake runs itself, the same way on
+// every platform, e.g. "smmake:rm -rf build"
+const builtinPrefix = "smmake:"
+
+// builtins are the commands of builtin recipe lines, by name. They get the
+// arguments with their flags and the directory to run in.
+var builtins = map[string]func(dir string, flags map[byte]bool, args []string) error{
+	"rm":    builtinRm,
+	"cp":    builtinCp,
+	"mv":    builtinMv,
+	"mkdir": builtinMkdir,
+	"touch": builtinTouch,
+}
+
+// builtinFlags are the options each builtin knows
+var builtinFlags = map[string]string{"rm": "rf", "cp": "r", "mv": "", "mkdir": "p", "touch": ""}
+
+// isBuiltin reports whether a recipe line is a builtin command
+func isBuiltin(cmd Command) bool {
+	return !cmd.Script && strings.HasPrefix(strings.TrimSpace(cmd.Cmd), builtinPrefix)
+}
+
+// builtinRunner runs builtin recipe lines, whatever runner the other lines
+// of the Makefile use
+type builtinRunner struct{}
+
+func (builtinRunner) Run(ctx context.Context, cmd Command, env Env) (Result, error) {
+	start := time.Now()
+	err := runBuiltin(cmd.Cmd, env.Dir)
+	result := Result{Duration: time.Since(start)}
+	if err != nil {
+		result.ExitCode = 1
+	}
+	return result, err
+}
+
+// runBuiltin parses and runs a builtin recipe line in dir
+func runBuiltin(line, dir string) error {
+	words := splitWords(strings.TrimPrefix(strings.TrimSpace(line), builtinPrefix))
+	if len(words) == 0 {
+		return fmt.Errorf("%s: missing command", builtinPrefix)
+	}
+	name := words[0]
+	fn, ok := builtins[name]
+	if !ok {
+		return fmt.Errorf("%s%s: unknown command, use rm, cp, mv, mkdir or touch", builtinPrefix, name)
+	}
+	flags := make(map[byte]bool)
+	var args []string
+	for i, word := range words[1:] {
+		if word == "--" {
+			args = append(args, words[i+2:]...)
+			break
+		}
+		if len(word) > 1 && word[0] == '-' && len(args) == 0 {
+			for _, c := range []byte(word[1:]) {
+				if !strings.ContainsRune(builtinFlags[name], rune(c)) {
+					return fmt.Errorf("%s%s: unknown option -%c", builtinPrefix, name, c)
+				}
+				flags[c] = true
+			}
+			continue
+		}
+		args = append(args, globArgs(dir, word)...)
+	}
+	if err := fn(dir, flags, args); err != nil {
+		return fmt.Errorf("%s%s: %w", builtinPrefix, name, err)
+	}
+	return nil
+}
+
+// splitWords splits a line at white space, keeping quoted parts and blanks
+// escaped as "\ " together. Other backslashes stay, as they separate
+// directories on Windows.
+func splitWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == '\\' && i+1 < len(line) && strings.IndexByte(" \t\"'", line[i+1]) >= 0:
+			word.WriteByte(line[i+1])
+			inWord = true
+			i++
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// globArgs expands a glob pattern argument with the glob engine, as a shell
+// would. A pattern that matches nothing is kept as it is.
+func globArgs(dir, arg string) []string {
+	if !hasGlobMeta(arg) {
+		return []string{arg}
+	}
+	matches := dirGlobber(dir).glob(arg)
+	if len(matches) == 0 {
+		return []string{arg}
+	}
+	return matches
+}
+
+// inDir returns where name is for a command running in dir, in the long
+// form on Windows if needed
+func inDir(dir, name string) string {
+	if dir == "" || filepath.IsAbs(name) {
+		return longPath(name)
+	}
+	return longPath(filepath.Join(dir, name))
+}
+
+func builtinRm(dir string, flags map[byte]bool, args []string) error {
+	if len(args) == 0 && !flags['f'] {
+		return errors.New("missing operand")
+	}
+	for _, arg := range args {
+		path := inDir(dir, arg)
+		info, err := os.Lstat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && flags['f']:
+			continue
+		case err != nil:
+			return err
+		case info.IsDir() && !flags['r']:
+			return fmt.Errorf("'%s' is a directory, use -r", arg)
+		case info.IsDir():
+			err = os.RemoveAll(path)
+		default:
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func builtinCp(dir string, flags map[byte]bool, args []string) error {
+	if len(args) < 2 {
+		return errors.New("wants a source and a destination")
+	}
+	sources, dest := args[:len(args)-1], inDir(dir, args[len(args)-1])
+	into := len(sources) > 1 || isDir(dest)
+	if into && !isDir(dest) {
+		return fmt.Errorf("'%s' is not a directory", args[len(args)-1])
+	}
+	for _, source := range sources {
+		src := inDir(dir, source)
+		target := dest
+		if into {
+			target = filepath.Join(dest, filepath.Base(src))
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if !flags['r'] {
+				return fmt.Errorf("'%s' is a directory, use -r", source)
+			}
+			err = copyTree(src, target)
+		} else {
+			err = copyFile(src, target, info.Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func builtinMv(dir string, _ map[byte]bool, args []string) error {
+	if len(args) < 2 {
+		return errors.New("wants a source and a destination")
+	}
+	sources, dest := args[:len(args)-1], inDir(dir, args[len(args)-1])
+	into := len(sources) > 1 || isDir(dest)
+	if into && !isDir(dest) {
+		return fmt.Errorf("'%s' is not a directory", args[len(args)-1])
+	}
+	for _, source := range sources {
+		src := inDir(dir, source)
+		target := dest
+		if into {
+			target = filepath.Join(dest, filepath.Base(src))
+		}
+		// Windows doesn't rename over an existing file
+		if info, err := os.Stat(target); err == nil && !info.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(src, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func builtinMkdir(dir string, flags map[byte]bool, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing operand")
+	}
+	for _, arg := range args {
+		var err error
+		if flags['p'] {
+			err = os.MkdirAll(inDir(dir, arg), 0o755)
+		} else {
+			err = os.Mkdir(inDir(dir, arg), 0o755)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func builtinTouch(dir string, _ map[byte]bool, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing operand")
+	}
+	now := time.Now()
+	for _, arg := range args {
+		path := inDir(dir, arg)
+		if err := os.Chtimes(path, now, now); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func copyFile(src, dest string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyTree copies the directory src to dest, which is created if needed
+func copyTree(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
@@ -0,0 +1,28 @@
+package smmake
+
+import (
+	"context"
+
+	"smmake/internal/color"
+)
+
+// cleanup runs the recipe of .ON_INTERRUPT once a build was cancelled, or
+// of .ON_ERROR once it failed otherwise or there is no .ON_INTERRUPT, e.g.
+// to tear down the services a test target started. It runs whatever
+// stopped the build, and its own failure is reported without replacing the
+// error of the build.
+func (s *Session) cleanup(ctx context.Context) {
+	m := s.m
+	name := ".ON_ERROR"
+	if ctx.Err() != nil && m.Targets[".ON_INTERRUPT"] != nil {
+		name = ".ON_INTERRUPT"
+	}
+	if m.Targets[name] == nil {
+		return
+	}
+	m.logf(LogInfo, name, "Running %s", color.Target(name))
+	s.stopped.Store(false)
+	if err := s.executeTarget(context.WithoutCancel(ctx), name, ""); err != nil {
+		m.logf(LogError, name, "%s failed: %v", name, err)
+	}
+}
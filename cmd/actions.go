@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"smmake"
+)
+
+// underGitHubActions reports whether smmake runs in a GitHub Actions job
+func underGitHubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// actionsError returns an ::error annotation, on a line of file if line
+// is set
+func actionsError(file string, line int, msg string) string {
+	var props []string
+	if file != "" {
+		props = append(props, "file="+actionsProperty(file))
+		if line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", line))
+		}
+	}
+	if len(props) == 0 {
+		return "::error::" + actionsEscape(msg)
+	}
+	return "::error " + strings.Join(props, ",") + "::" + actionsEscape(msg)
+}
+
+// annotateError prints an annotation for an error that stopped smmake
+// before building, such as a Makefile that doesn't parse, one for each of
+// its errors
+func annotateError(err error) {
+	var parseErrs smmake.ParseErrors
+	if errors.As(err, &parseErrs) {
+		for _, parseErr := range parseErrs {
+			fmt.Println(actionsError(parseErr.Filename, parseErr.Line, parseErr.Err.Error()))
+		}
+		return
+	}
+	var parseErr *smmake.ParseError
+	if errors.As(err, &parseErr) {
+		fmt.Println(actionsError(parseErr.Filename, parseErr.Line, parseErr.Err.Error()))
+	}
+}
+
+// actionsEscape escapes the message of a workflow command
+func actionsEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// actionsProperty escapes a property value of a workflow command
+func actionsProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(actionsEscape(s))
+}
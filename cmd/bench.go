@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"smmake/internal/color"
+	"smmake/internal/logging"
+)
+
+var benchFlags = []cliFlag{
+	{Names: []string{"-n", "--runs"}, Value: "N", Help: "Number of measured runs (default 5)"},
+	{Names: []string{"--warmup"}, Value: "N", Help: "Unmeasured runs before the measured ones (default 0)"},
+	{Names: []string{"--prepare"}, Value: "TARGET", Help: "Run TARGET before every run, e.g. clean for cold builds"},
+	{Names: []string{"--save"}, Value: "FILE", Help: "Save the results as a baseline"},
+	{Names: []string{"--baseline"}, Value: "FILE", Help: "Compare the results with a saved baseline"},
+}
+
+// benchResult is the outcome of benchmarking a target, as saved in a
+// baseline file
+type benchResult struct {
+	Target  string          `json:"target"`
+	Samples []time.Duration `json:"samples"`
+	Min     time.Duration   `json:"min"`
+	Median  time.Duration   `json:"median"`
+	Max     time.Duration   `json:"max"`
+}
+
+func benchTarget(ctx *cliContext, args []string) error {
+	flags, positional, err := parseCommandFlags("bench", benchFlags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("'bench' needs exactly one target")
+	}
+	target := positional[0]
+
+	runs, err := benchCount(flags, "runs", 5)
+	if err != nil {
+		return err
+	}
+	warmup, err := benchCount(flags, "warmup", 0)
+	if err != nil {
+		return err
+	}
+	if runs < 1 {
+		return errors.New("option '--runs' must be at least 1")
+	}
+
+	result := &benchResult{Target: target}
+	for i := 0; i < warmup+runs; i++ {
+		if prepare := flags["prepare"]; prepare != "" {
+			if _, err := benchRun(ctx, prepare); err != nil {
+				return fmt.Errorf("error preparing run %d: %w", i+1, err)
+			}
+		}
+		elapsed, err := benchRun(ctx, target)
+		if err != nil {
+			return fmt.Errorf("error in run %d: %w", i+1, err)
+		}
+		if i < warmup {
+			logging.Verbosef("Warmup %d: %s", i+1, elapsed.Round(time.Millisecond))
+			continue
+		}
+		logging.Verbosef("Run %d: %s", i+1-warmup, elapsed.Round(time.Millisecond))
+		result.Samples = append(result.Samples, elapsed)
+	}
+
+	sorted := append([]time.Duration(nil), result.Samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	result.Min, result.Max = sorted[0], sorted[len(sorted)-1]
+	result.Median = sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		result.Median = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+
+	fmt.Printf("%s: %d runs, min %s, median %s, max %s\n", color.Target(target), runs,
+		result.Min.Round(time.Millisecond), result.Median.Round(time.Millisecond), result.Max.Round(time.Millisecond))
+
+	if path := flags["baseline"]; path != "" {
+		if err := compareBaseline(os.Stdout, result, path); err != nil {
+			return err
+		}
+	}
+	if path := flags["save"]; path != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("error saving baseline: %v", err)
+		}
+		logging.Verbosef("Saved baseline to %s", path)
+	}
+	return nil
+}
+
+func benchCount(flags map[string]string, name string, def int) (int, error) {
+	value, ok := flags[name]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("option '--%s' requires a number", name)
+	}
+	return n, nil
+}
+
+// benchRun builds target on a freshly parsed Makefile, so no state is shared
+// between runs, and returns the wall time. Recipe output is discarded.
+func benchRun(ctx *cliContext, target string) (time.Duration, error) {
+	if err := ctx.loadPlugins(); err != nil {
+		return 0, err
+	}
+	makefile, err := ctx.parseConfig().ParseFile(ctx.args.buildFile())
+	if err != nil {
+		return 0, fmt.Errorf("error parsing Makefile: %w", err)
+	}
+	if err := ctx.attachPlugins(makefile); err != nil {
+		return 0, err
+	}
+	makefile.Silent = true
+	makefile.Output = func(string) io.Writer { return io.Discard }
+
+	start := time.Now()
+	err = makefile.Execute(context.Background(), target, ctx.buildOptions()...)
+	return time.Since(start), err
+}
+
+// compareBaseline prints how the results differ from a saved baseline
+func compareBaseline(w io.Writer, result *benchResult, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("baseline '%s' does not exist, create it with --save", path)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading baseline: %v", err)
+	}
+	var baseline benchResult
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return fmt.Errorf("error parsing baseline '%s': %v", path, err)
+	}
+	if baseline.Target != result.Target {
+		logging.Warnf("baseline '%s' was recorded for target '%s'", path, baseline.Target)
+	}
+
+	row := func(label string, old, cur time.Duration) {
+		change := "n/a"
+		if old > 0 {
+			pct := (float64(cur) - float64(old)) / float64(old) * 100
+			change = fmt.Sprintf("%+.1f%%", pct)
+			switch {
+			case pct <= -5:
+				change = color.Colorize(color.Green, change)
+			case pct >= 5:
+				change = color.Error(change)
+			}
+		}
+		fmt.Fprintf(w, "  %-6s %10s -> %-10s %s\n", label, old.Round(time.Millisecond), cur.Round(time.Millisecond), change)
+	}
+	fmt.Fprintf(w, "Compared with %s:\n", path)
+	row("min", baseline.Min, result.Min)
+	row("median", baseline.Median, result.Median)
+	row("max", baseline.Max, result.Max)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"smmake"
+)
+
+// ciSystem tells the CI systems with collapsible job logs apart
+type ciSystem int
+
+const (
+	ciNone ciSystem = iota
+	ciGitHub
+	ciGitLab
+)
+
+// detectCI returns the CI system smmake runs in, from the variables it
+// sets for jobs
+func detectCI() ciSystem {
+	switch {
+	case underGitHubActions():
+		return ciGitHub
+	case os.Getenv("GITLAB_CI") == "true":
+		return ciGitLab
+	}
+	return ciNone
+}
+
+// ciGroups makes the job log of a CI system collapsible: the echoed recipe
+// lines and the output of every target are held back until it is done,
+// then printed in a group of its own, so the output of targets built at the
+// same time doesn't mix. On GitHub Actions failed targets also get an error
+// annotation on the line of their rule.
+type ciGroups struct {
+	mutex    sync.Mutex
+	system   ciSystem
+	out      io.Writer
+	filename string
+	buffers  map[string]*bytes.Buffer
+}
+
+// groups is set while a build is grouped, for the console logger to hold
+// back the recipe lines it echoes
+var groups *ciGroups
+
+// attachCIGroups installs the grouping for system on m
+func attachCIGroups(m *smmake.Makefile, system ciSystem, out io.Writer) *ciGroups {
+	g := &ciGroups{system: system, out: out, filename: m.Filename, buffers: make(map[string]*bytes.Buffer)}
+	m.Output = func(target string) io.Writer { return groupWriter{g, target} }
+	m.AddHooks(smmake.Hooks{OnTargetFinish: g.targetFinish})
+	groups = g
+	return g
+}
+
+// write adds data to the output held back for target
+func (g *ciGroups) write(target string, data []byte) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.buffers[target] == nil {
+		g.buffers[target] = &bytes.Buffer{}
+	}
+	g.buffers[target].Write(data)
+}
+
+func (g *ciGroups) targetFinish(e smmake.TargetEvent) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if buf := g.buffers[e.Name]; buf != nil {
+		g.start(e)
+		g.out.Write(buf.Bytes())
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			fmt.Fprintln(g.out)
+		}
+		g.end(e)
+		delete(g.buffers, e.Name)
+	}
+	var depErr *smmake.DependencyError
+	if g.system != ciGitHub || e.Err == nil || e.Target == nil || errors.As(e.Err, &depErr) || errors.Is(e.Err, smmake.ErrStopped) {
+		return
+	}
+	line := 0
+	if !e.Target.Pattern {
+		line = e.Target.Line
+	}
+	fmt.Fprintln(g.out, actionsError(g.filename, line, fmt.Sprintf("target '%s' failed: %v", e.Name, e.Err)))
+}
+
+// start opens the group of a target
+func (g *ciGroups) start(e smmake.TargetEvent) {
+	switch g.system {
+	case ciGitHub:
+		fmt.Fprintf(g.out, "::group::%s\n", actionsEscape(e.Name))
+	case ciGitLab:
+		// GitLab shows the time between the markers as the duration of
+		// the section, so the start is dated back to when the recipe began
+		started := time.Now().Add(-e.Duration)
+		fmt.Fprintf(g.out, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s (%s)\n",
+			started.Unix(), gitlabSection(e.Name), e.Name, e.Duration.Round(time.Millisecond))
+	}
+}
+
+// end closes the group of a target
+func (g *ciGroups) end(e smmake.TargetEvent) {
+	switch g.system {
+	case ciGitHub:
+		fmt.Fprintln(g.out, "::endgroup::")
+	case ciGitLab:
+		fmt.Fprintf(g.out, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), gitlabSection(e.Name))
+	}
+}
+
+// groupWriter holds back the recipe output of a target
+type groupWriter struct {
+	g      *ciGroups
+	target string
+}
+
+func (w groupWriter) Write(data []byte) (int, error) {
+	w.g.write(w.target, data)
+	return len(data), nil
+}
+
+// gitlabSection turns a target name into a section name, which may only
+// hold letters, digits, '_', '.' and '-'
+func gitlabSection(name string) string {
+	return "smmake_" + strings.Map(func(r rune) rune {
+		switch {
+		case r == '_' || r == '.' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"smmake"
+	"smmake/internal/color"
+	"smmake/internal/logging"
+	"smmake/plugin"
+)
+
+// cliCommand describes a smmake subcommand
+type cliCommand struct {
+	Name    string
+	Args    string // usage of the positional arguments
+	Summary string // one line for the command list
+	Help    string // description shown by 'smmake <command> --help'
+	Flags   []cliFlag
+	Run     func(ctx *cliContext, args []string) error
+}
+
+// cliFlag documents a command line option
+type cliFlag struct {
+	Names []string
+	Value string // placeholder for the option's value, empty for switches
+	Help  string
+}
+
+// cliContext carries the global options into a command
+type cliContext struct {
+	args     arguments
+	makefile *smmake.Makefile
+	// envFileVars maps the variables set from --env-file to their file
+	envFileVars map[string]string
+	// plugins are the plugins started by loadPlugins
+	plugins       []*plugin.Plugin
+	pluginsLoaded bool
+}
+
+var graphFlags = []cliFlag{
+	{Names: []string{"--format"}, Value: "FORMAT", Help: "Output format: dot (default) or mermaid"},
+}
+
+var queryFlags = []cliFlag{
+	{Names: []string{"--format"}, Value: "FORMAT", Help: "Output format: text (default, a name per line) or json"},
+}
+
+var lintFlags = []cliFlag{
+	{Names: []string{"--format"}, Value: "FORMAT", Help: "Output format: text (default) or sarif, for code scanning"},
+}
+
+var exportFlags = []cliFlag{
+	{Names: []string{"--targets"}, Value: "LIST", Help: "Comma separated targets to export, with what they depend on"},
+}
+
+// commands are the available subcommands. A first argument that isn't a
+// command is a target, so 'smmake build' is short for 'smmake run build'.
+var commands = []*cliCommand{
+	{
+		Name:    "run",
+		Args:    "[target...]",
+		Summary: "Build targets (the default command)",
+		Help: "Builds the given targets in order, or 'all' when no target is given.\n" +
+			"Targets shared between them are only built once.",
+		Run: runTargets,
+	},
+	{
+		Name:    "list",
+		Summary: "List the targets and their descriptions",
+		Help: "Lists every target of the Makefile with the description from its\n" +
+			"trailing '## text' comment.",
+		Run: listTargets,
+	},
+	{
+		Name:    "graph",
+		Args:    "[target...]",
+		Summary: "Print the dependency graph as DOT or Mermaid",
+		Help: "Prints the dependency graph of the given targets, or of the whole\n" +
+			"Makefile, for Graphviz or Mermaid.",
+		Flags: graphFlags,
+		Run:   graphTargets,
+	},
+	{
+		Name:    "query",
+		Args:    "<expression>",
+		Summary: "Select targets and files from the dependency graph",
+		Help: "Evaluates a query over the dependency graph and prints the nodes it\n" +
+			"selects: deps(x) and rdeps(x) with an optional depth, somepath(x, y),\n" +
+			"allpaths(x, y), kind(file|phony|target, x) and filter(regexp, x), of\n" +
+			"names and glob patterns, combined with +, - and ^. For example\n" +
+			"'smmake query \"kind(target, rdeps(src/*.go))\"' lists the targets\n" +
+			"affected by a change to the Go files in src.",
+		Flags: queryFlags,
+		Run:   queryTargets,
+	},
+	{
+		Name:    "export",
+		Args:    "<format> [target...]",
+		Summary: "Translate the Makefile into another tool's format",
+		Help: "Writes the given targets and what they depend on, or all targets, as\n" +
+			"a Taskfile (taskfile) or a justfile (just) to stdout. Constructs the\n" +
+			"format can't represent are reported as warnings. gha writes a GitHub\n" +
+			"Actions workflow with a job running smmake for each of the targets, or\n" +
+			"for each documented target, which needs the jobs of its prerequisites.\n" +
+			"script writes a POSIX shell script running the recipes of the targets\n" +
+			"and their prerequisites in order, for machines without smmake.",
+		Flags: exportFlags,
+		Run:   exportMakefile,
+	},
+	{
+		Name:    "lint",
+		Summary: "Check the Makefile for common mistakes",
+		Help: "Reports missing prerequisites, undefined variables, circular\n" +
+			"dependencies and targets that should be declared .PHONY. Exits with\n" +
+			"an error when any error level problem is found. --format sarif\n" +
+			"writes the findings as SARIF, for GitHub code scanning to show them\n" +
+			"on pull requests.",
+		Flags: lintFlags,
+		Run:   lintMakefile,
+	},
+	{
+		Name:    "lsp",
+		Summary: "Run a language server for Makefiles",
+		Help: "Speaks the Language Server Protocol on stdin and stdout, for editors:\n" +
+			"go to the definition of targets and variables, hover for the\n" +
+			"expanded value of a variable or the recipe of a target, the outline\n" +
+			"of the Makefile and the findings of 'smmake lint' as diagnostics.",
+		Run: serveLanguage,
+	},
+	{
+		Name:    "fmt",
+		Args:    "[file...]",
+		Summary: "Rewrite Makefiles in the canonical format",
+		Help: "Formats the given Makefiles, or the one selected by -f, in place:\n" +
+			"single spaces around assignments, aligned '## text' comments, tab\n" +
+			"indented recipes, sorted .PHONY targets and no repeated blank lines.\n" +
+			"Comments are kept.",
+		Flags: fmtFlags,
+		Run:   formatMakefiles,
+	},
+	{
+		Name:    "init",
+		Args:    "[go|node|python|docker]",
+		Summary: "Create a starter Makefile for the project",
+		Help: "Writes a Makefile with documented build, test, lint and clean targets\n" +
+			"for the given project type, detected from the files in the current\n" +
+			"directory when it is left out. The file name follows -f.",
+		Flags: initFlags,
+		Run:   initMakefile,
+	},
+	{
+		Name:    "env",
+		Args:    "[name...]",
+		Summary: "Show the effective variables and where they come from",
+		Help: "Lists the variables of the Makefile, the --env-file files, the\n" +
+			"environment and the NAME=value arguments as they are resolved, with\n" +
+			"the origin of each one and whether recipes see it in their\n" +
+			"environment. Give names to show only those variables.",
+		Run: showEnv,
+	},
+	{
+		Name:    "bench",
+		Args:    "<target>",
+		Summary: "Time repeated builds of a target",
+		Help: "Builds the target several times from a freshly parsed Makefile and\n" +
+			"reports the minimum, median and maximum wall time. Use --prepare to\n" +
+			"run a target such as clean before every run for cold builds, and\n" +
+			"--save and --baseline to compare against an earlier measurement.",
+		Flags: benchFlags,
+		Run:   benchTarget,
+	},
+	{
+		Name:    "ui",
+		Summary: "Pick targets to run from an interactive list",
+		Help: "Opens a terminal UI listing the targets. Type to filter, select\n" +
+			"targets with space and build them with enter.",
+		Run: func(ctx *cliContext, args []string) error {
+			if _, _, err := parseCommandFlags("ui", nil, args); err != nil {
+				return err
+			}
+			if err := ctx.loadPlugins(); err != nil {
+				return err
+			}
+			makefile, err := ctx.loadMakefile()
+			if err != nil {
+				return err
+			}
+			return runUI(makefile, ctx.buildOptions())
+		},
+	},
+	{
+		Name:    "serve",
+		Summary: "Run builds requested over HTTP",
+		Help: "Serves an HTTP API for the Makefile: GET /targets lists the targets,\n" +
+			"POST /builds with {\"target\": \"build\", \"variables\": {\"MODE\": \"release\"}}\n" +
+			"queues a build, GET /builds/ID reports its status and GET\n" +
+			"/builds/ID/events streams its events as server-sent events. Builds\n" +
+			"run one at a time, each from a freshly parsed Makefile, and /metrics\n" +
+			"has their Prometheus metrics.",
+		Flags: serveFlags,
+		Run:   serveBuilds,
+	},
+	{
+		Name:    "plugins",
+		Summary: "List the installed plugins",
+		Help: "Starts the plugins in .smmake/plugins and ~/.config/smmake/plugins\n" +
+			"and lists the functions, runners and caches they provide. run,\n" +
+			"bench, ui and serve start them: their functions are available in the\n" +
+			"Makefile, their caches are used for every build and --runner NAME\n" +
+			"runs recipes with a plugin.",
+		Run: listPlugins,
+	},
+	{
+		Name:    "version",
+		Summary: "Show version information",
+		Help:    "Prints the version, commit and build date of smmake.",
+		Run: func(ctx *cliContext, args []string) error {
+			fmt.Println(versionString())
+			return nil
+		},
+	},
+}
+
+// findCommandByName returns the command with the given name, or nil
+func findCommandByName(name string) *cliCommand {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// findCommand returns the named command, defaulting to 'run'
+func findCommand(name string) *cliCommand {
+	if name == "" {
+		name = "run"
+	}
+	return findCommandByName(name)
+}
+
+// parseCommandFlags splits the arguments of a command into the values of its
+// options, keyed by the long option name, and the positional arguments.
+// Switches get the value "true".
+func parseCommandFlags(command string, flags []cliFlag, args []string) (map[string]string, []string, error) {
+	values := make(map[string]string)
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		var flag *cliFlag
+		for j := range flags {
+			for _, n := range flags[j].Names {
+				if n == name {
+					flag = &flags[j]
+				}
+			}
+		}
+		if flag == nil {
+			return nil, nil, fmt.Errorf("unknown option '%s' for '%s' (see 'smmake %s --help')", name, command, command)
+		}
+
+		key := strings.TrimLeft(flag.Names[len(flag.Names)-1], "-")
+		switch {
+		case flag.Value == "":
+			values[key] = "true"
+		case hasValue:
+			values[key] = value
+		case i+1 < len(args):
+			values[key] = args[i+1]
+			i++
+		default:
+			return nil, nil, fmt.Errorf("option '%s' requires a value", name)
+		}
+	}
+	return values, positional, nil
+}
+
+// parseConfig returns how the Makefile is parsed: with the variables given
+// on the command line and the console logger, and cached in the cache_dir
+// of the config or parseCacheDir if asked to
+func (ctx *cliContext) parseConfig() *smmake.ParseConfig {
+	c := &smmake.ParseConfig{Overrides: ctx.args.overrides, Logger: consoleLogger{}, MaxLineLength: ctx.args.maxLineLength, Undefined: ctx.args.undefined, POSIX: ctx.args.posix}
+	if ctx.args.parseCache {
+		c.CacheDir = ctx.args.cacheDir
+		if c.CacheDir == "" {
+			c.CacheDir = parseCacheDir
+		}
+	}
+	return c
+}
+
+// parseCacheDir is where --parse-cache keeps parsed Makefiles by default,
+// next to the project plugins
+const parseCacheDir = ".smmake/cache"
+
+// buildOptions returns how targets are built, from the command line and the
+// config file
+func (ctx *cliContext) buildOptions() []smmake.Option {
+	return []smmake.Option{
+		smmake.WithJobs(ctx.args.jobs),
+		smmake.WithShell(ctx.args.shell),
+		smmake.WithDryRun(ctx.args.dryRun),
+		smmake.WithKeepGoing(ctx.args.keepGoing),
+	}
+}
+
+// loadMakefile parses the Makefile selected by -f once and applies the
+// global output options to it. Plugins are only used if the command loaded
+// them before, as the ones that build targets do.
+func (ctx *cliContext) loadMakefile() (*smmake.Makefile, error) {
+	if ctx.makefile != nil {
+		return ctx.makefile, nil
+	}
+
+	path := ctx.args.buildFile()
+	logging.Verbosef("Attempting to parse Makefile: %s", path)
+	makefile, err := ctx.parseConfig().ParseFile(path)
+	// Imported tasks are enough to build without a Makefile
+	if errors.Is(err, fs.ErrNotExist) && len(ctx.args.imports) > 0 {
+		logging.Verbosef("No Makefile, only building imported targets")
+		makefile, err = ctx.parseConfig().Parse(strings.NewReader(""), path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Makefile: %w", err)
+	}
+	logging.Verbosef("Makefile parsed successfully")
+	for _, spec := range ctx.args.imports {
+		kind, dir, _ := strings.Cut(spec, "=")
+		if err := makefile.Import(kind, dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.attachPlugins(makefile); err != nil {
+		return nil, err
+	}
+	if logging.JSON {
+		makefile.Output = logging.Output
+		makefile.AddHooks(smmake.Hooks{OnTargetFinish: func(e smmake.TargetEvent) { logging.Flush(e.Name) }})
+	}
+
+	makefile.Silent = ctx.args.silent
+	makefile.NoSilent = ctx.args.noSilent
+	makefile.FutureOutOfDate = ctx.args.futureOutOfDate
+	makefile.AssumeOld = ctx.args.assumeOld
+	makefile.AssumeNew = ctx.args.assumeNew
+	if ctx.args.ignoreCase != nil {
+		makefile.IgnoreCase = *ctx.args.ignoreCase
+	}
+	if ctx.args.conflicts != "" {
+		if makefile.Conflicts, err = smmake.ParseConflictMode(ctx.args.conflicts); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.args.dirs != "" {
+		if makefile.Dirs, err = smmake.ParseDirMode(ctx.args.dirs); err != nil {
+			return nil, err
+		}
+	}
+	if ctx.args.symlinks != "" {
+		if makefile.Symlinks, err = smmake.ParseSymlinkMode(ctx.args.symlinks); err != nil {
+			return nil, err
+		}
+	}
+	ctx.makefile = makefile
+	return makefile, nil
+}
+
+// hasTargets reports whether a Makefile has a rule to build, other than a
+// pattern rule or a special target, as make wants one to build anything
+func hasTargets(makefile *smmake.Makefile) bool {
+	for name, t := range makefile.Targets {
+		if !t.Pattern && !strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func runTargets(ctx *cliContext, args []string) error {
+	_, targets, err := parseCommandFlags("run", nil, args)
+	if err != nil {
+		return err
+	}
+	if err := ctx.loadPlugins(); err != nil {
+		return err
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		if !hasTargets(makefile) {
+			return fmt.Errorf("%s: no targets", ctx.args.buildFile())
+		}
+		targets = []string{"all"} // Default target
+	}
+
+	var tap *tapReporter
+	switch ctx.args.output {
+	case "", "text":
+	case "tap":
+		if ctx.args.progress || ctx.args.summary {
+			return errors.New("--output tap can't be combined with --progress or --summary")
+		}
+		tap = attachTAP(makefile, os.Stdout)
+		defer tap.finish()
+	default:
+		return fmt.Errorf("invalid --output value '%s' (expected text or tap)", ctx.args.output)
+	}
+
+	if system := detectCI(); tap == nil && system != ciNone {
+		attachCIGroups(makefile, system, os.Stdout)
+	}
+
+	var p *progress
+	if ctx.args.progress {
+		if p, err = attachProgress(makefile, targets); err != nil {
+			return fmt.Errorf("error executing target: %w", err)
+		}
+	}
+
+	if ctx.args.summary {
+		summary := attachSummary(makefile)
+		defer summary.print(os.Stdout)
+	}
+
+	var provenance *smmake.Provenance
+	if ctx.args.provenance != "" {
+		if ctx.args.dryRun {
+			return errors.New("--provenance can't be used with --dry-run, nothing is built")
+		}
+		provenance = makefile.RecordProvenance(targets)
+	}
+
+	var counters *metrics
+	if ctx.args.metricsAddr != "" {
+		counters = newMetrics()
+		if err := serveMetrics(counters, ctx.args.metricsAddr); err != nil {
+			return err
+		}
+		counters.attach(makefile)
+	}
+
+	var services []string
+	if ctx.args.notify != "" {
+		if services, err = notifyServices(ctx.args.notify, ctx.args.notifiers); err != nil {
+			return err
+		}
+	}
+	// Webhooks and notifications are only sent for builds that run
+	announce := (len(ctx.args.webhooks) > 0 || len(services) > 0) && !ctx.args.dryRun
+
+	var report *buildReport
+	if ctx.args.junit != "" || announce {
+		report = collectResults(makefile)
+	}
+
+	// Interrupting smmake cancels the build, so .ON_INTERRUPT can run
+	buildCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	err = makefile.Build(buildCtx, targets, ctx.buildOptions()...)
+	if counters != nil {
+		counters.buildFinished(time.Since(start), err)
+	}
+	if announce {
+		result := newBuildResult(makefile, targets, report, time.Since(start), err)
+		postWebhooks(ctx.args.webhooks, result)
+		notify(services, ctx.args.notifiers, result)
+	}
+	if ctx.args.junit != "" {
+		if err := writeJUnit(ctx.args.junit, makefile.Filename, report); err != nil {
+			logging.Warnf("%v", err)
+		}
+	}
+	if err != nil {
+		if p != nil {
+			p.clear()
+		}
+		return fmt.Errorf("error executing target: %w", err)
+	}
+	if provenance != nil {
+		if err := writeProvenance(provenance, ctx.args); err != nil {
+			return err
+		}
+	}
+
+	logging.Verbosef("Target execution completed")
+	return nil
+}
+
+// writeProvenance writes the provenance statement of a build, signed if a
+// key was given
+func writeProvenance(p *smmake.Provenance, args arguments) error {
+	data, err := p.Statement(args.provenanceEnv)
+	if err != nil {
+		return fmt.Errorf("error writing provenance: %w", err)
+	}
+	if args.provenanceKey != "" {
+		pemData, err := os.ReadFile(args.provenanceKey)
+		if err != nil {
+			return fmt.Errorf("error reading provenance key: %w", err)
+		}
+		key, err := smmake.ParseSigningKey(pemData)
+		if err != nil {
+			return fmt.Errorf("error reading provenance key %s: %w", args.provenanceKey, err)
+		}
+		if data, err = smmake.SignStatement(data, key); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(args.provenance, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing provenance: %w", err)
+	}
+	logging.Verbosef("Provenance written to %s", args.provenance)
+	return nil
+}
+
+func listTargets(ctx *cliContext, args []string) error {
+	if _, _, err := parseCommandFlags("list", nil, args); err != nil {
+		return err
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	width := 0
+	for name, t := range makefile.Targets {
+		if t.Pattern || strings.HasPrefix(name, ".") {
+			continue
+		}
+		names = append(names, name)
+		width = max(width, len(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := makefile.Targets[name]
+		if t.Description == "" {
+			fmt.Println(color.Target(name))
+			continue
+		}
+		fmt.Printf("%s%s  %s\n", color.Target(name), strings.Repeat(" ", width-len(name)), t.Description)
+	}
+	return nil
+}
+
+func graphTargets(ctx *cliContext, args []string) error {
+	flags, targets, err := parseCommandFlags("graph", graphFlags, args)
+	if err != nil {
+		return err
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+	return makefile.WriteGraph(os.Stdout, targets, flags["format"])
+}
+
+func queryTargets(ctx *cliContext, args []string) error {
+	flags, args, err := parseCommandFlags("query", queryFlags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("query requires an expression")
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+	result, err := makefile.Query(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	switch flags["format"] {
+	case "", "text":
+		for _, name := range result {
+			fmt.Println(name)
+		}
+	case "json":
+		if result == nil {
+			result = []string{}
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unknown query format '%s', use text or json", flags["format"])
+	}
+	return nil
+}
+
+func exportMakefile(ctx *cliContext, args []string) error {
+	flags, args, err := parseCommandFlags("export", exportFlags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		var formats []string
+		for name := range smmake.Exporters {
+			formats = append(formats, name)
+		}
+		sort.Strings(formats)
+		return fmt.Errorf("export requires a format, one of %s", strings.Join(formats, ", "))
+	}
+	targets := args[1:]
+	if flags["targets"] != "" {
+		targets = append(targets, strings.Split(flags["targets"], ",")...)
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+	warnings, err := makefile.Export(os.Stdout, args[0], targets)
+	for _, w := range warnings {
+		logging.Warnf("%s", w)
+	}
+	return err
+}
+
+func lintMakefile(ctx *cliContext, args []string) error {
+	flags, _, err := parseCommandFlags("lint", lintFlags, args)
+	if err != nil {
+		return err
+	}
+	format := flags["format"]
+	if format != "" && format != "text" && format != "sarif" {
+		return fmt.Errorf("unknown lint format '%s', use text or sarif", format)
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+
+	findings := makefile.Lint()
+	errCount := 0
+	for _, f := range findings {
+		label := color.Warning(f.Severity + ":")
+		if f.Severity == smmake.SeverityError {
+			label = color.Error(f.Severity + ":")
+			errCount++
+		}
+		if format != "sarif" {
+			fmt.Printf("%s:%d: %s %s [%s]\n", makefile.Filename, f.Line, label, f.Message, f.Rule)
+		}
+	}
+	if format == "sarif" {
+		if err := writeSARIF(os.Stdout, makefile.Filename, findings); err != nil {
+			return err
+		}
+	}
+	if errCount > 0 {
+		return fmt.Errorf("lint found %d error(s) and %d warning(s)", errCount, len(findings)-errCount)
+	}
+	if len(findings) == 0 {
+		logging.Verbosef("No problems found")
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+	"smmake/internal/logging"
+)
+
+// projectConfigFile is looked up in the current directory
+const projectConfigFile = ".smmake.yaml"
+
+// config holds defaults for command line options, read from the user and
+// project configuration files
+type config struct {
+	Jobs     int      `yaml:"jobs"`
+	Color    string   `yaml:"color"`
+	Shell    string   `yaml:"shell"`
+	Runner   string   `yaml:"runner"`
+	EnvFiles []string `yaml:"env_files"`
+	// ParseCache caches the parsed Makefile, like --parse-cache
+	ParseCache bool `yaml:"parse_cache"`
+	// CacheDir is where the parse cache keeps parsed Makefiles
+	CacheDir string `yaml:"cache_dir"`
+	// RemoteCache is the endpoint handed to the cache plugins
+	RemoteCache string `yaml:"remote_cache"`
+	// Webhooks are posted when a build is over
+	Webhooks []webhookConfig `yaml:"webhooks"`
+	// Notify are the chat services --notify sends to, by name
+	Notify map[string]notifierConfig `yaml:"notify"`
+}
+
+// userConfigPath returns ~/.config/smmake/config.yaml, or the same path
+// below $XDG_CONFIG_HOME when it is set
+func userConfigPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "smmake", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "smmake", "config.yaml")
+}
+
+// loadConfig reads the user configuration and then the project
+// configuration, so project settings override user settings. Missing files
+// are not an error.
+func loadConfig() (config, error) {
+	var cfg config
+	for _, path := range []string{userConfigPath(), projectConfigFile} {
+		if path == "" {
+			continue
+		}
+		if err := cfg.merge(path); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// merge overrides the settings of cfg with the ones present in the file
+func (cfg *config) merge(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var file config
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("error parsing config file '%s': %v", path, err)
+	}
+	logging.Debugf("Loaded config file %s", path)
+
+	if file.Jobs != 0 {
+		cfg.Jobs = file.Jobs
+	}
+	if file.Color != "" {
+		cfg.Color = file.Color
+	}
+	if file.Shell != "" {
+		cfg.Shell = file.Shell
+	}
+	if file.Runner != "" {
+		cfg.Runner = file.Runner
+	}
+	if file.EnvFiles != nil {
+		cfg.EnvFiles = file.EnvFiles
+	}
+	if file.ParseCache {
+		cfg.ParseCache = true
+	}
+	if file.CacheDir != "" {
+		cfg.CacheDir = file.CacheDir
+	}
+	if file.RemoteCache != "" {
+		cfg.RemoteCache = file.RemoteCache
+	}
+	if file.Webhooks != nil {
+		cfg.Webhooks = file.Webhooks
+	}
+	for name, service := range file.Notify {
+		if cfg.Notify == nil {
+			cfg.Notify = make(map[string]notifierConfig)
+		}
+		cfg.Notify[name] = service
+	}
+	return nil
+}
+
+// applyDefaults fills in the options that weren't given on the command line
+func (cfg config) applyDefaults(args *arguments) {
+	if args.jobs == 0 {
+		args.jobs = cfg.Jobs
+	}
+	if args.color == "" {
+		args.color = cfg.Color
+	}
+	if args.shell == "" {
+		args.shell = cfg.Shell
+	}
+	if args.runner == "" {
+		args.runner = cfg.Runner
+	}
+	if args.envFiles == nil && !args.noDotenv {
+		args.envFiles = cfg.EnvFiles
+	}
+	if cfg.ParseCache {
+		args.parseCache = true
+	}
+	args.cacheDir = cfg.CacheDir
+	args.remoteCache = cfg.RemoteCache
+	args.webhooks = cfg.Webhooks
+	args.notifiers = cfg.Notify
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"smmake/internal/logging"
+)
+
+var docsFlags = []cliFlag{
+	{Names: []string{"-o", "--output"}, Value: "DIR", Help: "Write the files into DIR instead of printing them"},
+}
+
+// The docs command walks the command table, so it is registered here rather
+// than in it to avoid an initialization cycle
+func init() {
+	commands = append(commands, &cliCommand{
+		Name:    "docs",
+		Args:    "<man|markdown>",
+		Summary: "Generate the manual pages or the CLI reference",
+		Help: "Generates documentation from the command definitions. With --output,\n" +
+			"'man' writes smmake.1 and a smmake-<command>.1 page per command, and\n" +
+			"'markdown' writes smmake.md. Without it the pages are printed.",
+		Flags: docsFlags,
+		Run:   generateDocs,
+	})
+}
+
+func generateDocs(ctx *cliContext, args []string) error {
+	flags, positional, err := parseCommandFlags("docs", docsFlags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("'docs' needs a format, 'man' or 'markdown'")
+	}
+	dir := flags["output"]
+
+	switch positional[0] {
+	case "man":
+		if dir == "" {
+			writeManPage(os.Stdout, nil)
+			for _, cmd := range commands {
+				fmt.Println()
+				writeManPage(os.Stdout, cmd)
+			}
+			return nil
+		}
+		if err := writeDocFile(filepath.Join(dir, "smmake.1"), func(w io.Writer) { writeManPage(w, nil) }); err != nil {
+			return err
+		}
+		for _, cmd := range commands {
+			path := filepath.Join(dir, "smmake-"+cmd.Name+".1")
+			if err := writeDocFile(path, func(w io.Writer) { writeManPage(w, cmd) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "markdown":
+		if dir == "" {
+			writeMarkdown(os.Stdout)
+			return nil
+		}
+		return writeDocFile(filepath.Join(dir, "smmake.md"), writeMarkdown)
+	}
+	return fmt.Errorf("unknown docs format '%s' (expected 'man' or 'markdown')", positional[0])
+}
+
+func writeDocFile(path string, write func(io.Writer)) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating output directory: %v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating '%s': %v", path, err)
+	}
+	write(file)
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing '%s': %v", path, err)
+	}
+	logging.Infof("Wrote %s", path)
+	return nil
+}
+
+// writeManPage writes the roff source of the manual page of a command, or of
+// smmake itself when cmd is nil
+func writeManPage(w io.Writer, cmd *cliCommand) {
+	title, name, summary := "SMMAKE", "smmake", "Simple Multi-platform Make"
+	synopsis := []string{"[options] [target...]", "[options] <command> [arguments]"}
+	if cmd != nil {
+		title = "SMMAKE-" + strings.ToUpper(cmd.Name)
+		name = "smmake-" + cmd.Name
+		summary = cmd.Summary
+		synopsis = []string{"[options] " + strings.TrimSpace(cmd.Name+" "+cmd.Args)}
+	}
+
+	fmt.Fprintf(w, ".TH %s 1 \"%s\" \"smmake %s\" \"smmake Manual\"\n", title, time.Now().Format("January 2006"), VERSION)
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintf(w, "%s \\- %s\n", name, manEscape(summary))
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	for i, s := range synopsis {
+		if i > 0 {
+			fmt.Fprintln(w, ".br")
+		}
+		fmt.Fprintf(w, ".B smmake\n%s\n", manEscape(s))
+	}
+
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	if cmd == nil {
+		fmt.Fprintln(w, "smmake runs the targets of a Makefile on Linux, macOS and Windows alike.")
+		fmt.Fprintln(w, "A first argument that isn't a command is a target, so")
+		fmt.Fprintln(w, ".B smmake build")
+		fmt.Fprintln(w, "is short for")
+		fmt.Fprintln(w, ".BR \"smmake run build\" .")
+		fmt.Fprintln(w, ".SH COMMANDS")
+		for _, c := range commands {
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", manEscape(c.Name), manEscape(c.Summary))
+		}
+		fmt.Fprintln(w, ".SH OPTIONS")
+		writeManFlags(w, globalFlags)
+	} else {
+		fmt.Fprintln(w, manEscape(cmd.Help))
+		if len(cmd.Flags) > 0 {
+			fmt.Fprintln(w, ".SH OPTIONS")
+			writeManFlags(w, cmd.Flags)
+		}
+	}
+
+	fmt.Fprintln(w, ".SH SEE ALSO")
+	var refs []string
+	if cmd != nil {
+		refs = append(refs, ".BR smmake (1)")
+	}
+	for _, c := range commands {
+		if c != cmd {
+			refs = append(refs, ".BR smmake-"+c.Name+" (1)")
+		}
+	}
+	fmt.Fprintln(w, strings.Join(refs, ",\n"))
+}
+
+func writeManFlags(w io.Writer, flags []cliFlag) {
+	for _, f := range flags {
+		names := make([]string, len(f.Names))
+		for i, n := range f.Names {
+			names[i] = "\\fB" + manEscape(n) + "\\fR"
+		}
+		line := strings.Join(names, ", ")
+		if f.Value != "" {
+			value := strings.Trim(f.Value, "[=]")
+			if strings.HasPrefix(f.Value, "[") {
+				line += "[=\\fI" + value + "\\fR]"
+			} else {
+				line += " \\fI" + value + "\\fR"
+			}
+		}
+		fmt.Fprintf(w, ".TP\n%s\n%s\n", line, manEscape(f.Help))
+	}
+}
+
+// manEscape protects text from being read as roff requests or escapes
+func manEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeMarkdown writes the CLI reference as a single Markdown document
+func writeMarkdown(w io.Writer) {
+	fmt.Fprintln(w, "# smmake CLI reference")
+	fmt.Fprintln(w, "\n```")
+	fmt.Fprintln(w, "smmake [options] [target...]")
+	fmt.Fprintln(w, "smmake [options] <command> [arguments]")
+	fmt.Fprintln(w, "```")
+	fmt.Fprintln(w, "\nA first argument that isn't a command is a target, so `smmake build` is short for `smmake run build`.")
+
+	fmt.Fprintln(w, "\n## Options")
+	fmt.Fprintln(w, "\nThese options are shared by all commands.")
+	writeMarkdownFlags(w, globalFlags)
+
+	fmt.Fprintln(w, "\n## Commands")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "\n### %s\n", cmd.Name)
+		fmt.Fprintf(w, "\n```\nsmmake [options] %s\n```\n", strings.TrimSpace(cmd.Name+" "+cmd.Args))
+		fmt.Fprintf(w, "\n%s\n", strings.ReplaceAll(cmd.Help, "\n", " "))
+		if len(cmd.Flags) > 0 {
+			writeMarkdownFlags(w, cmd.Flags)
+		}
+	}
+}
+
+func writeMarkdownFlags(w io.Writer, flags []cliFlag) {
+	fmt.Fprintln(w, "\n| Option | Description |")
+	fmt.Fprintln(w, "| --- | --- |")
+	for _, f := range flags {
+		names := make([]string, len(f.Names))
+		for i, n := range f.Names {
+			names[i] = "`" + n + "`"
+		}
+		name := strings.Join(names, ", ")
+		if f.Value != "" {
+			name += " `" + f.Value + "`"
+		}
+		fmt.Fprintf(w, "| %s | %s |\n", name, strings.ReplaceAll(f.Help, "|", `\|`))
+	}
+}
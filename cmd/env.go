@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"smmake"
+	"smmake/internal/color"
+)
+
+// envEntry is a variable as 'smmake env' resolves it
+type envEntry struct {
+	Name     string
+	Value    string
+	Origin   string
+	Exported bool   // recipes see it in their environment
+	Shadows  string // environment value hidden by a Makefile or command line definition
+}
+
+// effectiveVariables merges the Makefile and command line variables with the
+// environment in the order expansion looks them up. envFileVars maps the
+// environment variables that were loaded from env files to their file.
+func effectiveVariables(m *smmake.Makefile, envFileVars map[string]string) map[string]*envEntry {
+	entries := make(map[string]*envEntry)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if name == "" {
+			continue // Windows keeps per drive directories as "=C:"
+		}
+		origin := smmake.OriginEnvironment
+		if path, ok := envFileVars[name]; ok {
+			origin = fmt.Sprintf("%s '%s'", smmake.OriginEnvFile, path)
+		}
+		entries[name] = &envEntry{Name: name, Value: value, Origin: origin, Exported: true}
+	}
+
+	// Makefile and command line variables win during expansion, but recipes
+	// are still run with the environment's value
+	for name, v := range m.Variables {
+		entry := &envEntry{Name: name, Value: v.Value, Origin: v.Origin}
+		if v.Origin == smmake.OriginMakefile {
+			entry.Origin = fmt.Sprintf("%s '%s', line %d", smmake.OriginMakefile, m.Filename, v.Line)
+		}
+		if env, ok := entries[name]; ok {
+			entry.Shadows = env.Value
+		}
+		entries[name] = entry
+	}
+	return entries
+}
+
+func showEnv(ctx *cliContext, args []string) error {
+	_, names, err := parseCommandFlags("env", nil, args)
+	if err != nil {
+		return err
+	}
+	makefile, err := ctx.loadMakefile()
+	if err != nil {
+		return err
+	}
+
+	entries := effectiveVariables(makefile, ctx.envFileVars)
+	if len(names) == 0 {
+		for name := range entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	for _, name := range names {
+		e := entries[name]
+		if e == nil {
+			fmt.Printf("%-*s   # undefined\n", width, name)
+			continue
+		}
+		notes := []string{e.Origin}
+		if e.Exported {
+			notes = append(notes, "exported")
+		}
+		if e.Shadows != "" {
+			notes = append(notes, fmt.Sprintf("recipes see the environment value '%s'", e.Shadows))
+		}
+		fmt.Printf("%s%s = %s  # %s\n", color.Target(name), strings.Repeat(" ", width-len(name)), e.Value, strings.Join(notes, ", "))
+	}
+	return nil
+}
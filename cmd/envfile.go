@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"smmake/internal/logging"
+)
+
+// dotenvFiles are loaded from the current directory when they exist, in
+// this order, unless --no-dotenv is given. The mode file, e.g.
+// .env.production, is only looked for when SMMAKE_MODE is set, on the
+// command line, in the environment or in one of the files before it.
+var dotenvFiles = []string{".env", ".env.local", ".env.$(SMMAKE_MODE)"}
+
+// loadEnvFiles loads the dotenv files that exist and then the files given
+// with --env-file or env_files, which must exist. Later files override what
+// earlier ones set. loaded maps the variables set to the file they came
+// from.
+func loadEnvFiles(args arguments, loaded map[string]string) error {
+	if !args.noDotenv {
+		for _, path := range dotenvFiles {
+			if strings.Contains(path, "$(SMMAKE_MODE)") {
+				mode, ok := args.overrides["SMMAKE_MODE"]
+				if !ok {
+					mode = os.Getenv("SMMAKE_MODE")
+				}
+				if mode == "" {
+					continue
+				}
+				path = strings.ReplaceAll(path, "$(SMMAKE_MODE)", mode)
+			}
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err := loadEnvFile(path, loaded); err != nil {
+				return err
+			}
+		}
+	}
+	for _, path := range args.envFiles {
+		if err := loadEnvFile(path, loaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEnvFile reads KEY=VALUE lines from a dotenv style file into the process
+// environment, where variable expansion and recipes pick them up. Variables
+// that are already set in the environment keep their value, unless an env
+// file in loaded set them. The variables it sets are added to loaded.
+func loadEnvFile(path string, loaded map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening env file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNum)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if _, fromFile := loaded[key]; !fromFile {
+			if _, exists := os.LookupEnv(key); exists {
+				continue
+			}
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		loaded[key] = path
+		logging.Debugf("Loaded %s from %s", key, path)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"smmake"
+	"smmake/ast"
+	"smmake/internal/logging"
+)
+
+var fmtFlags = []cliFlag{
+	{Names: []string{"--check"}, Help: "List the files that aren't formatted and fail, without changing them"},
+	{Names: []string{"--stdout"}, Help: "Print the formatted Makefile instead of rewriting the file"},
+}
+
+func formatMakefiles(ctx *cliContext, args []string) error {
+	flags, files, err := parseCommandFlags("fmt", fmtFlags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		files = []string{ctx.args.buildFile()}
+	}
+
+	unformatted := 0
+	for _, path := range files {
+		if !smmake.IsMakefile(path) {
+			return fmt.Errorf("'%s' is not a Makefile, fmt only formats Makefiles", path)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("error reading makefile: %v", err)
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading makefile: %v", err)
+		}
+		out, err := formatSource(src, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case flags["stdout"] != "":
+			os.Stdout.Write(out)
+		case bytes.Equal(src, out):
+			logging.Verbosef("%s is formatted", path)
+		case flags["check"] != "":
+			fmt.Println(path)
+			unformatted++
+		default:
+			if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("error writing makefile: %v", err)
+			}
+			logging.Infof("Formatted %s", path)
+		}
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("%d file(s) are not formatted, run 'smmake fmt' to fix them", unformatted)
+	}
+	return nil
+}
+
+// formatSource returns the canonical form of a Makefile
+func formatSource(src []byte, name string) ([]byte, error) {
+	tree, err := ast.Parse(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	ast.Format(tree)
+	var out bytes.Buffer
+	if err := (&ast.Config{AlignComments: true}).Fprint(&out, tree); err != nil {
+		return nil, err
+	}
+	// Files with Windows line endings keep them
+	if bytes.Contains(src, []byte("\r\n")) {
+		return bytes.ReplaceAll(out.Bytes(), []byte("\n"), []byte("\r\n")), nil
+	}
+	return out.Bytes(), nil
+}
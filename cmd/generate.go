@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"smmake"
+)
+
+// underGoGenerate reports whether smmake was started from a //go:generate
+// line, by the variables 'go generate' sets for the commands it runs
+func underGoGenerate() bool {
+	return os.Getenv("GOFILE") != "" && os.Getenv("GOLINE") != ""
+}
+
+// applyGoGenerate makes the output fit for 'go generate': no colors, no
+// echoed recipe lines and no progress indicators, so only the recipes and
+// errors are printed
+func applyGoGenerate(args *arguments) {
+	if args.color == "" {
+		args.color = "never"
+	}
+	args.silent = true
+	args.progress = false
+	args.summary = false
+}
+
+// exitCode returns the exit status for an error. Under 'go generate' it
+// tells failures apart: the exit code of a failed recipe, 2 for a Makefile
+// that can't be read or parsed and 1 for anything else.
+func exitCode(err error) int {
+	if !underGoGenerate() {
+		return 1
+	}
+	var recipeErr *smmake.RecipeError
+	if errors.As(err, &recipeErr) && recipeErr.ExitCode > 0 {
+		return recipeErr.ExitCode
+	}
+	var parseErr *smmake.ParseError
+	if errors.As(err, &parseErr) {
+		return 2
+	}
+	return 1
+}
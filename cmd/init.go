@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"smmake"
+	"smmake/internal/logging"
+)
+
+// scaffold is a starter Makefile for a kind of project
+type scaffold struct {
+	Kind      string
+	Variables [][2]string
+	Targets   []scaffoldTarget
+}
+
+type scaffoldTarget struct {
+	Name        string
+	Deps        []string
+	Description string
+	Commands    []string
+}
+
+// scaffoldKinds are the project types 'smmake init' knows, in the order
+// they are tried when detecting the project type
+var scaffoldKinds = []string{"go", "node", "python", "docker"}
+
+var initFlags = []cliFlag{
+	{Names: []string{"--force"}, Help: "Overwrite an existing Makefile"},
+}
+
+func initMakefile(ctx *cliContext, args []string) error {
+	flags, positional, err := parseCommandFlags("init", initFlags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("'init' takes at most one project type (%s)", strings.Join(scaffoldKinds, ", "))
+	}
+
+	kind := ""
+	if len(positional) == 1 {
+		kind = positional[0]
+	} else if kind = detectProjectKind("."); kind == "" {
+		return fmt.Errorf("could not detect the project type, run 'smmake init <%s>'", strings.Join(scaffoldKinds, "|"))
+	}
+
+	s, err := newScaffold(kind, ".")
+	if err != nil {
+		return err
+	}
+
+	path := ctx.args.makefilePath
+	if path == "" {
+		path = smmake.DefaultMakefile
+	}
+	if _, err := os.Stat(path); err == nil && flags["force"] == "" {
+		return fmt.Errorf("'%s' already exists, use --force to overwrite it", path)
+	}
+	if err := os.WriteFile(path, []byte(s.render()), 0o644); err != nil {
+		return fmt.Errorf("error writing makefile: %v", err)
+	}
+	logging.Infof("Created %s for a %s project, run 'smmake help' to see its targets", path, kind)
+	return nil
+}
+
+// detectProjectKind guesses the project type from the files in dir
+func detectProjectKind(dir string) string {
+	markers := map[string][]string{
+		"go":     {"go.mod"},
+		"node":   {"package.json"},
+		"python": {"pyproject.toml", "setup.py", "requirements.txt"},
+		"docker": {"Dockerfile"},
+	}
+	for _, kind := range scaffoldKinds {
+		for _, name := range markers[kind] {
+			if fileExists(filepath.Join(dir, name)) {
+				return kind
+			}
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// newScaffold builds the starter Makefile for kind, wired to the layout of
+// the project in dir
+func newScaffold(kind, dir string) (*scaffold, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(abs)
+
+	switch kind {
+	case "go":
+		return goScaffold(dir, name), nil
+	case "node":
+		return nodeScaffold(dir)
+	case "python":
+		return pythonScaffold(dir), nil
+	case "docker":
+		return dockerScaffold(name), nil
+	}
+	return nil, fmt.Errorf("unknown project type '%s' (expected one of %s)", kind, strings.Join(scaffoldKinds, ", "))
+}
+
+func goScaffold(dir, name string) *scaffold {
+	main := "."
+	if data, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if module, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+				name = filepath.Base(strings.TrimSpace(module))
+				break
+			}
+		}
+	}
+	// Prefer cmd/<name>, then a single directory below cmd
+	if fileExists(filepath.Join(dir, "cmd", name)) {
+		main = "./cmd/" + name
+	} else if entries, err := os.ReadDir(filepath.Join(dir, "cmd")); err == nil {
+		var dirs []string
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, e.Name())
+			}
+		}
+		if len(dirs) == 1 {
+			main = "./cmd/" + dirs[0]
+		} else if len(dirs) == 0 {
+			main = "./cmd"
+		}
+	}
+
+	return &scaffold{
+		Kind: "go",
+		Variables: [][2]string{
+			{"BINARY", name},
+			{"MAIN", main},
+			{"BIN_DIR", "bin"},
+		},
+		Targets: []scaffoldTarget{
+			{Name: "all", Deps: []string{"lint", "test", "build"}, Description: "Lint, test and build"},
+			{Name: "build", Description: "Build the binary into the bin directory", Commands: []string{
+				"go build -o $(BIN_DIR)/ $(MAIN)",
+			}},
+			{Name: "test", Description: "Run the tests", Commands: []string{"go test ./..."}},
+			{Name: "lint", Description: "Run go vet", Commands: []string{"go vet ./..."}},
+			{Name: "clean", Description: "Remove build artifacts", Commands: []string{
+				"go clean",
+				removeDirCommand("$(BIN_DIR)"),
+			}},
+		},
+	}
+}
+
+func nodeScaffold(dir string) (*scaffold, error) {
+	manager, install := "npm", "npm ci"
+	switch {
+	case fileExists(filepath.Join(dir, "pnpm-lock.yaml")):
+		manager, install = "pnpm", "pnpm install --frozen-lockfile"
+	case fileExists(filepath.Join(dir, "yarn.lock")):
+		manager, install = "yarn", "yarn install --frozen-lockfile"
+	case !fileExists(filepath.Join(dir, "package-lock.json")):
+		install = "npm install"
+	}
+
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading package.json: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &pkg); err != nil {
+			return nil, fmt.Errorf("error parsing package.json: %v", err)
+		}
+	}
+
+	s := &scaffold{
+		Kind:      "node",
+		Variables: [][2]string{{"PM", manager}, {"DIST_DIR", "dist"}},
+		Targets: []scaffoldTarget{
+			{Name: "all", Deps: []string{"install", "lint", "test", "build"}, Description: "Install, lint, test and build"},
+			{Name: "install", Description: "Install the dependencies with " + manager, Commands: []string{
+				strings.Replace(install, manager, "$(PM)", 1),
+			}},
+		},
+	}
+	// Wire the standard targets to the package.json scripts that exist,
+	// all of them when there is no package.json to go by
+	for _, script := range []string{"build", "test", "lint"} {
+		if pkg.Scripts != nil && pkg.Scripts[script] == "" {
+			continue
+		}
+		s.Targets = append(s.Targets, scaffoldTarget{
+			Name:        script,
+			Deps:        []string{"install"},
+			Description: fmt.Sprintf("Run the '%s' script", script),
+			Commands:    []string{"$(PM) run " + script},
+		})
+	}
+	s.Targets = append(s.Targets, scaffoldTarget{
+		Name: "clean", Description: "Remove build artifacts", Commands: []string{removeDirCommand("$(DIST_DIR)")},
+	})
+	pruneMissingDeps(s)
+	return s, nil
+}
+
+func pythonScaffold(dir string) *scaffold {
+	python := "python3"
+	if runtime.GOOS == "windows" {
+		python = "python"
+	}
+	src := "."
+	if fileExists(filepath.Join(dir, "src")) {
+		src = "src"
+	}
+	tests := "tests"
+	if !fileExists(filepath.Join(dir, tests)) && fileExists(filepath.Join(dir, "test")) {
+		tests = "test"
+	}
+
+	install := "$(PYTHON) -m pip install -r requirements.txt"
+	if fileExists(filepath.Join(dir, "pyproject.toml")) || fileExists(filepath.Join(dir, "setup.py")) {
+		install = "$(PYTHON) -m pip install -e ."
+	}
+
+	return &scaffold{
+		Kind: "python",
+		Variables: [][2]string{
+			{"PYTHON", python},
+			{"SRC_DIR", src},
+			{"TEST_DIR", tests},
+			{"DIST_DIR", "dist"},
+		},
+		Targets: []scaffoldTarget{
+			{Name: "all", Deps: []string{"lint", "test"}, Description: "Lint and test"},
+			{Name: "install", Description: "Install the project and its dependencies", Commands: []string{install}},
+			{Name: "build", Description: "Build the distribution packages", Commands: []string{"$(PYTHON) -m build"}},
+			{Name: "test", Description: "Run the tests with pytest", Commands: []string{"$(PYTHON) -m pytest $(TEST_DIR)"}},
+			{Name: "lint", Description: "Check the code with ruff", Commands: []string{"$(PYTHON) -m ruff check $(SRC_DIR)"}},
+			{Name: "clean", Description: "Remove build artifacts", Commands: []string{removeDirCommand("$(DIST_DIR)")}},
+		},
+	}
+}
+
+func dockerScaffold(name string) *scaffold {
+	return &scaffold{
+		Kind: "docker",
+		Variables: [][2]string{
+			{"IMAGE", strings.ToLower(name)},
+			{"TAG", "latest"},
+		},
+		Targets: []scaffoldTarget{
+			{Name: "all", Deps: []string{"lint", "build"}, Description: "Lint the Dockerfile and build the image"},
+			{Name: "build", Description: "Build the image", Commands: []string{"docker build -t $(IMAGE):$(TAG) ."}},
+			{Name: "test", Deps: []string{"build"}, Description: "Run the image once", Commands: []string{"docker run --rm $(IMAGE):$(TAG)"}},
+			{Name: "lint", Description: "Check the Dockerfile with hadolint", Commands: []string{"hadolint Dockerfile"}},
+			{Name: "clean", Description: "Remove the image", Commands: []string{"docker image rm $(IMAGE):$(TAG)"}},
+		},
+	}
+}
+
+// removeDirCommand returns a recipe line that deletes a directory on the
+// current platform
+func removeDirCommand(dir string) string {
+	if runtime.GOOS == "windows" {
+		return "powershell -NoProfile -Command Remove-Item -Recurse -Force -ErrorAction SilentlyContinue " + dir
+	}
+	return "rm -rf " + dir
+}
+
+// pruneMissingDeps drops prerequisites on targets the scaffold doesn't have
+func pruneMissingDeps(s *scaffold) {
+	names := make(map[string]bool)
+	for _, t := range s.Targets {
+		names[t.Name] = true
+	}
+	for i, t := range s.Targets {
+		var deps []string
+		for _, dep := range t.Deps {
+			if names[dep] {
+				deps = append(deps, dep)
+			}
+		}
+		s.Targets[i].Deps = deps
+	}
+}
+
+// render writes the scaffold as Makefile text
+func (s *scaffold) render() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Makefile for a %s project, generated by 'smmake init'\n", s.Kind)
+	b.WriteString("# Run 'smmake help' to list the documented targets.\n\n")
+
+	width := 0
+	for _, v := range s.Variables {
+		width = max(width, len(v[0]))
+	}
+	for _, v := range s.Variables {
+		fmt.Fprintf(&b, "%-*s = %s\n", width, v[0], v[1])
+	}
+
+	var names []string
+	for _, t := range s.Targets {
+		names = append(names, t.Name)
+	}
+	fmt.Fprintf(&b, "\n.PHONY: %s\n", strings.Join(names, " "))
+
+	b.WriteString("\n##@ Targets\n")
+	for _, t := range s.Targets {
+		b.WriteString("\n" + t.Name + ":")
+		if len(t.Deps) > 0 {
+			b.WriteString(" " + strings.Join(t.Deps, " "))
+		}
+		if t.Description != "" {
+			b.WriteString(" ## " + t.Description)
+		}
+		b.WriteString("\n")
+		for _, cmd := range t.Commands {
+			b.WriteString("\t" + cmd + "\n")
+		}
+	}
+	return b.String()
+}
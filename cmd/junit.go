@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// junitSuites is the root of a JUnit XML report, as read by Jenkins,
+// GitLab and most CI systems
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results of a build as a JUnit XML report with a
+// test case for each target. Failed targets carry the output of their
+// recipe, targets that didn't run are skipped.
+func writeJUnit(path, makefile string, r *buildReport) error {
+	results := r.wait()
+	suite := junitSuite{
+		Name:      makefile,
+		Time:      junitSeconds(r.duration),
+		Timestamp: r.start.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, t := range results {
+		c := junitCase{Name: t.Name, ClassName: makefile, Time: junitSeconds(t.Duration)}
+		switch {
+		case t.Skipped != "":
+			c.Skipped = &junitMessage{Message: t.Skipped}
+			suite.Skipped++
+		case t.Err != nil:
+			c.Failure = &junitMessage{Message: t.Err.Error()}
+			c.SystemOut, c.SystemErr = t.Stdout.String(), t.Stderr.String()
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Tests = len(suite.Cases)
+	report := junitSuites{
+		Name:     "smmake",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing JUnit report: %w", err)
+	}
+	return nil
+}
+
+// junitSeconds formats a duration the way JUnit reports do
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"smmake"
+	"smmake/internal/logging"
+)
+
+// consoleLogger prints the messages of the parser and the executor like
+// smmake's own, following -v, --debug and --log-format
+type consoleLogger struct{}
+
+// consoleLevels maps the library's levels to the ones of -v and --debug
+var consoleLevels = map[smmake.LogLevel]logging.Level{
+	smmake.LogError:   logging.LevelError,
+	smmake.LogWarn:    logging.LevelWarn,
+	smmake.LogInfo:    logging.LevelInfo,
+	smmake.LogVerbose: logging.LevelVerbose,
+	smmake.LogTrace:   logging.LevelTrace,
+	smmake.LogDebug:   logging.LevelDebug,
+}
+
+func (consoleLogger) Enabled(level smmake.LogLevel) bool {
+	return logging.Enabled(consoleLevels[level])
+}
+
+func (consoleLogger) Log(level smmake.LogLevel, target, msg string) {
+	switch level {
+	case smmake.LogError:
+		logging.TargetErrorf(target, "%s", msg)
+	case smmake.LogWarn:
+		logging.TargetWarnf(target, "%s", msg)
+	case smmake.LogInfo:
+		if groups != nil && target != "" && !logging.JSON {
+			// The recipe lines go in the group of their target
+			if logging.Enabled(logging.LevelInfo) {
+				groups.write(target, []byte(msg+"\n"))
+			}
+			return
+		}
+		logging.Targetf(logging.LevelInfo, target, "%s", msg)
+	default:
+		logging.Targetf(consoleLevels[level], target, "%s", msg)
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"smmake"
+	"smmake/ast"
+	"smmake/internal/logging"
+)
+
+// JSON-RPC error codes the language server answers with
+const (
+	lspParseError     = -32700
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+)
+
+// LSP symbol kinds and severities used for Makefiles
+const (
+	lspSymbolFunction = 12 // targets
+	lspSymbolVariable = 13
+	lspSeverityError  = 1
+	lspSeverityWarn   = 2
+)
+
+// lspServer is a language server for Makefiles over stdin and stdout. It
+// keeps the documents the editor has open, parses them on every change and
+// publishes the findings of the linter as diagnostics.
+type lspServer struct {
+	ctx   *cliContext
+	out   io.Writer
+	mutex sync.Mutex // serializes writes to out
+	docs  map[string]*lspDocument
+}
+
+// lspDocument is an open Makefile, as syntax tree and as evaluated model
+type lspDocument struct {
+	uri      string
+	path     string
+	lines    []string
+	tree     *ast.File
+	makefile *smmake.Makefile
+}
+
+// lspMessage is a JSON-RPC request, notification or response
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspSymbol struct {
+	Name           string   `json:"name"`
+	Detail         string   `json:"detail,omitempty"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+func serveLanguage(ctx *cliContext, args []string) error {
+	if _, _, err := parseCommandFlags("lsp", nil, args); err != nil {
+		return err
+	}
+	s := &lspServer{ctx: ctx, out: os.Stdout, docs: make(map[string]*lspDocument)}
+	return s.serve(bufio.NewReader(os.Stdin))
+}
+
+// serve answers the messages read from in until the editor sends exit or
+// closes the connection
+func (s *lspServer) serve(in *bufio.Reader) error {
+	for {
+		data, err := readLSPMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("language server: %w", err)
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.reply(nil, nil, &lspError{lspParseError, err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rpcErr := s.handle(msg.Method, msg.Params)
+		if msg.ID != nil {
+			s.reply(msg.ID, result, rpcErr)
+		}
+	}
+}
+
+// readLSPMessage reads the content of a message framed by a Content-Length
+// header
+func readLSPMessage(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length '%s'", strings.TrimSpace(value))
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(in, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *lspServer) write(msg lspMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logging.Warnf("language server: %v", err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// reply answers the request id. A null result is sent explicitly, as the
+// protocol requires a result or an error.
+func (s *lspServer) reply(id *json.RawMessage, result any, rpcErr *lspError) {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	if result == nil && rpcErr == nil {
+		result = json.RawMessage("null")
+	}
+	s.write(lspMessage{ID: id, Result: result, Error: rpcErr})
+}
+
+func (s *lspServer) notify(method string, params any) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		logging.Warnf("language server: %v", err)
+		return
+	}
+	s.write(lspMessage{Method: method, Params: data})
+}
+
+// handle runs a method and returns its result. Unknown notifications are
+// ignored, the reply to them is dropped by serve.
+func (s *lspServer) handle(method string, params json.RawMessage) (any, *lspError) {
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1, // the full text on every change
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "smmake", "version": VERSION},
+		}, nil
+	case "shutdown", "initialized", "$/cancelRequest", "textDocument/didSave":
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct{ TextDocument lspTextDocument }
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		s.open(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   lspTextDocument
+			ContentChanges []struct{ Text string }
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.open(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct{ TextDocument lspTextDocument }
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", map[string]any{"uri": p.TextDocument.URI, "diagnostics": []lspDiagnostic{}})
+		return nil, nil
+	case "textDocument/definition", "textDocument/hover":
+		var p lspPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		doc := s.docs[p.TextDocument.URI]
+		if doc == nil {
+			return nil, nil
+		}
+		if method == "textDocument/hover" {
+			return doc.hover(p.Position), nil
+		}
+		return doc.definition(p.Position), nil
+	case "textDocument/documentSymbol":
+		var p struct{ TextDocument lspTextDocument }
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		if doc := s.docs[p.TextDocument.URI]; doc != nil {
+			return doc.symbols(), nil
+		}
+		return []lspSymbol{}, nil
+	}
+	return nil, &lspError{lspMethodNotFound, fmt.Sprintf("method '%s' is not supported", method)}
+}
+
+// open parses the text of a document and publishes its diagnostics
+func (s *lspServer) open(uri, text string) {
+	doc := &lspDocument{uri: uri, path: uriPath(uri), lines: strings.Split(text, "\n")}
+	s.docs[uri] = doc
+	diagnostics := []lspDiagnostic{}
+
+	tree, err := ast.Parse(strings.NewReader(text), doc.path)
+	if err != nil {
+		diagnostics = append(diagnostics, lspDiagnostic{Severity: lspSeverityError, Source: "smmake", Message: err.Error()})
+	} else {
+		doc.tree = tree
+		doc.makefile = (&smmake.ParseConfig{Overrides: s.ctx.args.overrides}).FromAST(tree)
+		doc.makefile.Dir = filepath.Dir(doc.path)
+		for _, f := range doc.makefile.Lint() {
+			severity := lspSeverityWarn
+			if f.Severity == smmake.SeverityError {
+				severity = lspSeverityError
+			}
+			line := max(f.Line-1, 0)
+			diagnostics = append(diagnostics, lspDiagnostic{
+				Range:    doc.lineRange(line),
+				Severity: severity,
+				Code:     f.Rule,
+				Source:   "smmake",
+				Message:  f.Message,
+			})
+		}
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diagnostics})
+}
+
+// uriPath returns the file name of a file:// URI, or the URI itself
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// The protocol counts characters in UTF-16 code units, the syntax tree in
+// bytes from 1. lspColumn and byteColumn convert between them for a line.
+
+func (d *lspDocument) lspColumn(line, column int) int {
+	if line < 0 || line >= len(d.lines) {
+		return 0
+	}
+	text := d.lines[line][:min(max(column-1, 0), len(d.lines[line]))]
+	return len(utf16.Encode([]rune(text)))
+}
+
+func (d *lspDocument) byteColumn(p lspPosition) int {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return 0
+	}
+	text, units, i := d.lines[p.Line], 0, 0
+	for i < len(text) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		units++
+		if r >= 0x10000 {
+			units++ // a surrogate pair
+		}
+		i += size
+	}
+	return i
+}
+
+func (d *lspDocument) position(p ast.Pos) lspPosition {
+	return lspPosition{Line: p.Line - 1, Character: d.lspColumn(p.Line-1, p.Column)}
+}
+
+func (d *lspDocument) rangeOf(n ast.Node) lspRange {
+	return lspRange{Start: d.position(n.Pos()), End: d.position(n.End())}
+}
+
+// lineRange covers the text of a line, for the findings that only have one
+func (d *lspDocument) lineRange(line int) lspRange {
+	end := 0
+	if line < len(d.lines) {
+		end = d.lspColumn(line, len(d.lines[line])+1)
+	}
+	return lspRange{Start: lspPosition{Line: line}, End: lspPosition{Line: line, Character: end}}
+}
+
+// lspSymbolRef is what the cursor is on: a variable or a target
+type lspSymbolRef struct {
+	name     string
+	variable bool
+}
+
+// symbolAt returns the variable reference, the assigned variable or the
+// target or prerequisite at p
+func (d *lspDocument) symbolAt(p lspPosition) (lspSymbolRef, bool) {
+	if d.tree == nil || p.Line < 0 || p.Line >= len(d.lines) {
+		return lspSymbolRef{}, false
+	}
+	line, col := d.lines[p.Line], d.byteColumn(p)
+	if name, ok := variableAt(line, col); ok {
+		return lspSymbolRef{name, true}, true
+	}
+	within := func(w ast.Word) bool {
+		return w.From.Line == p.Line+1 && col >= w.From.Column-1 && col <= w.To.Column-1
+	}
+	for _, node := range d.tree.Nodes {
+		switch n := node.(type) {
+		case *ast.Assignment:
+			if within(n.Name) {
+				return lspSymbolRef{n.Name.Text, true}, true
+			}
+		case *ast.Rule:
+			for _, w := range append(append([]ast.Word{}, n.Targets...), n.Prereqs...) {
+				if within(w) {
+					return lspSymbolRef{w.Text, false}, true
+				}
+			}
+		}
+	}
+	return lspSymbolRef{}, false
+}
+
+// variableAt returns the name of the variable referenced at byte col of
+// line, by $(NAME), ${NAME} or $X. For a function call such as $(dir x) the
+// name is the function's.
+func variableAt(line string, col int) (string, bool) {
+	for i := 0; i < len(line)-1; i++ {
+		if line[i] != '$' {
+			continue
+		}
+		switch c := line[i+1]; c {
+		case '$':
+			i++
+		case '(', '{':
+			end := i + 2
+			for end < len(line) && !strings.ContainsRune(" \t:=,$()}{", rune(line[end])) {
+				end++
+			}
+			if col >= i && col <= end && end > i+2 {
+				return line[i+2 : end], true
+			}
+		default:
+			if col >= i && col <= i+1 {
+				return string(c), true
+			}
+		}
+	}
+	return "", false
+}
+
+// definition returns where the variable or target at p is defined: its
+// assignments, or the rules of the target, or the pattern rules it matches
+// if it has none
+func (d *lspDocument) definition(p lspPosition) []lspLocation {
+	ref, ok := d.symbolAt(p)
+	if !ok {
+		return nil
+	}
+	locations := []lspLocation{}
+	var patterns []lspLocation
+	for _, node := range d.tree.Nodes {
+		switch n := node.(type) {
+		case *ast.Assignment:
+			if ref.variable && n.Name.Text == ref.name {
+				locations = append(locations, lspLocation{d.uri, d.rangeOf(n.Name)})
+			}
+		case *ast.Directive:
+			if fields := strings.Fields(n.Args.Text); ref.variable && n.Name == "define" && len(fields) > 0 && fields[0] == ref.name {
+				locations = append(locations, lspLocation{d.uri, d.rangeOf(n)})
+			}
+		case *ast.Rule:
+			if ref.variable {
+				continue
+			}
+			for _, w := range n.Targets {
+				switch {
+				case w.Text == ref.name:
+					locations = append(locations, lspLocation{d.uri, d.rangeOf(w)})
+				case patternMatches(w.Text, ref.name):
+					patterns = append(patterns, lspLocation{d.uri, d.rangeOf(w)})
+				}
+			}
+		}
+	}
+	if len(locations) == 0 {
+		return patterns
+	}
+	return locations
+}
+
+// patternMatches reports whether a pattern target such as %.o matches name
+func patternMatches(pattern, name string) bool {
+	prefix, suffix, ok := strings.Cut(pattern, "%")
+	return ok && len(name) > len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix)
+}
+
+// hover describes the variable or target at p: the value of a variable as
+// written and expanded, the prerequisites and recipe of a target
+func (d *lspDocument) hover(p lspPosition) any {
+	ref, ok := d.symbolAt(p)
+	if !ok || d.makefile == nil {
+		return nil
+	}
+	var b strings.Builder
+	if ref.variable {
+		v := d.makefile.Variables[ref.name]
+		expanded, err := d.makefile.Expand("$(" + ref.name + ")")
+		if v == nil && (err != nil || expanded == "") {
+			return nil
+		}
+		if v != nil {
+			fmt.Fprintf(&b, "```make\n%s = %s\n```\n", v.Name, v.Value)
+			fmt.Fprintf(&b, "Defined in the %s", v.Origin)
+			if v.Line > 0 {
+				fmt.Fprintf(&b, " on line %d", v.Line)
+			}
+			b.WriteString("\n\n")
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "Doesn't expand: %v", err)
+		} else {
+			fmt.Fprintf(&b, "Expands to `%s`", expanded)
+		}
+	} else {
+		t := d.makefile.Targets[ref.name]
+		if t == nil {
+			for _, candidate := range d.makefile.Targets {
+				if candidate.Pattern && patternMatches(candidate.Name, ref.name) {
+					t = candidate
+					break
+				}
+			}
+		}
+		if t == nil {
+			return nil
+		}
+		if t.Description != "" {
+			b.WriteString(t.Description + "\n\n")
+		}
+		fmt.Fprintf(&b, "```make\n%s:", t.Name)
+		for _, dep := range t.Dependencies {
+			b.WriteString(" " + dep)
+		}
+		b.WriteString("\n")
+		for _, c := range t.Commands {
+			b.WriteString("\t" + c.Cmd + "\n")
+		}
+		b.WriteString("```")
+	}
+	return map[string]any{"contents": map[string]string{"kind": "markdown", "value": b.String()}}
+}
+
+// symbols returns the targets and variables of the document, in source
+// order
+func (d *lspDocument) symbols() []lspSymbol {
+	symbols := []lspSymbol{}
+	if d.tree == nil {
+		return symbols
+	}
+	for _, node := range d.tree.Nodes {
+		switch n := node.(type) {
+		case *ast.Assignment:
+			symbols = append(symbols, lspSymbol{
+				Name: n.Name.Text, Detail: n.Op + " " + n.Value.Text, Kind: lspSymbolVariable,
+				Range: d.rangeOf(n), SelectionRange: d.rangeOf(n.Name),
+			})
+		case *ast.Rule:
+			detail := ""
+			if n.Comment != nil && strings.HasPrefix(n.Comment.Text, "##") {
+				detail = strings.TrimSpace(strings.TrimPrefix(n.Comment.Text, "##"))
+			}
+			for _, w := range n.Targets {
+				symbols = append(symbols, lspSymbol{
+					Name: w.Text, Detail: detail, Kind: lspSymbolFunction,
+					Range: d.rangeOf(n), SelectionRange: d.rangeOf(w),
+				})
+			}
+		}
+	}
+	return symbols
+}
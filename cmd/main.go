@@ -1,248 +1,590 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
-	"regexp"
+	"strconv"
 	"strings"
-	"sync"
+
+	"smmake"
+	"smmake/internal/color"
+	"smmake/internal/logging"
+	"smmake/plugin"
 )
 
 var (
-	DEBUG   bool
 	VERSION = "v0.1.2"
 )
 
-// Target represents a make target and its commands
-type Target struct {
-	Name         string
-	Commands     []Command
-	Dependencies []string
-	Pattern      bool
-	PatternFrom  string
-	PatternTo    string
+// globalFlags documents the options shared by every command
+var globalFlags = []cliFlag{
+	{Names: []string{"-h", "--help"}, Help: "Show help for smmake or a command"},
+	{Names: []string{"-f", "--file"}, Value: "FILE", Help: "Specify a Makefile or smmake.yaml (default is 'Makefile', then smmake.yaml)"},
+	{Names: []string{"--version"}, Help: "Show version information"},
+	{Names: []string{"-v", "--verbose"}, Help: "Explain which targets are built and why"},
+	{Names: []string{"-vv"}, Help: "Also trace variable expansion"},
+	{Names: []string{"--debug"}, Help: "Also print parser decisions"},
+	{Names: []string{"-p", "--print-data-base"}, Help: "Print the parsed variables and rules, then exit"},
+	{Names: []string{"--why"}, Value: "TARGET", Help: "Explain why the recipes of a target and its prerequisites run, then exit"},
+	{Names: []string{"--color"}, Value: "[=WHEN]", Help: "Colorize output: always, never or auto (default)"},
+	{Names: []string{"--log-format"}, Value: "FORMAT", Help: "Log as plain text (default) or json, one object per line"},
+	{Names: []string{"--progress"}, Help: "Show a [done/total] progress indicator while building"},
+	{Names: []string{"--summary"}, Help: "Report executed, skipped, failed and not built targets and the slowest ones"},
+	{Names: []string{"--output"}, Value: "FORMAT", Help: "Report targets as text (default) or tap, Test Anything Protocol lines"},
+	{Names: []string{"--metrics-addr"}, Value: "ADDR", Help: "Serve Prometheus metrics on http://ADDR/metrics while smmake runs"},
+	{Names: []string{"--notify"}, Value: "[=LIST]", Help: "Send the result to the chat services of the config file, slack or teams"},
+	{Names: []string{"--junit"}, Value: "FILE", Help: "Write the results of the targets as a JUnit XML report"},
+	{Names: []string{"--provenance"}, Value: "FILE", Help: "Write a SLSA provenance statement for the files the build made"},
+	{Names: []string{"--provenance-key"}, Value: "KEY", Help: "Sign the provenance statement with a PEM private key"},
+	{Names: []string{"--provenance-env"}, Value: "LIST", Help: "Comma separated environment variables to record in the provenance"},
+	{Names: []string{"-s", "--silent"}, Help: "Don't echo recipe lines before running them"},
+	{Names: []string{"--no-silent"}, Help: "Echo recipe lines even if the Makefile declares .SILENT"},
+	{Names: []string{"-n", "--dry-run"}, Help: "Print the recipe lines that would run without running them"},
+	{Names: []string{"-k", "--keep-going"}, Help: "Keep building what doesn't depend on a failed target"},
+	{Names: []string{"--ignore-case"}, Help: "Match target and file names regardless of case (default on Windows and macOS)"},
+	{Names: []string{"--no-ignore-case"}, Help: "Match target and file names case-sensitively"},
+	{Names: []string{"--symlinks"}, Value: "MODE", Help: "Up-to-date checks use the time of the file a link points to (follow, default) or of the link"},
+	{Names: []string{"-o", "--old-file", "--assume-old"}, Value: "FILE", Help: "Take FILE to be up to date: don't make it, nor what depends on it because of it (repeatable)"},
+	{Names: []string{"-W", "--what-if", "--new-file", "--assume-new"}, Value: "FILE", Help: "Take FILE to be newer than anything, e.g. with --why to see what depends on it (repeatable)"},
+	{Names: []string{"--conflicts"}, Value: "MODE", Help: "A target named like an existing directory runs as if .PHONY (rule, default), or is up to date like the file (file)"},
+	{Names: []string{"--dirs"}, Value: "MODE", Help: "Directory prerequisites never make a target out of date (order-only, default), or newer files in them do (contents)"},
+	{Names: []string{"--future-out-of-date"}, Help: "Treat files modified in the future, as clock skew makes them, as out of date"},
+	{Names: []string{"-C", "--directory"}, Value: "DIR", Help: "Change to DIR before reading the Makefile"},
+	{Names: []string{"-j", "--jobs"}, Value: "N", Help: "Run at most N recipes at the same time"},
+	{Names: []string{"--shell"}, Value: "PROG", Help: "Run recipe lines through a shell, e.g. bash or pwsh, or none to run them directly"},
+	{Names: []string{"--runner"}, Value: "PLUGIN", Help: "Run recipe lines with a plugin, see 'smmake plugins'"},
+	{Names: []string{"--env-file"}, Value: "FILE", Help: "Load KEY=VALUE lines into the environment (repeatable)"},
+	{Names: []string{"--no-dotenv"}, Help: "Don't load .env, .env.local and .env.$SMMAKE_MODE, nor env_files"},
+	{Names: []string{"--warn-undefined-variables"}, Value: "[=error]", Help: "Warn about every reference to an undefined variable, or fail the parse"},
+	{Names: []string{"--posix"}, Help: "Parse the Makefile as POSIX make would, warning about GNU extensions, like a .POSIX target"},
+	{Names: []string{"--max-line-length"}, Value: "BYTES", Help: "Read Makefile lines of up to BYTES bytes (default 16 MiB)"},
+	{Names: []string{"--parse-cache"}, Help: "Reuse the parsed Makefile from .smmake/cache while nothing it was read from changed"},
+	{Names: []string{"--cpuprofile"}, Value: "FILE", Help: "Write a CPU profile of smmake itself, for 'go tool pprof'"},
+	{Names: []string{"--memprofile"}, Value: "FILE", Help: "Write a heap profile of smmake itself when it is done"},
+	{Names: []string{"--import"}, Value: "KIND[=DIR]", Help: "Add the tasks of another tool as targets, e.g. npm=web (repeatable)"},
 }
 
-type Command struct {
-	Cmd    string
-	Silent bool
+func printHelp() {
+	fmt.Println("smmake - Simple Multi-platform Make")
+	fmt.Println("\nUsage:")
+	fmt.Println("  smmake [options] [target...]")
+	fmt.Println("  smmake [options] <command> [arguments]")
+	fmt.Println("\nCommands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Println("\nOptions:")
+	printFlags(globalFlags)
+	fmt.Println("\nDefaults for -j, --color, --shell, --runner and --env-file can be set in")
+	fmt.Println(".smmake.yaml or ~/.config/smmake/config.yaml (keys jobs, color, shell,")
+	fmt.Println("runner, env_files), as well as where the parse cache is kept (cache_dir)")
+	fmt.Println("and the endpoint of the cache plugins (remote_cache).")
+	fmt.Println("\nExamples:")
+	fmt.Println("  smmake         # Run the default target")
+	fmt.Println("  smmake test    # Run the 'test' target, same as 'smmake run test'")
+	fmt.Println("  smmake clean build test  # Run 'clean', 'build' and 'test' in order")
+	fmt.Println("  smmake -f custom.mk build  # Use 'custom.mk' file and run 'build' target")
+	fmt.Println("  smmake --debug build  # Run 'build' target with debug output")
+	fmt.Println("  smmake -p > db.txt    # Dump the make database to a file")
+	fmt.Println("  smmake -n build       # Show the recipe lines 'build' would run")
+	fmt.Println("  smmake graph build --format mermaid  # Print the dependency graph of 'build'")
+	fmt.Println("  smmake help    # List the documented targets of the Makefile")
+	fmt.Println("  smmake build MODE=release  # Override the Makefile's MODE variable")
+	fmt.Println("  smmake --import npm=web web:test  # Run the test script of web/package.json")
+	fmt.Println("\nUnder 'go generate' (//go:generate smmake generate), recipe lines aren't echoed,")
+	fmt.Println("colors are off and a failed recipe's exit code is the exit code of smmake.")
+	fmt.Println("\nRun 'smmake <command> --help' for more information on a command.")
 }
 
-// Makefile represents the parsed makefile
-type Makefile struct {
-	Targets    map[string]*Target
-	Variables  map[string]string
-	mutex      sync.Mutex
-	executed   map[string]bool
-	processing map[string]bool
+// printCommandHelp shows the usage and options of a single command
+func printCommandHelp(cmd *cliCommand) {
+	fmt.Printf("Usage: smmake [options] %s %s\n", cmd.Name, cmd.Args)
+	fmt.Printf("\n%s\n", cmd.Help)
+	if len(cmd.Flags) > 0 {
+		fmt.Println("\nOptions:")
+		printFlags(cmd.Flags)
+	}
+	fmt.Println("\nRun 'smmake --help' for the options shared by all commands.")
 }
 
-// NewMakefile creates a new Makefile instance
-func NewMakefile() *Makefile {
-	return &Makefile{
-		Targets:    make(map[string]*Target),
-		Variables:  make(map[string]string),
-		executed:   make(map[string]bool),
-		processing: make(map[string]bool),
+func printFlags(flags []cliFlag) {
+	for _, f := range flags {
+		name := strings.Join(f.Names, ", ")
+		switch {
+		case strings.HasPrefix(f.Value, "["):
+			name += f.Value // optional value, --color[=WHEN]
+		case f.Value != "":
+			name += " " + f.Value
+		}
+		fmt.Printf("  %-24s %s\n", name, f.Help)
 	}
 }
 
-// findMatchingPatternRule finds a pattern rule that matches the target
-func (m *Makefile) findMatchingPatternRule(target string) *Target {
-	for _, t := range m.Targets {
-		if !t.Pattern {
-			continue
-		}
-		pattern := fmt.Sprintf("%s(.*)%s", t.PatternFrom, t.PatternTo)
-		if matched, _ := regexp.MatchString(pattern, target); matched {
-			return t
+func main() {
+	if err := run(); err != nil {
+		if underGitHubActions() {
+			annotateError(err)
 		}
+		logging.TargetErrorf(failedTarget(err), "%v", err)
+		os.Exit(exitCode(err))
 	}
-	return nil
 }
 
-// ExecuteTarget runs the commands for a specified target
-func (m *Makefile) ExecuteTarget(targetName string) error {
-	m.mutex.Lock()
-	if m.processing[targetName] {
-		m.mutex.Unlock()
-		return fmt.Errorf("circular dependency detected for target '%s'", targetName)
+// failedTarget returns the target whose recipe failed, or the innermost
+// prerequisite that couldn't be made, "" if err isn't about a target
+func failedTarget(err error) string {
+	var recipeErr *smmake.RecipeError
+	if errors.As(err, &recipeErr) {
+		return recipeErr.Target
 	}
-	if m.executed[targetName] {
-		m.mutex.Unlock()
-		return nil
+	var unknownErr *smmake.UnknownTargetError
+	if errors.As(err, &unknownErr) {
+		return unknownErr.Name
 	}
-	m.processing[targetName] = true
-	m.mutex.Unlock()
-
-	target := m.Targets[targetName]
-	if target == nil {
-
-		if DEBUG {
-			fmt.Printf("Target '%s' not found in Makefile\n", targetName) //DEDUG
-		}
-		// Check for pattern rules
-		if patternTarget := m.findMatchingPatternRule(targetName); patternTarget != nil {
-			target = patternTarget
-		} else {
-			// Check if it's a file
-			if _, err := os.Stat(targetName); err == nil {
-				m.mutex.Lock()
-				m.processing[targetName] = false
-				m.executed[targetName] = true
-				m.mutex.Unlock()
-				return nil
-			}
-			return fmt.Errorf("target '%s' not found", targetName)
-		}
+	target := ""
+	var depErr *smmake.DependencyError
+	for errors.As(err, &depErr) {
+		target, err = depErr.Dependency, depErr.Err
 	}
+	return target
+}
 
-	// Execute dependencies in parallel
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(target.Dependencies))
+func run() error {
 
-	for _, dep := range target.Dependencies {
-		wg.Add(1)
-		go func(dep string) {
-			defer wg.Done()
-			if err := m.ExecuteTarget(dep); err != nil {
-				errChan <- fmt.Errorf("error in dependency '%s': %v", dep, err)
-			}
-		}(dep)
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		return err
 	}
-
-	// Wait for all dependencies to complete
-	wg.Wait()
-	close(errChan)
-
-	// Check for dependency errors
-	for err := range errChan {
+	stopProfiling, err := startProfiling(args)
+	if err != nil {
 		return err
 	}
-
-	// Execute commands for this target
-	for _, cmd := range target.Commands {
-		fmt.Printf("Executing: %s\n", cmd.Cmd)
-
-		parts := strings.Fields(cmd.Cmd)
-		if len(parts) == 0 {
-			continue
-		}
-
-		command := exec.Command(parts[0], parts[1:]...)
-		command.Stdout = os.Stdout
-		command.Stderr = os.Stderr
-
-		if err := command.Run(); err != nil {
-			return fmt.Errorf("error executing command '%s': %v", cmd.Cmd, err)
+	defer stopProfiling()
+	if args.directory != "" {
+		if err := os.Chdir(args.directory); err != nil {
+			return fmt.Errorf("error changing directory: %v", err)
 		}
 	}
 
-	m.mutex.Lock()
-	m.processing[targetName] = false
-	m.executed[targetName] = true
-	m.mutex.Unlock()
-
-	return nil
-}
-
-func printHelp() {
-	fmt.Println("smmake - Simple Multi-platform Make")
-	fmt.Println("\nUsage:")
-	fmt.Println("  smmake [options] [target]")
-	fmt.Println("\nOptions:")
-	fmt.Println("  -h, --help     Show this help message")
-	fmt.Println("  -f, --file     Specify a Makefile (default is 'Makefile')")
-	fmt.Println("  -v, --version  Show version information")
-	fmt.Println("  --debug        Enable debug mode")
-	fmt.Println("\nExamples:")
-	fmt.Println("  smmake         # Run the default target")
-	fmt.Println("  smmake test    # Run the 'test' target")
-	fmt.Println("  smmake -f custom.mk build  # Use 'custom.mk' file and run 'build' target")
-	fmt.Println("  smmake --debug build  # Run 'build' target with debug output")
-}
-
-func main() {
-	if err := run(); err != nil {
-		log.Fatalf("Error: %v", err)
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
 	}
-}
-
-func run() error {
+	if underGoGenerate() {
+		applyGoGenerate(&args)
+	}
+	cfg.applyDefaults(&args)
 
-	args := parseArgs(os.Args[1:])
+	if err := color.Setup(args.color); err != nil {
+		return err
+	}
+	if err := logging.Setup(args.logFormat); err != nil {
+		return err
+	}
 
+	cmd := findCommand(args.command)
 	if args.showHelp {
-		printHelp()
+		if args.command == "" {
+			printHelp()
+		} else {
+			printCommandHelp(cmd)
+		}
 		return nil
 	}
 
 	if args.showVersion {
-		fmt.Println("smmake version", VERSION)
+		fmt.Println(versionString())
 		return nil
 	}
 
-	if DEBUG {
-		fmt.Println("Debug mode enabled")
-	}
+	logging.Debugf("Debug mode enabled")
 
-	fmt.Printf("Attempting to parse Makefile: %s\n", args.makefilePath)
-	makefile, err := ParseMakefile(args.makefilePath)
-	if err != nil {
-		return fmt.Errorf("error parsing Makefile: %w", err)
+	ctx := &cliContext{args: args, envFileVars: make(map[string]string)}
+	defer func() { plugin.Close(ctx.plugins) }()
+	if err := loadEnvFiles(args, ctx.envFileVars); err != nil {
+		return err
 	}
-	fmt.Println("Makefile parsed successfully")
 
-	if args.targetName == "" {
-		args.targetName = "all" // Default target
+	if args.printDatabase {
+		makefile, err := ctx.loadMakefile()
+		if err != nil {
+			return err
+		}
+		makefile.PrintDatabase(os.Stdout)
+		return nil
 	}
-
-	fmt.Printf("Attempting to execute target: %s\n", args.targetName)
-	if err := makefile.ExecuteTarget(args.targetName); err != nil {
-		return fmt.Errorf("error executing target: %w", err)
+	if args.why != "" {
+		makefile, err := ctx.loadMakefile()
+		if err != nil {
+			return err
+		}
+		return makefile.ExplainTarget(os.Stdout, args.why)
 	}
 
-	fmt.Println("Target execution completed")
-	return nil
+	return cmd.Run(ctx, args.commandArgs)
 }
 
 type arguments struct {
-	showHelp     bool
-	showVersion  bool
+	showHelp      bool
+	showVersion   bool
+	printDatabase bool
+	why           string
+	command       string
+	color         string
+	progress      bool
+	summary       bool
+	// output is the --output format of the build's own output
+	output string
+	// webhooks come from the config file only
+	webhooks []webhookConfig
+	// notify are the chat services of --notify, "all" for every one of
+	// notifiers, which come from the config file
+	notify    string
+	notifiers map[string]notifierConfig
+	// metricsAddr is where --metrics-addr serves /metrics
+	metricsAddr string
+	// junit is the file --junit writes the report to
+	junit string
+	// provenance is the file --provenance writes the statement to, signed
+	// with provenanceKey if set
+	provenance    string
+	provenanceKey string
+	provenanceEnv []string
+	silent        bool
+	noSilent      bool
+	noDotenv      bool
+	dryRun        bool
+	keepGoing     bool
+	directory     string
+	logFormat     string
+	jobs          int
+	shell         string
+	runner        string
+	envFiles      []string
+	// ignoreCase is set by --ignore-case and --no-ignore-case, nil keeps
+	// the default of the platform
+	ignoreCase *bool
+	// symlinks is the --symlinks mode, follow or link
+	symlinks string
+	// assumeOld and assumeNew are the files of -o and -W
+	assumeOld []string
+	assumeNew []string
+	// conflicts is the --conflicts mode, rule or file
+	conflicts string
+	// dirs is the --dirs mode, order-only or contents
+	dirs string
+	// futureOutOfDate is set by --future-out-of-date
+	futureOutOfDate bool
+	// parseCache is set by --parse-cache or parse_cache in the config
+	parseCache bool
+	// cacheDir and remoteCache are cache_dir and remote_cache in the
+	// config
+	cacheDir    string
+	remoteCache string
+	// maxLineLength is the --max-line-length limit, 0 for the default
+	maxLineLength int
+	// undefined is what --warn-undefined-variables makes of undefined
+	// variables
+	undefined smmake.UndefinedPolicy
+	// posix is set by --posix
+	posix bool
+	// cpuProfile and memProfile are the files --cpuprofile and
+	// --memprofile write
+	cpuProfile string
+	memProfile string
+	// imports are the --import KIND[=DIR] options
+	imports []string
+	// makefilePath is the file given with -f, see buildFile
 	makefilePath string
-	targetName   string
+	// overrides are the NAME=value variables given on the command line
+	overrides map[string]string
+	// commandArgs are the positional arguments and the options not known
+	// to smmake itself, passed on to the command
+	commandArgs []string
 }
 
-func parseArgs(args []string) arguments {
-	result := arguments{
-		makefilePath: "Makefile",
-	}
+func parseArgs(args []string) (arguments, error) {
+	var result arguments
 
 	for i := 0; i < len(args); i++ {
+		// Options of the command itself win over global ones of the same
+		// name, e.g. 'bench -n'
+		if result.command != "" && commandHasFlag(findCommandByName(result.command), args[i]) {
+			result.commandArgs = append(result.commandArgs, args[i])
+			continue
+		}
 		switch args[i] {
 		case "-h", "--help":
 			result.showHelp = true
-			return result
-		case "-v", "--version":
+		case "--version":
 			result.showVersion = true
-			return result
+			return result, nil
+		case "-v", "--verbose":
+			logging.Verbosity = max(logging.Verbosity, logging.LevelVerbose)
+		case "-vv":
+			logging.Verbosity = max(logging.Verbosity, logging.LevelTrace)
 		case "--debug":
-			DEBUG = true
+			logging.Verbosity = logging.LevelDebug
+		case "-p", "--print-data-base":
+			result.printDatabase = true
+		case "--why":
+			if i+1 < len(args) {
+				result.why = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--why option requires a target")
+			}
 		case "-f", "--file":
 			if i+1 < len(args) {
 				result.makefilePath = args[i+1]
 				i++
 			} else {
-				log.Fatal("Error: -f or --file option requires a filename")
+				return result, errors.New("-f or --file option requires a filename")
+			}
+		case "-j", "--jobs":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					return result, errors.New("-j or --jobs option requires a positive number")
+				}
+				result.jobs = n
+				i++
+			} else {
+				return result, errors.New("-j or --jobs option requires a number")
+			}
+		case "--max-line-length":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					return result, errors.New("--max-line-length option requires a positive number")
+				}
+				result.maxLineLength = n
+				i++
+			} else {
+				return result, errors.New("--max-line-length option requires a number")
+			}
+		case "--shell":
+			if i+1 < len(args) {
+				result.shell = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--shell option requires a program")
+			}
+		case "--runner":
+			if i+1 < len(args) {
+				result.runner = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--runner option requires a plugin name")
+			}
+		case "--env-file":
+			if i+1 < len(args) {
+				result.envFiles = append(result.envFiles, args[i+1])
+				i++
+			} else {
+				return result, errors.New("--env-file option requires a filename")
+			}
+		case "--import":
+			if i+1 < len(args) {
+				result.imports = append(result.imports, args[i+1])
+				i++
+			} else {
+				return result, errors.New("--import option requires a kind, e.g. npm")
+			}
+		case "--output":
+			if i+1 < len(args) {
+				result.output = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--output option requires a format")
+			}
+		case "--metrics-addr":
+			if i+1 < len(args) {
+				result.metricsAddr = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--metrics-addr option requires an address, e.g. :9100")
+			}
+		case "--notify":
+			result.notify = "all"
+		case "--junit":
+			if i+1 < len(args) {
+				result.junit = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--junit option requires a filename")
+			}
+		case "--provenance":
+			if i+1 < len(args) {
+				result.provenance = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--provenance option requires a filename")
+			}
+		case "--provenance-key":
+			if i+1 < len(args) {
+				result.provenanceKey = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--provenance-key option requires a key file")
+			}
+		case "--provenance-env":
+			if i+1 < len(args) {
+				result.provenanceEnv = append(result.provenanceEnv, strings.Split(args[i+1], ",")...)
+				i++
+			} else {
+				return result, errors.New("--provenance-env option requires variable names")
+			}
+		case "--log-format":
+			if i+1 < len(args) {
+				result.logFormat = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--log-format option requires a value")
+			}
+		case "--color":
+			result.color = "always"
+		case "--progress":
+			result.progress = true
+		case "--summary":
+			result.summary = true
+		case "-s", "--silent", "--quiet":
+			result.silent = true
+		case "--no-silent":
+			result.noSilent = true
+		case "--no-dotenv":
+			result.noDotenv = true
+		case "--parse-cache":
+			result.parseCache = true
+		case "--posix":
+			result.posix = true
+		case "--warn-undefined-variables":
+			result.undefined = smmake.UndefinedWarn
+		case "--cpuprofile":
+			if i+1 < len(args) {
+				result.cpuProfile = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--cpuprofile option requires a filename")
+			}
+		case "--memprofile":
+			if i+1 < len(args) {
+				result.memProfile = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--memprofile option requires a filename")
+			}
+		case "-n", "--dry-run", "--just-print":
+			result.dryRun = true
+		case "-k", "--keep-going":
+			result.keepGoing = true
+		case "--ignore-case", "--no-ignore-case":
+			ignoreCase := args[i] == "--ignore-case"
+			result.ignoreCase = &ignoreCase
+		case "-o", "--old-file", "--assume-old":
+			if i+1 < len(args) {
+				result.assumeOld = append(result.assumeOld, args[i+1])
+				i++
+			} else {
+				return result, fmt.Errorf("%s option requires a filename", args[i])
+			}
+		case "-W", "--what-if", "--new-file", "--assume-new":
+			if i+1 < len(args) {
+				result.assumeNew = append(result.assumeNew, args[i+1])
+				i++
+			} else {
+				return result, fmt.Errorf("%s option requires a filename", args[i])
+			}
+		case "--conflicts":
+			if i+1 < len(args) {
+				result.conflicts = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--conflicts option requires rule or file")
+			}
+		case "--dirs":
+			if i+1 < len(args) {
+				result.dirs = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--dirs option requires order-only or contents")
+			}
+		case "--future-out-of-date":
+			result.futureOutOfDate = true
+		case "--symlinks":
+			if i+1 < len(args) {
+				result.symlinks = args[i+1]
+				i++
+			} else {
+				return result, errors.New("--symlinks option requires follow or link")
+			}
+		case "-C", "--directory":
+			if i+1 < len(args) {
+				result.directory = args[i+1]
+				i++
+			} else {
+				return result, errors.New("-C or --directory option requires a directory")
 			}
 		default:
-			if result.targetName == "" {
-				result.targetName = args[i]
+			if strings.HasPrefix(args[i], "--color=") {
+				result.color = strings.TrimPrefix(args[i], "--color=")
+				continue
+			}
+			if policy, ok := strings.CutPrefix(args[i], "--warn-undefined-variables="); ok {
+				var err error
+				if result.undefined, err = smmake.ParseUndefinedPolicy(policy); err != nil {
+					return result, err
+				}
+				continue
+			}
+			if strings.HasPrefix(args[i], "--notify=") {
+				result.notify = strings.TrimPrefix(args[i], "--notify=")
+				continue
+			}
+			if name, value, ok := strings.Cut(args[i], "="); ok && isVariableName(name) {
+				if result.overrides == nil {
+					result.overrides = make(map[string]string)
+				}
+				result.overrides[name] = value
+				continue
+			}
+			// The first word names the command, unless it is a target
+			// for the implicit 'run' command
+			if result.command == "" && len(result.commandArgs) == 0 && findCommandByName(args[i]) != nil {
+				result.command = args[i]
+				continue
 			}
+			result.commandArgs = append(result.commandArgs, args[i])
 		}
 	}
 
-	return result
+	return result, nil
+}
+
+// buildFile returns the build file given with -f, or the first of
+// smmake.BuildFiles in the current directory
+func (a arguments) buildFile() string {
+	if a.makefilePath != "" {
+		return a.makefilePath
+	}
+	return smmake.FindBuildFile("")
+}
+
+// commandHasFlag reports whether cmd has an option named like arg
+func commandHasFlag(cmd *cliCommand, arg string) bool {
+	name, _, _ := strings.Cut(arg, "=")
+	for _, f := range cmd.Flags {
+		for _, n := range f.Names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isVariableName reports whether s can be the name in a NAME=value argument
+func isVariableName(s string) bool {
+	return s != "" && !strings.HasPrefix(s, "-") && !strings.ContainsAny(s, " \t:#$()")
 }
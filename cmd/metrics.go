@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"smmake"
+	"smmake/internal/logging"
+)
+
+// metricsBuckets are the upper bounds of the duration histograms, in
+// seconds, from quick recipes to long builds
+var metricsBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800}
+
+// metrics counts what the builds of a long running smmake did, for a
+// Prometheus server to scrape from /metrics. A target skipped by a hook,
+// i.e. restored by a cache plugin, is a cache hit and a target whose recipe
+// ran a miss.
+type metrics struct {
+	mutex    sync.Mutex
+	builds   map[string]int // by result
+	executed map[string]int // by target
+	failures map[string]int // by target
+	hits     int
+	recipes  map[string]*histogram // recipe durations by target
+	build    histogram
+}
+
+// histogram is a Prometheus histogram of durations in seconds
+type histogram struct {
+	counts []int // per bucket, not cumulative, the last one for +Inf
+	sum    float64
+	total  int
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]int, len(metricsBuckets)+1)
+	}
+	i := sort.SearchFloat64s(metricsBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.total++
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		builds:   make(map[string]int),
+		executed: make(map[string]int),
+		failures: make(map[string]int),
+		recipes:  make(map[string]*histogram),
+	}
+}
+
+// attach counts the targets of the builds of m
+func (c *metrics) attach(m *smmake.Makefile) {
+	m.AddHooks(smmake.Hooks{OnTargetFinish: c.targetFinish})
+}
+
+func (c *metrics) targetFinish(e smmake.TargetEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch {
+	case e.Ran:
+		c.executed[e.Name]++
+		if e.Err != nil {
+			c.failures[e.Name]++
+		}
+		if c.recipes[e.Name] == nil {
+			c.recipes[e.Name] = &histogram{}
+		}
+		c.recipes[e.Name].observe(e.Duration.Seconds())
+	case e.Err == nil && e.Target != nil:
+		c.hits++
+	}
+}
+
+// buildFinished counts a build of the goals that took d
+func (c *metrics) buildFinished(d time.Duration, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	c.builds[result]++
+	c.build.observe(d.Seconds())
+}
+
+// write writes the metrics in the Prometheus text format
+func (c *metrics) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP smmake_builds_total Builds by result.")
+	fmt.Fprintln(w, "# TYPE smmake_builds_total counter")
+	for _, result := range []string{"failure", "success"} {
+		fmt.Fprintf(w, "smmake_builds_total{result=%q} %d\n", result, c.builds[result])
+	}
+	writeHistogram(w, "smmake_build_duration_seconds", "Wall time of builds.", map[string]*histogram{"": &c.build})
+
+	fmt.Fprintln(w, "# HELP smmake_targets_executed_total Targets whose recipe ran.")
+	fmt.Fprintln(w, "# TYPE smmake_targets_executed_total counter")
+	for _, name := range sortedNames(c.executed) {
+		fmt.Fprintf(w, "smmake_targets_executed_total{target=%s} %d\n", metricsLabel(name), c.executed[name])
+	}
+	fmt.Fprintln(w, "# HELP smmake_target_failures_total Targets whose recipe failed.")
+	fmt.Fprintln(w, "# TYPE smmake_target_failures_total counter")
+	for _, name := range sortedNames(c.failures) {
+		fmt.Fprintf(w, "smmake_target_failures_total{target=%s} %d\n", metricsLabel(name), c.failures[name])
+	}
+	misses := 0
+	for _, n := range c.executed {
+		misses += n
+	}
+	fmt.Fprintln(w, "# HELP smmake_cache_hits_total Targets restored by a cache instead of running their recipe.")
+	fmt.Fprintln(w, "# TYPE smmake_cache_hits_total counter")
+	fmt.Fprintf(w, "smmake_cache_hits_total %d\n", c.hits)
+	fmt.Fprintln(w, "# HELP smmake_cache_misses_total Targets whose recipe ran.")
+	fmt.Fprintln(w, "# TYPE smmake_cache_misses_total counter")
+	fmt.Fprintf(w, "smmake_cache_misses_total %d\n", misses)
+	writeHistogram(w, "smmake_recipe_duration_seconds", "Wall time of the recipes of targets.", c.recipes)
+}
+
+// writeHistogram writes a histogram per target, or a single histogram for
+// the target ""
+func writeHistogram(w io.Writer, name, help string, byTarget map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, target := range sortedNames(byTarget) {
+		h := byTarget[target]
+		labels := ""
+		if target != "" {
+			labels = "target=" + metricsLabel(target) + ","
+		}
+		cumulative := 0
+		for i, bound := range metricsBuckets {
+			if h.counts != nil {
+				cumulative += h.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.total)
+		labels = strings.TrimSuffix(labels, ",")
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.total)
+	}
+}
+
+// serveMetrics serves the metrics on /metrics at addr until smmake exits
+func serveMetrics(c *metrics, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error serving metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		c.write(w)
+	})
+	logging.Verbosef("Serving metrics on http://%s/metrics", listener.Addr())
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// metricsLabel quotes a label value
+func metricsLabel(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// sortedNames returns the keys of a map, sorted
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
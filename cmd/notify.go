@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"smmake/internal/logging"
+)
+
+// defaultNotifyTemplate is the message of a notification unless the config
+// file has its own
+const defaultNotifyTemplate = `{{if eq .Status "success"}}✅{{else}}❌{{end}} smmake {{join .Goals " "}} {{if eq .Status "success"}}succeeded{{else}}failed{{end}} in {{.Duration}}
+{{- if .Failed}}
+Failed: {{join .Failed ", "}}{{end}}`
+
+// notifierConfig is a chat service of the config file, notified of the
+// builds run with --notify:
+//
+//	notify:
+//	  slack:
+//	    url: https://hooks.slack.com/services/T000/B000/XXXX
+//	  teams:
+//	    url: https://example.webhook.office.com/webhookb2/...
+//	    on: [failure]
+type notifierConfig struct {
+	URL string `yaml:"url"`
+	// On lists the results to notify of, success and failure, both if
+	// empty
+	On []string `yaml:"on"`
+	// Template is a text/template over the buildResult for the message,
+	// defaultNotifyTemplate if empty. The log excerpt of a failure follows
+	// it.
+	Template string `yaml:"template"`
+}
+
+// notifiers format a message for the payload of a chat service's incoming
+// webhook
+var notifiers = map[string]func(text, excerpt string, success bool) any{
+	"slack": slackPayload,
+	"teams": teamsPayload,
+}
+
+func slackPayload(text, excerpt string, success bool) any {
+	if excerpt != "" {
+		text += "\n```\n" + excerpt + "\n```"
+	}
+	return map[string]string{"text": text}
+}
+
+// teamsPayload is a message card, which Teams incoming webhooks render with
+// Markdown
+func teamsPayload(text, excerpt string, success bool) any {
+	title, rest, _ := strings.Cut(text, "\n")
+	color := "2EB886"
+	if !success {
+		color = "D00000"
+	}
+	// Teams needs blank lines between paragraphs and indents code blocks
+	body := strings.ReplaceAll(rest, "\n", "\n\n")
+	if excerpt != "" {
+		body += "\n\n    " + strings.ReplaceAll(excerpt, "\n", "\n    ")
+	}
+	return map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    title,
+		"themeColor": color,
+		"title":      title,
+		"text":       strings.TrimSpace(body),
+	}
+}
+
+// notifyServices returns the chat services --notify selected, all the
+// configured ones for "all"
+func notifyServices(selected string, services map[string]notifierConfig) ([]string, error) {
+	if len(services) == 0 {
+		return nil, fmt.Errorf("--notify: no chat services configured, add %s under notify in the config file", strings.Join(sortedNames(notifiers), " or "))
+	}
+	if selected == "all" {
+		return sortedNames(services), nil
+	}
+	names := strings.Split(selected, ",")
+	for _, name := range names {
+		if notifiers[name] == nil {
+			return nil, fmt.Errorf("--notify: unknown service '%s', known are %s", name, strings.Join(sortedNames(notifiers), ", "))
+		}
+		if _, ok := services[name]; !ok {
+			return nil, fmt.Errorf("--notify: %s is not configured in the config file", name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// notify sends the result of a build to the chat services. Services that
+// fail to take the message are reported as warnings.
+func notify(names []string, services map[string]notifierConfig, r buildResult) {
+	for _, name := range names {
+		service := services[name]
+		if len(service.On) > 0 && !contains(service.On, r.Status) {
+			continue
+		}
+		text := service.Template
+		if text == "" {
+			text = defaultNotifyTemplate
+		}
+		message, err := renderTemplate(text, r)
+		if err != nil {
+			logging.Warnf("notify %s: %v", name, err)
+			continue
+		}
+		body, err := json.Marshal(notifiers[name](string(message), r.Excerpt, r.Status == "success"))
+		if err == nil {
+			err = post(service.URL, "", nil, body)
+		}
+		if err != nil {
+			logging.Warnf("notify %s: %v", name, err)
+		}
+	}
+}
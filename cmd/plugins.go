@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"smmake"
+	"smmake/internal/color"
+	"smmake/internal/logging"
+	"smmake/plugin"
+)
+
+// projectPluginDir is looked up in the current directory
+const projectPluginDir = ".smmake/plugins"
+
+// pluginDirs returns where plugins are looked up: the project's directory,
+// then ~/.config/smmake/plugins
+func pluginDirs() []string {
+	dirs := []string{projectPluginDir}
+	if path := userConfigPath(); path != "" {
+		dirs = append(dirs, filepath.Join(filepath.Dir(path), "plugins"))
+	}
+	return dirs
+}
+
+// loadPlugins starts the plugins once and registers their functions, so
+// they are available when the Makefile is parsed
+func (ctx *cliContext) loadPlugins() error {
+	if ctx.pluginsLoaded {
+		return nil
+	}
+	ctx.pluginsLoaded = true
+	if ctx.args.remoteCache != "" {
+		// the plugins inherit the environment, as recipes do
+		if err := os.Setenv(plugin.RemoteCacheEnv, ctx.args.remoteCache); err != nil {
+			return err
+		}
+	}
+	plugins, err := plugin.Discover(pluginDirs()...)
+	if err != nil {
+		return err
+	}
+	for _, p := range plugins {
+		logging.Debugf("Loaded plugin %s from %s", p.Name, p.Path)
+		p.OnError = func(err error) { logging.Warnf("%v", err) }
+		p.RegisterFunctions(smmake.DefaultFunctions)
+	}
+	ctx.plugins = plugins
+	return nil
+}
+
+// attachPlugins makes the cache plugins cache the targets of makefile, and
+// the plugin selected by --runner run its recipes
+func (ctx *cliContext) attachPlugins(makefile *smmake.Makefile) error {
+	for _, p := range ctx.plugins {
+		if p.Cache {
+			makefile.AddHooks(p.Hooks(makefile))
+		}
+	}
+	if ctx.args.runner == "" {
+		return nil
+	}
+	for _, p := range ctx.plugins {
+		if p.Name == ctx.args.runner && p.Runner {
+			makefile.Runner = p.RecipeRunner()
+			return nil
+		}
+	}
+	return fmt.Errorf("no plugin named '%s' runs recipes", ctx.args.runner)
+}
+
+func listPlugins(ctx *cliContext, args []string) error {
+	if _, _, err := parseCommandFlags("plugins", nil, args); err != nil {
+		return err
+	}
+	if err := ctx.loadPlugins(); err != nil {
+		return err
+	}
+	if len(ctx.plugins) == 0 {
+		fmt.Printf("No plugins in %s\n", strings.Join(pluginDirs(), " or "))
+		return nil
+	}
+	for _, p := range ctx.plugins {
+		var provides []string
+		if len(p.Functions) > 0 {
+			provides = append(provides, "functions "+strings.Join(p.Functions, ", "))
+		}
+		if p.Runner {
+			provides = append(provides, "runner")
+		}
+		if p.Cache {
+			provides = append(provides, "cache")
+		}
+		fmt.Fprintf(os.Stdout, "%-16s %s (%s)\n", color.Target(p.Name), p.Path, strings.Join(provides, "; "))
+	}
+	return nil
+}
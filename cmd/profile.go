@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+
+	"smmake/internal/logging"
+)
+
+// startProfiling starts the CPU profile of --cpuprofile. The returned
+// function stops it and writes the heap profile of --memprofile, once smmake
+// is done.
+func startProfiling(args arguments) (func(), error) {
+	var cpu *os.File
+	if args.cpuProfile != "" {
+		var err error
+		if cpu, err = os.Create(args.cpuProfile); err != nil {
+			return nil, fmt.Errorf("error creating CPU profile: %v", err)
+		}
+		if err := rpprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, fmt.Errorf("error starting CPU profile: %v", err)
+		}
+	}
+	return func() {
+		if cpu != nil {
+			rpprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if args.memProfile != "" {
+			if err := writeHeapProfile(args.memProfile); err != nil {
+				logging.Errorf("error writing memory profile: %v", err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(name string) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	// the profile shows the live objects as of the last collection
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// handlePprof serves the profiles of net/http/pprof under /debug/pprof/,
+// e.g. for 'go tool pprof http://ADDR/debug/pprof/profile'
+func handlePprof(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+}
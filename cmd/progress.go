@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"smmake"
+	"smmake/internal/color"
+)
+
+// progressInterval is how often a progress line is printed when the output
+// is not a terminal and the line cannot be updated in place
+const progressInterval = 5 * time.Second
+
+// progress renders a "[done/total] target" indicator from the executor hooks.
+//
+// On a terminal the line is redrawn in place and cleared while commands
+// print their output. Elsewhere (CI logs, pipes) a plain line is printed at
+// most once per progressInterval.
+type progress struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	tty       bool
+	total     int
+	done      int
+	running   []string
+	lastPrint time.Time
+	visible   bool
+}
+
+// attachProgress installs a progress indicator for building goals on m
+func attachProgress(m *smmake.Makefile, goals []string) (*progress, error) {
+	nodes, err := m.BuildGraph(goals...)
+	if err != nil {
+		return nil, err
+	}
+	p := &progress{
+		out:   os.Stdout,
+		tty:   color.IsTerminal(os.Stdout),
+		total: len(nodes),
+	}
+	m.AddHooks(smmake.Hooks{
+		OnTargetStart:   p.targetStart,
+		OnTargetFinish:  p.targetFinish,
+		OnCommandStart:  func(smmake.CommandEvent) { p.clear() },
+		OnCommandFinish: func(smmake.CommandEvent) { p.redraw() },
+	})
+	return p, nil
+}
+
+func (p *progress) targetStart(e smmake.TargetEvent) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.running = append(p.running, e.Name)
+	p.render(false)
+	return nil
+}
+
+func (p *progress) targetFinish(e smmake.TargetEvent) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, r := range p.running {
+		if r == e.Name {
+			p.running = append(p.running[:i], p.running[i+1:]...)
+			break
+		}
+	}
+	if e.Err == nil {
+		p.done++
+	}
+	p.render(p.done == p.total)
+}
+
+// clear removes the in-place progress line so command output starts on a
+// clean line
+func (p *progress) clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.tty && p.visible {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.visible = false
+	}
+}
+
+func (p *progress) redraw() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.render(false)
+}
+
+// render prints the current state. Callers must hold the mutex.
+func (p *progress) render(final bool) {
+	line := fmt.Sprintf("[%d/%d]", p.done, p.total)
+	if len(p.running) > 0 {
+		line += " building " + color.Target(p.running[len(p.running)-1])
+		if len(p.running) > 1 {
+			line += fmt.Sprintf(" (+%d more)", len(p.running)-1)
+		}
+	}
+
+	if p.tty {
+		fmt.Fprint(p.out, "\r\033[K"+line)
+		p.visible = true
+		if final {
+			fmt.Fprintln(p.out)
+			p.visible = false
+		}
+		return
+	}
+
+	if final || time.Since(p.lastPrint) >= progressInterval {
+		fmt.Fprintln(p.out, line)
+		p.lastPrint = time.Now()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"smmake"
+)
+
+// targetResult is how a target of the build ended, for the test reports
+type targetResult struct {
+	Name string
+	Ran  bool
+	// Skipped is why a target with a rule didn't run, empty if it ran or
+	// failed before it could
+	Skipped  string
+	Duration time.Duration
+	Err      error
+	// Stdout and Stderr hold what its recipe lines printed
+	Stdout, Stderr bytes.Buffer
+}
+
+// buildReport collects the results of the targets of a build from its
+// events, for the reporters that write them once the build is over
+type buildReport struct {
+	makefile *smmake.Makefile
+	sub      *smmake.Subscription
+	done     chan struct{}
+	start    time.Time
+	duration time.Duration
+	results  []*targetResult
+}
+
+// collectResults starts collecting the results of the next build of m
+func collectResults(m *smmake.Makefile) *buildReport {
+	r := &buildReport{makefile: m, sub: m.Subscribe(), done: make(chan struct{}), start: time.Now()}
+	go r.collect()
+	return r
+}
+
+func (r *buildReport) collect() {
+	defer close(r.done)
+	byName := make(map[string]*targetResult)
+	result := func(name string) *targetResult {
+		if byName[name] == nil {
+			byName[name] = &targetResult{Name: name}
+		}
+		return byName[name]
+	}
+	for e := range r.sub.Events() {
+		switch e := e.(type) {
+		case smmake.TargetStarted:
+			result(e.Target).Ran = true
+		case smmake.TargetSkipped:
+			result(e.Target).Skipped = e.Reason
+		case smmake.CommandOutput:
+			if e.Stderr {
+				result(e.Target).Stderr.Write(e.Data)
+			} else {
+				result(e.Target).Stdout.Write(e.Data)
+			}
+		case smmake.TargetFinished:
+			t := result(e.Target)
+			t.Duration, t.Err = e.Duration, e.Err
+			var depErr *smmake.DependencyError
+			switch {
+			case t.Ran:
+			case t.Err == nil && r.makefile.Targets[t.Name] == nil:
+				// A source file, not something the build made
+				continue
+			case errors.As(t.Err, &depErr) && depErr.Target == t.Name:
+				t.Skipped = "prerequisite '" + depErr.Dependency + "' failed"
+			case errors.Is(t.Err, smmake.ErrStopped):
+				t.Skipped = t.Err.Error()
+			}
+			r.results = append(r.results, t)
+		case smmake.BuildFinished:
+			r.duration = e.Duration
+			return
+		}
+	}
+}
+
+// wait returns the results of the targets in the order they finished, once
+// the build is over
+func (r *buildReport) wait() []*targetResult {
+	<-r.done
+	r.sub.Close()
+	return r.results
+}
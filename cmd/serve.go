@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"smmake"
+	"smmake/internal/logging"
+)
+
+// defaultServeAddr keeps the server to the local machine unless --addr
+// says otherwise
+const defaultServeAddr = "localhost:7070"
+
+// maxRequestBody limits the body of POST /builds
+const maxRequestBody = 1 << 20
+
+// maxServerBuilds is how many builds the server remembers, the oldest
+// finished ones are forgotten beyond that
+const maxServerBuilds = 100
+
+var serveFlags = []cliFlag{
+	{Names: []string{"--addr"}, Value: "ADDR", Help: "Address to listen on (default " + defaultServeAddr + ")"},
+	{Names: []string{"--token"}, Value: "TOKEN", Help: "Require 'Authorization: Bearer TOKEN' (default $SMMAKE_SERVE_TOKEN)"},
+	{Names: []string{"--pprof"}, Help: "Serve the profiles of the server under /debug/pprof/"},
+}
+
+// server runs the builds requested over HTTP, one at a time, each from a
+// freshly parsed Makefile with the variables of its request
+type server struct {
+	ctx     *cliContext
+	token   string
+	metrics *metrics
+
+	// hosts are the Host headers requests may have, any if nil, so a web
+	// page can't reach a local server through DNS rebinding
+	hosts map[string]bool
+
+	mutex  sync.Mutex
+	builds []*serverBuild
+	lastID int
+	// queue is held by the build that is running
+	queue sync.Mutex
+}
+
+// serverBuild is a build requested from the server and its event log
+type serverBuild struct {
+	id        string
+	targets   []string
+	variables map[string]string
+
+	mutex    sync.Mutex
+	state    string // queued, running, success or failure
+	queued   time.Time
+	started  time.Time
+	finished time.Time
+	result   *buildResult
+	events   []json.RawMessage
+	// changed is closed and replaced whenever an event is added or the
+	// status changes
+	changed chan struct{}
+}
+
+// buildStatus is how a build is reported by the API
+type buildStatus struct {
+	ID        string            `json:"id"`
+	Targets   []string          `json:"targets"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Status    string            `json:"status"`
+	Queued    time.Time         `json:"queued"`
+	Started   *time.Time        `json:"started,omitempty"`
+	Finished  *time.Time        `json:"finished,omitempty"`
+	Result    *buildResult      `json:"result,omitempty"`
+}
+
+func serveBuilds(ctx *cliContext, args []string) error {
+	flags, _, err := parseCommandFlags("serve", serveFlags, args)
+	if err != nil {
+		return err
+	}
+	if err := ctx.loadPlugins(); err != nil {
+		return err
+	}
+	// Parse once up front, so a broken Makefile fails here rather than in
+	// every build
+	if _, err := ctx.loadMakefile(); err != nil {
+		return err
+	}
+	addr := flags["addr"]
+	if addr == "" {
+		addr = defaultServeAddr
+	}
+	s := &server{ctx: ctx, token: flags["token"], metrics: newMetrics()}
+	if s.token == "" {
+		s.token = os.Getenv("SMMAKE_SERVE_TOKEN")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid --addr '%s': %v", addr, err)
+	}
+	if !isLoopback(host) && s.token == "" {
+		return fmt.Errorf("serving on %s needs --token or SMMAKE_SERVE_TOKEN, anyone who can reach it could run builds", addr)
+	}
+	s.hosts = allowedHosts(host, port)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /targets", s.listTargets)
+	mux.HandleFunc("GET /builds", s.listBuilds)
+	mux.HandleFunc("POST /builds", s.startBuild)
+	mux.HandleFunc("GET /builds/{id}", s.buildStatus)
+	mux.HandleFunc("GET /builds/{id}/events", s.buildEvents)
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.metrics.write(w)
+	})
+	if flags["pprof"] != "" {
+		handlePprof(mux)
+	}
+	logging.Infof("Serving %s on http://%s", ctx.args.buildFile(), addr)
+	server := &http.Server{
+		Addr:    addr,
+		Handler: s.authorize(mux),
+		// A request is small, the event streams its response can turn
+		// into are what may take long
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+	}
+	return server.ListenAndServe()
+}
+
+// recipeVariables decide what runs the recipes rather than what they build,
+// so a build request, which may only pick targets and settings, can't set
+// them
+var recipeVariables = map[string]bool{
+	"SHELL":       true,
+	".SHELLFLAGS": true,
+	"MAKESHELL":   true,
+	"MAKEFLAGS":   true,
+	"MFLAGS":      true,
+}
+
+// isLoopback reports whether a listen host only accepts connections from
+// the local machine
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// allowedHosts returns the Host headers of requests to a listen address,
+// nil for one listening on every interface, whose names aren't known
+func allowedHosts(host, port string) map[string]bool {
+	if host == "" || net.ParseIP(host) != nil && net.ParseIP(host).IsUnspecified() {
+		return nil
+	}
+	names := []string{host}
+	if isLoopback(host) {
+		names = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	hosts := make(map[string]bool)
+	for _, name := range names {
+		hosts[net.JoinHostPort(name, port)] = true
+	}
+	return hosts
+}
+
+// authorize rejects requests without the token, if there is one, and the
+// ones a web page could have sent: to another Host than the server's, from
+// another Origin, or POSTs that aren't JSON, which browsers send without
+// asking the server first
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.hosts != nil && !s.hosts[r.Host] {
+			httpError(w, http.StatusForbidden, "unexpected Host '"+r.Host+"'")
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			httpError(w, http.StatusForbidden, "cross-origin requests are not allowed")
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				httpError(w, http.StatusUnsupportedMediaType, "requests must be application/json")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		}
+		if s.token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				httpError(w, http.StatusUnauthorized, "missing or wrong token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) listTargets(w http.ResponseWriter, r *http.Request) {
+	makefile, err := s.ctx.loadMakefile()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	type target struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		Section     string `json:"section,omitempty"`
+		Phony       bool   `json:"phony"`
+	}
+	phony := make(map[string]bool)
+	if t := makefile.Targets[".PHONY"]; t != nil {
+		for _, name := range t.Dependencies {
+			phony[name] = true
+		}
+	}
+	targets := []target{}
+	for _, name := range sortedNames(makefile.Targets) {
+		t := makefile.Targets[name]
+		if t.Pattern || strings.HasPrefix(name, ".") {
+			continue
+		}
+		targets = append(targets, target{Name: name, Description: t.Description, Section: t.Section, Phony: phony[name]})
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+func (s *server) listBuilds(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	builds := append([]*serverBuild(nil), s.builds...)
+	s.mutex.Unlock()
+	statuses := []buildStatus{}
+	for _, b := range builds {
+		statuses = append(statuses, b.status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *server) startBuild(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target    string            `json:"target"`
+		Targets   []string          `json:"targets"`
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	targets := req.Targets
+	if req.Target != "" {
+		targets = append([]string{req.Target}, targets...)
+	}
+	if len(targets) == 0 {
+		targets = []string{"all"}
+	}
+	for name := range req.Variables {
+		if !isVariableName(name) {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid variable name '%s'", name))
+			return
+		}
+		if recipeVariables[name] {
+			httpError(w, http.StatusForbidden, fmt.Sprintf("variable '%s' can't be set by a build request", name))
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	s.lastID++
+	b := &serverBuild{
+		id:        strconv.Itoa(s.lastID),
+		targets:   targets,
+		variables: req.Variables,
+		state:     "queued",
+		queued:    time.Now(),
+		changed:   make(chan struct{}),
+	}
+	s.builds = append(s.builds, b)
+	s.forgetBuilds()
+	s.mutex.Unlock()
+	go s.run(b)
+
+	w.Header().Set("Location", "/builds/"+b.id)
+	writeJSON(w, http.StatusAccepted, b.status())
+}
+
+func (s *server) buildStatus(w http.ResponseWriter, r *http.Request) {
+	b := s.build(r.PathValue("id"))
+	if b == nil {
+		httpError(w, http.StatusNotFound, "no such build")
+		return
+	}
+	writeJSON(w, http.StatusOK, b.status())
+}
+
+// buildEvents streams the events of a build as server-sent events, from the
+// first one, until the build is over
+func (s *server) buildEvents(w http.ResponseWriter, r *http.Request) {
+	b := s.build(r.PathValue("id"))
+	if b == nil {
+		httpError(w, http.StatusNotFound, "no such build")
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := 0
+	for {
+		b.mutex.Lock()
+		events := b.events[sent:]
+		done := b.state == "success" || b.state == "failure"
+		changed := b.changed
+		b.mutex.Unlock()
+
+		for _, e := range events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+		}
+		sent += len(events)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// forgetBuilds drops the oldest finished builds beyond maxServerBuilds.
+// Queued and running builds are kept. s.mutex must be held.
+func (s *server) forgetBuilds() {
+	excess := len(s.builds) - maxServerBuilds
+	kept := s.builds[:0]
+	for _, b := range s.builds {
+		if excess > 0 && b.done() {
+			excess--
+			continue
+		}
+		kept = append(kept, b)
+	}
+	clear(s.builds[len(kept):])
+	s.builds = kept
+}
+
+// done reports whether a build is over
+func (b *serverBuild) done() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state == "success" || b.state == "failure"
+}
+
+// build returns the build with the given id, or nil
+func (s *server) build(id string) *serverBuild {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, b := range s.builds {
+		if b.id == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// run builds b once the builds before it are done
+func (s *server) run(b *serverBuild) {
+	s.queue.Lock()
+	defer s.queue.Unlock()
+	b.setStatus("running")
+
+	// Every build parses the Makefile with its own variables, so builds
+	// don't see each other's settings
+	args := s.ctx.args
+	args.overrides = make(map[string]string)
+	for name, value := range s.ctx.args.overrides {
+		args.overrides[name] = value
+	}
+	for name, value := range b.variables {
+		args.overrides[name] = value
+	}
+	ctx := &cliContext{args: args, envFileVars: s.ctx.envFileVars, plugins: s.ctx.plugins, pluginsLoaded: s.ctx.pluginsLoaded}
+
+	start := time.Now()
+	makefile, err := ctx.loadMakefile()
+	var report *buildReport
+	if err == nil {
+		makefile.Output = func(string) io.Writer { return io.Discard }
+		s.metrics.attach(makefile)
+		report = collectResults(makefile)
+		sub := makefile.Subscribe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for e := range sub.Events() {
+				b.addEvent(e)
+				if _, ok := e.(smmake.BuildFinished); ok {
+					return
+				}
+			}
+		}()
+		err = makefile.Build(context.Background(), b.targets, ctx.buildOptions()...)
+		<-done
+		sub.Close()
+	}
+	s.metrics.buildFinished(time.Since(start), err)
+
+	var result buildResult
+	if report != nil {
+		result = newBuildResult(makefile, b.targets, report, time.Since(start), err)
+	} else {
+		result = buildResult{Goals: b.targets, Status: "failure", Error: err.Error(), Failed: []string{}}
+	}
+	b.mutex.Lock()
+	b.result = &result
+	b.mutex.Unlock()
+	b.setStatus(result.Status)
+}
+
+func (b *serverBuild) setStatus(status string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.state = status
+	switch status {
+	case "running":
+		b.started = time.Now()
+	case "success", "failure":
+		b.finished = time.Now()
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *serverBuild) addEvent(e smmake.Event) {
+	data, err := json.Marshal(eventJSON(e))
+	if err != nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.events = append(b.events, data)
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *serverBuild) status() buildStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := buildStatus{ID: b.id, Targets: b.targets, Variables: b.variables, Status: b.state, Queued: b.queued, Result: b.result}
+	if !b.started.IsZero() {
+		s.Started = &b.started
+	}
+	if !b.finished.IsZero() {
+		s.Finished = &b.finished
+	}
+	return s
+}
+
+// eventJSON returns the fields of an event for the event stream, with its
+// type in "type"
+func eventJSON(e smmake.Event) map[string]any {
+	fields := map[string]any{"time": e.EventTime()}
+	setErr := func(err error) {
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+	}
+	switch e := e.(type) {
+	case smmake.BuildStarted:
+		fields["type"], fields["goals"] = "build_started", e.Goals
+	case smmake.BuildFinished:
+		fields["type"], fields["duration_ms"] = "build_finished", e.Duration.Milliseconds()
+		setErr(e.Err)
+	case smmake.TargetQueued:
+		fields["type"], fields["target"] = "target_queued", e.Target
+	case smmake.TargetStarted:
+		fields["type"], fields["target"] = "target_started", e.Target
+	case smmake.TargetSkipped:
+		fields["type"], fields["target"], fields["reason"] = "target_skipped", e.Target, e.Reason
+	case smmake.TargetFinished:
+		fields["type"], fields["target"], fields["duration_ms"] = "target_finished", e.Target, e.Duration.Milliseconds()
+		setErr(e.Err)
+	case smmake.CommandStarted:
+		fields["type"], fields["target"], fields["command"] = "command_started", e.Target, e.Command.Cmd
+	case smmake.CommandOutput:
+		fields["type"], fields["target"], fields["data"] = "command_output", e.Target, string(e.Data)
+		if e.Stderr {
+			fields["stream"] = "stderr"
+		} else {
+			fields["stream"] = "stdout"
+		}
+	case smmake.CommandFinished:
+		fields["type"], fields["target"], fields["command"] = "command_finished", e.Target, e.Command.Cmd
+		fields["exit_code"] = e.Result.ExitCode
+		setErr(e.Err)
+	}
+	return fields
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
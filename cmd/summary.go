@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"smmake"
+	"smmake/internal/color"
+)
+
+// summarySlowest is how many of the slowest targets the summary lists
+const summarySlowest = 5
+
+// buildSummary records the outcome and duration of every target from the
+// executor hooks and prints a report once the build is over
+type buildSummary struct {
+	mutex     sync.Mutex
+	start     time.Time
+	durations map[string]time.Duration
+	skipped   int
+	failed    []string
+	// notBuilt are the targets a failed prerequisite or the end of the
+	// build kept from running
+	notBuilt []string
+}
+
+// attachSummary installs a build summary on m
+func attachSummary(m *smmake.Makefile) *buildSummary {
+	s := &buildSummary{
+		start:     time.Now(),
+		durations: make(map[string]time.Duration),
+	}
+	m.AddHooks(smmake.Hooks{
+		OnTargetFinish: s.targetFinish,
+	})
+	return s
+}
+
+func (s *buildSummary) targetFinish(e smmake.TargetEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var depErr *smmake.DependencyError
+	switch {
+	case e.Ran && e.Err != nil:
+		s.failed = append(s.failed, e.Name)
+		s.durations[e.Name] = e.Duration
+	case e.Ran:
+		s.durations[e.Name] = e.Duration
+	case e.Err == nil:
+		// Finished without running a recipe, e.g. an existing file
+		s.skipped++
+	case errors.As(e.Err, &depErr) && depErr.Target == e.Name, errors.Is(e.Err, smmake.ErrStopped):
+		s.notBuilt = append(s.notBuilt, e.Name)
+	default:
+		// It can't be made, e.g. it is unknown
+		s.failed = append(s.failed, e.Name)
+	}
+}
+
+// print writes the report: how many targets were executed, skipped,
+// failed and not built, the total wall time and the slowest targets
+func (s *buildSummary) print(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	executed := 0
+	for name := range s.durations {
+		if !slices.Contains(s.failed, name) {
+			executed++
+		}
+	}
+	failed := fmt.Sprintf("%d failed", len(s.failed))
+	if len(s.failed) > 0 {
+		failed = color.Error(failed)
+	}
+	fmt.Fprintf(w, "%s %d executed, %d skipped, %s, %d not built in %s\n", color.Colorize(color.Bold, "Build summary:"),
+		executed, s.skipped, failed, len(s.notBuilt), time.Since(s.start).Round(time.Millisecond))
+	for _, name := range s.failed {
+		fmt.Fprintf(w, "  failed: %s\n", color.Target(name))
+	}
+	for _, name := range s.notBuilt {
+		fmt.Fprintf(w, "  not built: %s\n", color.Target(name))
+	}
+
+	names := make([]string, 0, len(s.durations))
+	for name := range s.durations {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s.durations[names[i]] != s.durations[names[j]] {
+			return s.durations[names[i]] > s.durations[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > summarySlowest {
+		names = names[:summarySlowest]
+	}
+	if len(names) == 0 {
+		return
+	}
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	fmt.Fprintln(w, "Slowest targets:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s%*s  %s\n", color.Target(name), width-len(name), "", s.durations[name].Round(time.Millisecond))
+	}
+}
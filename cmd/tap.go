@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"smmake"
+)
+
+// tapReporter prints the targets of a build as Test Anything Protocol
+// lines as they finish. Everything else on stdout, the recipe lines and
+// their output, becomes a diagnostic line starting with '#', so TAP
+// consumers like prove can read the output as it is.
+type tapReporter struct {
+	mutex sync.Mutex
+	out   io.Writer
+	count int
+	// partial holds the last, unterminated line of output of each target
+	partial map[string][]byte
+}
+
+// attachTAP installs a TAP reporter on m writing to out
+func attachTAP(m *smmake.Makefile, out io.Writer) *tapReporter {
+	r := &tapReporter{out: out, partial: make(map[string][]byte)}
+	fmt.Fprintln(out, "TAP version 13")
+	m.Silent = true
+	m.Output = func(target string) io.Writer { return tapOutput{r, target} }
+	m.AddHooks(smmake.Hooks{
+		OnTargetFinish: r.targetFinish,
+		OnCommandStart: func(e smmake.CommandEvent) {
+			if !e.Command.Silent {
+				r.comment([]byte(e.Command.Cmd + "\n"))
+			}
+		},
+	})
+	return r
+}
+
+func (r *tapReporter) targetFinish(e smmake.TargetEvent) {
+	if e.Target == nil {
+		// Source files aren't tests
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if rest := r.partial[e.Name]; len(rest) > 0 {
+		fmt.Fprintf(r.out, "# %s\n", rest)
+		delete(r.partial, e.Name)
+	}
+	r.count++
+
+	var depErr *smmake.DependencyError
+	switch {
+	case !e.Ran && errors.As(e.Err, &depErr) && depErr.Target == e.Name:
+		fmt.Fprintf(r.out, "ok %d - %s # SKIP prerequisite '%s' failed\n", r.count, e.Name, depErr.Dependency)
+	case !e.Ran && errors.Is(e.Err, smmake.ErrStopped):
+		fmt.Fprintf(r.out, "ok %d - %s # SKIP %v\n", r.count, e.Name, e.Err)
+	case e.Err != nil:
+		fmt.Fprintf(r.out, "not ok %d - %s\n", r.count, e.Name)
+		fmt.Fprintln(r.out, "  ---")
+		fmt.Fprintf(r.out, "  message: %s\n", yamlQuote(e.Err.Error()))
+		fmt.Fprintf(r.out, "  duration_ms: %d\n", e.Duration.Milliseconds())
+		fmt.Fprintln(r.out, "  ...")
+	case !e.Ran:
+		fmt.Fprintf(r.out, "ok %d - %s # SKIP up to date\n", r.count, e.Name)
+	default:
+		fmt.Fprintf(r.out, "ok %d - %s\n", r.count, e.Name)
+	}
+}
+
+// finish prints the plan, once the build is over
+func (r *tapReporter) finish() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fmt.Fprintf(r.out, "1..%d\n", r.count)
+}
+
+// comment writes complete lines of output as diagnostics
+func (r *tapReporter) comment(data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" {
+			fmt.Fprintf(r.out, "# %s", line)
+		}
+	}
+}
+
+// tapOutput turns the recipe output of a target into diagnostic lines
+type tapOutput struct {
+	r      *tapReporter
+	target string
+}
+
+func (o tapOutput) Write(data []byte) (int, error) {
+	o.r.mutex.Lock()
+	buf := append(o.r.partial[o.target], data...)
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	o.r.partial[o.target] = append([]byte(nil), buf[end:]...)
+	o.r.mutex.Unlock()
+	if end > 0 {
+		o.r.comment(buf[:end])
+	}
+	return len(data), nil
+}
+
+// yamlQuote quotes s as a single quoted YAML scalar
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, "'", "''"), "\n", " ") + "'"
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"smmake"
+	"smmake/internal/color"
+)
+
+// Keys decoded from the raw terminal input
+const (
+	keyUp = iota
+	keyDown
+	keyEnter
+	keyBackspace
+	keyToggle
+	keyQuit
+	keyRune
+)
+
+type uiKey struct {
+	kind int
+	r    rune
+}
+
+// runUI opens an interactive picker listing the Makefile's targets. The
+// chosen targets are then built while their output is shown in one pane per
+// target.
+func runUI(m *smmake.Makefile, opts []smmake.Option) error {
+	if !color.IsTerminal(os.Stdin) || !color.IsTerminal(os.Stdout) {
+		return errors.New("'smmake ui' needs an interactive terminal")
+	}
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("error switching terminal to raw mode: %v", err)
+	}
+	// Alternate screen buffer, hidden cursor
+	fmt.Print("\033[?1049h\033[?25l")
+	restored := false
+	restore := func() {
+		if !restored {
+			fmt.Print("\033[?25h\033[?1049l")
+			term.Restore(int(os.Stdin.Fd()), oldState)
+			restored = true
+		}
+	}
+	defer restore()
+
+	keys := make(chan uiKey)
+	go readKeys(os.Stdin, keys)
+
+	goals := pickTargets(m, keys)
+	if len(goals) == 0 {
+		return nil
+	}
+	buildErr := runPanes(m, goals, keys, opts)
+	restore()
+	if buildErr != nil {
+		return fmt.Errorf("error executing target: %w", buildErr)
+	}
+	fmt.Printf("Built %s\n", strings.Join(goals, ", "))
+	return nil
+}
+
+// readKeys decodes raw terminal input into keys
+func readKeys(r io.Reader, keys chan<- uiKey) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		in := buf[:n]
+		for len(in) > 0 {
+			switch {
+			case len(in) >= 3 && in[0] == 0x1b && in[1] == '[':
+				switch in[2] {
+				case 'A':
+					keys <- uiKey{kind: keyUp}
+				case 'B':
+					keys <- uiKey{kind: keyDown}
+				}
+				in = in[3:]
+			case in[0] == 0x1b || in[0] == 3: // Esc, Ctrl-C
+				keys <- uiKey{kind: keyQuit}
+				in = in[1:]
+			case in[0] == '\r' || in[0] == '\n':
+				keys <- uiKey{kind: keyEnter}
+				in = in[1:]
+			case in[0] == 127 || in[0] == 8:
+				keys <- uiKey{kind: keyBackspace}
+				in = in[1:]
+			case in[0] == ' ' || in[0] == '\t':
+				keys <- uiKey{kind: keyToggle}
+				in = in[1:]
+			default:
+				r, size := utf8.DecodeRune(in)
+				if unicode.IsPrint(r) {
+					keys <- uiKey{kind: keyRune, r: r}
+				}
+				in = in[size:]
+			}
+		}
+	}
+}
+
+// fuzzyMatch reports whether all runes of filter appear in s in order
+func fuzzyMatch(s, filter string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(filter) {
+		idx := strings.IndexRune(s, r)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// pickTargets shows the target list until the user runs or quits. It
+// returns the targets to build in the order they were selected.
+func pickTargets(m *smmake.Makefile, keys <-chan uiKey) []string {
+	var names []string
+	for name, t := range m.Targets {
+		if !t.Pattern && !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	filter := ""
+	cursor := 0
+	selected := make(map[string]bool)
+	var order []string
+
+	for {
+		var matches []string
+		for _, name := range names {
+			description := strings.ToLower(m.Targets[name].Description)
+			if fuzzyMatch(name, filter) || strings.Contains(description, strings.ToLower(filter)) {
+				matches = append(matches, name)
+			}
+		}
+		cursor = min(cursor, max(len(matches)-1, 0))
+		drawPicker(m, matches, filter, cursor, selected)
+
+		key, ok := <-keys
+		if !ok {
+			return nil
+		}
+		switch key.kind {
+		case keyQuit:
+			return nil
+		case keyUp:
+			cursor = max(cursor-1, 0)
+		case keyDown:
+			cursor++
+		case keyBackspace:
+			if filter != "" {
+				_, size := utf8.DecodeLastRuneInString(filter)
+				filter = filter[:len(filter)-size]
+			}
+		case keyRune:
+			filter += string(key.r)
+		case keyToggle:
+			if len(matches) == 0 {
+				break
+			}
+			name := matches[cursor]
+			selected[name] = !selected[name]
+			if selected[name] {
+				order = append(order, name)
+			} else {
+				for i, n := range order {
+					if n == name {
+						order = append(order[:i], order[i+1:]...)
+						break
+					}
+				}
+			}
+		case keyEnter:
+			if len(order) > 0 {
+				return order
+			}
+			if len(matches) > 0 {
+				return []string{matches[cursor]}
+			}
+		}
+	}
+}
+
+func drawPicker(m *smmake.Makefile, matches []string, filter string, cursor int, selected map[string]bool) {
+	width, height := terminalSize()
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	writeLine(&b, width, color.Colorize(color.Bold, "smmake ui")+"  type to filter, up/down to move, space to select, enter to run, esc to quit")
+	writeLine(&b, width, "> "+filter)
+
+	nameWidth := 0
+	for _, name := range matches {
+		nameWidth = max(nameWidth, utf8.RuneCountInString(name))
+	}
+
+	// Keep the cursor visible, leaving room for the preview below the list
+	rows := max(height-6, 1)
+	first := 0
+	if cursor >= rows {
+		first = cursor - rows + 1
+	}
+	for i := first; i < len(matches) && i < first+rows; i++ {
+		name := matches[i]
+		pointer, mark := "  ", "[ ]"
+		if i == cursor {
+			pointer = "> "
+		}
+		if selected[name] {
+			mark = "[x]"
+		}
+		line := fmt.Sprintf("%s%s %-*s  %s", pointer, mark, nameWidth, name, m.Targets[name].Description)
+		if i == cursor {
+			line = color.Colorize("7", line)
+		}
+		writeLine(&b, width, line)
+	}
+
+	if len(matches) > 0 {
+		t := m.Targets[matches[cursor]]
+		writeLine(&b, width, strings.Repeat("-", width))
+		deps := "(none)"
+		if len(t.Dependencies) > 0 {
+			deps = strings.Join(t.Dependencies, " ")
+		}
+		writeLine(&b, width, fmt.Sprintf("%s depends on: %s", color.Target(t.Name), deps))
+	}
+	fmt.Print(b.String())
+}
+
+// terminalSize returns the size of the terminal, falling back to 80x24 when
+// it can't be determined
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// writeLine appends s cut to the terminal width, ending with the CRLF raw
+// mode needs
+func writeLine(b *strings.Builder, width int, s string) {
+	if !strings.Contains(s, "\033") && utf8.RuneCountInString(s) > width {
+		s = string([]rune(s)[:width])
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
+
+// pane collects the output of a single target
+type pane struct {
+	name    string
+	status  string
+	lines   []string
+	partial string
+}
+
+// paneSet is written to concurrently by the executor and read by the
+// renderer
+type paneSet struct {
+	mutex sync.Mutex
+	order []*pane
+	byKey map[string]*pane
+}
+
+func (ps *paneSet) get(name string) *pane {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	p := ps.byKey[name]
+	if p == nil {
+		p = &pane{name: name, status: "waiting"}
+		ps.byKey[name] = p
+		ps.order = append(ps.order, p)
+	}
+	return p
+}
+
+// lookup returns the pane of a target without creating it
+func (ps *paneSet) lookup(name string) *pane {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.byKey[name]
+}
+
+func (ps *paneSet) setStatus(name, status string) {
+	p := ps.get(name)
+	ps.mutex.Lock()
+	p.status = status
+	ps.mutex.Unlock()
+}
+
+func (ps *paneSet) write(name string, data []byte) {
+	p := ps.get(name)
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	text := p.partial + string(data)
+	parts := strings.Split(text, "\n")
+	for _, line := range parts[:len(parts)-1] {
+		// Progress bars rewrite the line with \r, keep the last version
+		line = strings.TrimSuffix(line, "\r")
+		if idx := strings.LastIndex(line, "\r"); idx >= 0 {
+			line = line[idx+1:]
+		}
+		p.lines = append(p.lines, line)
+	}
+	p.partial = parts[len(parts)-1]
+}
+
+// paneWriter sends the output of one target to its pane
+type paneWriter struct {
+	panes *paneSet
+	name  string
+}
+
+func (w paneWriter) Write(data []byte) (int, error) {
+	w.panes.write(w.name, data)
+	return len(data), nil
+}
+
+// runPanes builds the goals and renders the output of each target until the
+// build is over and a key has been pressed
+func runPanes(m *smmake.Makefile, goals []string, keys <-chan uiKey, opts []smmake.Option) error {
+	panes := &paneSet{byKey: make(map[string]*pane)}
+
+	// Echoed commands go to the panes instead of the screen
+	m.Silent = true
+	m.Output = func(target string) io.Writer { return paneWriter{panes, target} }
+	m.AddHooks(smmake.Hooks{
+		OnCommandStart: func(e smmake.CommandEvent) {
+			panes.write(e.Target, []byte("$ "+e.Command.Cmd+"\n"))
+		},
+		OnTargetStart: func(e smmake.TargetEvent) error {
+			panes.setStatus(e.Name, "running")
+			return nil
+		},
+		OnTargetFinish: func(e smmake.TargetEvent) {
+			// Files without a rule finish without ever starting, they get no pane
+			if e.Err != nil {
+				panes.setStatus(e.Name, "failed")
+			} else if panes.lookup(e.Name) != nil {
+				panes.setStatus(e.Name, "done")
+			}
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- m.Build(context.Background(), goals, opts...) }()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			footer := color.Colorize(color.Green, "Build finished") + ", press any key to exit"
+			if err != nil {
+				footer = color.Error("Build failed: ") + err.Error() + ", press any key to exit"
+			}
+			drawPanes(panes, footer)
+			<-keys
+			return err
+		case <-ticker.C:
+			drawPanes(panes, "Building "+strings.Join(goals, ", ")+"...")
+		}
+	}
+}
+
+func drawPanes(panes *paneSet, footer string) {
+	width, height := terminalSize()
+
+	panes.mutex.Lock()
+	defer panes.mutex.Unlock()
+
+	// Give every pane a title and at least three lines of output, showing
+	// the most recent panes when they don't all fit
+	visible := panes.order
+	maxPanes := max((height-1)/4, 1)
+	if len(visible) > maxPanes {
+		visible = visible[len(visible)-maxPanes:]
+	}
+	rows := 0
+	if len(visible) > 0 {
+		rows = max((height-1)/len(visible)-1, 1)
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	for _, p := range visible {
+		status := p.status
+		switch status {
+		case "done":
+			status = color.Colorize(color.Green, status)
+		case "failed":
+			status = color.Error(status)
+		}
+		title := fmt.Sprintf("-- %s (%s) ", color.Target(p.name), status)
+		writeLine(&b, width, title+strings.Repeat("-", max(width-len(p.name)-len(p.status)-7, 0)))
+
+		lines := p.lines
+		if p.partial != "" {
+			lines = append(lines[:len(lines):len(lines)], p.partial)
+		}
+		if len(lines) > rows {
+			lines = lines[len(lines)-rows:]
+		}
+		for i := 0; i < rows; i++ {
+			if i < len(lines) {
+				writeLine(&b, width, lines[i])
+			} else {
+				writeLine(&b, width, "")
+			}
+		}
+	}
+	b.WriteString(footer)
+	fmt.Print(b.String())
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.VERSION=v0.1.3 -X main.commit=abc1234 -X main.date=2024-01-01T00:00:00Z" ./cmd
+//
+// commit and date fall back to the VCS stamp Go embeds in the binary.
+var (
+	commit = ""
+	date   = ""
+)
+
+// versionString describes the running binary without touching the filesystem
+func versionString() string {
+	version, rev, built := VERSION, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v := info.Main.Version; version == "" && v != "" && v != "(devel)" {
+			version = v
+		}
+		settings := make(map[string]string)
+		for _, s := range info.Settings {
+			settings[s.Key] = s.Value
+		}
+		if rev == "" {
+			rev = settings["vcs.revision"]
+			if len(rev) > 12 {
+				rev = rev[:12]
+			}
+			if rev != "" && settings["vcs.modified"] == "true" {
+				rev += "-dirty"
+			}
+		}
+		if built == "" {
+			built = settings["vcs.time"]
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return fmt.Sprintf("smmake version %s (commit %s, built %s, %s %s/%s)",
+		version, rev, built, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"smmake"
+	"smmake/internal/logging"
+)
+
+// webhookTimeout bounds how long a webhook may hold up the exit of smmake
+const webhookTimeout = 10 * time.Second
+
+// webhookConfig is a webhook of the config file, posted when a build is
+// over:
+//
+//	webhooks:
+//	  - url: https://chatops.example.com/hooks/build
+//	    on: [failure]
+//	    headers: {Authorization: Bearer xyz}
+//	    template: '{"text": "{{.Goals}} {{.Status}} in {{.Duration}}"}'
+type webhookConfig struct {
+	URL string `yaml:"url"`
+	// On lists the results the webhook is posted for, success and failure,
+	// both if empty
+	On      []string          `yaml:"on"`
+	Headers map[string]string `yaml:"headers"`
+	// Template is a text/template over the buildResult, the JSON of the
+	// result if empty
+	Template string `yaml:"template"`
+	// ContentType defaults to application/json
+	ContentType string `yaml:"content_type"`
+}
+
+// buildResult summarizes a build for the webhooks
+type buildResult struct {
+	Goals      []string `json:"goals"`
+	Status     string   `json:"status"` // success or failure
+	Duration   string   `json:"duration"`
+	DurationMS int64    `json:"duration_ms"`
+	Failed     []string `json:"failed"`
+	Error      string   `json:"error,omitempty"`
+	// Excerpt is the end of the output of the first failed target
+	Excerpt  string `json:"excerpt,omitempty"`
+	Makefile string `json:"makefile"`
+}
+
+// excerptLines is how much of the output of a failed target is sent
+const excerptLines = 20
+
+// newBuildResult summarizes a build of goals from the results of its
+// targets. The failed targets are the ones that failed themselves, not for
+// a prerequisite.
+func newBuildResult(m *smmake.Makefile, goals []string, report *buildReport, d time.Duration, err error) buildResult {
+	r := buildResult{
+		Goals:      goals,
+		Status:     "success",
+		Duration:   d.Round(time.Millisecond).String(),
+		DurationMS: d.Milliseconds(),
+		Failed:     []string{},
+		Makefile:   m.Filename,
+	}
+	if err != nil {
+		r.Status, r.Error = "failure", err.Error()
+	}
+	for _, t := range report.wait() {
+		if t.Err == nil || t.Skipped != "" {
+			continue
+		}
+		if len(r.Failed) == 0 {
+			output := strings.TrimRight(t.Stdout.String()+t.Stderr.String(), "\n")
+			lines := strings.Split(output, "\n")
+			r.Excerpt = strings.Join(lines[max(len(lines)-excerptLines, 0):], "\n")
+		}
+		r.Failed = append(r.Failed, t.Name)
+	}
+	return r
+}
+
+// postWebhooks posts the result of a build to the webhooks that want it.
+// Webhooks that fail are reported as warnings, the build's result stays.
+func postWebhooks(hooks []webhookConfig, r buildResult) {
+	for _, hook := range hooks {
+		if len(hook.On) > 0 && !contains(hook.On, r.Status) {
+			continue
+		}
+		if err := postWebhook(hook, r); err != nil {
+			logging.Warnf("webhook %s: %v", hook.URL, err)
+		}
+	}
+}
+
+func postWebhook(hook webhookConfig, r buildResult) error {
+	body, err := webhookBody(hook.Template, r)
+	if err != nil {
+		return err
+	}
+	return post(hook.URL, hook.ContentType, hook.Headers, body)
+}
+
+// post sends body to url, as JSON unless contentType says otherwise
+func post(url, contentType string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	client := http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	logging.Verbosef("Posted the build result to %s", url)
+	return nil
+}
+
+// webhookBody renders the body of a webhook: the template, with json to
+// quote values, or the result as JSON
+func webhookBody(text string, r buildResult) ([]byte, error) {
+	if text == "" {
+		return json.Marshal(r)
+	}
+	return renderTemplate(text, r)
+}
+
+// templateFuncs are the functions of the webhook and notification templates
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// renderTemplate executes a template of the config file over r
+func renderTemplate(text string, r buildResult) ([]byte, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error in template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r); err != nil {
+		return nil, fmt.Errorf("error in template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
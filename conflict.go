@@ -0,0 +1,44 @@
+package smmake
+
+import "strings"
+
+// fileConflict returns what exists with the name of a target that has a
+// recipe and isn't .PHONY, when that is likely a mistake: a "directory", or
+// a "file" named like a target that conventionally isn't one, such as
+// test. It is "" if there is no such conflict.
+func (m *Makefile) fileConflict(t *Target, name string, stats *statCache) string {
+	if t == nil || t.Pattern || len(t.Commands) == 0 || strings.HasPrefix(name, ".") || m.isPhony(name) {
+		return ""
+	}
+	info, broken, err := stats.fileInfo(m, name)
+	switch {
+	case err != nil || broken:
+		return ""
+	case info.IsDir():
+		return "directory"
+	case conventionalPhony[name]:
+		return "file"
+	}
+	return ""
+}
+
+// warnConflict warns about a target that is named like an existing file or
+// directory, see fileConflict
+func (m *Makefile) warnConflict(t *Target, name, kind string) {
+	m.logf(LogWarn, name, "%s:%d: target '%s' is named like an existing %s, declare it .PHONY if it isn't made as one",
+		m.Filename, t.Line, name, kind)
+}
+
+// fileUpToDate reports whether the existing file of a target is up to date
+// with the prerequisites deps, as decide sees it, with the file info in
+// stats
+func (m *Makefile) fileUpToDate(name string, deps []string, stats *statCache) bool {
+	nodes, err := m.BuildGraph(name)
+	if err != nil || nodes[name] == nil {
+		return false
+	}
+	node := *nodes[name]
+	node.Deps = deps
+	d := m.decide(&node, m.deciderWith(nodes, make(map[string]*decision), stats), stats)
+	return !d.Rebuild && !d.Err
+}
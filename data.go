@@ -0,0 +1,171 @@
+package smmake
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readData decodes a JSON, YAML or TOML file, told apart by its extension
+func (m *Makefile) readData(name string) (any, error) {
+	data, err := m.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &v)
+	case ".toml":
+		v, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("'%s' is not a JSON, YAML or TOML file", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// queryData selects a value by a path such as .service.port or
+// .servers[0].host. The path "." is the whole document.
+func queryData(v any, path string) (any, error) {
+	rest := strings.TrimSpace(path)
+	if !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+		return nil, fmt.Errorf("path '%s' must start with '.'", path)
+	}
+	for rest != "" && rest != "." {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			rest = rest[end:]
+			object, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("path '%s': '%s' is not in an object", path, key)
+			}
+			if v, ok = object[key]; !ok {
+				return nil, fmt.Errorf("path '%s': no key '%s'", path, key)
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path '%s': missing ']'", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("path '%s': invalid index '%s'", path, rest[1:end])
+			}
+			rest = rest[end+1:]
+			list, ok := v.([]any)
+			if !ok || index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("path '%s': no element %d", path, index)
+			}
+			v = list[index]
+		default:
+			return nil, fmt.Errorf("path '%s': unexpected '%s'", path, rest)
+		}
+	}
+	return v, nil
+}
+
+// dataString turns a value into the text of a variable: scalars as
+// written, lists of scalars as words and anything else as JSON
+func dataString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		words := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]any, []any:
+				data, _ := json.Marshal(v)
+				return string(data)
+			}
+			words = append(words, dataString(item))
+		}
+		return strings.Join(words, " ")
+	case map[string]any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// dataFunction is $(data FILE PATH), the value at PATH in a data file
+func dataFunction(m *Makefile, args []string) (string, error) {
+	fields := strings.Fields(strings.Join(args, " "))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("data wants a file and a path, e.g. $(data config.yaml .service.port)")
+	}
+	v, err := m.readData(fields[0])
+	if err != nil {
+		return "", err
+	}
+	if v, err = queryData(v, fields[1]); err != nil {
+		return "", err
+	}
+	return dataString(v), nil
+}
+
+// dataFileDirective evaluates "datafile [PREFIX] FILE", which defines a
+// variable for every value in the file that isn't an object, named by its
+// keys joined with dots: service.port, or cfg.service.port with a prefix
+func (m *Makefile) dataFileDirective(args string, line int) {
+	fields := strings.Fields(m.expandVariables(args))
+	if len(fields) == 0 || len(fields) > 2 {
+		m.logf(LogWarn, "", "%s:%d: datafile wants a file and an optional prefix, e.g. 'datafile cfg config.yaml'", m.Filename, line)
+		return
+	}
+	prefix, name := "", fields[len(fields)-1]
+	if len(fields) == 2 {
+		prefix = fields[0] + "."
+	}
+	v, err := m.readData(name)
+	if err != nil {
+		m.logf(LogWarn, "", "%s:%d: %v", m.Filename, line, err)
+		return
+	}
+	object, ok := v.(map[string]any)
+	if !ok {
+		m.logf(LogWarn, "", "%s:%d: %s doesn't hold an object", m.Filename, line, name)
+		return
+	}
+	m.defineData(prefix, object, line)
+}
+
+func (m *Makefile) defineData(prefix string, object map[string]any, line int) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := prefix + key
+		if nested, ok := object[key].(map[string]any); ok {
+			m.defineData(name+".", nested, line)
+			continue
+		}
+		if old := m.Variables[name]; old != nil && old.Origin == OriginCommandLine {
+			m.logf(LogDebug, "", "  variable '%s' is overridden on the command line", name)
+			continue
+		}
+		m.setVariable(&Variable{Name: name, Value: dataString(object[key]), Origin: OriginMakefile, Line: line})
+	}
+}
@@ -0,0 +1,105 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PrintDatabase writes the parsed Makefile in the style of make's -p output.
+//
+// Variables, rules and pattern rules are each printed in sorted order so the
+// output is stable between runs and can be diffed.
+//
+// Parameters:
+//   - w: The writer the database is printed to.
+func (m *Makefile) PrintDatabase(w io.Writer) {
+	fmt.Fprintf(w, "# Make data base for '%s'\n", m.Filename)
+
+	fmt.Fprintln(w, "\n# Variables")
+	for _, name := range sortedKeys(m.Variables) {
+		v := m.Variables[name]
+		if v.Origin == OriginMakefile {
+			fmt.Fprintf(w, "\n# %s (from '%s', line %d)\n", v.Origin, m.Filename, v.Line)
+		} else {
+			fmt.Fprintf(w, "\n# %s\n", v.Origin)
+		}
+		op := ":="
+		if v.Recursive {
+			op = "="
+		}
+		fmt.Fprintf(w, "%s %s %s\n", v.Name, op, v.Value)
+	}
+
+	var rules, patterns []*Target
+	for _, name := range sortedKeys(m.Targets) {
+		if t := m.Targets[name]; t.Pattern {
+			patterns = append(patterns, t)
+		} else {
+			rules = append(rules, t)
+		}
+	}
+
+	fmt.Fprintln(w, "\n# Files")
+	for _, t := range rules {
+		m.printRule(w, t)
+	}
+
+	fmt.Fprintln(w, "\n# Pattern Rules")
+	for _, t := range patterns {
+		m.printRule(w, t)
+	}
+
+	fmt.Fprintf(w, "\n# %d variables, %d rules, %d pattern rules\n", len(m.Variables), len(rules), len(patterns))
+}
+
+// printRule writes a single rule with its resolved prerequisites and recipe,
+// or each of the "::" rules of a target
+func (m *Makefile) printRule(w io.Writer, t *Target) {
+	if t.DoubleColon && len(t.Rules) > 0 {
+		for _, r := range t.Rules {
+			m.printRule(w, &Target{Name: t.Name, Dependencies: r.Dependencies, Commands: r.Commands, Line: r.Line, DoubleColon: true})
+		}
+		return
+	}
+	deps := make([]string, 0, len(t.Dependencies))
+	for _, dep := range t.Dependencies {
+		deps = append(deps, m.expandVariables(dep))
+	}
+
+	colon := ":"
+	if t.DoubleColon {
+		colon = "::"
+	}
+	fmt.Fprintln(w)
+	if len(deps) > 0 {
+		fmt.Fprintf(w, "%s%s %s\n", t.Name, colon, strings.Join(deps, " "))
+	} else {
+		fmt.Fprintf(w, "%s%s\n", t.Name, colon)
+	}
+	if len(t.Commands) == 0 {
+		fmt.Fprintf(w, "#  (from '%s', line %d), no recipe\n", m.Filename, t.Line)
+		return
+	}
+	line := t.Line
+	if t.recipeRule != 0 {
+		line = t.recipeRule
+	}
+	fmt.Fprintf(w, "#  recipe to execute (from '%s', line %d):\n", m.Filename, line)
+	for _, cmd := range t.Commands {
+		for _, line := range cmd.lines() {
+			fmt.Fprintf(w, "\t%s\n", line)
+		}
+	}
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
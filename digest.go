@@ -0,0 +1,135 @@
+package smmake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// digestCache remembers the SHA-256 of the files a Makefile hashed, with
+// the size and modification time they had, so a file that kept both isn't
+// read again by the next build of a watch loop or a daemon
+type digestCache struct {
+	mutex sync.Mutex
+	files map[string]cachedDigest
+	// dirs are the sums of the directory prerequisites, see dirContents,
+	// as they were when a target was made, by target and directory
+	dirs map[[2]string]string
+}
+
+type cachedDigest struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// digestsMutex guards the creation of Makefile.digests
+var digestsMutex sync.Mutex
+
+func (m *Makefile) digestCache() *digestCache {
+	digestsMutex.Lock()
+	defer digestsMutex.Unlock()
+	if m.digests == nil {
+		m.digests = &digestCache{files: make(map[string]cachedDigest), dirs: make(map[[2]string]string)}
+	}
+	return m.digests
+}
+
+// hashFiles returns the hex SHA-256 of every file, or the error reading
+// it. The files are hashed by as many workers as there are CPUs, which
+// keeps trees like vendor/ or node_modules/ quick to hash.
+func (m *Makefile) hashFiles(names []string) ([]string, []error) {
+	sums := make([]string, len(names))
+	errs := make([]error, len(names))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(names) {
+		workers = len(names)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				sums[i], errs[i] = m.hashFile(names[i])
+			}
+		}()
+	}
+	for i := range names {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return sums, errs
+}
+
+// HashFile returns the hex SHA-256 of a file relative to the Makefile's
+// directory. The digest is remembered while the file keeps its size and
+// modification time.
+func (m *Makefile) HashFile(name string) (string, error) {
+	return m.hashFile(name)
+}
+
+// hashFile returns the hex SHA-256 of a file, the cached one if its size
+// and modification time didn't change
+func (m *Makefile) hashFile(name string) (string, error) {
+	info, err := m.stat(name)
+	if err != nil {
+		return "", err
+	}
+	cache := m.digestCache()
+	key := m.path(name)
+	cache.mutex.Lock()
+	cached, ok := cache.files[key]
+	cache.mutex.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	file, err := m.open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	cache.mutex.Lock()
+	cache.files[key] = cachedDigest{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	cache.mutex.Unlock()
+	return sum, nil
+}
+
+// madeWith remembers the contents of the directory prerequisites of a
+// target whose recipe ran, for the next builds to tell whether they changed
+func (m *Makefile) madeWith(targetName string, deps []string) {
+	if m.Dirs != DirContents || m.DryRun {
+		return
+	}
+	cache := m.digestCache()
+	for _, dep := range deps {
+		if info, err := m.stat(dep); err != nil || !info.IsDir() {
+			continue
+		}
+		sum := m.dirContents(dep).sum
+		cache.mutex.Lock()
+		cache.dirs[[2]string{targetName, dep}] = sum
+		cache.mutex.Unlock()
+	}
+}
+
+// madeWithDir returns the sum of a directory prerequisite when the target
+// was last made, false if it wasn't made by this Makefile
+func (m *Makefile) madeWithDir(targetName, dir string) (string, bool) {
+	cache := m.digestCache()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	sum, ok := cache.dirs[[2]string{targetName, dir}]
+	return sum, ok
+}
@@ -0,0 +1,126 @@
+package smmake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseError is a Makefile that couldn't be read or parsed
+type ParseError struct {
+	Filename string
+	Line     int // 0 if the error isn't about a line
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Filename, e.Line, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ParseErrors are the errors of a Makefile with several problems, in the
+// order of their lines. The parser carries on at the next rule after an
+// error, so they are all reported at once.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// parseErrors returns nil for no errors, the error itself for one and
+// ParseErrors sorted by line for several
+func parseErrors(errs []*ParseError) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return ParseErrors(errs)
+}
+
+// UnknownTargetError is a goal or prerequisite that has no rule and isn't
+// an existing file
+type UnknownTargetError struct {
+	Name string
+	// Suggestions are the known targets with a similar name, closest first
+	Suggestions []string
+}
+
+func (e *UnknownTargetError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("target '%s' not found", e.Name)
+	}
+	quoted := make([]string, len(e.Suggestions))
+	for i, s := range e.Suggestions {
+		quoted[i] = "'" + s + "'"
+	}
+	return fmt.Sprintf("target '%s' not found; did you mean %s?", e.Name, strings.Join(quoted, " or "))
+}
+
+// RecipeError is a recipe line that failed
+type RecipeError struct {
+	Target  string
+	Command string
+	// ExitCode is the exit status of the command, or -1 if it didn't exit
+	// on its own, e.g. because it couldn't be started or was killed
+	ExitCode int
+	Err      error
+}
+
+func (e *RecipeError) Error() string {
+	return fmt.Sprintf("error executing command '%s': %v", e.Command, e.Err)
+}
+
+func (e *RecipeError) Unwrap() error { return e.Err }
+
+// DependencyError is a target that wasn't built because one of its
+// prerequisites failed
+type DependencyError struct {
+	Target     string
+	Dependency string
+	Err        error
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("error in dependency '%s': %v", e.Dependency, e.Err)
+}
+
+func (e *DependencyError) Unwrap() error { return e.Err }
+
+// CycleError is a dependency cycle. Path starts and ends with the same
+// target, e.g. [a b a] for a target a depending on b depending on a.
+type CycleError struct {
+	Path []string
+	// Locations are where the rules of Path are, e.g. Makefile:12, "" if
+	// unknown
+	Locations []string
+}
+
+func (e *CycleError) Error() string {
+	names := make([]string, len(e.Path))
+	for i, name := range e.Path {
+		names[i] = name
+		if i < len(e.Path)-1 && i < len(e.Locations) && e.Locations[i] != "" {
+			names[i] += " (" + e.Locations[i] + ")"
+		}
+	}
+	return "circular dependency detected: " + strings.Join(names, " -> ")
+}
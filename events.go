@@ -0,0 +1,253 @@
+package smmake
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Event is something that happened during a build. It is one of the event
+// types below; use a type switch to tell them apart.
+//
+// Every target the build reaches is reported in the same order: a
+// TargetQueued, then a TargetStarted if its recipe runs or a TargetSkipped
+// if it doesn't, then a TargetFinished.
+type Event interface {
+	EventTime() time.Time
+}
+
+// EventInfo holds what all events have in common
+type EventInfo struct {
+	Time time.Time
+	// BuildID is the ID of the session the event is from, which tells the
+	// builds of one Makefile apart, see Session.ID
+	BuildID uint64
+}
+
+// EventTime returns when the event happened
+func (e EventInfo) EventTime() time.Time { return e.Time }
+
+// BuildStarted is published by Build before the first goal is built
+type BuildStarted struct {
+	EventInfo
+	Goals []string
+}
+
+// BuildFinished is published by Build once all goals are built, or one of
+// them failed
+type BuildFinished struct {
+	EventInfo
+	Duration time.Duration
+	Err      error
+}
+
+// TargetQueued is published when the build first reaches a target, before
+// its prerequisites are built
+type TargetQueued struct {
+	EventInfo
+	Target string
+}
+
+// TargetStarted is published when the recipe of a target starts
+type TargetStarted struct {
+	EventInfo
+	Target string
+}
+
+// TargetSkipped is published for targets that are done without running a
+// recipe
+type TargetSkipped struct {
+	EventInfo
+	Target string
+	Reason string
+}
+
+// TargetFinished is published once a target is done, whether its recipe
+// ran, was skipped or the target failed
+type TargetFinished struct {
+	EventInfo
+	Target   string
+	Duration time.Duration
+	Err      error
+}
+
+// CommandStarted is published before a recipe line runs
+type CommandStarted struct {
+	EventInfo
+	Target  string
+	Command Command
+}
+
+// CommandOutput carries output written by a recipe line. Data is not reused
+// after the event is published.
+type CommandOutput struct {
+	EventInfo
+	Target string
+	Data   []byte
+	Stderr bool
+}
+
+// CommandFinished is published after a recipe line ran
+type CommandFinished struct {
+	EventInfo
+	Target  string
+	Command Command
+	Result  Result
+	Err     error
+}
+
+// Subscription receives the events of a Makefile. Events are queued for
+// every subscriber separately, so a slow subscriber neither holds up the
+// build nor the other subscribers.
+type Subscription struct {
+	bus    *eventBus
+	events chan Event
+	done   chan struct{}
+
+	mutex  sync.Mutex
+	ready  *sync.Cond
+	queue  []Event
+	closed bool
+}
+
+// eventBus fans events out to the subscriptions of a Makefile
+type eventBus struct {
+	mutex sync.Mutex
+	subs  []*Subscription
+}
+
+// busMutex guards the creation of Makefile.bus
+var busMutex sync.Mutex
+
+func (m *Makefile) eventBus() *eventBus {
+	busMutex.Lock()
+	defer busMutex.Unlock()
+	if m.bus == nil {
+		m.bus = &eventBus{}
+	}
+	return m.bus
+}
+
+// Subscribe returns a subscription to the events published from now on.
+// Call Close once done with it.
+func (m *Makefile) Subscribe() *Subscription {
+	bus := m.eventBus()
+	s := &Subscription{
+		bus:    bus,
+		events: make(chan Event),
+		done:   make(chan struct{}),
+	}
+	s.ready = sync.NewCond(&s.mutex)
+	go s.deliver()
+
+	bus.mutex.Lock()
+	bus.subs = append(bus.subs, s)
+	bus.mutex.Unlock()
+	return s
+}
+
+// Events returns the channel events are delivered on, in the order they
+// were published. It is closed after Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close ends the subscription. Events not received yet are dropped.
+func (s *Subscription) Close() {
+	s.bus.remove(s)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+		s.ready.Signal()
+	}
+}
+
+// deliver moves queued events to the channel until the subscription is
+// closed
+func (s *Subscription) deliver() {
+	defer close(s.events)
+	for {
+		s.mutex.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.ready.Wait()
+		}
+		if s.closed {
+			s.mutex.Unlock()
+			return
+		}
+		e := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		select {
+		case s.events <- e:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Subscription) push(e Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.queue = append(s.queue, e)
+		s.ready.Signal()
+	}
+}
+
+func (b *eventBus) remove(s *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// active reports whether anyone is subscribed, so events that are costly
+// to make, like command output, are only made when needed
+func (b *eventBus) active() bool {
+	if b == nil {
+		return false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subs) > 0
+}
+
+// publish queues e for every subscriber
+func (b *eventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, s := range b.subs {
+		s.push(e)
+	}
+}
+
+// outputPublisher passes recipe output on to w and publishes a copy of it
+type outputPublisher struct {
+	bus    *eventBus
+	info   func() EventInfo
+	w      io.Writer
+	target string
+	stderr bool
+}
+
+func (p outputPublisher) Write(data []byte) (int, error) {
+	p.bus.publish(CommandOutput{
+		EventInfo: p.info(),
+		Target:    p.target,
+		Data:      append([]byte(nil), data...),
+		Stderr:    p.stderr,
+	})
+	return p.w.Write(data)
+}
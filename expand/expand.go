@@ -0,0 +1,91 @@
+// Package expand evaluates make expressions outside of a Makefile, the way
+// smmake.Makefile.Expand does and smmake expands function arguments:
+// $(VAR), ${VAR} and single character references like $X, and function
+// calls like $(patsubst %.c,%.o,$(SRCS)) or $(shell git describe).
+// Undefined variables expand to nothing and "$$" to "$".
+//
+// A recipe line expands differently in two ways. The references to
+// undefined variables are kept in the line for the shell, and the
+// automatic variables are filled in with names quoted for it. Here the
+// automatic variables are ordinary ones: SetAutomatic sets $@, $< and $^,
+// unquoted, and the others, like $+ or $(@D), are undefined.
+//
+//	e := expand.New(map[string]string{"VERSION": "1.4.0"})
+//	tag, err := e.Expand("$(VERSION)-$(shell git rev-parse --short HEAD)")
+package expand
+
+import (
+	"strings"
+
+	"smmake"
+)
+
+// Expander evaluates expressions against a set of variables. Variables it
+// doesn't have are looked up in the environment, then in the Resolver; the
+// ones not found anywhere expand to nothing, like in make.
+type Expander struct {
+	// Resolver provides the variables that are neither set nor in the
+	// environment
+	Resolver smmake.Resolver
+	// Functions are the functions expressions can call,
+	// smmake.DefaultFunctions if nil
+	Functions *smmake.FunctionRegistry
+	// Shell runs the $(shell) commands, /bin/sh or cmd on Windows if empty
+	Shell string
+	// Dir is the directory $(shell) commands and $(wildcard) globs run in,
+	// the current directory if empty
+	Dir string
+
+	vars map[string]string
+}
+
+// New returns an Expander with the given variables, which may be nil
+func New(vars map[string]string) *Expander {
+	e := &Expander{vars: make(map[string]string, len(vars))}
+	for name, value := range vars {
+		e.vars[name] = value
+	}
+	return e
+}
+
+// Set defines a variable. Like a make variable defined with ":=", its
+// value is used as it is, without being expanded again.
+func (e *Expander) Set(name, value string) {
+	e.vars[name] = value
+}
+
+// SetAutomatic defines the automatic variables of a recipe: $@ is the
+// target, $< the first prerequisite and $^ all of them without duplicates.
+// The names are used as they are, not quoted as in a recipe line.
+func (e *Expander) SetAutomatic(target string, prereqs ...string) {
+	e.vars["@"] = target
+	e.vars["<"] = ""
+	if len(prereqs) > 0 {
+		e.vars["<"] = prereqs[0]
+	}
+	var unique []string
+	seen := make(map[string]bool)
+	for _, p := range prereqs {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	e.vars["^"] = strings.Join(unique, " ")
+}
+
+// Expand evaluates an expression. "$$" stands for a literal "$". A function
+// that fails, e.g. a $(shell) command exiting with an error, expands to
+// nothing and is returned as the error, the rest of the expression is still
+// expanded.
+func (e *Expander) Expand(expr string) (string, error) {
+	m := smmake.NewMakefile()
+	m.Resolver = e.Resolver
+	m.Functions = e.Functions
+	m.Shell = e.Shell
+	m.Dir = e.Dir
+	for name, value := range e.vars {
+		m.Var(name, value)
+	}
+	return m.Expand(expr)
+}
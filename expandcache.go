@@ -0,0 +1,129 @@
+package smmake
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxCachedExpansions bounds the expansion cache, which starts over once
+// it holds that many results
+const maxCachedExpansions = 1 << 16
+
+// expansionCache remembers what expressions expanded to. Recursive
+// variables are expanded every time they are referenced, so a big build
+// expands the same CFLAGS in thousands of recipe lines. The results are
+// valid for one generation of the variables, which every assignment ends.
+type expansionCache struct {
+	mutex      sync.Mutex
+	generation uint64
+	results    map[string]string
+}
+
+// expansionsMutex guards the creation of Makefile.expansions
+var expansionsMutex sync.Mutex
+
+func (m *Makefile) expansionCache() *expansionCache {
+	expansionsMutex.Lock()
+	defer expansionsMutex.Unlock()
+	if m.expansions == nil {
+		m.expansions = &expansionCache{results: make(map[string]string)}
+	}
+	return m.expansions
+}
+
+// lookup returns the cached result for key, or the generation a new
+// result is stored for
+func (c *expansionCache) lookup(key string) (uint64, string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result, ok := c.results[key]
+	return c.generation, result, ok
+}
+
+// store caches a result unless the variables changed while it was expanded
+func (c *expansionCache) store(generation uint64, key, result string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if len(c.results) >= maxCachedExpansions {
+		c.results = make(map[string]string)
+	}
+	c.results[key] = result
+}
+
+// current returns the generation of the variables
+func (c *expansionCache) current() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+// invalidate starts a new generation, forgetting every result
+func (c *expansionCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.results = make(map[string]string)
+}
+
+// setVariable defines a variable, invalidating the cached expansions. Every
+// change to Variables goes through it or unsetVariable.
+func (m *Makefile) setVariable(v *Variable) {
+	m.Variables[v.Name] = v
+	m.expansionCache().invalidate()
+}
+
+// unsetVariable removes a variable, invalidating the cached expansions
+func (m *Makefile) unsetVariable(name string) {
+	delete(m.Variables, name)
+	m.expansionCache().invalidate()
+}
+
+// pureFunctions are the functions whose result only depends on their
+// arguments, which the expansion cache may remember
+var pureFunctions = map[string]bool{
+	"subst": true, "patsubst": true, "strip": true, "findstring": true, "filter": true,
+	"filter-out": true, "sort": true, "word": true, "wordlist": true, "words": true,
+	"firstword": true, "lastword": true, "dir": true, "notdir": true, "suffix": true,
+	"basename": true, "abspath": true, "joinpath": true, "addsuffix": true,
+	"addprefix": true, "join": true, "if": true, "or": true, "and": true,
+	"foreach": true, "call": true,
+}
+
+// expansionModes start the keys of the cache, as expanding the same string
+// keeping undefined variables or "$$" differs
+var expansionModes = [...]string{"--", "k-", "-u", "ku"}
+
+// memoized is expand through the expansion cache. Only expansions that
+// depend on nothing but the variables are cached: not the ones that call
+// $(shell), $(wildcard) or another impure function, read the environment
+// or a Resolver, or fail.
+func (e *expansion) memoized(str string, keepUndefined bool) string {
+	if !strings.Contains(str, "$") {
+		return str
+	}
+	mode := 0
+	if keepUndefined {
+		mode |= 1
+	}
+	if e.unescape {
+		mode |= 2
+	}
+	key := expansionModes[mode] + str
+
+	cache := e.m.expansionCache()
+	generation, result, ok := cache.lookup(key)
+	if ok {
+		return result
+	}
+	impure := e.impure
+	e.impure = false
+	result = e.expand(str, keepUndefined)
+	if !e.impure {
+		cache.store(generation, key, result)
+	}
+	e.impure = e.impure || impure
+	return result
+}
@@ -0,0 +1,241 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// An Exporter writes the targets of a Makefile in the format of another
+// build tool, the given targets and what they depend on or all of them. It
+// returns what it couldn't represent, a warning each.
+type Exporter func(m *Makefile, w io.Writer, targets []string) ([]string, error)
+
+// Exporters are the formats Makefile.Export writes, by name
+var Exporters = map[string]Exporter{
+	"gha":      exportGHA,
+	"just":     exportJustfile,
+	"script":   exportScript,
+	"taskfile": exportTaskfile,
+}
+
+// Export translates the Makefile into another build tool's format, see
+// Exporters. Recipe lines are written as smmake would run them, with
+// variables and automatic variables filled in; the warnings list the
+// constructs the format has no equivalent for.
+func (m *Makefile) Export(w io.Writer, format string, targets []string) ([]string, error) {
+	exporter := Exporters[format]
+	if exporter == nil {
+		return nil, fmt.Errorf("can't export to '%s', known are %s", format, strings.Join(sortedKeys(Exporters), ", "))
+	}
+	return exporter(m, w, targets)
+}
+
+// exportTarget is a target as the exporters see it: made by its own rule or
+// a pattern rule, with the recipe lines ready for a shell
+type exportTarget struct {
+	*Target
+	Phony bool
+	// Needs are the prerequisites made by rules, Sources the files without
+	// one
+	Needs   []string
+	Sources []string
+	Lines   []Command
+}
+
+// exportTargets returns the targets to export in the order of their rules,
+// the targets of pattern rules as the files they make, and warnings about
+// what exporters can't represent
+func (m *Makefile) exportTargets(roots []string) ([]*exportTarget, []string, error) {
+	nodes, err := m.BuildGraph(roots...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var warnings []string
+	if len(roots) == 0 {
+		for _, name := range sortedKeys(m.Targets) {
+			if m.Targets[name].Pattern {
+				warnings = append(warnings, fmt.Sprintf("pattern rule '%s' is only exported for the files it makes for other targets", name))
+			}
+		}
+	}
+	for _, name := range []string{".WASM", ".DEFAULT", ".ONESHELL", ".NOTPARALLEL"} {
+		if m.Targets[name] != nil {
+			warnings = append(warnings, fmt.Sprintf("%s has no equivalent and is left out", name))
+		}
+	}
+
+	var targets []*exportTarget
+	for _, name := range sortedKeys(nodes) {
+		node := nodes[name]
+		if node.File {
+			continue
+		}
+		t := m.Targets[name]
+		stem := ""
+		if node.Pattern != "" {
+			pattern := m.Targets[node.Pattern]
+			t = instantiatePattern(pattern, name)
+			stem, _ = patternStem(pattern, name, true)
+		}
+		e := &exportTarget{Target: t, Phony: node.Phony}
+		for _, dep := range uniqueDeps(node.Deps) {
+			if nodes[dep].File {
+				e.Sources = append(e.Sources, dep)
+			} else {
+				e.Needs = append(e.Needs, dep)
+			}
+		}
+		rules := []*DoubleColonRule{{Dependencies: t.Dependencies, Commands: t.Commands}}
+		if t.DoubleColon && len(t.Rules) > 0 {
+			rules = t.Rules
+		}
+		for _, rule := range rules {
+			// the recipe runs as for a target that doesn't exist, so $?
+			// names every prerequisite
+			auto := &automatic{target: t.Name, prereqs: rule.Dependencies, newer: uniqueDeps(rule.Dependencies), stem: stem, quote: shellQuote}
+			for _, cmd := range rule.Commands {
+				if cmd.Script {
+					warnings = append(warnings, fmt.Sprintf("target '%s': scripts can't be exported, leaving out %s", name, cmd.summary()))
+					continue
+				}
+				text := cmd.Cmd
+				if isBuiltin(cmd) {
+					// the builtins are the POSIX commands of the same name
+					text = strings.TrimPrefix(strings.TrimSpace(text), builtinPrefix)
+				}
+				line, undefined := expandRecipe(text, auto)
+				for _, ref := range undefined {
+					warnings = append(warnings, fmt.Sprintf("target '%s': '%s' is not defined, it is written as it is", name, ref))
+				}
+				e.Lines = append(e.Lines, Command{Cmd: line, Silent: m.isSilent(name, cmd)})
+			}
+		}
+		targets = append(targets, e)
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].Line < targets[j].Line
+	})
+	return targets, warnings, nil
+}
+
+// environ returns the Env of a target as a map
+func (t *exportTarget) environ() map[string]any {
+	if len(t.Env) == 0 {
+		return nil
+	}
+	env := make(map[string]any)
+	for _, kv := range t.Env {
+		key, value, _ := strings.Cut(kv, "=")
+		env[key] = value
+	}
+	return env
+}
+
+// exportVariables returns the values of the Makefile's own variables, in the
+// order they were defined
+func (m *Makefile) exportVariables() []*Variable {
+	var vars []*Variable
+	for _, name := range sortedKeys(m.Variables) {
+		v := m.Variables[name]
+		if v.Origin == OriginEnvironment {
+			continue
+		}
+		value, err := m.Expand("$(" + name + ")")
+		if err != nil {
+			continue
+		}
+		vars = append(vars, &Variable{Name: name, Value: value, Origin: v.Origin, Line: v.Line})
+	}
+	sort.SliceStable(vars, func(i, j int) bool { return vars[i].Line < vars[j].Line })
+	return vars
+}
+
+// exportScript writes a POSIX shell script that runs the recipe lines of
+// the targets and of what they depend on, in an order make could run them
+// in. It runs every recipe, up to date or not, and stops at the first one
+// that fails.
+func exportScript(m *Makefile, w io.Writer, roots []string) ([]string, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("export script requires a target")
+	}
+	targets, warnings, err := m.exportTargets(roots)
+	if err != nil {
+		return nil, err
+	}
+	g, err := m.Graph(roots...)
+	if err != nil {
+		return nil, err
+	}
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*exportTarget, len(targets))
+	for _, t := range targets {
+		byName[t.Name] = t
+	}
+
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintf(w, "# Generated by 'smmake export script' from %s for %s\n", m.Filename, strings.Join(roots, " "))
+	fmt.Fprintln(w, "set -e")
+	for _, name := range order {
+		t := byName[name]
+		if t == nil || len(t.Lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n# %s\n", name)
+		// The directory and environment of a target only apply to its
+		// own lines, so those run in a subshell
+		var setup []string
+		for _, kv := range t.Env {
+			key, value, _ := strings.Cut(kv, "=")
+			setup = append(setup, "export "+key+"="+shellQuote(value))
+		}
+		if t.Dir != "" {
+			setup = append(setup, "cd "+shellQuote(t.Dir))
+		}
+		for _, line := range t.Lines {
+			if !line.Silent {
+				fmt.Fprintf(w, "printf '%%s\\n' %s\n", shellQuote(line.Cmd))
+			}
+			if len(setup) > 0 {
+				fmt.Fprintf(w, "(%s; %s)\n", strings.Join(setup, "; "), line.Cmd)
+			} else {
+				fmt.Fprintln(w, line.Cmd)
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// exportName turns a target or variable name into an identifier of just
+// and GitHub Actions: letters, digits, '_' and '-', not starting with a
+// digit or '-'
+func exportName(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case (c == '-' || c >= '0' && c <= '9') && i > 0:
+		case c >= '0' && c <= '9':
+			b.WriteByte('_')
+		default:
+			c = '-'
+			if i == 0 {
+				c = '_'
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// shellQuote quotes a word for a POSIX shell, if it needs quotes
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,123 @@
+package smmake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stat returns the file info of a file target, from FS if set
+func (m *Makefile) stat(name string) (fs.FileInfo, error) {
+	if m.FS == nil {
+		return os.Stat(longPath(m.path(name)))
+	}
+	return fs.Stat(m.FS, fsName(m.path(name)))
+}
+
+// lstat is stat without following a final symbolic link. An FS has no
+// links, so it is stat there.
+func (m *Makefile) lstat(name string) (fs.FileInfo, error) {
+	if m.FS == nil {
+		return os.Lstat(longPath(m.path(name)))
+	}
+	return fs.Stat(m.FS, fsName(m.path(name)))
+}
+
+// fileInfo returns the file info of a file target for the up-to-date
+// checks, the one of the link or of the file it points to as Symlinks
+// says. broken reports a link to a file that doesn't exist, whose info is
+// the link's.
+func (m *Makefile) fileInfo(name string) (info fs.FileInfo, broken bool, err error) {
+	info, err = m.lstat(name)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return info, false, err
+	}
+	target, err := m.stat(name)
+	if err != nil {
+		return info, true, nil
+	}
+	if m.Symlinks == SymlinkLink {
+		return info, false, nil
+	}
+	return target, false, nil
+}
+
+// dirContents describes what is below a directory prerequisite
+type dirContents struct {
+	// newest is the latest modification time of the files and directories
+	// below it, the zero time if it is empty
+	newest time.Time
+	// sum is the hex SHA-256 of their names and modification times, in
+	// the order of their names, which a removed file changes as well
+	sum string
+}
+
+// dirContents returns what is below a directory
+func (m *Makefile) dirContents(dir string) dirContents {
+	var c dirContents
+	h := sha256.New()
+	visit := func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." || name == longPath(m.path(dir)) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			if info.ModTime().After(c.newest) {
+				c.newest = info.ModTime()
+			}
+			// WalkDir visits the names in lexical order
+			fmt.Fprintf(h, "%s\x00%d\n", name, info.ModTime().UnixNano())
+		}
+		return nil
+	}
+	if m.FS == nil {
+		filepath.WalkDir(longPath(m.path(dir)), visit)
+	} else if sub, err := fs.Sub(m.FS, fsName(m.path(dir))); err == nil {
+		fs.WalkDir(sub, ".", visit)
+	}
+	c.sum = hex.EncodeToString(h.Sum(nil))
+	return c
+}
+
+// readFile returns the content of a file, from FS if set
+func (m *Makefile) readFile(name string) ([]byte, error) {
+	if m.FS == nil {
+		data, err := os.ReadFile(longPath(m.path(name)))
+		if m.record != nil {
+			m.record.file(m.path(name), data, err)
+		}
+		return data, err
+	}
+	return fs.ReadFile(m.FS, fsName(m.path(name)))
+}
+
+// open opens a file for reading, from FS if set
+func (m *Makefile) open(name string) (fs.File, error) {
+	if m.FS == nil {
+		return os.Open(longPath(m.path(name)))
+	}
+	return m.FS.Open(fsName(m.path(name)))
+}
+
+// glob returns the files matching pattern with the glob engine, from FS if
+// set, relative to Dir like the pattern
+func (m *Makefile) glob(pattern string) []string {
+	matches := m.globber().glob(pattern)
+	if m.record != nil {
+		m.record.glob(m.path(pattern), matches)
+	}
+	return matches
+}
+
+// fsName turns a file name into the slash separated, unrooted form io/fs
+// expects. Names outside of the file system stay invalid, so they are
+// reported as missing.
+func fsName(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "./")
+}
@@ -0,0 +1,470 @@
+package smmake
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Function is a make function, called for $(name arg,...) references while
+// the Makefile m is expanded. The arguments are split at unnested commas
+// and expanded before the call. An error is reported as a warning and the
+// reference expands to nothing.
+type Function func(m *Makefile, args []string) (string, error)
+
+// LazyFunction is a make function that expands its arguments itself, like
+// $(if), which only expands the branch it picks. The arguments are split at
+// unnested commas and passed as written; expand expands one of them, or any
+// other text, the way Function arguments are expanded.
+type LazyFunction func(m *Makefile, args []string, expand func(string) string) (string, error)
+
+// FunctionRegistry holds the functions available during expansion. It is
+// safe for concurrent use.
+type FunctionRegistry struct {
+	mutex sync.RWMutex
+	funcs map[string]LazyFunction
+}
+
+// DefaultFunctions is the registry used by Makefiles that don't have their
+// own. It holds the built-in functions and the ones added with
+// RegisterFunction.
+var DefaultFunctions = NewFunctionRegistry()
+
+// NewFunctionRegistry returns a registry with the built-in functions
+func NewFunctionRegistry() *FunctionRegistry {
+	r := &FunctionRegistry{funcs: make(map[string]LazyFunction)}
+	registerBuiltins(r)
+	return r
+}
+
+// RegisterFunction adds a function to DefaultFunctions, replacing any
+// function of the same name
+func RegisterFunction(name string, fn Function) {
+	DefaultFunctions.Register(name, fn)
+}
+
+// Register adds a function to the registry, replacing any function of the
+// same name
+func (r *FunctionRegistry) Register(name string, fn Function) {
+	r.RegisterLazy(name, func(m *Makefile, args []string, expand func(string) string) (string, error) {
+		expanded := make([]string, len(args))
+		for i, arg := range args {
+			expanded[i] = expand(arg)
+		}
+		return fn(m, expanded)
+	})
+}
+
+// RegisterLazy adds a function that expands its own arguments to the
+// registry, replacing any function of the same name
+func (r *FunctionRegistry) RegisterLazy(name string, fn LazyFunction) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.funcs[name] = fn
+}
+
+// Lookup returns the named function. The arguments of a LazyFunction are
+// taken as expanded already.
+func (r *FunctionRegistry) Lookup(name string) (Function, bool) {
+	fn, ok := r.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return func(m *Makefile, args []string) (string, error) {
+		return fn(m, args, func(s string) string { return s })
+	}, true
+}
+
+// lookup returns the named function as the expansion calls it
+func (r *FunctionRegistry) lookup(name string) (LazyFunction, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	fn, ok := r.funcs[name]
+	return fn, ok
+}
+
+// Names returns the names of the registered functions, sorted
+func (r *FunctionRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return sortedKeys(r.funcs)
+}
+
+// functions returns the registry m expands functions with
+func (m *Makefile) functions() *FunctionRegistry {
+	if m.Functions != nil {
+		return m.Functions
+	}
+	return DefaultFunctions
+}
+
+func registerBuiltins(r *FunctionRegistry) {
+	r.Register("subst", fixedArgs(3, func(a []string) string {
+		return strings.ReplaceAll(a[2], a[0], a[1])
+	}))
+	r.Register("patsubst", fixedArgs(3, func(a []string) string {
+		return mapWords(a[2], func(word string) string {
+			if stem, ok := matchPattern(a[0], word); ok {
+				return strings.Replace(a[1], "%", stem, 1)
+			}
+			return word
+		})
+	}))
+	r.Register("strip", fixedArgs(1, func(a []string) string {
+		return strings.Join(strings.Fields(a[0]), " ")
+	}))
+	r.Register("findstring", fixedArgs(2, func(a []string) string {
+		if strings.Contains(a[1], a[0]) {
+			return a[0]
+		}
+		return ""
+	}))
+	r.Register("filter", fixedArgs(2, func(a []string) string {
+		return filterWords(a[0], a[1], true)
+	}))
+	r.Register("filter-out", fixedArgs(2, func(a []string) string {
+		return filterWords(a[0], a[1], false)
+	}))
+	r.Register("sort", fixedArgs(1, func(a []string) string {
+		words := strings.Fields(a[0])
+		sort.Strings(words)
+		var unique []string
+		for _, w := range words {
+			if len(unique) == 0 || unique[len(unique)-1] != w {
+				unique = append(unique, w)
+			}
+		}
+		return strings.Join(unique, " ")
+	}))
+	r.Register("word", func(_ *Makefile, args []string) (string, error) {
+		a, err := arity(args, 2)
+		if err != nil {
+			return "", err
+		}
+		n, err := wordIndex(a[0])
+		if err != nil {
+			return "", err
+		}
+		if words := strings.Fields(a[1]); n <= len(words) {
+			return words[n-1], nil
+		}
+		return "", nil
+	})
+	r.Register("wordlist", func(_ *Makefile, args []string) (string, error) {
+		a, err := arity(args, 3)
+		if err != nil {
+			return "", err
+		}
+		start, err := wordIndex(a[0])
+		if err != nil {
+			return "", err
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(a[1]))
+		if err != nil {
+			return "", fmt.Errorf("non-numeric second argument '%s'", a[1])
+		}
+		words := strings.Fields(a[2])
+		end = min(end, len(words))
+		if start > end {
+			return "", nil
+		}
+		return strings.Join(words[start-1:end], " "), nil
+	})
+	r.Register("words", fixedArgs(1, func(a []string) string {
+		return strconv.Itoa(len(strings.Fields(a[0])))
+	}))
+	r.Register("firstword", fixedArgs(1, func(a []string) string {
+		if words := strings.Fields(a[0]); len(words) > 0 {
+			return words[0]
+		}
+		return ""
+	}))
+	r.Register("lastword", fixedArgs(1, func(a []string) string {
+		if words := strings.Fields(a[0]); len(words) > 0 {
+			return words[len(words)-1]
+		}
+		return ""
+	}))
+	r.Register("dir", fixedArgs(1, func(a []string) string {
+		return mapWords(a[0], func(word string) string {
+			if i := strings.LastIndex(word, "/"); i >= 0 {
+				return word[:i+1]
+			}
+			return "./"
+		})
+	}))
+	r.Register("notdir", fixedArgs(1, func(a []string) string {
+		return mapWords(a[0], func(word string) string {
+			return word[strings.LastIndex(word, "/")+1:]
+		})
+	}))
+	r.Register("abspath", func(m *Makefile, args []string) (string, error) {
+		a, err := arity(args, 1)
+		if err != nil {
+			return "", err
+		}
+		return mapWords(a[0], m.absPath), nil
+	})
+	r.Register("realpath", func(m *Makefile, args []string) (string, error) {
+		a, err := arity(args, 1)
+		if err != nil {
+			return "", err
+		}
+		return mapWords(a[0], m.realPath), nil
+	})
+	r.Register("joinpath", func(_ *Makefile, args []string) (string, error) {
+		return joinPath(args), nil
+	})
+	r.Register("suffix", fixedArgs(1, func(a []string) string {
+		return mapWords(a[0], func(word string) string {
+			_, suffix := splitSuffix(word)
+			return suffix
+		})
+	}))
+	r.Register("basename", fixedArgs(1, func(a []string) string {
+		return mapWords(a[0], func(word string) string {
+			base, _ := splitSuffix(word)
+			return base
+		})
+	}))
+	r.Register("addsuffix", fixedArgs(2, func(a []string) string {
+		return mapWords(a[1], func(word string) string { return word + a[0] })
+	}))
+	r.Register("addprefix", fixedArgs(2, func(a []string) string {
+		return mapWords(a[1], func(word string) string { return a[0] + word })
+	}))
+	r.Register("join", fixedArgs(2, func(a []string) string {
+		first, second := strings.Fields(a[0]), strings.Fields(a[1])
+		joined := make([]string, max(len(first), len(second)))
+		for i := range joined {
+			if i < len(first) {
+				joined[i] = first[i]
+			}
+			if i < len(second) {
+				joined[i] += second[i]
+			}
+		}
+		return strings.Join(joined, " ")
+	}))
+	r.Register("wildcard", func(m *Makefile, args []string) (string, error) {
+		a, err := arity(args, 1)
+		if err != nil {
+			return "", err
+		}
+		var matches []string
+		for _, pattern := range strings.Fields(a[0]) {
+			matches = append(matches, m.glob(pattern)...)
+		}
+		return strings.Join(matches, " "), nil
+	})
+	// The conditional functions expand their arguments up to the one that
+	// decides, like make does
+	r.RegisterLazy("if", func(_ *Makefile, args []string, expand func(string) string) (string, error) {
+		if len(args) < 2 {
+			return "", fmt.Errorf("insufficient number of arguments (%d)", len(args))
+		}
+		if strings.TrimSpace(expand(args[0])) != "" {
+			return expand(args[1]), nil
+		}
+		return expand(strings.Join(args[2:], ",")), nil
+	})
+	r.RegisterLazy("or", func(_ *Makefile, args []string, expand func(string) string) (string, error) {
+		for _, arg := range args {
+			if arg = strings.TrimSpace(expand(arg)); arg != "" {
+				return arg, nil
+			}
+		}
+		return "", nil
+	})
+	r.RegisterLazy("and", func(_ *Makefile, args []string, expand func(string) string) (string, error) {
+		last := ""
+		for _, arg := range args {
+			if last = strings.TrimSpace(expand(arg)); last == "" {
+				return "", nil
+			}
+		}
+		return last, nil
+	})
+	r.RegisterLazy("foreach", func(m *Makefile, args []string, expand func(string) string) (string, error) {
+		if len(args) < 3 {
+			return "", fmt.Errorf("insufficient number of arguments (%d)", len(args))
+		}
+		name := strings.TrimSpace(expand(args[0]))
+		text := strings.Join(args[2:], ",")
+		var results []string
+		for _, word := range strings.Fields(expand(args[1])) {
+			restore := m.bindVariables([]string{name}, []string{word})
+			results = append(results, expand(text))
+			restore()
+		}
+		return strings.Join(results, " "), nil
+	})
+	r.RegisterLazy("call", func(m *Makefile, args []string, expand func(string) string) (string, error) {
+		if len(args) < 1 {
+			return "", fmt.Errorf("insufficient number of arguments (%d)", len(args))
+		}
+		names := make([]string, len(args))
+		values := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = strconv.Itoa(i)
+			values[i] = expand(arg)
+		}
+		values[0] = strings.TrimSpace(values[0])
+		v, ok := m.Variables[values[0]]
+		if !ok {
+			return "", nil
+		}
+		restore := m.bindVariables(names, values)
+		defer restore()
+		return expand(v.Value), nil
+	})
+	r.Register("shell", func(m *Makefile, args []string) (string, error) {
+		a, err := arity(args, 1)
+		if err != nil {
+			return "", err
+		}
+		return m.shellOutput(a[0])
+	})
+	r.Register("data", dataFunction)
+	r.Register("secret", func(m *Makefile, args []string) (string, error) {
+		a, err := arity(args, 1)
+		if err != nil {
+			return "", err
+		}
+		return m.secretPlaceholder(strings.TrimSpace(a[0]))
+	})
+}
+
+// bindVariables defines simple variables with the values, as $(foreach) and
+// $(call) do while they expand their text, and returns a function that
+// restores the variables they hide
+func (m *Makefile) bindVariables(names, values []string) func() {
+	hidden := make([]*Variable, len(names))
+	for i, name := range names {
+		hidden[i] = m.Variables[name]
+		m.setVariable(&Variable{Name: name, Value: values[i], Origin: OriginMakefile, Line: m.line})
+	}
+	return func() {
+		for i := len(names) - 1; i >= 0; i-- {
+			if hidden[i] != nil {
+				m.setVariable(hidden[i])
+			} else {
+				m.unsetVariable(names[i])
+			}
+		}
+	}
+}
+
+// shellOutput runs a $(shell) command through the Makefile's shell, or the
+// system's if it has none, and returns its output with newlines turned into
+// spaces. Unlike in make, a failing command is an error.
+func (m *Makefile) shellOutput(command string) (string, error) {
+	shell, _ := m.shellFor(nil)
+	if shell == "" {
+		shell = "/bin/sh"
+		if runtime.GOOS == "windows" {
+			shell = "cmd"
+		}
+	}
+	cmd := exec.Command(shell, shellFlag(shell), command)
+	cmd.Dir = m.Dir
+	cmd.Env = m.environ()
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimRight(strings.ReplaceAll(string(out), "\r\n", "\n"), "\n")
+	return strings.ReplaceAll(text, "\n", " "), nil
+}
+
+// fixedArgs adapts fn to a function taking n arguments, see arity
+func fixedArgs(n int, fn func(args []string) string) Function {
+	return func(_ *Makefile, args []string) (string, error) {
+		a, err := arity(args, n)
+		if err != nil {
+			return "", err
+		}
+		return fn(a), nil
+	}
+}
+
+// arity checks that a function taking n arguments got them all. Like in
+// make, the commas after the first n-1 belong to the last argument.
+func arity(args []string, n int) ([]string, error) {
+	if len(args) < n {
+		return nil, fmt.Errorf("insufficient number of arguments (%d)", len(args))
+	}
+	if len(args) > n {
+		args = append(args[:n-1:n-1], strings.Join(args[n-1:], ","))
+	}
+	return args, nil
+}
+
+func wordIndex(arg string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil {
+		return 0, fmt.Errorf("non-numeric first argument '%s'", arg)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("first argument must be greater than 0, not %d", n)
+	}
+	return n, nil
+}
+
+// mapWords applies fn to the white space separated words of text, dropping
+// the words it maps to nothing
+func mapWords(text string, fn func(word string) string) string {
+	var out []string
+	for _, word := range strings.Fields(text) {
+		if mapped := fn(word); mapped != "" {
+			out = append(out, mapped)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// filterWords keeps the words of text that match one of the patterns, or
+// the ones that match none of them
+func filterWords(patterns, text string, keep bool) string {
+	return mapWords(text, func(word string) string {
+		matched := false
+		for _, pattern := range strings.Fields(patterns) {
+			if _, ok := matchPattern(pattern, word); ok {
+				matched = true
+				break
+			}
+		}
+		if matched == keep {
+			return word
+		}
+		return ""
+	})
+}
+
+// matchPattern matches word against a pattern with an optional '%' and
+// returns the part the '%' stands for
+func matchPattern(pattern, word string) (string, bool) {
+	prefix, suffix, wildcard := strings.Cut(pattern, "%")
+	if !wildcard {
+		return "", pattern == word
+	}
+	if len(word) < len(prefix)+len(suffix) || !strings.HasPrefix(word, prefix) || !strings.HasSuffix(word, suffix) {
+		return "", false
+	}
+	return word[len(prefix) : len(word)-len(suffix)], true
+}
+
+// splitSuffix splits a file name before the last '.' of its last element
+func splitSuffix(word string) (string, string) {
+	i := strings.LastIndex(word, ".")
+	if i < 0 || strings.Contains(word[i:], "/") {
+		return word, ""
+	}
+	return word[:i], word[i:]
+}
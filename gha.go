@@ -0,0 +1,117 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ghaInstall are the steps of a job that make smmake available
+const ghaInstall = `      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+      - name: Install smmake
+        run: |
+          git clone --depth 1 https://github.com/datstma/smmake.git "$RUNNER_TEMP/smmake"
+          (cd "$RUNNER_TEMP/smmake/cmd" && go build -o "$RUNNER_TEMP/bin/smmake" .)
+          echo "$RUNNER_TEMP/bin" >> "$GITHUB_PATH"
+`
+
+// exportGHA writes a GitHub Actions workflow with a job for each of the
+// targets, or each documented target if none are given, which runs smmake
+// for the target. A job needs the jobs of the targets its target depends
+// on, directly or through targets without a job; as jobs run on different
+// machines, it still builds those itself.
+func exportGHA(m *Makefile, w io.Writer, targets []string) ([]string, error) {
+	if len(targets) == 0 {
+		for _, name := range sortedKeys(m.Targets) {
+			if t := m.Targets[name]; t.Description != "" && !t.Pattern {
+				targets = append(targets, name)
+			}
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("no targets to export, give them or document them with '## text'")
+		}
+	}
+	g, err := m.Graph(targets...)
+	if err != nil {
+		return nil, err
+	}
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make(map[string]bool)
+	for _, name := range targets {
+		jobs[name] = true
+	}
+
+	var warnings []string
+	ids := make(map[string]string)
+	for _, name := range targets {
+		ids[name] = exportName(name)
+		if ids[name] != name {
+			warnings = append(warnings, fmt.Sprintf("the job of target '%s' is named '%s', job ids only allow letters, digits, '_' and '-'", name, ids[name]))
+		}
+	}
+
+	fmt.Fprintf(w, "# Generated by 'smmake export gha' from %s\n", m.Filename)
+	fmt.Fprintln(w, "name: smmake")
+	fmt.Fprintln(w, "on:\n  push:\n  pull_request:")
+	fmt.Fprintln(w, "jobs:")
+	for _, name := range order {
+		if !jobs[name] {
+			continue
+		}
+		fmt.Fprintf(w, "  %s:\n", ids[name])
+		fmt.Fprintf(w, "    name: %s\n", yamlString(name))
+		if needs := ghaNeeds(g, name, jobs); len(needs) > 0 {
+			for i := range needs {
+				needs[i] = ids[needs[i]]
+			}
+			fmt.Fprintf(w, "    needs: [%s]\n", strings.Join(needs, ", "))
+		}
+		fmt.Fprintln(w, "    runs-on: ubuntu-latest")
+		fmt.Fprintln(w, "    steps:")
+		fmt.Fprint(w, ghaInstall)
+		fmt.Fprintf(w, "      - run: smmake %s\n", yamlString(shellQuote(name)))
+	}
+	return warnings, nil
+}
+
+// ghaNeeds returns the targets with jobs that name depends on, looking
+// through the targets without one, sorted
+func ghaNeeds(g *Graph, name string, jobs map[string]bool) []string {
+	seen := make(map[string]bool)
+	var needs []string
+	var visit func(string)
+	visit = func(node string) {
+		for _, dep := range uniqueDeps(g.Nodes[node].Deps) {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if jobs[dep] {
+				needs = append(needs, dep)
+				continue
+			}
+			visit(dep)
+		}
+	}
+	visit(name)
+	sort.Strings(needs)
+	return needs
+}
+
+// yamlString writes s as a YAML scalar, quoted if it has to be
+func yamlString(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,290 @@
+package smmake
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The glob engine behind $(wildcard), glob prerequisites, glob() of scripts
+// and the smmake: builtins. Patterns use forward slashes on every platform
+// and never go through a shell:
+//
+//	*       any run of characters in one path segment, dot files included
+//	?       any single character but '/'
+//	[abc]   one of the characters, [a-z] a range, [!abc] or [^abc] none of them
+//	{a,b}   either alternative, which may hold slashes and nest
+//	**      as a whole segment, zero or more directories
+//	\*      a literal '*', and so on for the other special characters
+//
+// ** doesn't descend into symbolic links to directories, so a link back to
+// a parent can't make a glob loop. Matches come back sorted, without
+// duplicates, spelled relative to the pattern like build/*.o gives
+// build/main.o.
+
+// hasGlobMeta reports whether s holds any of the special glob characters
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[{")
+}
+
+// expandBraces turns the {a,b} alternatives of a pattern into a pattern for
+// each, at most 1024 in all
+func expandBraces(pattern string) []string {
+	depth, open := 0, -1
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				open = i
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				// {a} is no alternative, only its content might be
+				open = -1
+				continue
+			}
+			prefix, suffix := pattern[:open], pattern[i+1:]
+			var patterns []string
+			start := open + 1
+			for _, end := range append(commas, i) {
+				for _, p := range expandBraces(prefix + pattern[start:end] + suffix) {
+					if len(patterns) < 1024 {
+						patterns = append(patterns, p)
+					}
+				}
+				start = end + 1
+			}
+			return patterns
+		}
+	}
+	return []string{pattern}
+}
+
+// matchSegment matches one segment of a name against one of a pattern
+func matchSegment(pattern, name string) bool {
+	matched, _ := path.Match(negateClasses(pattern), name)
+	return matched
+}
+
+// negateClasses turns the [!...] classes of a pattern into the [^...] of
+// path.Match, which has no [!...]. A '!' that doesn't start a class stays,
+// like the one of an escaped \[! or of [a!].
+func negateClasses(pattern string) string {
+	if !strings.Contains(pattern, "[!") {
+		return pattern
+	}
+	b := []byte(pattern)
+	inClass := false
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '\\':
+			i++
+		case inClass:
+			inClass = b[i] != ']'
+		case b[i] == '[':
+			inClass = true
+			if i+1 < len(b) && b[i+1] == '!' {
+				b[i+1] = '^'
+				i++
+			}
+		}
+	}
+	return string(b)
+}
+
+// matchGlob reports whether a slash separated name matches pattern, for
+// names that aren't files, e.g. targets
+func matchGlob(pattern, name string) bool {
+	names := strings.Split(name, "/")
+	for _, p := range expandBraces(pattern) {
+		if matchSegments(strings.Split(p, "/"), names) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 || !matchSegment(patterns[0], names[0]) {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// globber finds files for the glob engine. Its names are the names of the
+// pattern, where "" is the directory the pattern is relative to.
+type globber struct {
+	readDir func(dir string) ([]fs.DirEntry, error)
+	exists  func(name string) bool
+}
+
+// globber returns the glob engine for the files of the Makefile, in FS if
+// set, with names relative to Dir
+func (m *Makefile) globber() globber {
+	if m.FS == nil {
+		return dirGlobber(m.Dir)
+	}
+	return globber{
+		readDir: func(dir string) ([]fs.DirEntry, error) {
+			return fs.ReadDir(m.FS, fsName(m.path(dir)))
+		},
+		exists: func(name string) bool {
+			_, err := fs.Stat(m.FS, fsName(m.path(name)))
+			return err == nil
+		},
+	}
+}
+
+// dirGlobber returns the glob engine for the operating system's files, with
+// names relative to dir
+func dirGlobber(dir string) globber {
+	return globber{
+		readDir: func(name string) ([]fs.DirEntry, error) {
+			return os.ReadDir(inDir(dir, filepath.FromSlash(name)))
+		},
+		exists: func(name string) bool {
+			_, err := os.Lstat(inDir(dir, filepath.FromSlash(name)))
+			return err == nil
+		},
+	}
+}
+
+// glob returns the files matching pattern. A pattern without special
+// characters matches the file it names, if that exists.
+func (g globber) glob(pattern string) []string {
+	pattern = filepath.ToSlash(pattern)
+	found := make(map[string]bool)
+	for _, p := range expandBraces(pattern) {
+		segments := strings.Split(p, "/")
+		// the directories before the first special segment are read as
+		// they are, including a leading / or C:
+		literal := 0
+		for literal < len(segments) && !hasGlobMeta(segments[literal]) {
+			literal++
+		}
+		if literal == len(segments) {
+			if g.exists(unescapeGlob(p)) {
+				found[unescapeGlob(p)] = true
+			}
+			continue
+		}
+		dir := unescapeGlob(strings.Join(segments[:literal], "/"))
+		if literal > 0 && dir == "" {
+			dir = "/"
+		}
+		g.walk(dir, segments[literal:], found)
+	}
+	matches := make([]string, 0, len(found))
+	for match := range found {
+		matches = append(matches, match)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// walk adds the files below dir that match the rest of the segments
+func (g globber) walk(dir string, segments []string, found map[string]bool) {
+	if len(segments) == 0 {
+		found[dir] = true
+		return
+	}
+	segment := segments[0]
+	if !hasGlobMeta(segment) {
+		name := joinGlob(dir, unescapeGlob(segment))
+		if len(segments) == 1 {
+			if g.exists(name) {
+				found[name] = true
+			}
+			return
+		}
+		g.walk(name, segments[1:], found)
+		return
+	}
+	listing := dir
+	if listing == "" {
+		listing = "."
+	}
+	entries, err := g.readDir(listing)
+	if err != nil {
+		return
+	}
+	if segment == "**" {
+		if len(segments) > 1 {
+			g.walk(dir, segments[1:], found)
+		}
+		for _, entry := range entries {
+			name := joinGlob(dir, entry.Name())
+			if len(segments) == 1 {
+				found[name] = true
+			}
+			if entry.IsDir() {
+				g.walk(name, segments, found)
+			}
+		}
+		return
+	}
+	for _, entry := range entries {
+		if !matchSegment(segment, entry.Name()) {
+			continue
+		}
+		name := joinGlob(dir, entry.Name())
+		if len(segments) == 1 {
+			found[name] = true
+		} else {
+			g.walk(name, segments[1:], found)
+		}
+	}
+}
+
+func joinGlob(dir, name string) string {
+	switch {
+	case dir == "":
+		return name
+	case strings.HasSuffix(dir, "/"):
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// unescapeGlob drops the backslashes of escaped special characters
+func unescapeGlob(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`*?[]{}\,`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
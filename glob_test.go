@@ -0,0 +1,90 @@
+package smmake
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.c", "main.c", true},
+		{"*.c", "src/main.c", false},
+		{"*", ".hidden", true},
+		{"*.c", ".main.c", true},
+		{"?.c", "a.c", true},
+		{"?.c", "ab.c", false},
+
+		{"**", "a/b/c", true},
+		{"**/*.c", "main.c", true},
+		{"**/*.c", "src/lib/main.c", true},
+		{"src/**/*.c", "src/main.c", true},
+		{"src/**/*.c", "lib/main.c", false},
+		{"src/**", "src", true},
+
+		{"*.{c,h}", "main.h", true},
+		{"*.{c,h}", "main.o", false},
+		{"{src,lib}/*.c", "lib/a.c", true},
+		{"{a,{b,c}}.txt", "c.txt", true},
+		{"{a}.txt", "{a}.txt", true},
+
+		{"[a-c].o", "b.o", true},
+		{"[!a-c].o", "b.o", false},
+		{"[!a-c].o", "d.o", true},
+		{"[^a-c].o", "d.o", true},
+		{"[a!].o", "!.o", true},
+		{"x[!.]y", "x.y", false},
+
+		{`\*.c`, "*.c", true},
+		{`\*.c`, "main.c", false},
+		{`\[!x].c`, "[!x].c", true},
+		{`\[!x].c`, "a.c", false},
+		{`a\?`, "a?", true},
+		{`a\?`, "ab", false},
+	}
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.name); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestGlobFiles(t *testing.T) {
+	m := NewMakefile()
+	m.FS = fstest.MapFS{
+		".env":             {},
+		"main.c":           {},
+		"util.h":           {},
+		"src/a.c":          {},
+		"src/.hidden.c":    {},
+		"src/lib/b.c":      {},
+		"src/lib/b.h":      {},
+		"docs/[!x].md":     {},
+		"docs/readme.md":   {},
+		"build/out/main.o": {},
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.c", []string{"main.c"}},
+		{"*", []string{".env", "build", "docs", "main.c", "src", "util.h"}},
+		{"src/*.c", []string{"src/.hidden.c", "src/a.c"}},
+		{"src/**/*.c", []string{"src/.hidden.c", "src/a.c", "src/lib/b.c"}},
+		{"**/*.o", []string{"build/out/main.o"}},
+		{"src/lib/*.{c,h}", []string{"src/lib/b.c", "src/lib/b.h"}},
+		{"{main,util}.*", []string{"main.c", "util.h"}},
+		{"docs/[!r]*.md", []string{"docs/[!x].md"}},
+		{`docs/\[!x].md`, []string{"docs/[!x].md"}},
+		{"src/[!a].c", nil},
+		{"missing/*.c", nil},
+	}
+	for _, test := range tests {
+		if got := m.glob(test.pattern); !slices.Equal(got, test.want) {
+			t.Errorf("glob(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
@@ -1,3 +1,12 @@
 module smmake
 
 go 1.22
+
+require (
+	github.com/tetratelabs/wazero v1.8.2
+	go.starlark.net v0.0.0-20240705175910-70002002b310
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,397 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GraphNode is a single target or file in the dependency graph
+type GraphNode struct {
+	Name    string
+	Phony   bool
+	File    bool   // no rule, expected to exist on disk
+	Pattern string // name of the pattern rule this node was instantiated from
+	Deps    []string
+}
+
+// BuildGraph collects the dependency graph reachable from roots. If no roots
+// are given, the graph of every non-pattern, non-special target is returned.
+func (m *Makefile) BuildGraph(roots ...string) (map[string]*GraphNode, error) {
+	nodes := make(map[string]*GraphNode)
+	index := m.foldIndex()
+
+	var visit func(name string) string
+	visit = func(name string) string {
+		name = canonicalName(index, name)
+		if _, ok := nodes[name]; ok {
+			return name
+		}
+		node := &GraphNode{Name: name, Phony: m.isPhony(name)}
+		nodes[name] = node
+
+		target := m.Targets[name]
+		if target == nil || target.Pattern {
+			if patternTarget := m.findMatchingPatternRule(name); patternTarget != nil {
+				target = instantiatePattern(patternTarget, name)
+				node.Pattern = patternTarget.Name
+			} else {
+				node.File = true
+				return name
+			}
+		}
+
+		for _, dep := range target.Dependencies {
+			node.Deps = append(node.Deps, visit(m.expandVariables(dep)))
+		}
+		return name
+	}
+
+	if len(roots) > 0 {
+		for _, root := range roots {
+			root = canonicalName(index, normalizeName(root))
+			if m.Targets[root] == nil && m.findMatchingPatternRule(root) == nil {
+				return nil, m.unknownTargetError(root)
+			}
+			visit(root)
+		}
+		return nodes, nil
+	}
+
+	for name, t := range m.Targets {
+		if t.Pattern || strings.HasPrefix(name, ".") {
+			continue
+		}
+		visit(name)
+	}
+	return nodes, nil
+}
+
+// Graph is a resolved dependency graph, for tools that analyse a Makefile
+// rather than run it, e.g. to find the targets affected by a change
+type Graph struct {
+	// Nodes holds every target and file of the graph by name
+	Nodes map[string]*GraphNode
+	// dependents maps a node to the nodes that depend on it directly
+	dependents map[string][]string
+}
+
+// Edge is a dependency of one node on another
+type Edge struct {
+	From string // the target
+	To   string // its prerequisite
+}
+
+// Graph returns the dependency graph reachable from roots, or of every
+// target if no roots are given, see BuildGraph
+func (m *Makefile) Graph(roots ...string) (*Graph, error) {
+	nodes, err := m.BuildGraph(roots...)
+	if err != nil {
+		return nil, err
+	}
+	g := &Graph{Nodes: nodes, dependents: make(map[string][]string)}
+	for _, name := range sortedKeys(nodes) {
+		for _, dep := range uniqueDeps(nodes[name].Deps) {
+			g.dependents[dep] = append(g.dependents[dep], name)
+		}
+	}
+	return g, nil
+}
+
+// Edges returns every dependency in the graph, sorted by target and then in
+// prerequisite order
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, name := range sortedKeys(g.Nodes) {
+		for _, dep := range g.Nodes[name].Deps {
+			edges = append(edges, Edge{From: name, To: dep})
+		}
+	}
+	return edges
+}
+
+// Roots returns the nodes nothing depends on, sorted
+func (g *Graph) Roots() []string {
+	var roots []string
+	for _, name := range sortedKeys(g.Nodes) {
+		if len(g.dependents[name]) == 0 {
+			roots = append(roots, name)
+		}
+	}
+	return roots
+}
+
+// Leaves returns the nodes without prerequisites, sorted
+func (g *Graph) Leaves() []string {
+	var leaves []string
+	for _, name := range sortedKeys(g.Nodes) {
+		if len(g.Nodes[name].Deps) == 0 {
+			leaves = append(leaves, name)
+		}
+	}
+	return leaves
+}
+
+// Dependents returns the nodes that depend on name directly, sorted
+func (g *Graph) Dependents(name string) []string {
+	return g.dependents[name]
+}
+
+// Affected returns the nodes that depend on any of names, directly or
+// through other nodes, sorted. Names not in the graph affect nothing.
+func (g *Graph) Affected(names ...string) []string {
+	affected := make(map[string]bool)
+	queue := append([]string(nil), names...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dependent := range g.dependents[name] {
+			if !affected[dependent] {
+				affected[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	return sortedKeys(affected)
+}
+
+// TopologicalOrder returns the nodes with every prerequisite before the
+// targets that need it, ties broken by name. It fails with a *CycleError if
+// the graph has a cycle.
+func (g *Graph) TopologicalOrder() ([]string, error) {
+	pending := make(map[string]int, len(g.Nodes))
+	var ready []string
+	for _, name := range sortedKeys(g.Nodes) {
+		pending[name] = len(uniqueDeps(g.Nodes[name].Deps))
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	order := make([]string, 0, len(g.Nodes))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range g.dependents[name] {
+			if pending[dependent]--; pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(order) < len(g.Nodes) {
+		for _, name := range sortedKeys(pending) {
+			if pending[name] > 0 {
+				if path := g.cycleFrom(name); path != nil {
+					return nil, &CycleError{Path: path}
+				}
+			}
+		}
+	}
+	return order, nil
+}
+
+// cycleFrom returns the first cycle found following the prerequisites of
+// the names in order, starting and ending with the same node
+func (g *Graph) cycleFrom(names ...string) []string {
+	onStack := make(map[string]int)
+	done := make(map[string]bool)
+	var stack []string
+	var walk func(name string) []string
+	walk = func(name string) []string {
+		if i, ok := onStack[name]; ok {
+			return append(append([]string(nil), stack[i:]...), name)
+		}
+		if done[name] || g.Nodes[name] == nil {
+			return nil
+		}
+		onStack[name] = len(stack)
+		stack = append(stack, name)
+		for _, dep := range g.Nodes[name].Deps {
+			if cycle := walk(dep); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		delete(onStack, name)
+		done[name] = true
+		return nil
+	}
+	for _, name := range names {
+		if cycle := walk(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// checkCycles fails with a *CycleError if the graph of goal has a cycle,
+// so a build finds it before any recipe runs. Goals without a rule are
+// left to the executor to report.
+func (m *Makefile) checkCycles(goal string) error {
+	nodes, err := m.BuildGraph(goal)
+	if err != nil {
+		return nil
+	}
+	if path := (&Graph{Nodes: nodes}).cycleFrom(sortedKeys(nodes)...); path != nil {
+		return m.cycleError(path)
+	}
+	return nil
+}
+
+// cycleError returns the error of a cycle, with the location of the rule
+// of each target
+func (m *Makefile) cycleError(path []string) *CycleError {
+	e := &CycleError{Path: path, Locations: make([]string, len(path))}
+	for i, name := range path {
+		t := m.Targets[name]
+		if t == nil || t.Pattern {
+			t = m.findMatchingPatternRule(name)
+		}
+		if t != nil && t.Line > 0 && m.Filename != "" {
+			e.Locations[i] = fmt.Sprintf("%s:%d", m.Filename, t.Line)
+		}
+	}
+	return e
+}
+
+// uniqueDeps drops repeated names, for targets listing a prerequisite twice
+func uniqueDeps(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	var unique []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// isPhony reports whether the target is listed as a prerequisite of .PHONY
+func (m *Makefile) isPhony(name string) bool {
+	return m.lists(".PHONY", name)
+}
+
+// patternStem returns the part of name the '%' of a pattern rule matches,
+// which is never empty. The text around '%' is compared ignoring case if
+// fold is set.
+func patternStem(t *Target, name string, fold bool) (string, bool) {
+	from, to := len(t.PatternFrom), len(t.PatternTo)
+	if len(name) <= from+to {
+		return "", false
+	}
+	prefix, suffix := name[:from], name[len(name)-to:]
+	if fold {
+		if !strings.EqualFold(prefix, t.PatternFrom) || !strings.EqualFold(suffix, t.PatternTo) {
+			return "", false
+		}
+	} else if prefix != t.PatternFrom || suffix != t.PatternTo {
+		return "", false
+	}
+	return name[from : len(name)-to], true
+}
+
+// instantiatePattern returns a copy of a pattern rule for the given target
+// name, with '%' in the prerequisites replaced by the matched stem. The rule
+// was matched already, ignoring case if IgnoreCase is set, so case is
+// ignored here.
+func instantiatePattern(t *Target, name string) *Target {
+	stem, ok := patternStem(t, name, true)
+	if !ok {
+		stem = name
+	}
+
+	instance := *t
+	instance.Name = name
+	instance.Pattern = false
+	instance.Dependencies = make([]string, 0, len(t.Dependencies))
+	for _, dep := range t.Dependencies {
+		instance.Dependencies = append(instance.Dependencies, strings.ReplaceAll(dep, "%", stem))
+	}
+	return &instance
+}
+
+// WriteGraph writes the dependency graph in the given format ("dot" or
+// "mermaid"), optionally pruned to the targets reachable from roots.
+//
+// Phony targets are drawn dashed, files without a rule as notes, and pattern
+// rule instantiations are labelled with the pattern they came from.
+func (m *Makefile) WriteGraph(w io.Writer, roots []string, format string) error {
+	nodes, err := m.BuildGraph(roots...)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "", "dot":
+		writeDOT(w, nodes)
+	case "mermaid":
+		writeMermaid(w, nodes)
+	default:
+		return fmt.Errorf("unknown graph format '%s' (expected 'dot' or 'mermaid')", format)
+	}
+	return nil
+}
+
+func writeDOT(w io.Writer, nodes map[string]*GraphNode) {
+	fmt.Fprintln(w, "digraph smmake {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, name := range sortedKeys(nodes) {
+		node := nodes[name]
+		var attrs []string
+		label := node.Name
+		if node.Pattern != "" {
+			label += "\n(" + node.Pattern + ")"
+		}
+		attrs = append(attrs, fmt.Sprintf("label=%q", label))
+		if node.Phony {
+			attrs = append(attrs, "style=dashed")
+		}
+		if node.File {
+			attrs = append(attrs, "shape=note")
+		}
+		fmt.Fprintf(w, "  %q [%s];\n", node.Name, strings.Join(attrs, ", "))
+	}
+	for _, name := range sortedKeys(nodes) {
+		for _, dep := range nodes[name].Deps {
+			fmt.Fprintf(w, "  %q -> %q;\n", name, dep)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func writeMermaid(w io.Writer, nodes map[string]*GraphNode) {
+	names := sortedKeys(nodes)
+	ids := make(map[string]string, len(names))
+	for i, name := range names {
+		ids[name] = fmt.Sprintf("n%d", i)
+	}
+
+	fmt.Fprintln(w, "graph LR")
+	for _, name := range names {
+		node := nodes[name]
+		label := strings.ReplaceAll(node.Name, `"`, "#quot;")
+		if node.Pattern != "" {
+			label += "<br/>(" + node.Pattern + ")"
+		}
+		class := ""
+		switch {
+		case node.Phony:
+			class = ":::phony"
+		case node.File:
+			class = ":::file"
+		}
+		fmt.Fprintf(w, "  %s[\"%s\"]%s\n", ids[name], label, class)
+	}
+	for _, name := range names {
+		for _, dep := range nodes[name].Deps {
+			fmt.Fprintf(w, "  %s --> %s\n", ids[name], ids[dep])
+		}
+	}
+	fmt.Fprintln(w, "  classDef phony stroke-dasharray: 5 5")
+	fmt.Fprintln(w, "  classDef file fill:#eee")
+}
@@ -0,0 +1,71 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"smmake/internal/color"
+)
+
+// PrintTargetHelp lists the documented targets, the ones with a trailing
+// "## text" comment, grouped by the "##@ Section" comment above them. It is
+// what 'smmake help' runs when the Makefile doesn't define a help target.
+//
+// Parameters:
+//   - w: The writer the help text is printed to.
+func (m *Makefile) PrintTargetHelp(w io.Writer) {
+	var documented, all []*Target
+	for name, t := range m.Targets {
+		if t.Pattern || strings.HasPrefix(name, ".") {
+			continue
+		}
+		all = append(all, t)
+		if t.Description != "" {
+			documented = append(documented, t)
+		}
+	}
+
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  smmake [target...]")
+
+	// Without any documentation, at least name the targets
+	if len(documented) == 0 {
+		sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+		fmt.Fprintln(w, "\nTargets:")
+		for _, t := range all {
+			fmt.Fprintf(w, "  %s\n", color.Target(t.Name))
+		}
+		return
+	}
+
+	// Targets keep the order they are defined in the Makefile, and sections
+	// the order they first appear in
+	sort.Slice(documented, func(i, j int) bool { return documented[i].Line < documented[j].Line })
+	width := utf8.RuneCountInString("help")
+	var sections []string
+	bySection := make(map[string][]*Target)
+	for _, t := range documented {
+		width = max(width, utf8.RuneCountInString(t.Name))
+		if _, ok := bySection[t.Section]; !ok {
+			sections = append(sections, t.Section)
+		}
+		bySection[t.Section] = append(bySection[t.Section], t)
+	}
+
+	for _, section := range sections {
+		title := section
+		if title == "" {
+			title = "Targets"
+		}
+		fmt.Fprintf(w, "\n%s:\n", color.Colorize(color.Bold, title))
+		for _, t := range bySection[section] {
+			fmt.Fprintf(w, "  %s%s  %s\n", color.Target(t.Name), strings.Repeat(" ", width-utf8.RuneCountInString(t.Name)), t.Description)
+		}
+	}
+	if m.Targets["help"] == nil {
+		fmt.Fprintf(w, "\n  %s%s  %s\n", color.Target("help"), strings.Repeat(" ", width-4), "Show this help")
+	}
+}
@@ -0,0 +1,83 @@
+package smmake
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// An Importer adds the tasks another build tool defines in dir, relative to
+// Makefile.Dir, to a Makefile as targets
+type Importer func(m *Makefile, dir string) error
+
+// Importers are the build tools the import directive and Makefile.Import
+// know, by the name the directive uses:
+//
+//	import npm web
+//
+// makes the scripts of web/package.json targets named web:build, web:test
+// and so on.
+var Importers = map[string]Importer{
+	"just":     importJustfile,
+	"npm":      importNpm,
+	"taskfile": importTaskfile,
+}
+
+// Import adds the tasks of another build tool in dir as targets, see
+// Importers. The targets are named after dir, or after the tool for the
+// Makefile's own directory, and don't replace the Makefile's own rules.
+func (m *Makefile) Import(kind, dir string) error {
+	importer := Importers[kind]
+	if importer == nil {
+		return fmt.Errorf("can't import '%s', known are %s", kind, strings.Join(sortedKeys(Importers), ", "))
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if err := importer(m, dir); err != nil {
+		return fmt.Errorf("error importing %s from %s: %w", kind, dir, err)
+	}
+	return nil
+}
+
+// importDirective evaluates "import KIND [DIR]"
+func (m *Makefile) importDirective(args string, line int) {
+	if m.record != nil {
+		m.record.refuse("it imports tasks")
+	}
+	fields := strings.Fields(m.expandVariables(args))
+	if len(fields) == 0 || len(fields) > 2 {
+		m.logf(LogWarn, "", "%s:%d: import wants a kind and a directory, e.g. 'import npm web'", m.Filename, line)
+		return
+	}
+	dir := "."
+	if len(fields) == 2 {
+		dir = fields[1]
+	}
+	if err := m.Import(fields[0], dir); err != nil {
+		m.logf(LogWarn, "", "%s:%d: %v", m.Filename, line, err)
+	}
+}
+
+// importPrefix returns what the names of the targets imported from dir
+// start with: the name of the directory and a colon
+func importPrefix(kind, dir string) string {
+	name := filepath.Base(filepath.Clean(dir))
+	if name == "." || name == string(filepath.Separator) {
+		name = kind
+	}
+	return name + ":"
+}
+
+// importTarget returns a builder for a phony target imported from another
+// build tool, or nil if the Makefile already has a rule of that name
+func (m *Makefile) importTarget(name, section string) *TargetBuilder {
+	if m.Targets[name] != nil {
+		m.logf(LogDebug, "", "  not importing '%s', the Makefile has a rule for it", name)
+		return nil
+	}
+	m.logf(LogDebug, "", "  importing target '%s'", name)
+	b := m.Target(name).Phony()
+	b.t.Section = section
+	return b
+}
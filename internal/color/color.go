@@ -0,0 +1,63 @@
+// Package color decorates smmake's output with ANSI colors.
+package color
+
+import (
+	"fmt"
+	"os"
+)
+
+// Enabled controls whether output is decorated with ANSI colors
+var Enabled bool
+
+// ANSI codes for Colorize
+const (
+	Bold   = "1"
+	Green  = "32"
+	Cyan   = "1;36"
+	Yellow = "1;33"
+	Red    = "1;31"
+)
+
+// Setup decides whether colors are used for the given --color mode.
+//
+// In "auto" mode colors are only enabled when stdout is a terminal and the
+// NO_COLOR environment variable is not set (see https://no-color.org).
+func Setup(mode string) error {
+	switch mode {
+	case "always":
+		Enabled = true
+	case "never":
+		Enabled = false
+	case "", "auto":
+		_, noColor := os.LookupEnv("NO_COLOR")
+		Enabled = !noColor && IsTerminal(os.Stdout)
+	default:
+		return fmt.Errorf("invalid --color value '%s' (expected always, never or auto)", mode)
+	}
+	if Enabled {
+		enableVirtualTerminal()
+	}
+	return nil
+}
+
+// IsTerminal reports whether the file is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Colorize wraps s in the given ANSI color code when colors are enabled
+func Colorize(code, s string) string {
+	if !Enabled {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+func Target(s string) string  { return Colorize(Cyan, s) }
+func Command(s string) string { return Colorize(Green, s) }
+func Warning(s string) string { return Colorize(Yellow, s) }
+func Error(s string) string   { return Colorize(Red, s) }
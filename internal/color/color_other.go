@@ -0,0 +1,7 @@
+//go:build !windows
+
+package color
+
+// enableVirtualTerminal is a no-op, terminals outside Windows handle ANSI
+// escape sequences natively.
+func enableVirtualTerminal() {}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package color
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const enableVirtualTerminalProcessing = 0x0004
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// enableVirtualTerminal turns on ANSI escape sequence processing for the
+// Windows console, which is off by default on older Windows 10 builds.
+func enableVirtualTerminal() {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		var mode uint32
+		handle := f.Fd()
+		if r, _, _ := procGetConsoleMode.Call(handle, uintptr(unsafe.Pointer(&mode))); r == 0 {
+			continue
+		}
+		procSetConsoleMode.Call(handle, uintptr(mode|enableVirtualTerminalProcessing))
+	}
+}
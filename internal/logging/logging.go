@@ -0,0 +1,190 @@
+// Package logging prints smmake's messages at the Verbosity selected on the
+// command line, as plain text or JSON lines.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"smmake/internal/color"
+)
+
+// Level controls how much smmake reports about what it is doing
+type Level int
+
+const (
+	LevelError   Level = iota
+	LevelWarn          // warnings about the Makefile
+	LevelInfo          // default: recipe lines and errors
+	LevelVerbose       // -v: target scheduling decisions
+	LevelTrace         // -vv: variable expansion traces
+	LevelDebug         // --debug: parser decisions
+)
+
+var levelNames = map[Level]string{
+	LevelError:   "error",
+	LevelWarn:    "warn",
+	LevelInfo:    "info",
+	LevelVerbose: "verbose",
+	LevelTrace:   "trace",
+	LevelDebug:   "debug",
+}
+
+var (
+	// Verbosity is the highest level that is printed
+	Verbosity = LevelInfo
+	// JSON switches every message to a JSON object per line
+	JSON bool
+
+	logMutex sync.Mutex
+	// partial are the unfinished last lines of the recipe output of the
+	// targets in JSON mode, see Output
+	partial = make(map[string][]byte)
+)
+
+// logEntry is the shape of a message in --log-format json mode
+type logEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Target    string `json:"target"`
+	Message   string `json:"message"`
+}
+
+// Setup selects plain text or JSON log output
+func Setup(format string) error {
+	switch format {
+	case "", "text":
+		JSON = false
+	case "json":
+		// Structured logs are post-processed, not read on a terminal, so
+		// drop the color codes
+		JSON = true
+		color.Enabled = false
+	default:
+		return fmt.Errorf("invalid --log-format value '%s' (expected text or json)", format)
+	}
+	return nil
+}
+
+// Targetf prints a message about a target if level is enabled by the
+// current Verbosity
+func Targetf(level Level, target, format string, a ...any) {
+	if level > Verbosity {
+		return
+	}
+	out := os.Stdout
+	if level <= LevelWarn {
+		out = os.Stderr
+	}
+	msg := fmt.Sprintf(format, a...)
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if JSON {
+		writeEntry(out, levelNames[level], target, msg)
+		return
+	}
+	fmt.Fprintln(out, msg)
+}
+
+// writeEntry prints a message as a JSON entry, with logMutex held
+func writeEntry(out io.Writer, level, target, msg string) {
+	data, _ := json.Marshal(logEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     level,
+		Target:    target,
+		Message:   msg,
+	})
+	fmt.Fprintln(out, string(data))
+}
+
+// Output returns where the recipe output of a target goes in JSON mode:
+// every line becomes an entry of level "output" on stdout. A line without
+// a newline is held back until the next write or Flush.
+func Output(target string) io.Writer {
+	return outputWriter(target)
+}
+
+type outputWriter string
+
+func (w outputWriter) Write(data []byte) (int, error) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	buf := append(partial[string(w)], data...)
+	for {
+		end := bytes.IndexByte(buf, '\n')
+		if end < 0 {
+			break
+		}
+		writeEntry(os.Stdout, "output", string(w), strings.TrimSuffix(string(buf[:end]), "\r"))
+		buf = buf[end+1:]
+	}
+	if len(buf) > 0 {
+		partial[string(w)] = append([]byte(nil), buf...)
+	} else {
+		delete(partial, string(w))
+	}
+	return len(data), nil
+}
+
+// Flush prints the line of recipe output Output holds back for a target
+func Flush(target string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if buf, ok := partial[target]; ok {
+		writeEntry(os.Stdout, "output", target, string(buf))
+		delete(partial, target)
+	}
+}
+
+// Logf prints a message that is not about a specific target
+func Logf(level Level, format string, a ...any) {
+	Targetf(level, "", format, a...)
+}
+
+func Infof(format string, a ...any)    { Logf(LevelInfo, format, a...) }
+func Verbosef(format string, a ...any) { Logf(LevelVerbose, format, a...) }
+func Tracef(format string, a ...any)   { Logf(LevelTrace, format, a...) }
+func Debugf(format string, a ...any)   { Logf(LevelDebug, format, a...) }
+
+// Warnf prints a warning message to stderr
+func Warnf(format string, a ...any) {
+	TargetWarnf("", format, a...)
+}
+
+// Errorf prints an error message to stderr
+func Errorf(format string, a ...any) {
+	TargetErrorf("", format, a...)
+}
+
+// TargetWarnf prints a warning about a target to stderr
+func TargetWarnf(target, format string, a ...any) {
+	labeled(LevelWarn, target, color.Warning("Warning:"), fmt.Sprintf(format, a...))
+}
+
+// TargetErrorf prints an error about a target to stderr
+func TargetErrorf(target, format string, a ...any) {
+	labeled(LevelError, target, color.Error("Error:"), fmt.Sprintf(format, a...))
+}
+
+// labeled prints a message after its label, which JSON entries have as
+// their level instead
+func labeled(level Level, target, label, msg string) {
+	if JSON {
+		Targetf(level, target, "%s", msg)
+		return
+	}
+	Targetf(level, target, "%s %s", label, msg)
+}
+
+// Enabled reports whether messages at level would be printed, so callers
+// can skip building expensive debug output
+func Enabled(level Level) bool {
+	return level <= Verbosity
+}
@@ -0,0 +1,416 @@
+package smmake
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// justfileNames are the names just looks for
+var justfileNames = []string{"justfile", "Justfile", ".justfile"}
+
+var (
+	justAssignment  = regexp.MustCompile(`^(export\s+)?([A-Za-z_][\w-]*)\s*:=\s*(.*)$`)
+	justAlias       = regexp.MustCompile(`^alias\s+([A-Za-z_][\w-]*)\s*:=\s*([A-Za-z_][\w-]*)$`)
+	justRecipeName  = regexp.MustCompile(`^(@?)([A-Za-z_][\w-]*)`)
+	justInterpolate = regexp.MustCompile(`{{(.*?)}}`)
+	justIdentifier  = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+)
+
+// justRecipe is a recipe of a justfile
+type justRecipe struct {
+	name   string
+	quiet  bool
+	doc    string
+	params []justParam
+	deps   []string
+	body   []string
+	line   int
+}
+
+// justParam is a parameter of a recipe, which is set like a variable on
+// the command line
+type justParam struct {
+	name     string
+	value    string
+	exported bool
+}
+
+// justfile is what the importer reads from a justfile
+type justfile struct {
+	vars    map[string]string
+	exports []string
+	export  bool // set export, all variables and parameters are exported
+	recipes []*justRecipe
+	aliases [][2]string
+}
+
+// importJustfile adds a target for each recipe of the justfile in dir. The
+// parameters of a recipe are variables that can be set on the command line,
+// NAME=value, and default to the value the justfile gives them; so are the
+// justfile's own variables. A recipe's dependencies become prerequisites,
+// without their arguments. Only string literals, variables and '+' are
+// understood in {{...}} interpolations, others are left as they are, and
+// recipes that are scripts, starting with #!, are not imported.
+func importJustfile(m *Makefile, dir string) error {
+	var data []byte
+	var filename string
+	err := fs.ErrNotExist
+	for _, name := range justfileNames {
+		filename = path.Join(dir, name)
+		if data, err = m.readFile(filename); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	jf := m.parseJustfile(string(data))
+
+	prefix := importPrefix("just", dir)
+	section := strings.TrimSuffix(prefix, ":")
+	for _, r := range jf.recipes {
+		if len(r.body) > 0 && strings.HasPrefix(r.body[0], "#!") {
+			m.logf(LogWarn, "", "%s:%d: not importing recipe '%s', it is a script", filename, r.line, r.name)
+			continue
+		}
+		b := m.importTarget(prefix+r.name, section)
+		if b == nil {
+			continue
+		}
+		if !strings.HasPrefix(r.name, "_") {
+			b.Describe(r.doc)
+		}
+		b.t.Dir = dir
+		for _, dep := range r.deps {
+			b.Deps(prefix + dep)
+		}
+
+		lookup := jf.lookup(m, r)
+		b.t.Env = append(b.t.Env, jf.exportsOf(lookup)...)
+		for _, p := range r.params {
+			if p.exported || jf.export {
+				value, _ := lookup(p.name)
+				b.t.Env = append(b.t.Env, p.name+"="+value)
+			}
+		}
+		for _, line := range r.body {
+			silent := r.quiet
+			if strings.HasPrefix(line, "@") {
+				// In a quiet recipe, @ echoes the line instead
+				silent = !silent
+				line = line[1:]
+			}
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			b.t.Commands = append(b.t.Commands, Command{Cmd: interpolateJust(line, lookup), Silent: silent})
+		}
+	}
+	for _, alias := range jf.aliases {
+		if b := m.importTarget(prefix+alias[0], section); b != nil {
+			b.Deps(prefix + alias[1])
+		}
+	}
+	return nil
+}
+
+// parseJustfile reads the variables, aliases and recipes of a justfile
+func (m *Makefile) parseJustfile(src string) *justfile {
+	jf := &justfile{vars: make(map[string]string)}
+	var recipe *justRecipe
+	doc := ""
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// Lines ending in a backslash continue on the next one
+		for strings.HasSuffix(line, `\`) && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, `\`) + strings.TrimSpace(lines[i])
+		}
+		trimmed := strings.TrimSpace(line)
+
+		if recipe != nil && (trimmed == "" || line[0] == ' ' || line[0] == '\t') {
+			if trimmed != "" {
+				recipe.body = append(recipe.body, trimmed)
+			}
+			continue
+		}
+		recipe = nil
+
+		switch {
+		case trimmed == "":
+			doc = ""
+		case strings.HasPrefix(trimmed, "#"):
+			doc = strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+		case strings.HasPrefix(trimmed, "["):
+			// Attributes keep the comment above them as the doc comment
+		case trimmed == "set export" || trimmed == "set export := true":
+			jf.export = true
+		case strings.HasPrefix(trimmed, "set ") || strings.HasPrefix(trimmed, "import ") || strings.HasPrefix(trimmed, "mod "):
+			m.logf(LogDebug, "", "  ignoring justfile line %d: %s", i+1, trimmed)
+		case justAlias.MatchString(trimmed):
+			match := justAlias.FindStringSubmatch(trimmed)
+			jf.aliases = append(jf.aliases, [2]string{match[1], match[2]})
+		case justAssignment.MatchString(trimmed):
+			match := justAssignment.FindStringSubmatch(trimmed)
+			value, _ := evalJust(match[3], jf.lookupVar)
+			jf.vars[match[2]] = value
+			if match[1] != "" {
+				jf.exports = append(jf.exports, match[2])
+			}
+		default:
+			recipe = jf.parseRecipe(trimmed, doc, i+1)
+			if recipe == nil {
+				m.logf(LogDebug, "", "  ignoring justfile line %d: %s", i+1, trimmed)
+				continue
+			}
+			jf.recipes = append(jf.recipes, recipe)
+			doc = ""
+		}
+	}
+	return jf
+}
+
+// parseRecipe reads the header of a recipe, "name PARAMS: DEPS", nil if
+// the line isn't one
+func (jf *justfile) parseRecipe(header, doc string, line int) *justRecipe {
+	match := justRecipeName.FindStringSubmatch(header)
+	if match == nil {
+		return nil
+	}
+	colon := -1
+	for i, quote := len(match[0]), rune(0); i < len(header); i++ {
+		switch c := rune(header[i]); {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ':':
+			colon = i
+		}
+		if colon >= 0 {
+			break
+		}
+	}
+	if colon < 0 || strings.HasPrefix(header[colon:], ":=") {
+		return nil
+	}
+	r := &justRecipe{name: match[2], quiet: match[1] == "@", doc: doc, line: line}
+
+	for _, word := range justWords(header[len(match[0]):colon]) {
+		word = strings.TrimLeft(word, "+*")
+		p := justParam{}
+		if strings.HasPrefix(word, "$") {
+			p.exported, word = true, word[1:]
+		}
+		name, def, _ := strings.Cut(word, "=")
+		p.name = name
+		p.value, _ = evalJust(def, jf.lookupVar)
+		r.params = append(r.params, p)
+	}
+	for _, word := range justWords(header[colon+1:]) {
+		// (name ARGS) passes arguments; the dependencies after && run
+		// after the recipe in just, before it here
+		word = strings.TrimPrefix(word, "(")
+		if fields := strings.Fields(word); len(fields) > 0 && word != "&&" {
+			r.deps = append(r.deps, strings.TrimSuffix(fields[0], ")"))
+		}
+	}
+	return r
+}
+
+// justWords splits at white space outside of quotes and parentheses
+func justWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	depth, quote := 0, rune(0)
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case (c == ' ' || c == '\t') && depth == 0:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			continue
+		}
+		word.WriteRune(c)
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// lookup returns how the names in the interpolations of a recipe resolve:
+// command line variables first, then the recipe's parameters, then the
+// justfile's variables
+func (jf *justfile) lookup(m *Makefile, r *justRecipe) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if v := m.Variables[name]; v != nil && v.Origin == OriginCommandLine {
+			return v.Value, true
+		}
+		for _, p := range r.params {
+			if p.name == name {
+				return p.value, true
+			}
+		}
+		return jf.lookupVar(name)
+	}
+}
+
+func (jf *justfile) lookupVar(name string) (string, bool) {
+	value, ok := jf.vars[name]
+	return value, ok
+}
+
+// exportsOf returns the exported variables as KEY=VALUE pairs
+func (jf *justfile) exportsOf(lookup func(string) (string, bool)) []string {
+	names := jf.exports
+	if jf.export {
+		names = sortedKeys(jf.vars)
+	}
+	var pairs []string
+	for _, name := range names {
+		value, _ := lookup(name)
+		pairs = append(pairs, name+"="+value)
+	}
+	return pairs
+}
+
+// interpolateJust fills in the {{...}} of a recipe line. {{{{ is a literal
+// {{.
+func interpolateJust(line string, lookup func(string) (string, bool)) string {
+	parts := strings.Split(line, "{{{{")
+	for i, part := range parts {
+		parts[i] = justInterpolate.ReplaceAllStringFunc(part, func(s string) string {
+			if value, ok := evalJust(s[2:len(s)-2], lookup); ok {
+				return value
+			}
+			return s
+		})
+	}
+	return strings.Join(parts, "{{")
+}
+
+// evalJust evaluates the expressions the importer understands: string
+// literals and names, joined by '+'
+func evalJust(expr string, lookup func(string) (string, bool)) (string, bool) {
+	var value strings.Builder
+	for _, term := range splitJustTerms(expr) {
+		term = strings.TrimSpace(term)
+		switch {
+		case len(term) >= 2 && (term[0] == '"' || term[0] == '\'') && term[len(term)-1] == term[0]:
+			value.WriteString(term[1 : len(term)-1])
+		case justIdentifier.MatchString(term):
+			v, ok := lookup(term)
+			if !ok {
+				return "", false
+			}
+			value.WriteString(v)
+		default:
+			return "", false
+		}
+	}
+	return value.String(), true
+}
+
+// splitJustTerms splits an expression at the '+' outside of quotes
+func splitJustTerms(expr string) []string {
+	var terms []string
+	start, quote := 0, byte(0)
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '+':
+			terms = append(terms, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+// exportJustfile writes a justfile with a recipe for each target. just has
+// no notion of files, so every recipe runs whenever it is asked for, and
+// names that aren't just identifiers are changed to be.
+func exportJustfile(m *Makefile, w io.Writer, roots []string) ([]string, error) {
+	targets, warnings, err := m.exportTargets(roots)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, t := range targets {
+		names[t.Name] = exportName(t.Name)
+		if names[t.Name] != t.Name {
+			warnings = append(warnings, fmt.Sprintf("target '%s' is named '%s', just only allows letters, digits, '_' and '-'", t.Name, names[t.Name]))
+		}
+		if !t.Phony && len(t.Lines) > 0 {
+			warnings = append(warnings, fmt.Sprintf("target '%s' is a file, its recipe runs even if it is up to date", t.Name))
+		}
+	}
+
+	fmt.Fprintf(w, "# Generated by 'smmake export just' from %s\n", m.Filename)
+	for _, v := range m.exportVariables() {
+		if name := exportName(v.Name); name == v.Name {
+			fmt.Fprintf(w, "%s := %s\n", name, justString(v.Value))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("variable '%s' is left out, it isn't a just identifier", v.Name))
+		}
+	}
+	for _, t := range targets {
+		fmt.Fprintln(w)
+		if t.Description != "" {
+			fmt.Fprintf(w, "# %s\n", t.Description)
+		}
+		header := names[t.Name] + ":"
+		for _, need := range t.Needs {
+			header += " " + names[need]
+		}
+		fmt.Fprintln(w, header)
+		prefix := ""
+		if t.Dir != "" {
+			prefix = "cd " + t.Dir + " && "
+		}
+		for _, kv := range t.Env {
+			key, value, _ := strings.Cut(kv, "=")
+			prefix += key + "=" + shellQuote(value) + " "
+		}
+		for _, line := range t.Lines {
+			at := ""
+			if line.Silent {
+				at = "@"
+			}
+			fmt.Fprintf(w, "    %s%s%s\n", at, prefix, strings.ReplaceAll(line.Cmd, "{{", "{{{{"))
+		}
+	}
+	return warnings, nil
+}
+
+// justString quotes a value as a just string literal, raw if it can be
+func justString(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(s) + `"`
+}
@@ -0,0 +1,155 @@
+package smmake
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severities of lint findings
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintFinding is a single problem found in a Makefile
+type LintFinding struct {
+	Line     int
+	Severity string
+	Rule     string
+	Message  string
+}
+
+// LintRules describes the rules of Lint, by the name findings carry in
+// their Rule field
+var LintRules = map[string]string{
+	"missing-prerequisite": "A prerequisite has no rule to make it and is no file",
+	"undefined-variable":   "A recipe references a variable that is not defined",
+	"missing-phony":        "A target conventionally not a file is not declared .PHONY",
+	"unknown-phony":        ".PHONY declares a target that has no rule",
+	"circular-dependency":  "Targets depend on each other in a cycle",
+	"file-conflict":        "A target that is not .PHONY is named like an existing directory, or like a file it conventionally isn't",
+}
+
+// conventionalPhony are target names that are almost never files
+var conventionalPhony = map[string]bool{
+	"all": true, "build": true, "check": true, "clean": true, "dist": true,
+	"fmt": true, "help": true, "install": true, "lint": true, "run": true,
+	"test": true, "watch": true,
+}
+
+// unexpandedVariable matches references left in a recipe after expansion
+var unexpandedVariable = regexp.MustCompile(`\$[\(\{]([^\)\}]+)[\)\}]`)
+
+// Lint checks the Makefile for common mistakes and returns the findings
+// ordered by line.
+func (m *Makefile) Lint() []LintFinding {
+	var findings []LintFinding
+	add := func(line int, severity, rule, format string, a ...any) {
+		findings = append(findings, LintFinding{line, severity, rule, fmt.Sprintf(format, a...)})
+	}
+
+	for _, name := range sortedKeys(m.Targets) {
+		t := m.Targets[name]
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		for _, dep := range t.Dependencies {
+			dep = m.expandVariables(dep)
+			if t.Pattern || strings.Contains(dep, "%") {
+				continue
+			}
+			if m.Targets[dep] != nil || m.findMatchingPatternRule(dep) != nil {
+				continue
+			}
+			if _, err := m.stat(dep); err != nil {
+				add(t.Line, SeverityError, "missing-prerequisite",
+					"no rule to make '%s', needed by '%s', and no such file", dep, name)
+			}
+		}
+
+		for _, cmd := range t.Commands {
+			if cmd.Script {
+				continue
+			}
+			for _, match := range unexpandedVariable.FindAllStringSubmatch(cmd.Cmd, -1) {
+				add(t.Line, SeverityWarning, "undefined-variable",
+					"recipe of '%s' references undefined variable '%s'", name, match[1])
+			}
+		}
+
+		if kind := m.fileConflict(t, name, nil); kind != "" {
+			add(t.Line, SeverityWarning, "file-conflict",
+				"target '%s' is named like an existing %s, declare it .PHONY if it isn't made as one", name, kind)
+		} else if !t.Pattern && conventionalPhony[name] && !m.isPhony(name) {
+			add(t.Line, SeverityWarning, "missing-phony",
+				"target '%s' is not a file and should be declared .PHONY", name)
+		}
+	}
+
+	if phony := m.Targets[".PHONY"]; phony != nil {
+		for _, dep := range phony.Dependencies {
+			if dep = m.expandVariables(dep); m.Targets[dep] == nil {
+				add(phony.Line, SeverityWarning, "unknown-phony",
+					".PHONY declares '%s', which has no rule", dep)
+			}
+		}
+	}
+
+	for _, cycle := range m.findCycles() {
+		add(m.Targets[cycle[0]].Line, SeverityError, "circular-dependency",
+			"circular dependency %s", strings.Join(cycle, " -> "))
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings
+}
+
+// findCycles returns every dependency cycle between explicit rules, each
+// starting and ending with the same target
+func (m *Makefile) findCycles() [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(name string)
+	visit = func(name string) {
+		t := m.Targets[name]
+		if t == nil || t.Pattern {
+			return
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range t.Dependencies {
+			dep = m.expandVariables(dep)
+			switch state[dep] {
+			case visiting:
+				for i, n := range stack {
+					if n == dep {
+						cycle := append([]string(nil), stack[i:]...)
+						cycles = append(cycles, append(cycle, dep))
+						break
+					}
+				}
+			case unvisited:
+				visit(dep)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+
+	for _, name := range sortedKeys(m.Targets) {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,100 @@
+package smmake
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LogLevel is how important a log message is, from errors to parser
+// debugging output
+type LogLevel int
+
+const (
+	LogError   LogLevel = iota
+	LogWarn             // problems with the Makefile
+	LogInfo             // the recipe lines being run
+	LogVerbose          // target scheduling decisions
+	LogTrace            // variable expansion traces
+	LogDebug            // parser decisions
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogError:
+		return "error"
+	case LogWarn:
+		return "warn"
+	case LogInfo:
+		return "info"
+	case LogVerbose:
+		return "verbose"
+	case LogTrace:
+		return "trace"
+	case LogDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// Logger receives the messages of the parser and the executor. Target is
+// the target a message is about, empty for messages about the Makefile as
+// a whole. Loggers may be called concurrently.
+type Logger interface {
+	// Enabled reports whether messages at level are wanted, so they are
+	// only formatted when they are
+	Enabled(level LogLevel) bool
+	Log(level LogLevel, target, msg string)
+}
+
+// NewSlogLogger returns a Logger writing to l. Verbose, trace and debug
+// messages are logged below slog.LevelDebug, in that order.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Enabled(level LogLevel) bool {
+	return s.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (s slogLogger) Log(level LogLevel, target, msg string) {
+	if target == "" {
+		s.l.Log(context.Background(), slogLevel(level), msg)
+		return
+	}
+	s.l.Log(context.Background(), slogLevel(level), msg, "target", target)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogError:
+		return slog.LevelError
+	case LogWarn:
+		return slog.LevelWarn
+	case LogInfo:
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug - slog.Level(level-LogVerbose)
+}
+
+// logf formats and logs a message if the logger wants it. Makefiles
+// without a Logger don't log.
+func (m *Makefile) logf(level LogLevel, target, format string, a ...any) {
+	if m.record != nil && level <= LogWarn {
+		// the warnings would be missing when the parse is reused
+		m.record.refuse("it has warnings")
+	}
+	if m.logEnabled(level) {
+		m.Logger.Log(level, target, m.maskSecrets(fmt.Sprintf(format, a...)))
+	}
+}
+
+// logEnabled reports whether messages at level are logged, so callers can
+// skip building expensive debug output
+func (m *Makefile) logEnabled(level LogLevel) bool {
+	return m.Logger != nil && m.Logger.Enabled(level)
+}
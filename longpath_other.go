@@ -0,0 +1,7 @@
+//go:build !windows
+
+package smmake
+
+// longPath returns name, only Windows limits the length of paths below
+// what file systems allow
+func longPath(name string) string { return name }
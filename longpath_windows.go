@@ -0,0 +1,29 @@
+//go:build windows
+
+package smmake
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the longest directory name Windows accepts without the \\?\
+// prefix, MAX_PATH less room for an 8.3 file name
+const maxPath = 248
+
+// longPath returns the \\?\ form of a file name whose absolute path is too
+// long for Windows, e.g. deep in a node_modules tree, and \\?\UNC\ for one
+// on a network share. The os package only does this for absolute names.
+func longPath(name string) string {
+	if name == "" || strings.HasPrefix(name, `\\?\`) {
+		return name
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil || len(abs) < maxPath {
+		return name
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
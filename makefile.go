@@ -0,0 +1,338 @@
+// Package smmake parses Makefiles and runs their targets. It is the engine
+// behind the smmake command, which lives in the cmd directory.
+package smmake
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"time"
+)
+
+// Target represents a make target and its commands
+type Target struct {
+	Name         string
+	Commands     []Command
+	Dependencies []string
+	Pattern      bool
+	PatternFrom  string
+	PatternTo    string
+	Description  string // from a trailing "## text" comment on the rule line
+	Section      string // from the last "##@ Section" comment before the rule
+	Line         int
+	// Dir is the directory the recipe lines run in, relative to
+	// Makefile.Dir, if not that one
+	Dir string
+	// Env are KEY=VALUE pairs added to the environment of the recipe lines,
+	// after Makefile.Env
+	Env []string
+	// Shell runs the recipe lines of the target through another shell than
+	// the Makefile's, see Makefile.Shell
+	Shell string
+	// DoubleColon marks a target of "::" rules, whose recipes all run in
+	// order rather than the last one replacing the others
+	DoubleColon bool
+	// Rules are the "::" rules of a DoubleColon target, in order, each with
+	// its own prerequisites and recipe. Dependencies and Commands hold
+	// those of all of them.
+	Rules []*DoubleColonRule
+
+	// recipeRule is the line of the rule the recipe was read from while
+	// parsing
+	recipeRule int
+}
+
+type Command struct {
+	Cmd    string
+	Silent bool
+	// Script marks the Starlark source of a script: block, see scriptLine
+	Script bool
+}
+
+// DoubleColonRule is one of the "::" rules of a target. Like make, each of
+// them is made on its own: its prerequisites, then its recipe, which sees
+// only its own prerequisites in $^ and $<.
+type DoubleColonRule struct {
+	Dependencies []string
+	Commands     []Command
+	Line         int
+}
+
+// addCommand appends a recipe line to a target, and to its last "::" rule
+func (t *Target) addCommand(cmd Command) {
+	t.Commands = append(t.Commands, cmd)
+	if len(t.Rules) > 0 {
+		r := t.Rules[len(t.Rules)-1]
+		r.Commands = append(r.Commands, cmd)
+	}
+}
+
+// Variable represents a make variable and where it was defined
+type Variable struct {
+	Name   string
+	Value  string
+	Origin string
+	Line   int
+	// Recursive marks a variable defined with '=', whose value is expanded
+	// every time it is referenced rather than once where it is defined
+	Recursive bool
+}
+
+// Variable origins, as reported by the database printer and 'smmake env'
+const (
+	OriginMakefile    = "makefile"
+	OriginCommandLine = "command line"
+	OriginEnvironment = "environment"
+	OriginEnvFile     = "env file"
+	OriginBuilder     = "builder"
+)
+
+// Makefile represents the parsed makefile
+type Makefile struct {
+	Filename string
+	Targets  map[string]*Target
+	// Variables are the variables by name. Define them with Var rather than
+	// in the map, whose changes the expansion cache doesn't see.
+	Variables map[string]*Variable
+
+	// Silent suppresses echoing of every recipe line, like -s
+	Silent bool
+	// NoSilent ignores any .SILENT declaration in the Makefile
+	NoSilent bool
+	// Jobs limits how many targets run their recipes at the same time,
+	// zero means no limit
+	Jobs int
+	// Shell runs every recipe line through the given shell, whatever the
+	// targets and the SHELL variable say. If empty the shell is chosen as
+	// shellFor describes, NoShell executes recipe lines directly.
+	Shell string
+	// Runner runs the recipe lines, ExecRunner if nil
+	Runner Runner
+	// Resolver provides the variables that are defined neither in the
+	// Makefile nor in the environment
+	Resolver Resolver
+	// Functions are the functions available in $(name args) references,
+	// DefaultFunctions if nil
+	Functions *FunctionRegistry
+	// Logger receives what the executor reports, nothing is logged if nil
+	Logger Logger
+	// DryRun prints the recipe lines instead of running them, like -n
+	DryRun bool
+	// KeepGoing builds as much as possible after a target failed, like -k
+	KeepGoing bool
+	// IgnoreCase matches target and file names regardless of case, so
+	// Build and build are one target, as they are one file on Windows and
+	// macOS, where it is set by NewMakefile. Names are shown as the rules
+	// spell them.
+	IgnoreCase bool
+
+	// Symlinks decides whose modification time symbolic links have in the
+	// up-to-date checks, the time of the file they point to by default.
+	// Like Dirs, AssumeNew and FutureOutOfDate it changes what --why, Plan
+	// and ConflictFile decide; a build doesn't skip up to date targets yet
+	// and runs their recipes either way.
+	Symlinks SymlinkMode
+	// Conflicts decides whether a target or an existing file named like it
+	// wins, the target by default. With ConflictFile a build leaves out the
+	// recipe of a target whose existing file is up to date.
+	Conflicts ConflictMode
+	// Dirs decides when directory prerequisites make a target out of date,
+	// never by default, in the checks Symlinks lists
+	Dirs DirMode
+	// AssumeOld are files taken to be up to date, like make's -o: their
+	// recipes don't run, and they make nothing depending on them out of
+	// date
+	AssumeOld []string
+	// AssumeNew are files taken to be newer than anything, like make's -W,
+	// so --why and Plan show what depends on them
+	AssumeNew []string
+	// FutureOutOfDate makes the up-to-date checks treat files modified in
+	// the future, by a clock ahead of this one, as out of date, rather
+	// than only warning about them
+	FutureOutOfDate bool
+	// Dir is the directory recipes run in and file targets are looked up
+	// in, the current directory if empty
+	Dir string
+	// FS is where file targets are looked up, their modification times
+	// read and $(wildcard) globs, the operating system's file system if
+	// nil. Recipes always run against the real file system.
+	FS fs.FS
+	// EnvPolicy decides what environment recipes run with
+	EnvPolicy EnvPolicy
+	// Env holds KEY=VALUE pairs added to the environment of recipes
+	Env []string
+	// Undefined decides what referencing an undefined variable does,
+	// nothing by default
+	Undefined UndefinedPolicy
+	// POSIX turns off the GNU extensions POSIX make doesn't have, such as
+	// functions and wildcards in prerequisites, warning where they are
+	// used. It is set by a .POSIX target.
+	POSIX bool
+
+	// Output returns where the recipe output of a target is written,
+	// instead of stdout and stderr
+	Output func(target string) io.Writer
+
+	hooks   []Hooks
+	bus     *eventBus
+	secrets *secretStore
+	// expansions caches what expressions expanded to
+	expansions *expansionCache
+	// digests caches the SHA-256 of the files hashed
+	digests *digestCache
+	// index finds rules in big Makefiles, see ruleIndex
+	index *ruleIndex
+	// targetWrites counts the targets added or replaced, see setTarget
+	targetWrites uint64
+	// record collects what the parse depends on while a Makefile is parsed
+	// for the parse cache
+	record *parseRecord
+	// line is the line being parsed, where undefined variables are
+	// reported, 0 once the Makefile is parsed
+	line int
+	// undefinedErrs are the undefined variables of the parse with
+	// UndefinedError
+	undefinedErrs []*ParseError
+}
+
+// Hooks are optional callbacks the executor invokes as targets and their
+// commands start and finish, for timing, notifications or custom caching.
+// All of them may be called concurrently, for different targets.
+type Hooks struct {
+	// OnTargetStart is called before the recipe of a target runs. Returning
+	// ErrSkipTarget treats the target as up to date without running its
+	// recipe, any other error fails the target.
+	OnTargetStart func(e TargetEvent) error
+	// OnTargetFinish is called once for every target the build reaches,
+	// including files without a rule and targets that failed before their
+	// recipe could start
+	OnTargetFinish func(e TargetEvent)
+	// OnCommandStart is called before a recipe line runs
+	OnCommandStart func(e CommandEvent)
+	// OnCommandFinish is called after a recipe line ran
+	OnCommandFinish func(e CommandEvent)
+}
+
+// ErrSkipTarget is returned by an OnTargetStart hook to skip the recipe of
+// a target, e.g. because its outputs were restored from a cache
+var ErrSkipTarget = errors.New("skip target")
+
+// TargetEvent describes a target for the target hooks. Ran, Duration and Err
+// are only set for OnTargetFinish.
+type TargetEvent struct {
+	Name string
+	// Target is the rule the target is built with, nil for files without a
+	// rule and unknown targets
+	Target *Target
+	// Prerequisites are the prerequisites the target is made from, those
+	// of a pattern rule filled in, set once they are made
+	Prerequisites []string
+	// Ran reports whether the recipe was started
+	Ran      bool
+	Duration time.Duration
+	Err      error
+}
+
+// CommandEvent describes a recipe line for the command hooks. Result and Err
+// are only set for OnCommandFinish.
+type CommandEvent struct {
+	Target  string
+	Command Command
+	Result  Result
+	Err     error
+}
+
+// AddHooks registers callbacks, in addition to the ones already registered
+func (m *Makefile) AddHooks(h Hooks) {
+	m.hooks = append(m.hooks, h)
+}
+
+// NewMakefile creates a new Makefile instance
+func NewMakefile() *Makefile {
+	return &Makefile{
+		Targets:    make(map[string]*Target),
+		Variables:  make(map[string]*Variable),
+		IgnoreCase: caseInsensitiveOS(),
+		bus:        &eventBus{},
+	}
+}
+
+// findMatchingPatternRule finds the pattern rule that matches the target.
+// Like make, of several rules the one with the shortest stem wins, as the
+// most specific, so build/%.o is chosen over %.o for build/main.o, and of
+// rules with stems as long the one defined first.
+func (m *Makefile) findMatchingPatternRule(target string) *Target {
+	var best *Target
+	bestStem := 0
+	for _, t := range m.patternRules(target) {
+		stem, ok := patternStem(t, target, m.IgnoreCase)
+		if !ok {
+			continue
+		}
+		if best == nil || len(stem) < bestStem || len(stem) == bestStem &&
+			(t.Line < best.Line || t.Line == best.Line && t.Name < best.Name) {
+			best, bestStem = t, len(stem)
+		}
+	}
+	return best
+}
+
+// ExecuteTarget runs the commands for a specified target. Every call is a
+// build of its own, in a new Session.
+func (m *Makefile) ExecuteTarget(targetName string) error {
+	return m.NewSession().executeTarget(context.Background(), targetName, "")
+}
+
+// Build builds the goals in order in a new Session, with the options
+// applied for this call only, see Session.Build. Every call starts from
+// nothing built, so a Makefile can be built again after it changed on disk
+// or in code.
+func (m *Makefile) Build(ctx context.Context, goals []string, opts ...Option) error {
+	return m.withOptions(opts).NewSession().Build(ctx, goals)
+}
+
+// withOptions returns a copy of m with the options applied, or m itself
+// if there are none. The copy shares the targets, variables, hooks and
+// subscribers of m, so concurrent builds with different options don't
+// change each other's settings.
+func (m *Makefile) withOptions(opts []Option) *Makefile {
+	if len(opts) == 0 {
+		return m
+	}
+	c := *m
+	// appending options must not write to the arrays of m
+	c.Env = slices.Clip(c.Env)
+	c.AssumeOld = slices.Clip(c.AssumeOld)
+	c.AssumeNew = slices.Clip(c.AssumeNew)
+	c.bus = m.eventBus()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// Execute builds target like Build
+func (m *Makefile) Execute(ctx context.Context, target string, opts ...Option) error {
+	return m.Build(ctx, []string{target}, opts...)
+}
+
+// isSilent reports whether a recipe line should run without being echoed,
+// either because of a leading '@', the -s flag or a .SILENT declaration
+func (m *Makefile) isSilent(targetName string, cmd Command) bool {
+	if cmd.Silent || m.Silent {
+		return true
+	}
+	if m.NoSilent {
+		return false
+	}
+	special := m.Targets[".SILENT"]
+	if special == nil {
+		return false
+	}
+	if len(special.Dependencies) == 0 {
+		return true
+	}
+	return m.lists(".SILENT", targetName)
+}
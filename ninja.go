@@ -0,0 +1,413 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"smmake/ast"
+)
+
+// IsNinjaFile reports whether a build file is read by ParseNinja rather than
+// as a Makefile, from its extension
+func IsNinjaFile(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".ninja")
+}
+
+// IsMakefile reports whether a build file is read as a Makefile, that is
+// neither as a YAML build file nor as a Ninja file
+func IsMakefile(name string) bool {
+	return !IsYAMLBuildFile(name) && !IsNinjaFile(name)
+}
+
+// ninjaRule is a rule of a Ninja file, its variables unevaluated
+type ninjaRule struct {
+	name string
+	vars map[string]string
+}
+
+// ninjaScope holds the variables of a Ninja file, or of a subninja, which
+// sees the ones of the file including it
+type ninjaScope struct {
+	vars   map[string]string
+	parent *ninjaScope
+}
+
+func (s *ninjaScope) lookup(name string) string {
+	for ; s != nil; s = s.parent {
+		if value, ok := s.vars[name]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// ninjaParser turns the statements of a Ninja file into targets
+type ninjaParser struct {
+	c        *ParseConfig
+	m        *Makefile
+	rules    map[string]*ninjaRule
+	defaults []string
+	// outputs and inputs are every path built and used, for the default
+	// goal when there is no default statement
+	outputs []string
+	inputs  map[string]bool
+}
+
+// ParseNinja reads a Ninja build file, the kind other tools generate, into
+// the same model as a Makefile, so it is built by the same scheduler: each
+// build statement is a target for its first output, with the explicit,
+// implicit and order-only inputs as prerequisites and the command of its
+// rule, evaluated with Ninja's scoping, as its recipe line. The other
+// outputs depend on the first one. The prerequisites listed in an existing
+// depfile are added as well. phony edges are .PHONY targets, and unless
+// the file builds it, "all" builds the default statements' targets, or the
+// outputs nothing uses. Pools and deps = gcc/msvc are ignored, and, like in
+// Ninja, commands are run without a shell unless one is configured.
+func (c *ParseConfig) ParseNinja(r io.Reader, name string) (*Makefile, error) {
+	p := &ninjaParser{
+		c:      c,
+		m:      c.FromAST(&ast.File{Name: name}),
+		rules:  map[string]*ninjaRule{"phony": {name: "phony"}},
+		inputs: make(map[string]bool),
+	}
+	scope := &ninjaScope{vars: make(map[string]string)}
+	if err := p.parse(r, name, scope); err != nil {
+		return nil, &ParseError{Filename: name, Err: err}
+	}
+	for name, value := range scope.vars {
+		if v := p.m.Variables[name]; v == nil || v.Origin != OriginCommandLine {
+			p.m.setVariable(&Variable{Name: name, Value: value, Origin: OriginMakefile})
+		}
+	}
+
+	if p.m.Targets["all"] == nil {
+		goals := p.defaults
+		if len(goals) == 0 {
+			for _, out := range p.outputs {
+				if !p.inputs[out] {
+					goals = append(goals, out)
+				}
+			}
+		}
+		p.m.Target("all").Deps(goals...).Phony()
+	}
+	return p.m, nil
+}
+
+// parse reads the statements of a Ninja file into scope
+func (p *ninjaParser) parse(r io.Reader, filename string, scope *ninjaScope) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if data, err = ast.Source(data); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	lines := ninjaLines(string(data))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// The indented lines after a rule, build or pool are its bindings
+		var bindings [][2]string
+		for i+1 < len(lines) && lines[i+1].indented {
+			i++
+			key, value, ok := strings.Cut(lines[i].text, "=")
+			if !ok {
+				return fmt.Errorf("%s:%d: expected 'name = value'", filename, lines[i].number)
+			}
+			bindings = append(bindings, [2]string{strings.TrimSpace(key), strings.TrimLeft(value, " ")})
+		}
+
+		keyword, rest, _ := strings.Cut(line.text, " ")
+		rest = strings.TrimLeft(rest, " ")
+		switch keyword {
+		case "rule":
+			rule := &ninjaRule{name: rest, vars: make(map[string]string)}
+			for _, b := range bindings {
+				rule.vars[b[0]] = b[1]
+			}
+			p.rules[rest] = rule
+		case "build":
+			if err := p.build(rest, bindings, scope, line.number); err != nil {
+				return fmt.Errorf("%s:%d: %w", filename, line.number, err)
+			}
+		case "default":
+			for _, word := range splitNinja(rest) {
+				p.defaults = append(p.defaults, evalNinja(word, scope.lookup))
+			}
+		case "include", "subninja":
+			path := evalNinja(rest, scope.lookup)
+			child := scope
+			if keyword == "subninja" {
+				child = &ninjaScope{vars: make(map[string]string), parent: scope}
+			}
+			if err := p.include(path, child); err != nil {
+				return fmt.Errorf("%s:%d: %w", filename, line.number, err)
+			}
+		case "pool":
+			p.m.logf(LogDebug, "", "  ignoring pool '%s' on line %d", rest, line.number)
+		default:
+			key, value, ok := strings.Cut(line.text, "=")
+			if !ok {
+				return fmt.Errorf("%s:%d: unexpected '%s'", filename, line.number, line.text)
+			}
+			scope.vars[strings.TrimSpace(key)] = evalNinja(strings.TrimLeft(value, " "), scope.lookup)
+		}
+	}
+	return nil
+}
+
+// include parses another Ninja file, like the including one relative to
+// the working directory
+func (p *ninjaParser) include(path string, scope *ninjaScope) error {
+	var file io.ReadCloser
+	var err error
+	if p.c.FS != nil {
+		file, err = p.c.FS.Open(fsName(path))
+	} else {
+		file, err = os.Open(longPath(path))
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return p.parse(file, path, scope)
+}
+
+// build adds the targets of a build statement,
+// "build OUTPUTS | IMPLICIT: RULE INPUTS | IMPLICIT || ORDER-ONLY"
+func (p *ninjaParser) build(statement string, bindings [][2]string, scope *ninjaScope, line int) error {
+	var outs, implicitOuts, ruleName []string
+	var ins, implicitIns, orderOnly []string
+	part := &outs
+	for _, word := range splitNinja(statement) {
+		switch {
+		case word == ":" && (part == &outs || part == &implicitOuts):
+			part = &ruleName
+		case part == &ruleName:
+			ruleName = append(ruleName, word)
+			part = &ins
+		case word == "|" && part == &outs:
+			part = &implicitOuts
+		case word == "|" && part == &ins:
+			part = &implicitIns
+		case word == "||" && (part == &ins || part == &implicitIns):
+			part = &orderOnly
+		case word == "|@":
+			// Validations don't affect the build
+			part = new([]string)
+		default:
+			*part = append(*part, word)
+		}
+	}
+	if len(ruleName) == 0 || len(outs) == 0 {
+		return fmt.Errorf("build statement without outputs or rule")
+	}
+	rule := p.rules[ruleName[0]]
+	if rule == nil {
+		return fmt.Errorf("unknown rule '%s'", ruleName[0])
+	}
+
+	// Bindings of the statement see the file's variables and the ones
+	// before them
+	edge := &ninjaScope{vars: make(map[string]string), parent: scope}
+	for _, b := range bindings {
+		edge.vars[b[0]] = evalNinja(b[1], edge.lookup)
+	}
+	eval := func(words []string) []string {
+		paths := make([]string, len(words))
+		for i, w := range words {
+			paths[i] = evalNinja(w, edge.lookup)
+		}
+		return paths
+	}
+	outs, implicitOuts = eval(outs), eval(implicitOuts)
+	ins, implicitIns, orderOnly = eval(ins), eval(implicitIns), eval(orderOnly)
+
+	// The rule's variables are evaluated for the edge, with $in and $out
+	var lookup func(string) string
+	expanding := make(map[string]bool)
+	lookup = func(name string) string {
+		switch name {
+		case "in":
+			return joinShell(ins, " ")
+		case "in_newline":
+			return joinShell(ins, "\n")
+		case "out":
+			return joinShell(outs, " ")
+		}
+		if value, ok := edge.vars[name]; ok {
+			return value
+		}
+		if value, ok := rule.vars[name]; ok && !expanding[name] {
+			expanding[name] = true
+			defer delete(expanding, name)
+			return evalNinja(value, lookup)
+		}
+		return scope.lookup(name)
+	}
+
+	first := outs[0]
+	b := p.m.Target(first)
+	b.t.Line = line
+	b.Deps(ins...).Deps(implicitIns...).Deps(orderOnly...)
+	if depfile := lookup("depfile"); depfile != "" {
+		b.Deps(p.depfile(depfile)...)
+		b.t.Dependencies = uniqueDeps(b.t.Dependencies)
+	}
+	if rule.name == "phony" {
+		b.Phony()
+	} else if command := lookup("command"); command != "" {
+		b.t.Commands = append(b.t.Commands, Command{Cmd: command})
+		b.Describe(lookup("description"))
+		if lookup("restat") != "" {
+			b.Restat()
+		}
+	}
+	for _, out := range append(outs[1:], implicitOuts...) {
+		p.m.Target(out).Deps(first)
+	}
+
+	p.outputs = append(p.outputs, outs...)
+	for _, in := range append(append(ins, implicitIns...), orderOnly...) {
+		p.inputs[in] = true
+	}
+	return nil
+}
+
+// depfile returns the prerequisites a depfile written by an earlier build
+// lists, in the Makefile syntax compilers write them in
+func (p *ninjaParser) depfile(name string) []string {
+	data, err := p.m.readFile(name)
+	if err != nil {
+		return nil
+	}
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\\\r\n", " "), "\\\n", " ")
+	var deps []string
+	for _, line := range strings.Split(text, "\n") {
+		if colon := strings.Index(line, ": "); colon >= 0 {
+			deps = append(deps, strings.Fields(line[colon+2:])...)
+		}
+	}
+	return deps
+}
+
+// ninjaLine is a logical line of a Ninja file, $-continued lines joined
+type ninjaLine struct {
+	text     string
+	number   int
+	indented bool
+}
+
+// ninjaLines returns the lines of a Ninja file without comments and blank
+// lines
+func ninjaLines(src string) []ninjaLine {
+	var lines []ninjaLine
+	raw := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(raw); i++ {
+		number := i + 1
+		text := raw[i]
+		for continued(text) && i+1 < len(raw) {
+			i++
+			text = text[:len(text)-1] + strings.TrimLeft(raw[i], " ")
+		}
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lines = append(lines, ninjaLine{text: trimmed, number: number, indented: len(trimmed) < len(text)})
+	}
+	return lines
+}
+
+// continued reports whether a line ends in an unescaped '$'
+func continued(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, "$"))
+	return n%2 == 1
+}
+
+// splitNinja splits a build or default statement into paths, and the ':',
+// '|', '||' and '|@' separators, at unescaped spaces. Paths are returned
+// unevaluated.
+func splitNinja(s string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$' && i+1 < len(s):
+			word.WriteString(s[i : i+2])
+			i++
+		case c == ' ':
+			flush()
+		case c == ':':
+			flush()
+			words = append(words, ":")
+		case c == '|':
+			flush()
+			sep := "|"
+			if i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '@') {
+				sep += string(s[i+1])
+				i++
+			}
+			words = append(words, sep)
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return words
+}
+
+// evalNinja expands the variables of a Ninja string: $name, ${name} and the
+// escapes $$, "$ " and $:
+func evalNinja(s string, lookup func(string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; {
+		case c == '$' || c == ' ' || c == ':':
+			b.WriteByte(c)
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i-1:])
+				return b.String()
+			}
+			b.WriteString(lookup(s[i+1 : i+end]))
+			i += end
+		default:
+			j := i
+			for j < len(s) && isNinjaVarChar(s[j]) {
+				j++
+			}
+			b.WriteString(lookup(s[i:j]))
+			i = j - 1
+		}
+	}
+	return b.String()
+}
+
+func isNinjaVarChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// joinShell joins paths for a command, quoting the ones that need it
+func joinShell(paths []string, sep string) string {
+	quoted := make([]string, len(paths))
+	for i, path := range paths {
+		quoted[i] = shellQuote(path)
+	}
+	return strings.Join(quoted, sep)
+}
@@ -0,0 +1,63 @@
+package smmake
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// packageJSON is the part of a package.json the npm importer reads
+type packageJSON struct {
+	Scripts        map[string]string `json:"scripts"`
+	PackageManager string            `json:"packageManager"`
+}
+
+// lockFiles tell which package manager a package uses, if package.json
+// doesn't say
+var lockFiles = []struct{ file, manager string }{
+	{"pnpm-lock.yaml", "pnpm"},
+	{"yarn.lock", "yarn"},
+	{"bun.lockb", "bun"},
+	{"bun.lock", "bun"},
+}
+
+// importNpm adds a target for each script of dir/package.json, which runs
+// it with the package manager of the package
+func importNpm(m *Makefile, dir string) error {
+	data, err := m.readFile(path.Join(dir, "package.json"))
+	if err != nil {
+		return err
+	}
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return err
+	}
+	manager := m.packageManager(dir, pkg)
+
+	prefix := importPrefix("npm", dir)
+	for _, name := range sortedKeys(pkg.Scripts) {
+		b := m.importTarget(prefix+name, strings.TrimSuffix(prefix, ":"))
+		if b == nil {
+			continue
+		}
+		b.Describe(pkg.Scripts[name])
+		b.t.Dir = dir
+		b.t.Commands = append(b.t.Commands, Command{Cmd: manager + " run " + name})
+	}
+	return nil
+}
+
+// packageManager returns the package manager a package.json names, or the
+// one whose lock file is next to it, npm by default
+func (m *Makefile) packageManager(dir string, pkg packageJSON) string {
+	if pkg.PackageManager != "" {
+		name, _, _ := strings.Cut(pkg.PackageManager, "@")
+		return name
+	}
+	for _, lock := range lockFiles {
+		if _, err := m.stat(path.Join(dir, lock.file)); err == nil {
+			return lock.manager
+		}
+	}
+	return "npm"
+}
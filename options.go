@@ -0,0 +1,276 @@
+package smmake
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Option configures a run of Build or Execute. Options set the Makefile
+// field of the same name for that run only.
+type Option func(*Makefile)
+
+// WithJobs limits how many recipes run at the same time, zero for no limit
+func WithJobs(n int) Option {
+	return func(m *Makefile) { m.Jobs = n }
+}
+
+// WithDryRun prints the recipe lines instead of running them
+func WithDryRun(dryRun bool) Option {
+	return func(m *Makefile) { m.DryRun = dryRun }
+}
+
+// WithKeepGoing keeps building the targets that don't depend on a failed
+// one, and the remaining goals
+func WithKeepGoing(keepGoing bool) Option {
+	return func(m *Makefile) { m.KeepGoing = keepGoing }
+}
+
+// WithIgnoreCase matches target and file names regardless of case
+func WithIgnoreCase(ignoreCase bool) Option {
+	return func(m *Makefile) { m.IgnoreCase = ignoreCase }
+}
+
+// WithDir runs the recipes in dir, and looks file targets up there
+func WithDir(dir string) Option {
+	return func(m *Makefile) { m.Dir = dir }
+}
+
+// WithEnvPolicy selects the environment recipes run with
+func WithEnvPolicy(policy EnvPolicy) Option {
+	return func(m *Makefile) { m.EnvPolicy = policy }
+}
+
+// WithEnv adds KEY=VALUE pairs to the environment of recipes, whatever the
+// EnvPolicy
+func WithEnv(vars ...string) Option {
+	return func(m *Makefile) { m.Env = append(m.Env, vars...) }
+}
+
+// WithFS looks file targets up in fsys instead of the operating system's
+// file system
+func WithFS(fsys fs.FS) Option {
+	return func(m *Makefile) { m.FS = fsys }
+}
+
+// WithRunner runs the recipe lines with r
+func WithRunner(r Runner) Option {
+	return func(m *Makefile) { m.Runner = r }
+}
+
+// WithShell runs every recipe line through shell
+func WithShell(shell string) Option {
+	return func(m *Makefile) { m.Shell = shell }
+}
+
+// WithSymlinks selects how up-to-date checks treat symbolic links
+func WithSymlinks(mode SymlinkMode) Option {
+	return func(m *Makefile) { m.Symlinks = mode }
+}
+
+// WithUndefined selects what referencing an undefined variable does
+func WithUndefined(policy UndefinedPolicy) Option {
+	return func(m *Makefile) { m.Undefined = policy }
+}
+
+// WithDirs selects how up-to-date checks treat directory prerequisites
+func WithDirs(mode DirMode) Option {
+	return func(m *Makefile) { m.Dirs = mode }
+}
+
+// DirMode decides when a directory prerequisite makes a target out of
+// date. Its own modification time changes whenever a file is created or
+// removed in it, temporary files included, so it isn't compared.
+type DirMode int
+
+const (
+	// DirOrderOnly only has the directory made before the target, like an
+	// order-only prerequisite in make: it never makes the target out of
+	// date
+	DirOrderOnly DirMode = iota
+	// DirContents makes the target out of date when a file or directory
+	// below the directory is newer than it, or when the names or
+	// modification times below it changed since the Makefile made the
+	// target, as they do when a file is removed
+	DirContents
+)
+
+// ParseDirMode returns the mode called order-only or contents
+func ParseDirMode(name string) (DirMode, error) {
+	switch name {
+	case "order-only":
+		return DirOrderOnly, nil
+	case "contents":
+		return DirContents, nil
+	}
+	return DirOrderOnly, fmt.Errorf("unknown directory mode '%s', use order-only or contents", name)
+}
+
+// WithConflicts selects whether a target or an existing file of the same
+// name wins, see ConflictMode
+func WithConflicts(mode ConflictMode) Option {
+	return func(m *Makefile) { m.Conflicts = mode }
+}
+
+// ConflictMode decides what a target that isn't .PHONY means when a
+// directory of its name exists, or a file named like a target that
+// conventionally isn't one, such as test. Either way a warning suggests
+// declaring it .PHONY.
+type ConflictMode int
+
+const (
+	// ConflictRule runs the recipe as if the target were .PHONY
+	ConflictRule ConflictMode = iota
+	// ConflictFile takes the existing file for the target, like make, so
+	// the recipe only runs if a prerequisite is newer or phony
+	ConflictFile
+)
+
+// ParseConflictMode returns the mode called rule or file
+func ParseConflictMode(name string) (ConflictMode, error) {
+	switch name {
+	case "rule":
+		return ConflictRule, nil
+	case "file":
+		return ConflictFile, nil
+	}
+	return ConflictRule, fmt.Errorf("unknown conflict mode '%s', use rule or file", name)
+}
+
+// WithAssumeOld takes files to be up to date, see Makefile.AssumeOld
+func WithAssumeOld(names ...string) Option {
+	return func(m *Makefile) { m.AssumeOld = append(m.AssumeOld, names...) }
+}
+
+// WithAssumeNew takes files to be newer than anything, see
+// Makefile.AssumeNew
+func WithAssumeNew(names ...string) Option {
+	return func(m *Makefile) { m.AssumeNew = append(m.AssumeNew, names...) }
+}
+
+// WithFutureOutOfDate sets whether files modified in the future are out of
+// date
+func WithFutureOutOfDate(on bool) Option {
+	return func(m *Makefile) { m.FutureOutOfDate = on }
+}
+
+// SymlinkMode decides whose modification time a symbolic link has in the
+// up-to-date checks. Either way a link whose file doesn't exist is out of
+// date as a target, and can't be made as a prerequisite without a rule.
+type SymlinkMode int
+
+const (
+	// SymlinkFollow gives a link the time of the file it points to, like
+	// make, so a link farm is as new as what it links to
+	SymlinkFollow SymlinkMode = iota
+	// SymlinkLink gives a link its own time, so replacing a link counts as
+	// a change and touching what it points to doesn't
+	SymlinkLink
+)
+
+// ParseSymlinkMode returns the mode called follow or link
+func ParseSymlinkMode(name string) (SymlinkMode, error) {
+	switch name {
+	case "follow":
+		return SymlinkFollow, nil
+	case "link":
+		return SymlinkLink, nil
+	}
+	return SymlinkFollow, fmt.Errorf("unknown symlink mode '%s', use follow or link", name)
+}
+
+// UndefinedPolicy decides what referencing an undefined variable does,
+// which otherwise leaves $(VAR) in recipe lines and expands to nothing
+// elsewhere
+type UndefinedPolicy int
+
+const (
+	// UndefinedIgnore says nothing about undefined variables
+	UndefinedIgnore UndefinedPolicy = iota
+	// UndefinedWarn warns about every reference to an undefined variable,
+	// like make's --warn-undefined-variables
+	UndefinedWarn
+	// UndefinedError reports them as errors, which fail the parse
+	UndefinedError
+)
+
+// ParseUndefinedPolicy returns the policy called ignore, warn or error
+func ParseUndefinedPolicy(name string) (UndefinedPolicy, error) {
+	switch name {
+	case "ignore":
+		return UndefinedIgnore, nil
+	case "warn":
+		return UndefinedWarn, nil
+	case "error":
+		return UndefinedError, nil
+	}
+	return UndefinedIgnore, fmt.Errorf("unknown undefined variable policy '%s', use ignore, warn or error", name)
+}
+
+// EnvPolicy decides what environment recipes run with
+type EnvPolicy int
+
+const (
+	// EnvInherit runs recipes with the environment of smmake
+	EnvInherit EnvPolicy = iota
+	// EnvExportAll adds the Makefile and command line variables to the
+	// environment of smmake, like .EXPORT_ALL_VARIABLES in make
+	EnvExportAll
+	// EnvIsolated runs recipes with nothing but Makefile.Env
+	EnvIsolated
+)
+
+// ErrStopped fails the targets that were not started because another
+// target failed and KeepGoing is not set
+var ErrStopped = errors.New("not started, an earlier target failed")
+
+// environ returns the environment recipes run with, nil to inherit the
+// one of smmake
+func (m *Makefile) environ() []string {
+	var env []string
+	switch m.EnvPolicy {
+	case EnvIsolated:
+		env = make([]string, 0, len(m.Env))
+	case EnvExportAll:
+		env = os.Environ()
+		for _, name := range sortedKeys(m.Variables) {
+			env = append(env, name+"="+m.Variables[name].Value)
+		}
+	default:
+		if len(m.Env) == 0 {
+			return nil
+		}
+		env = os.Environ()
+	}
+	return append(env, m.Env...)
+}
+
+// targetEnviron returns the environment of the recipe lines of a target
+func (m *Makefile) targetEnviron(t *Target) []string {
+	env := m.environ()
+	if len(t.Env) == 0 {
+		return env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env, t.Env...)
+}
+
+// targetDir returns the directory the recipe lines of a target run in
+func (m *Makefile) targetDir(t *Target) string {
+	if t.Dir == "" {
+		return m.Dir
+	}
+	return m.path(t.Dir)
+}
+
+// path returns where a file target is, relative to Dir
+func (m *Makefile) path(name string) string {
+	if m.Dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(m.Dir, name)
+}
@@ -0,0 +1,266 @@
+package smmake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"smmake/ast"
+)
+
+// parseCacheVersion changes whenever cached models can't be read back the
+// same way anymore
+const parseCacheVersion = 2
+
+// cacheableFunctions are the functions whose result only depends on their
+// arguments and on what the parse records, files read and globs. A Makefile
+// calling any other, like $(shell), is parsed every time.
+var cacheableFunctions = map[string]bool{
+	"subst": true, "patsubst": true, "strip": true, "findstring": true, "filter": true,
+	"filter-out": true, "sort": true, "word": true, "wordlist": true, "words": true,
+	"firstword": true, "lastword": true, "dir": true, "notdir": true, "suffix": true,
+	"basename": true, "abspath": true, "joinpath": true, "addsuffix": true,
+	"addprefix": true, "join": true, "wildcard": true, "data": true, "if": true,
+	"or": true, "and": true, "foreach": true, "call": true,
+}
+
+// parseRecord is what a parse depended on besides the Makefile itself, to
+// tell whether its cached model is still the one parsing would give
+type parseRecord struct {
+	// Files are the files read, with the SHA-256 of their content, "" for
+	// the ones that couldn't be read
+	Files map[string]string `json:"files,omitempty"`
+	// Env are the environment variables looked up, nil for unset ones
+	Env map[string]*string `json:"env,omitempty"`
+	// Globs are the glob patterns expanded, with their matches
+	Globs map[string][]string `json:"globs,omitempty"`
+	// uncacheable says why the model can't be cached, if it can't
+	uncacheable string
+}
+
+func (r *parseRecord) file(name string, data []byte, err error) {
+	sum := ""
+	if err == nil {
+		hash := sha256.Sum256(data)
+		sum = hex.EncodeToString(hash[:])
+	}
+	if r.Files == nil {
+		r.Files = make(map[string]string)
+	}
+	r.Files[name] = sum
+}
+
+func (r *parseRecord) env(name string, value string, ok bool) {
+	if r.Env == nil {
+		r.Env = make(map[string]*string)
+	}
+	if ok {
+		r.Env[name] = &value
+	} else {
+		r.Env[name] = nil
+	}
+}
+
+func (r *parseRecord) glob(pattern string, matches []string) {
+	if r.Globs == nil {
+		r.Globs = make(map[string][]string)
+	}
+	r.Globs[pattern] = append([]string{}, matches...)
+}
+
+// refuse keeps the model from being cached, for the first reason given
+func (r *parseRecord) refuse(reason string) {
+	if r.uncacheable == "" {
+		r.uncacheable = reason
+	}
+}
+
+// lookupEnv is os.LookupEnv, recorded while parsing for the cache
+func (m *Makefile) lookupEnv(name string) (string, bool) {
+	value, ok := os.LookupEnv(name)
+	if m.record != nil {
+		m.record.env(name, value, ok)
+	}
+	return value, ok
+}
+
+// cachedModel is the file a parsed Makefile is cached in
+type cachedModel struct {
+	Version   int                  `json:"version"`
+	Makefile  string               `json:"makefile"`
+	Record    *parseRecord         `json:"record"`
+	Targets   map[string]*Target   `json:"targets"`
+	Variables map[string]*Variable `json:"variables"`
+}
+
+// parseCached parses a Makefile, or returns the model cached in CacheDir if
+// nothing it was parsed from changed since: the Makefile, the files it read,
+// the environment variables it looked up, what its globs match, the
+// command line variables and the smmake binary. Makefiles that run
+// $(shell) commands, use $(secret), import tasks or cause warnings are
+// never cached.
+func (c *ParseConfig) parseCached(filename string) (*Makefile, error) {
+	data, err := os.ReadFile(longPath(filename))
+	if err != nil {
+		return nil, &ParseError{Filename: filename, Err: fmt.Errorf("error opening makefile: %w", err)}
+	}
+	sum := sha256.Sum256(data)
+	makefileSum := hex.EncodeToString(sum[:])
+	path := filepath.Join(c.CacheDir, c.cacheKey(filename)+".json")
+
+	if m := c.loadCached(path, filename, makefileSum); m != nil {
+		return m, nil
+	}
+
+	file, err := ast.ParseMaxLineLength(bytes.NewReader(data), filename, c.MaxLineLength)
+	if err != nil {
+		return nil, &ParseError{Filename: filename, Err: err}
+	}
+	errs := checkRecipes(file)
+	record := &parseRecord{}
+	if c.Resolver != nil || c.Functions != nil {
+		record.refuse("it has a Resolver or its own functions")
+	}
+	m := c.fromAST(file, record)
+	m.record = nil
+	if err := parseErrors(append(errs, m.undefinedErrs...)); err != nil {
+		return nil, err
+	}
+	if record.uncacheable != "" {
+		m.logf(LogDebug, "", "Not caching %s: %s", filename, record.uncacheable)
+		return m, nil
+	}
+	cached, err := json.Marshal(&cachedModel{
+		Version:   parseCacheVersion,
+		Makefile:  makefileSum,
+		Record:    record,
+		Targets:   m.Targets,
+		Variables: m.Variables,
+	})
+	if err == nil {
+		err = writeFileAtomic(path, cached)
+	}
+	if err != nil {
+		m.logf(LogDebug, "", "Not caching %s: %v", filename, err)
+	}
+	return m, nil
+}
+
+// loadCached returns the model cached at path if it is still valid
+func (c *ParseConfig) loadCached(path, filename, makefileSum string) *Makefile {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cached cachedModel
+	if json.Unmarshal(data, &cached) != nil || cached.Version != parseCacheVersion ||
+		cached.Makefile != makefileSum || cached.Record == nil || !cached.Record.valid() {
+		return nil
+	}
+	m := NewMakefile()
+	m.Filename = filename
+	m.Resolver = c.Resolver
+	m.Functions = c.Functions
+	m.Logger = c.Logger
+	m.Undefined = c.Undefined
+	m.Targets = cached.Targets
+	m.targetWrites++
+	m.POSIX = c.POSIX || m.Targets[".POSIX"] != nil
+	m.Variables = cached.Variables
+	for _, name := range sortedKeys(m.Targets) {
+		if m.Targets[name].Commands == nil {
+			m.Targets[name].Commands = make([]Command, 0)
+		}
+	}
+	m.logf(LogDebug, "", "Using the cached parse of %s from %s", filename, path)
+	return m
+}
+
+// valid reports whether the files, environment and globs of a parse are
+// still what they were
+func (r *parseRecord) valid() bool {
+	check := &parseRecord{}
+	for name, sum := range r.Files {
+		data, err := os.ReadFile(longPath(name))
+		check.file(name, data, err)
+		if check.Files[name] != sum {
+			return false
+		}
+	}
+	for name, value := range r.Env {
+		now, ok := os.LookupEnv(name)
+		if ok != (value != nil) || ok && now != *value {
+			return false
+		}
+	}
+	globber := dirGlobber("")
+	for pattern, matches := range r.Globs {
+		if strings.Join(globber.glob(pattern), "\x00") != strings.Join(matches, "\x00") {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey names the cache file of a Makefile, which differs for other
+// working directories, command line variables, line length limits and
+// smmake binaries
+func (c *ParseConfig) cacheKey(filename string) string {
+	h := sha256.New()
+	abs, _ := filepath.Abs(filename)
+	wd, _ := os.Getwd()
+	parts := []string{abs, wd, binaryIdentity(), strconv.Itoa(c.MaxLineLength), strconv.Itoa(int(c.Undefined)), strconv.FormatBool(c.POSIX)}
+	for _, name := range sortedKeys(c.Overrides) {
+		parts = append(parts, name+"="+c.Overrides[name])
+	}
+	h.Write([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// binaryIdentity identifies the build of smmake, so a new one parses again
+func binaryIdentity() string {
+	var parts []string
+	if info, ok := debug.ReadBuildInfo(); ok {
+		parts = append(parts, info.Main.Version)
+		for _, setting := range info.Settings {
+			if strings.HasPrefix(setting.Key, "vcs.") {
+				parts = append(parts, setting.Value)
+			}
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			parts = append(parts, info.ModTime().String())
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeFileAtomic writes a file through a temporary one, so concurrent runs
+// never read half of it
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
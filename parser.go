@@ -0,0 +1,845 @@
+package smmake
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"smmake/ast"
+)
+
+// ParseMakefile reads and parses a Makefile.
+//
+// It processes the file line by line, identifying targets, dependencies, commands,
+// and variable definitions. It creates a Makefile struct that represents the
+// parsed content of the Makefile.
+//
+// Parameters:
+//   - filename: A string representing the path to the Makefile to be parsed.
+//
+// Returns:
+//   - *Makefile: A pointer to a Makefile struct containing the parsed information.
+//   - error: An error if any occurred during the parsing process, nil otherwise.
+func ParseMakefile(filename string) (*Makefile, error) {
+	return ParseMakefileWithOverrides(filename, nil)
+}
+
+// ParseMakefileWithOverrides parses a Makefile like ParseMakefile, with the
+// variables given on the command line taking precedence over the Makefile's
+// own definitions of them.
+func ParseMakefileWithOverrides(filename string, overrides map[string]string) (*Makefile, error) {
+	c := &ParseConfig{Overrides: overrides}
+	return c.ParseFile(filename)
+}
+
+// Parse reads a Makefile from r, for content that doesn't live in a file
+// such as embedded strings or test fixtures.
+//
+// Parameters:
+//   - r: The reader the Makefile text is read from.
+//   - name: The name diagnostics refer to and Makefile.Filename is set to,
+//     for example the name of the embedded file.
+func Parse(r io.Reader, name string) (*Makefile, error) {
+	return (&ParseConfig{}).Parse(r, name)
+}
+
+// FromAST builds the Makefile model from a syntax tree, as Parse does after
+// parsing it.
+func FromAST(file *ast.File) *Makefile {
+	return (&ParseConfig{}).FromAST(file)
+}
+
+// ParseConfig controls how a Makefile is evaluated while it is parsed
+type ParseConfig struct {
+	// Overrides are variables given on the command line, they take
+	// precedence over the Makefile's own definitions of them
+	Overrides map[string]string
+	// Resolver is consulted for variables that are neither defined in the
+	// Makefile nor in the environment, and becomes the Makefile's Resolver
+	Resolver Resolver
+	// Functions are the functions available while the Makefile is
+	// evaluated, and become the Makefile's Functions
+	Functions *FunctionRegistry
+	// Logger receives the parser's warnings and debugging output, and
+	// becomes the Makefile's Logger
+	Logger Logger
+	// FS is where ParseFile reads the Makefile from and $(wildcard) globs,
+	// and becomes the Makefile's FS. The operating system's file system is
+	// used if nil.
+	FS fs.FS
+	// CacheDir is where ParseFile caches the models of the Makefiles it
+	// parses, e.g. .smmake/cache, to reuse them while nothing they were
+	// parsed from changes. Only Makefiles on the operating system's file
+	// system are cached, not YAML or Ninja files.
+	CacheDir string
+	// MaxLineLength is the longest line of a Makefile that is read, in
+	// bytes, ast.DefaultMaxLineLength if 0
+	MaxLineLength int
+	// Undefined decides what referencing an undefined variable does while
+	// the Makefile is evaluated, and becomes the Makefile's. With
+	// UndefinedError they fail the parse.
+	Undefined UndefinedPolicy
+	// POSIX parses the Makefile as POSIX make would, as a .POSIX target
+	// does, and becomes the Makefile's POSIX
+	POSIX bool
+}
+
+// ParseFile reads and parses the named Makefile, or a build file in the YAML
+// dialect or a Ninja file if it is named like one, see IsMakefile
+func (c *ParseConfig) ParseFile(filename string) (*Makefile, error) {
+	var info fs.FileInfo
+	var err error
+	if c.FS != nil {
+		info, err = fs.Stat(c.FS, fsName(filename))
+	} else {
+		info, err = os.Stat(longPath(filename))
+	}
+	if err == nil && info.IsDir() {
+		return nil, &ParseError{Filename: filename, Err: fmt.Errorf("error opening makefile: %s is a directory", filename)}
+	}
+	if c.CacheDir != "" && c.FS == nil && !IsYAMLBuildFile(filename) && !IsNinjaFile(filename) {
+		return c.parseCached(filename)
+	}
+	var file io.ReadCloser
+	if c.FS != nil {
+		file, err = c.FS.Open(fsName(filename))
+	} else {
+		file, err = os.Open(longPath(filename))
+	}
+	if err != nil {
+		return nil, &ParseError{Filename: filename, Err: fmt.Errorf("error opening makefile: %w", err)}
+	}
+	defer file.Close()
+	switch {
+	case IsYAMLBuildFile(filename):
+		return c.ParseYAML(file, filename)
+	case IsNinjaFile(filename):
+		return c.ParseNinja(file, filename)
+	}
+	return c.Parse(file, filename)
+}
+
+// Parse reads a Makefile from r, see the Parse function
+func (c *ParseConfig) Parse(r io.Reader, name string) (*Makefile, error) {
+	file, err := ast.ParseMaxLineLength(r, name, c.MaxLineLength)
+	if err != nil {
+		return nil, &ParseError{Filename: name, Err: err}
+	}
+	errs := checkRecipes(file)
+	m := c.FromAST(file)
+	if err := parseErrors(append(errs, m.undefinedErrs...)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkRecipes fails like make does for a recipe line before the first
+// rule, which belongs to no target, and for a line after a rule that is
+// indented with spaces rather than a tab, which would leave the rule
+// without its recipe. Indented comments are no recipe lines. After an
+// error the check resumes at the next rule, as the lines up to it are
+// likely wrong the same way.
+func checkRecipes(file *ast.File) []*ParseError {
+	var errs []*ParseError
+	seenRule, inRule, recovering := false, false, false
+	fail := func(line int, msg string) {
+		errs = append(errs, &ParseError{Filename: file.Name, Line: line, Err: errors.New(msg)})
+		recovering = true
+	}
+	for _, node := range file.Nodes {
+		if _, ok := node.(*ast.Rule); !ok && recovering {
+			continue
+		}
+		switch n := node.(type) {
+		case *ast.Rule:
+			seenRule, inRule, recovering = true, true, false
+		case *ast.RecipeLine:
+			if !seenRule && !strings.HasPrefix(strings.TrimSpace(n.Text), "#") {
+				fail(n.From.Line, "recipe commences before first target")
+			}
+		case *ast.BadLine:
+			if inRule && strings.HasPrefix(n.Text, " ") {
+				fail(n.From.Line, "missing separator (did you mean TAB?)")
+			}
+			inRule = false
+		case *ast.BlankLine, *ast.Comment:
+		default:
+			inRule = false
+		}
+	}
+	return errs
+}
+
+// FromAST builds the Makefile model from a syntax tree
+func (c *ParseConfig) FromAST(file *ast.File) *Makefile {
+	return c.fromAST(file, nil)
+}
+
+// fromAST is FromAST, collecting what the parse depends on in record if
+// it isn't nil
+func (c *ParseConfig) fromAST(file *ast.File, record *parseRecord) *Makefile {
+	makefile := NewMakefile()
+	makefile.record = record
+	makefile.Filename = file.Name
+	makefile.Resolver = c.Resolver
+	makefile.Functions = c.Functions
+	makefile.Logger = c.Logger
+	makefile.FS = c.FS
+	makefile.Undefined = c.Undefined
+	makefile.POSIX = c.POSIX || declaresPOSIX(file)
+	for name, value := range c.Overrides {
+		makefile.setVariable(&Variable{Name: name, Value: value, Origin: OriginCommandLine, Recursive: true})
+	}
+
+	// currentTargets are the targets of the last rule, on currentRule,
+	// recipe lines are added to them
+	var currentTargets []*Target
+	currentRule := 0
+	currentSection := ""
+
+	for _, node := range file.Nodes {
+		makefile.line = node.Pos().Line
+		switch n := node.(type) {
+		case *ast.Comment:
+			// "##@ Section" comments group the documented targets that follow
+			if n.From.Column == 1 && strings.HasPrefix(n.Text, "##@") {
+				currentSection = strings.TrimSpace(strings.TrimPrefix(n.Text, "##@"))
+			}
+		case *ast.Assignment:
+			makefile.assign(n)
+		case *ast.Rule:
+			currentTargets = makefile.addRule(n, currentSection)
+			currentRule = n.From.Line
+			for _, line := range n.Recipe() {
+				makefile.addRecipeLine(currentTargets, currentRule, line)
+			}
+		case *ast.RecipeLine:
+			// A recipe line after an assignment still belongs to the rule
+			// before it
+			makefile.addRecipeLine(currentTargets, currentRule, n)
+		case *ast.Directive:
+			if !posixDirectives[n.Name] && makefile.POSIX {
+				makefile.notPOSIX("'%s'", n.Name)
+				continue
+			}
+			if n.Name == "import" {
+				makefile.importDirective(n.Args.Text, n.From.Line)
+				continue
+			}
+			if n.Name == "datafile" {
+				makefile.dataFileDirective(n.Args.Text, n.From.Line)
+				continue
+			}
+			makefile.logf(LogDebug, "", "  ignoring unsupported directive '%s' on line %d", n.Name, n.From.Line)
+		case *ast.BadLine:
+			makefile.logf(LogDebug, "", "  ignoring line %d: %s", n.From.Line, n.Text)
+		}
+	}
+	makefile.line = 0
+
+	// At the end of the function, print out the parsed targets
+	if makefile.logEnabled(LogDebug) {
+		for targetName, target := range makefile.Targets {
+			makefile.logf(LogDebug, "", "Parsed target: %s", targetName)
+			makefile.logf(LogDebug, "", "  Commands:")
+			for _, cmd := range target.Commands {
+				silentStr := ""
+				if cmd.Silent {
+					silentStr = "(silent) "
+				}
+				makefile.logf(LogDebug, "", "    %s%s", silentStr, cmd.Cmd)
+			}
+			makefile.logf(LogDebug, "", "  Dependencies: %v", target.Dependencies)
+		}
+	}
+
+	return makefile
+}
+
+// declaresPOSIX reports whether a Makefile has a .POSIX target
+func declaresPOSIX(file *ast.File) bool {
+	for _, node := range file.Nodes {
+		if rule, ok := node.(*ast.Rule); ok {
+			for _, w := range rule.Targets {
+				if w.Text == ".POSIX" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// posixDirectives are the directives of POSIX make
+var posixDirectives = map[string]bool{"include": true, "-include": true}
+
+// notPOSIX warns about a construct POSIX make doesn't have, in POSIX mode
+func (m *Makefile) notPOSIX(format string, a ...any) {
+	if !m.POSIX {
+		return
+	}
+	m.logf(LogWarn, "", "%s: %s is not POSIX", m.location(), fmt.Sprintf(format, a...))
+}
+
+// location is the Makefile and the line being parsed, for warnings about
+// what it expands. Once it is parsed it is just the Makefile.
+func (m *Makefile) location() string {
+	if m.line == 0 {
+		return m.Filename
+	}
+	return fmt.Sprintf("%s:%d", m.Filename, m.line)
+}
+
+// assign evaluates a variable assignment
+func (m *Makefile) assign(a *ast.Assignment) {
+	name, value := a.Name.Text, a.Value.Text
+	prefix := a.Prefix
+	if prefix != "" && m.POSIX {
+		m.notPOSIX("'%s'", prefix)
+		prefix = ""
+	}
+	if a.Op == ":=" {
+		m.notPOSIX("':='")
+	}
+	old, defined := m.Variables[name]
+	if defined && old.Origin == OriginCommandLine && prefix != "override" {
+		m.logf(LogDebug, "", "  variable '%s' is overridden on the command line", name)
+		return
+	}
+
+	recursive := true
+	switch a.Op {
+	case "?=":
+		if _, inEnv := m.lookupEnv(name); defined || inEnv {
+			m.logf(LogDebug, "", "  variable '%s' is already defined", name)
+			return
+		}
+	case ":=", "::=":
+		value, recursive = m.expandVariables(value), false
+	case "+=":
+		// appending keeps the flavor of the variable, like make
+		if defined && !old.Recursive {
+			value, recursive = m.expandVariables(value), false
+		}
+		if defined && old.Value != "" {
+			value = old.Value + " " + value
+		}
+	case "!=":
+		m.logf(LogWarn, "", "%s:%d: ignoring shell assignment to '%s', '!=' is not supported", m.Filename, a.From.Line, name)
+		return
+	}
+
+	m.setVariable(&Variable{
+		Name:      name,
+		Value:     value,
+		Origin:    OriginMakefile,
+		Line:      a.From.Line,
+		Recursive: recursive,
+	})
+	if m.logEnabled(LogDebug) {
+		m.logf(LogDebug, "", "  variable '%s' = '%s'", name, value)
+	}
+}
+
+// addRule adds a target for each of the rule's targets and returns them.
+// Like make, a target of several rules has the prerequisites of all of
+// them, and the recipe of the last one with a recipe. "::" rules instead
+// each get a DoubleColonRule of their own. Pattern rules are replaced.
+func (m *Makefile) addRule(rule *ast.Rule, section string) []*Target {
+	// Like make, targets and prerequisites are expanded when the rule is
+	// read, so a variable can hold several of them
+	deps := make([]string, 0, len(rule.Prereqs))
+	for _, w := range rule.Prereqs {
+		deps = append(deps, m.globPrereqs(normalizeNames(splitNames(m.expandVariables(w.Text))))...)
+	}
+	description := ""
+	if rule.Comment != nil && strings.HasPrefix(rule.Comment.Text, "##") {
+		description = strings.TrimSpace(strings.TrimPrefix(rule.Comment.Text, "##"))
+	}
+
+	var names []string
+	for _, w := range rule.Targets {
+		names = append(names, normalizeNames(splitNames(m.expandVariables(w.Text)))...)
+	}
+
+	var targets []*Target
+	for _, targetName := range names {
+		if old := m.Targets[targetName]; old != nil && !old.Pattern {
+			if old.DoubleColon != (rule.Colon == "::") {
+				m.logf(LogWarn, "", "%s:%d: target '%s' has both : and :: rules, ignoring this one", m.Filename, rule.From.Line, targetName)
+				continue
+			}
+			old.Dependencies = appendNew(old.Dependencies, deps)
+			if old.DoubleColon {
+				old.Rules = append(old.Rules, &DoubleColonRule{Dependencies: deps, Line: rule.From.Line})
+			}
+			if old.Description == "" && description != "" {
+				old.Description, old.Section = description, section
+			}
+			if m.logEnabled(LogDebug) {
+				m.logf(LogDebug, "", "  rule '%s' adds prerequisites %v", targetName, deps)
+			}
+			targets = append(targets, old)
+			continue
+		}
+		target := &Target{
+			Name:         targetName,
+			Commands:     make([]Command, 0),
+			Dependencies: deps,
+			Line:         rule.From.Line,
+			DoubleColon:  rule.Colon == "::",
+		}
+		if target.DoubleColon {
+			target.Rules = []*DoubleColonRule{{Dependencies: deps, Line: rule.From.Line}}
+		}
+
+		if !setPattern(target) {
+			m.logf(LogWarn, "", "%s:%d: ignoring pattern rule '%s' with more than one '%%'", m.Filename, rule.From.Line, targetName)
+			continue
+		}
+		if target.Pattern {
+			m.notPOSIX("the pattern rule '%s'", targetName)
+		}
+
+		if description != "" {
+			target.Description = description
+			target.Section = section
+		}
+
+		if m.logEnabled(LogDebug) {
+			m.logf(LogDebug, "", "  rule '%s' with prerequisites %v", targetName, target.Dependencies)
+		}
+		m.setTarget(targetName, target)
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// appendNew appends the names that aren't in list yet
+func appendNew(list, names []string) []string {
+	merged := append(make([]string, 0, len(list)+len(names)), list...)
+	for _, name := range names {
+		if !slices.Contains(merged, name) {
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
+
+// splitNames splits the targets or prerequisites of a rule like make: at
+// blanks that aren't escaped as "\ ", and only at ASCII ones, so names may
+// hold non-breaking and other Unicode spaces. "\ " and "\<tab>" become a
+// part of the name, as "$$" becomes a literal '$'.
+func splitNames(s string) []string {
+	var names []string
+	var name strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == ' ' || s[i+1] == '\t'):
+			name.WriteByte(s[i+1])
+			i++
+		case c == '$' && i+1 < len(s) && s[i+1] == '$':
+			name.WriteByte('$')
+			i++
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			if name.Len() > 0 {
+				names = append(names, name.String())
+				name.Reset()
+			}
+		default:
+			name.WriteByte(c)
+		}
+	}
+	if name.Len() > 0 {
+		names = append(names, name.String())
+	}
+	return names
+}
+
+// globPrereqs replaces the glob patterns among prerequisites by the files
+// they match, as make does. A pattern that matches nothing stays, so it
+// fails as a missing prerequisite, and so do the ones of pattern rules.
+func (m *Makefile) globPrereqs(deps []string) []string {
+	globbed := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		if hasGlobMeta(dep) && !strings.Contains(dep, "%") {
+			if m.POSIX {
+				m.notPOSIX("the wildcard in '%s'", dep)
+				globbed = append(globbed, dep)
+				continue
+			}
+			if matches := m.glob(dep); len(matches) > 0 {
+				globbed = append(globbed, matches...)
+				continue
+			}
+		}
+		globbed = append(globbed, dep)
+	}
+	return globbed
+}
+
+// setPattern marks a target whose name contains '%' as a pattern rule. It
+// returns false if the name has more than one '%'.
+func setPattern(t *Target) bool {
+	if !strings.Contains(t.Name, "%") {
+		return true
+	}
+	pattern := strings.Split(t.Name, "%")
+	if len(pattern) != 2 {
+		return false
+	}
+	t.Pattern = true
+	t.PatternFrom = pattern[0]
+	t.PatternTo = pattern[1]
+	return true
+}
+
+// addRecipeLine adds a recipe line of the rule on line rule to the
+// commands of targets
+func (m *Makefile) addRecipeLine(targets []*Target, rule int, line *ast.RecipeLine) {
+	// The lines after a script: line are its source, as they are written
+	continued := len(targets) > 0 && targets[0].recipeRule == rule && inScript(targets[0])
+	m.line = line.From.Line
+	for _, target := range targets {
+		m.startRecipe(target, rule)
+	}
+	// Like make, a continued line loses the recipe prefix of the lines
+	// that continue it, and keeps the backslashes for the shell
+	text := line.Text
+	if prefix := line.Prefix; strings.Contains(text, "\\\n") {
+		if prefix == "" {
+			prefix = "\t"
+		}
+		text = strings.ReplaceAll(text, "\\\n"+prefix, "\\\n")
+	}
+	if continued {
+		for _, target := range targets {
+			script := &target.Commands[len(target.Commands)-1]
+			if script.Cmd != "" {
+				script.Cmd += "\n"
+			}
+			script.Cmd += text
+			if len(target.Rules) > 0 {
+				r := target.Rules[len(target.Rules)-1]
+				r.Commands[len(r.Commands)-1].Cmd = script.Cmd
+			}
+		}
+		return
+	}
+	if strings.TrimSpace(text) == scriptLine {
+		m.notPOSIX("'%s'", scriptLine)
+		for _, target := range targets {
+			m.logf(LogDebug, "", "  script for '%s'", target.Name)
+			target.addCommand(Command{Script: true})
+		}
+		return
+	}
+
+	command := text
+	// Lines that only hold a comment are not run
+	if strings.HasPrefix(strings.TrimSpace(command), "#") {
+		return
+	}
+	silent := false
+	if strings.HasPrefix(command, "@") {
+		silent = true
+		command = strings.TrimPrefix(command, "@")
+	}
+	command = strings.TrimSpace(command)
+	// Expand variables in command
+	command = m.expandVariables(command)
+	for _, target := range targets {
+		if m.logEnabled(LogDebug) {
+			m.logf(LogDebug, "", "  recipe line for '%s': %s", target.Name, command)
+		}
+		target.addCommand(Command{
+			Cmd:    command,
+			Silent: silent,
+		})
+	}
+}
+
+// startRecipe makes the rule on line rule the one whose recipe a target
+// has. The recipe of an earlier rule is dropped with a warning, as make
+// does, unless the target has "::" rules, whose recipes add up.
+func (m *Makefile) startRecipe(target *Target, rule int) {
+	if target.recipeRule == rule {
+		return
+	}
+	if target.recipeRule != 0 && !target.DoubleColon && len(target.Commands) > 0 {
+		m.logf(LogWarn, "", "%s:%d: overriding recipe for target '%s'", m.Filename, rule, target.Name)
+		m.logf(LogWarn, "", "%s:%d: ignoring old recipe for target '%s'", m.Filename, target.recipeRule, target.Name)
+		target.Commands = make([]Command, 0)
+	}
+	target.recipeRule = rule
+}
+
+// inScript reports whether the recipe of a target ends with a script, which
+// the next recipe lines belong to
+func inScript(t *Target) bool {
+	return len(t.Commands) > 0 && t.Commands[len(t.Commands)-1].Script
+}
+
+// expandVariables replaces $(VAR) or ${VAR} with their values and calls
+// the functions in $(name args) references. "$$" and references that can't
+// be expanded are left as they are.
+func (m *Makefile) expandVariables(str string) string {
+	e := &expansion{m: m}
+	return e.memoized(str, true)
+}
+
+// Expand evaluates a make expression against the variables of m, the way
+// the parser expands function arguments: undefined variables expand to
+// nothing and "$$" to "$". The error is the first function call that
+// failed, which expands to nothing, or with UndefinedError the first
+// undefined variable.
+func (m *Makefile) Expand(str string) (string, error) {
+	e := &expansion{m: m, unescape: true}
+	result := e.memoized(str, false)
+	return result, e.err
+}
+
+// expansion is a single expansion of a string
+type expansion struct {
+	m *Makefile
+	// unescape turns "$$" into "$" rather than keeping it for later
+	unescape bool
+	// err is the first error of a function call, or the first undefined
+	// variable with UndefinedError
+	err error
+	// impure is set once the expansion depends on more than the variables,
+	// so the expansion cache doesn't keep it
+	impure bool
+	// active are the recursive variables being expanded, to catch the
+	// ones that reference themselves
+	active []string
+}
+
+// expand expands the references in str. Undefined variables are kept as
+// they are written if keepUndefined is set, and expand to nothing
+// otherwise, as they do in function arguments.
+func (e *expansion) expand(str string, keepUndefined bool) string {
+	next := strings.IndexByte(str, '$')
+	if next < 0 {
+		// most words and recipe lines reference nothing
+		return str
+	}
+	var b strings.Builder
+	b.Grow(len(str))
+	for i := 0; i < len(str); i++ {
+		if str[i] != '$' {
+			// copy the text up to the next reference at once
+			next = strings.IndexByte(str[i:], '$')
+			if next < 0 {
+				b.WriteString(str[i:])
+				break
+			}
+			b.WriteString(str[i : i+next])
+			i += next
+		}
+		if i+1 == len(str) {
+			b.WriteByte('$')
+			break
+		}
+		switch c := str[i+1]; c {
+		case '$':
+			if e.unescape {
+				b.WriteByte('$')
+			} else {
+				b.WriteString("$$")
+			}
+			i++
+			continue
+		case '(', '{':
+		default:
+			// A single character names the variable, like $@ or $X
+			b.WriteString(e.expandVariable(str[i:i+2], string(c), keepUndefined))
+			i++
+			continue
+		}
+		end := closingParen(str, i+1)
+		if end < 0 {
+			b.WriteString(str[i:])
+			break
+		}
+		b.WriteString(e.expandReference(str[i:end+1], keepUndefined))
+		i = end
+	}
+	return b.String()
+}
+
+// expandReference expands a single $(...) or ${...} reference
+func (e *expansion) expandReference(ref string, keepUndefined bool) string {
+	body := ref[2 : len(ref)-1]
+	if k := strings.IndexAny(body, " \t"); k > 0 {
+		if fn, found := e.m.functions().lookup(body[:k]); found {
+			if e.m.POSIX {
+				// POSIX make has no functions, the reference names an
+				// undefined variable
+				e.impure = true
+				e.m.notPOSIX("$(%s)", body[:k])
+				return ""
+			}
+			if e.m.record != nil && !cacheableFunctions[body[:k]] {
+				e.m.record.refuse("it calls $(" + body[:k] + ")")
+			}
+			if e.m.Functions != nil || !pureFunctions[body[:k]] {
+				e.impure = true
+			}
+			return e.callFunction(ref, fn, body[k+1:])
+		}
+	}
+	return e.expandVariable(ref, e.expand(body, false), keepUndefined)
+}
+
+// expandVariable looks up the variable a reference names
+func (e *expansion) expandVariable(ref, varName string, keepUndefined bool) string {
+	m := e.m
+	if v, ok := m.Variables[varName]; ok {
+		value := v.Value
+		if v.Recursive {
+			value = e.expandRecursive(v, keepUndefined)
+		}
+		if m.logEnabled(LogTrace) {
+			m.logf(LogTrace, "", "Expanding %s to '%s'", ref, value)
+		}
+		return value
+	}
+	// Like make, fall back to the environment
+	e.impure = true
+	if val, ok := m.lookupEnv(varName); ok {
+		m.logf(LogTrace, "", "Expanding %s to '%s' from the environment", ref, val)
+		return val
+	}
+	if m.Resolver != nil {
+		if val, ok := m.Resolver.Resolve(varName); ok {
+			m.logf(LogTrace, "", "Expanding %s to '%s' from the resolver", ref, val)
+			return val
+		}
+	}
+	e.undefined(varName)
+	if !keepUndefined {
+		m.logf(LogTrace, "", "Variable '%s' is undefined, expanding %s to nothing", varName, ref)
+		return ""
+	}
+	m.logf(LogTrace, "", "Variable '%s' is undefined, leaving %s as is", varName, ref)
+	return ref
+}
+
+// undefined reports a reference to an undefined variable as the
+// Makefile's Undefined policy says, except to the automatic variables,
+// which the session fills in when the recipe runs, see expandRecipe
+func (e *expansion) undefined(varName string) {
+	m := e.m
+	if m.Undefined == UndefinedIgnore || isAutomatic(varName) {
+		return
+	}
+	err := fmt.Errorf("undefined variable '%s'", varName)
+	level := LogWarn
+	if m.Undefined == UndefinedError {
+		level = LogError
+		if e.err == nil {
+			e.err = err
+		}
+		if m.line > 0 {
+			m.undefinedErrs = append(m.undefinedErrs, &ParseError{Filename: m.Filename, Line: m.line, Err: err})
+		}
+	}
+	m.logf(level, "", "%s: %v", m.location(), err)
+}
+
+// isAutomatic reports whether a variable is one of make's automatic
+// variables, like @ or <D
+func isAutomatic(name string) bool {
+	if len(name) == 2 && (name[1] == 'D' || name[1] == 'F') {
+		name = name[:1]
+	}
+	return len(name) == 1 && strings.Contains("@%<?^+|*", name)
+}
+
+// expandRecursive expands the value of a recursive variable where it is
+// referenced. A variable that references itself expands to nothing.
+func (e *expansion) expandRecursive(v *Variable, keepUndefined bool) string {
+	if slices.Contains(e.active, v.Name) {
+		e.impure = true
+		err := fmt.Errorf("recursive variable '%s' references itself", v.Name)
+		if e.err == nil {
+			e.err = err
+		}
+		e.m.logf(LogWarn, "", "%s:%d: %v", e.m.Filename, v.Line, err)
+		return ""
+	}
+	e.active = append(e.active, v.Name)
+	result := e.memoized(v.Value, keepUndefined)
+	e.active = e.active[:len(e.active)-1]
+	return result
+}
+
+// callFunction calls the function of a function reference, which expands
+// the arguments it needs. A failing function is reported and expands to
+// nothing.
+func (e *expansion) callFunction(ref string, fn LazyFunction, args string) string {
+	m := e.m
+	parts := splitArgs(strings.TrimLeft(args, " \t"))
+	result, err := fn(m, parts, func(s string) string {
+		return e.expand(s, false)
+	})
+	if err != nil {
+		e.impure = true
+		if e.err == nil {
+			e.err = fmt.Errorf("%s: %w", ref, err)
+		}
+		m.logf(LogWarn, "", "%s: %s: %v", m.Filename, ref, err)
+		return ""
+	}
+	if m.logEnabled(LogTrace) {
+		m.logf(LogTrace, "", "Expanding %s to '%s'", ref, result)
+	}
+	return result
+}
+
+// closingParen returns the index of the parenthesis or brace closing the
+// one at str[open], or -1 if it isn't closed
+func closingParen(str string, open int) int {
+	closing := byte(')')
+	if str[open] == '{' {
+		closing = '}'
+	}
+	depth := 0
+	for i := open; i < len(str); i++ {
+		switch str[i] {
+		case str[open]:
+			depth++
+		case closing:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitArgs splits function arguments at the commas that are not inside a
+// nested reference
+func splitArgs(args string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, args[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, args[start:])
+}
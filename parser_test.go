@@ -0,0 +1,70 @@
+package smmake
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestContinuedLines(t *testing.T) {
+	m, err := Parse(strings.NewReader("SRCS = a.c \\\n   b.c\\\n\tc.c\n\nall: a \\\n   b\n\techo $(SRCS) \\\n\t  done\n"), "Makefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := m.Variables["SRCS"]; v == nil || v.Value != "a.c b.c c.c" {
+		t.Errorf("SRCS = %+v, want a.c b.c c.c", v)
+	}
+	all := m.Targets["all"]
+	if all == nil {
+		t.Fatal("no target all")
+	}
+	if want := []string{"a", "b"}; !slices.Equal(all.Dependencies, want) {
+		t.Errorf("all depends on %q, want %q", all.Dependencies, want)
+	}
+	if len(all.Commands) != 1 || all.Commands[0].Cmd != "echo a.c b.c c.c \\\n  done" {
+		t.Errorf("all runs %+v, want one continued line", all.Commands)
+	}
+}
+
+func TestContinuedLineIsNoMissingSeparator(t *testing.T) {
+	for _, makefile := range []string{
+		"all: a \\\n   b\n\techo $^\n",
+		"all: a\n\techo \\\n  b\n",
+		"X = 1 \\\n    2\nall:\n\techo $(X)\n",
+	} {
+		if _, err := Parse(strings.NewReader(makefile), "Makefile"); err != nil {
+			t.Errorf("parsing %q: %v", makefile, err)
+		}
+	}
+	if _, err := Parse(strings.NewReader("all: a\n    echo a\n"), "Makefile"); err == nil {
+		t.Error("a space indented recipe line parsed, want missing separator")
+	}
+}
+
+func TestFunctionsExpandTheArgumentsTheyUse(t *testing.T) {
+	m, err := Parse(strings.NewReader("pair = $(2)-$(1)\nf = outer\n"), "Makefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for expr, want := range map[string]string{
+		"$(if x,a,$(shell exit 1))":            "a",
+		"$(if ,$(shell exit 1),b)":             "b",
+		"$(or a,$(shell exit 1))":              "a",
+		"$(and ,$(shell exit 1))":              "",
+		"$(foreach f,1 2 3,$(f)x)":             "1x 2x 3x",
+		"$(foreach f,a b,$(call pair,$(f),y))": "y-a y-b",
+		"$(call pair,a,b)":                     "b-a",
+		"$(f)":                                 "outer",
+	} {
+		got, err := m.Expand(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", expr, got, want)
+		}
+	}
+	if _, ok := m.Variables["1"]; ok {
+		t.Errorf("$(call) left $(1) defined")
+	}
+}
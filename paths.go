@@ -0,0 +1,134 @@
+package smmake
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// normalizeName makes file names written for one platform match the same
+// names written for another: backslashes separating directories become
+// slashes and redundant ./ and // are dropped, so build\app.exe,
+// ./build/app.exe and build//app.exe are all build/app.exe. A backslash
+// before white space or a character make treats specially is an escape and
+// stays, as do names with unexpanded references and URLs. UNC names such as
+// \\server\share\src.c and \\?\C:\long\path keep their leading two
+// separators, as //server/share/src.c.
+func normalizeName(name string) string {
+	if name == "" || strings.Contains(name, "$") || strings.Contains(name, "://") {
+		return name
+	}
+	unc := strings.HasPrefix(name, `\\`) || strings.HasPrefix(name, "//")
+	if unc {
+		name = "//" + name[2:]
+	}
+	if strings.Contains(name, `\`) {
+		var b strings.Builder
+		for i := 0; i < len(name); i++ {
+			if name[i] == '\\' && (i+1 == len(name) || !strings.ContainsRune(" \t#:%=;$\\\"'", rune(name[i+1]))) {
+				b.WriteByte('/')
+				continue
+			}
+			b.WriteByte(name[i])
+		}
+		name = b.String()
+	}
+	if !strings.Contains(name, "/") {
+		return name
+	}
+	if unc {
+		return "/" + path.Clean(name)
+	}
+	return path.Clean(name)
+}
+
+// normalizeNames normalizes every name of a list, in place
+func normalizeNames(names []string) []string {
+	for i, name := range names {
+		names[i] = normalizeName(name)
+	}
+	return names
+}
+
+// absPath returns name as an absolute, clean path with forward slashes,
+// relative to the directory of the Makefile
+func (m *Makefile) absPath(name string) string {
+	name = filepath.FromSlash(normalizeName(name))
+	if !filepath.IsAbs(name) {
+		dir := m.Dir
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		name = filepath.Join(dir, name)
+	}
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+// realPath is absPath with symbolic links resolved, or "" if name doesn't
+// exist
+func (m *Makefile) realPath(name string) string {
+	resolved, err := filepath.EvalSymlinks(filepath.FromSlash(m.absPath(name)))
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(resolved)
+}
+
+// joinPath joins the parts of a path with slashes and cleans the result
+func joinPath(parts []string) string {
+	var elems []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			elems = append(elems, normalizeName(part))
+		}
+	}
+	if len(elems) == 0 {
+		return ""
+	}
+	return path.Join(elems...)
+}
+
+// caseInsensitiveOS reports whether the usual file systems of the platform
+// ignore case, as NTFS and APFS do by default
+func caseInsensitiveOS() bool {
+	return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
+
+// foldIndex maps every target name in lower case to the name of its rule,
+// nil unless IgnoreCase is set. Of rules that only differ in case, the
+// first in sorted order wins.
+func (m *Makefile) foldIndex() map[string]string {
+	if !m.IgnoreCase {
+		return nil
+	}
+	index := make(map[string]string, len(m.Targets))
+	for _, name := range sortedKeys(m.Targets) {
+		folded := strings.ToLower(name)
+		if _, ok := index[folded]; !ok {
+			index[folded] = name
+		}
+	}
+	return index
+}
+
+// canonicalName returns the name of the rule name refers to in a fold
+// index, so Build and build are the same target, or name itself if no rule
+// matches or there is no index
+func canonicalName(index map[string]string, name string) string {
+	if index == nil {
+		return name
+	}
+	if canonical, ok := index[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// sameName compares target names, ignoring case if IgnoreCase is set
+func (m *Makefile) sameName(a, b string) bool {
+	if m.IgnoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
@@ -0,0 +1,120 @@
+package smmake
+
+// Plan is what building a set of goals would do, worked out without running
+// anything
+type Plan struct {
+	Goals []string
+	// Steps are the targets whose recipes would run, every prerequisite
+	// before the targets that need it
+	Steps []PlanStep
+	// Files are the prerequisites without a rule that are used as they are
+	Files []string
+}
+
+// PlanStep is a target the build reaches, whose recipe runs unless Skip is
+// set
+type PlanStep struct {
+	Target string
+	// Pattern is the pattern rule the target is made with, if any
+	Pattern  string
+	Phony    bool
+	Commands []Command
+	// Reason explains why the recipe runs, as ExplainTarget does, or why it
+	// is skipped
+	Reason string
+	// Skip reports whether Build skips the recipe: the target is assumed to
+	// be old (WithAssumeOld), or it is named like an existing file that is
+	// up to date under ConflictFile. Targets listed in .RESTAT may also
+	// prune their dependents, but that depends on what their recipes write,
+	// so it isn't known before the build.
+	Skip bool
+	// Stale reports whether make would run the recipe, because the file of
+	// the target is missing or older than its prerequisites. smmake doesn't
+	// skip up to date targets otherwise, so their recipes run as well.
+	Stale bool
+}
+
+// Plan returns the targets and recipe lines that building goals would run,
+// given the files that exist now. The steps are the ones Build reaches, and
+// skips the same ones, see PlanStep.Skip. A goal or prerequisite that can't be made fails
+// the plan with an *UnknownTargetError, a dependency cycle with a
+// *CycleError.
+func (m *Makefile) Plan(goals ...string) (*Plan, error) {
+	nodes, err := m.BuildGraph(goals...)
+	if err != nil {
+		return nil, err
+	}
+
+	decide := m.decider(nodes)
+	stats := newStatCache()
+
+	plan := &Plan{Goals: goals}
+	visited := make(map[string]bool)
+	var stack []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		for i, n := range stack {
+			if n == name {
+				return m.cycleError(append(append([]string(nil), stack[i:]...), name))
+			}
+		}
+		if visited[name] {
+			return nil
+		}
+		visited[name] = true
+		node := nodes[name]
+		target := m.Targets[name]
+		if node.Pattern != "" {
+			target = instantiatePattern(m.Targets[node.Pattern], name)
+		}
+		if !node.File && m.assumed(m.AssumeOld, name) {
+			// like Build, the prerequisites of an old target aren't reached
+			plan.Steps = append(plan.Steps, PlanStep{
+				Target:   name,
+				Pattern:  node.Pattern,
+				Phony:    node.Phony,
+				Commands: target.Commands,
+				Reason:   decide(name).Reason,
+				Skip:     true,
+			})
+			return nil
+		}
+		stack = append(stack, name)
+		for _, dep := range node.Deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+
+		d := decide(name)
+		if node.File {
+			if d.Err {
+				return m.unknownTargetError(name)
+			}
+			plan.Files = append(plan.Files, name)
+			return nil
+		}
+		step := PlanStep{
+			Target:   name,
+			Pattern:  node.Pattern,
+			Phony:    node.Phony,
+			Commands: target.Commands,
+			Reason:   d.Reason,
+			Stale:    d.Rebuild,
+		}
+		if kind := m.fileConflict(m.Targets[name], name, stats); kind != "" && m.Conflicts == ConflictFile && m.fileUpToDate(name, node.Deps, stats) {
+			step.Reason = "the existing " + kind + " is up to date"
+			step.Skip = true
+		}
+		plan.Steps = append(plan.Steps, step)
+		return nil
+	}
+	index := m.foldIndex()
+	for _, goal := range goals {
+		if err := visit(canonicalName(index, normalizeName(goal))); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
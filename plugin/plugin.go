@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"smmake"
+)
+
+// Plugin is a running plugin process
+type Plugin struct {
+	Description
+	Path string
+	// OnError is called with the errors that can't fail a target, such as a
+	// failed save to the cache. They are dropped if it is nil.
+	OnError func(err error)
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+
+	mutex  sync.Mutex
+	nextID int
+}
+
+// Start runs the plugin at path and asks it what it provides
+func Start(path string) (*Plugin, error) {
+	p := &Plugin{Path: path, cmd: exec.Command(path)}
+	p.cmd.Stderr = os.Stderr
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.stdin = stdin
+	p.stdout = bufio.NewScanner(stdout)
+	p.stdout.Buffer(nil, 64<<20)
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting plugin %s: %w", path, err)
+	}
+	if err := p.request("describe", nil, &p.Description); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+// Discover starts the plugins found in the directories, in the order of
+// the directories and then by file name. Directories that don't exist are
+// skipped. On error the plugins started so far are closed.
+func Discover(dirs ...string) ([]*Plugin, error) {
+	var plugins []*Plugin
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			Close(plugins)
+			return nil, fmt.Errorf("error reading plugin directory: %w", err)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			if !isExecutable(entry) {
+				continue
+			}
+			p, err := Start(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				Close(plugins)
+				return nil, err
+			}
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins, nil
+}
+
+// Close stops all the plugins
+func Close(plugins []*Plugin) {
+	for _, p := range plugins {
+		p.Close()
+	}
+}
+
+// isExecutable reports whether a directory entry is a program, by its mode
+// or, on Windows, its extension
+func isExecutable(entry fs.DirEntry) bool {
+	if !entry.Type().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(entry.Name()), ".exe")
+	}
+	info, err := entry.Info()
+	return err == nil && info.Mode()&0o111 != 0
+}
+
+// Close ends the plugin by closing its stdin, and waits for it to exit
+func (p *Plugin) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// request sends a request and decodes the result of its response into
+// result, unless that is nil
+func (p *Plugin) request(method string, params, result any) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.nextID++
+	req := Request{ID: p.nextID, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("plugin %s: %w", p.Path, err)
+	}
+
+	if !p.stdout.Scan() {
+		err := p.stdout.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("plugin %s: %w", p.Path, err)
+	}
+	var resp Response
+	if err := json.Unmarshal(p.stdout.Bytes(), &resp); err != nil {
+		return fmt.Errorf("plugin %s: invalid response: %w", p.Path, err)
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("plugin %s: response %d to request %d", p.Path, resp.ID, req.ID)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result == nil || resp.Result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("plugin %s: invalid %s result: %w", p.Path, method, err)
+	}
+	return nil
+}
+
+// RegisterFunctions adds the functions of the plugin to r
+func (p *Plugin) RegisterFunctions(r *smmake.FunctionRegistry) {
+	for _, name := range p.Functions {
+		r.Register(name, func(_ *smmake.Makefile, args []string) (string, error) {
+			var result CallResult
+			if err := p.request("call", CallParams{Function: name, Args: args}, &result); err != nil {
+				return "", err
+			}
+			return result.Value, nil
+		})
+	}
+}
+
+// RecipeRunner returns a smmake.Runner that sends the recipe lines to the plugin.
+// Their output is passed on once they finished, and they are not stopped
+// when the context is done.
+func (p *Plugin) RecipeRunner() smmake.Runner {
+	return runner{p}
+}
+
+type runner struct {
+	p *Plugin
+}
+
+func (r runner) Run(_ context.Context, cmd smmake.Command, env smmake.Env) (smmake.Result, error) {
+	start := time.Now()
+	var out RunResult
+	err := r.p.request("run", RunParams{
+		Target:  env.Target,
+		Command: cmd.Cmd,
+		Shell:   env.Shell,
+		Dir:     env.Dir,
+		Environ: env.Environ,
+	}, &out)
+	result := smmake.Result{ExitCode: out.ExitCode, Duration: time.Since(start)}
+	if err != nil {
+		return result, err
+	}
+	io.WriteString(env.Stdout, out.Stdout)
+	io.WriteString(env.Stderr, out.Stderr)
+	if out.ExitCode != 0 {
+		return result, fmt.Errorf("exit status %d", out.ExitCode)
+	}
+	return result, nil
+}
+
+// Hooks returns the hooks that use the plugin as a cache for the targets of
+// m: a target it can restore is skipped, and a target whose recipe ran
+// successfully is saved
+func (p *Plugin) Hooks(m *smmake.Makefile) smmake.Hooks {
+	return smmake.Hooks{
+		OnTargetStart: func(e smmake.TargetEvent) error {
+			var result RestoreResult
+			if err := p.request("restore", cacheParams(m, e), &result); err != nil {
+				return fmt.Errorf("error restoring '%s' from %s: %w", e.Name, p.Name, err)
+			}
+			if result.Hit {
+				return smmake.ErrSkipTarget
+			}
+			return nil
+		},
+		OnTargetFinish: func(e smmake.TargetEvent) {
+			if !e.Ran || e.Err != nil {
+				return
+			}
+			if err := p.request("save", cacheParams(m, e), nil); err != nil && p.OnError != nil {
+				p.OnError(fmt.Errorf("error saving '%s' to %s: %w", e.Name, p.Name, err))
+			}
+		},
+	}
+}
+
+// cacheParams describes a target to the cache, with the digests of its
+// prerequisites as they are now
+func cacheParams(m *smmake.Makefile, e smmake.TargetEvent) CacheParams {
+	params := CacheParams{Target: e.Name}
+	if e.Target != nil {
+		for _, cmd := range e.Target.Commands {
+			params.Commands = append(params.Commands, cmd.Cmd)
+		}
+	}
+	for _, name := range e.Prerequisites {
+		// a prerequisite that is no file, like a phony target, has no
+		// digest
+		sum, _ := m.HashFile(name)
+		params.Prerequisites = append(params.Prerequisites, Prerequisite{Name: name, SHA256: sum})
+	}
+	return params
+}
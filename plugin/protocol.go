@@ -0,0 +1,107 @@
+// Package plugin runs smmake extensions as separate programs, so functions,
+// runners and cache backends can be added without forking smmake.
+//
+// A plugin is an executable that reads requests from stdin and writes
+// responses to stdout, one JSON object per line, and is free to log to
+// stderr. smmake sends one request at a time and waits for its response:
+//
+//	{"id":1,"method":"describe"}
+//	{"id":1,"result":{"name":"vault","functions":["secret"],"cache":false}}
+//
+// The methods are
+//
+//	describe  what the plugin provides, answered with a Description
+//	call      a function, CallParams answered with a CallResult
+//	run       a recipe line, RunParams answered with a RunResult
+//	restore   a cache lookup, CacheParams answered with a RestoreResult
+//	save      a built target to cache, CacheParams answered with nothing
+//
+// A response with a non-empty "error" fails the request. Plugins written in
+// Go can leave the protocol to Serve.
+//
+// A cache plugin finds the remote_cache endpoint of the smmake config file,
+// if it has one, in the environment variable SMMAKE_REMOTE_CACHE.
+package plugin
+
+import "encoding/json"
+
+// RemoteCacheEnv is the environment variable plugins are started with when
+// the config file sets remote_cache
+const RemoteCacheEnv = "SMMAKE_REMOTE_CACHE"
+
+// Request is a message from smmake to a plugin
+type Request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response answers the request of the same ID
+type Response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Description is what a plugin provides, the result of "describe"
+type Description struct {
+	Name string `json:"name"`
+	// Functions are the make functions the plugin implements
+	Functions []string `json:"functions,omitempty"`
+	// Runner reports whether the plugin can run recipe lines
+	Runner bool `json:"runner,omitempty"`
+	// Cache reports whether the plugin restores and saves targets
+	Cache bool `json:"cache,omitempty"`
+}
+
+// CallParams are the parameters of "call"
+type CallParams struct {
+	Function string   `json:"function"`
+	Args     []string `json:"args"`
+}
+
+// CallResult is the result of "call"
+type CallResult struct {
+	Value string `json:"value"`
+}
+
+// RunParams are the parameters of "run", see smmake.Env
+type RunParams struct {
+	Target  string   `json:"target"`
+	Command string   `json:"command"`
+	Shell   string   `json:"shell,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+	Environ []string `json:"environ,omitempty"`
+}
+
+// RunResult is the result of "run". A non-zero exit code fails the recipe.
+type RunResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+}
+
+// CacheParams are the parameters of "restore" and "save". A cached target
+// can be restored if its recipe and its prerequisites, down to the content
+// of their files, are the ones it was saved with.
+type CacheParams struct {
+	Target   string   `json:"target"`
+	Commands []string `json:"commands,omitempty"`
+	// Prerequisites are the prerequisites the target is made from, in
+	// the order of its rules
+	Prerequisites []Prerequisite `json:"prerequisites,omitempty"`
+}
+
+// Prerequisite is a prerequisite of a cached target
+type Prerequisite struct {
+	Name string `json:"name"`
+	// SHA256 is the hex SHA-256 of the file, empty for prerequisites that
+	// are no file, like phony targets, or can't be read
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// RestoreResult is the result of "restore". A hit skips the recipe of the
+// target.
+type RestoreResult struct {
+	Hit bool `json:"hit"`
+}
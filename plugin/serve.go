@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Handler implements a plugin for Serve. Only the fields that are set are
+// announced to smmake.
+type Handler struct {
+	Name      string
+	Functions map[string]func(args []string) (string, error)
+	Run       func(params RunParams) (RunResult, error)
+	// Restore and Save make the plugin a cache, both must be set
+	Restore func(params CacheParams) (bool, error)
+	Save    func(params CacheParams) error
+}
+
+// Serve answers the requests of smmake on stdin and stdout until stdin is
+// closed. It is what the main function of a plugin written in Go calls.
+func Serve(h Handler) error {
+	return serve(h, os.Stdin, os.Stdout)
+}
+
+func serve(h Handler, r io.Reader, w io.Writer) error {
+	in := bufio.NewScanner(r)
+	in.Buffer(nil, 64<<20)
+	out := json.NewEncoder(w)
+	for in.Scan() {
+		var req Request
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			return fmt.Errorf("invalid request: %w", err)
+		}
+		resp := Response{ID: req.ID}
+		result, err := h.handle(req)
+		if err != nil {
+			resp.Error = err.Error()
+		} else if result != nil {
+			if resp.Result, err = json.Marshal(result); err != nil {
+				resp.Error = err.Error()
+			}
+		}
+		if err := out.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return in.Err()
+}
+
+// handle dispatches a request to the handler
+func (h Handler) handle(req Request) (any, error) {
+	switch req.Method {
+	case "describe":
+		d := Description{Name: h.Name, Runner: h.Run != nil, Cache: h.Restore != nil && h.Save != nil}
+		for name := range h.Functions {
+			d.Functions = append(d.Functions, name)
+		}
+		sort.Strings(d.Functions)
+		return d, nil
+	case "call":
+		var params CallParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		fn := h.Functions[params.Function]
+		if fn == nil {
+			return nil, fmt.Errorf("unknown function '%s'", params.Function)
+		}
+		value, err := fn(params.Args)
+		return CallResult{Value: value}, err
+	case "run":
+		var params RunParams
+		if h.Run == nil {
+			return nil, fmt.Errorf("plugin %s doesn't run recipes", h.Name)
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return h.Run(params)
+	case "restore", "save":
+		var params CacheParams
+		if h.Restore == nil || h.Save == nil {
+			return nil, fmt.Errorf("plugin %s isn't a cache", h.Name)
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if req.Method == "save" {
+			return nil, h.Save(params)
+		}
+		hit, err := h.Restore(params)
+		return RestoreResult{Hit: hit}, err
+	}
+	return nil, fmt.Errorf("unknown method '%s'", req.Method)
+}
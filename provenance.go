@@ -0,0 +1,265 @@
+package smmake
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProvenanceBuildType identifies builds by smmake in provenance statements
+const ProvenanceBuildType = "https://github.com/datstma/smmake/provenance/v1"
+
+// Provenance records what a build of some goals ran, for a SLSA provenance
+// statement about the files it made. Create it with RecordProvenance before
+// the build and write the statement once the build is done.
+type Provenance struct {
+	m     *Makefile
+	goals []string
+
+	mutex    sync.Mutex
+	started  time.Time
+	finished time.Time
+	// commands are the recipe lines that ran, by target
+	commands map[string][]string
+}
+
+// RecordProvenance installs hooks on m that record the recipe lines the
+// build of goals runs
+func (m *Makefile) RecordProvenance(goals []string) *Provenance {
+	p := &Provenance{m: m, goals: goals, started: time.Now(), commands: make(map[string][]string)}
+	m.AddHooks(Hooks{
+		OnCommandFinish: func(e CommandEvent) {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			p.commands[e.Target] = append(p.commands[e.Target], e.Command.Cmd)
+			p.finished = time.Now()
+		},
+	})
+	return p
+}
+
+// provenanceStatement is an in-toto statement with a SLSA provenance
+// predicate, see https://slsa.dev/spec/v1.0/provenance
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []provenanceFile    `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type provenanceFile struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenancePredicate struct {
+	BuildDefinition struct {
+		BuildType          string `json:"buildType"`
+		ExternalParameters struct {
+			Makefile  string            `json:"makefile"`
+			Targets   []string          `json:"targets"`
+			Variables map[string]string `json:"variables,omitempty"`
+		} `json:"externalParameters"`
+		InternalParameters struct {
+			Commands    map[string][]string `json:"commands"`
+			Environment map[string]string   `json:"environment,omitempty"`
+		} `json:"internalParameters"`
+		ResolvedDependencies []provenanceFile `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  time.Time `json:"startedOn"`
+			FinishedOn time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// Statement returns the provenance of the build as JSON: its subjects are
+// the files the goals and their prerequisites made, with their SHA-256
+// digests, and it records the Makefile and source files it read, the
+// command line variables, the recipe lines that ran and the values of the
+// environment variables named in env. Other environment variables are left
+// out, as they may hold secrets.
+func (p *Provenance) Statement(env []string) ([]byte, error) {
+	m := p.m
+	g, err := m.Graph(p.goals...)
+	if err != nil {
+		return nil, err
+	}
+	var s provenanceStatement
+	s.Type = "https://in-toto.io/Statement/v1"
+	s.PredicateType = "https://slsa.dev/provenance/v1"
+	s.Subject = []provenanceFile{}
+	def := &s.Predicate.BuildDefinition
+	def.BuildType = ProvenanceBuildType
+	def.ExternalParameters.Makefile = m.Filename
+	def.ExternalParameters.Targets = p.goals
+	def.ResolvedDependencies = []provenanceFile{}
+	if m.Filename != "" {
+		if digest, err := m.fileDigest(m.Filename); err == nil {
+			def.ResolvedDependencies = append(def.ResolvedDependencies, provenanceFile{URI: m.Filename, Digest: digest})
+		}
+	}
+	for _, name := range sortedKeys(m.Variables) {
+		if v := m.Variables[name]; v.Origin == OriginCommandLine {
+			if def.ExternalParameters.Variables == nil {
+				def.ExternalParameters.Variables = make(map[string]string)
+			}
+			def.ExternalParameters.Variables[name] = v.Value
+		}
+	}
+
+	var names []string
+	for _, name := range sortedKeys(g.Nodes) {
+		if !g.Nodes[name].Phony {
+			names = append(names, name)
+		}
+	}
+	sums, errs := m.hashFiles(names)
+	for i, name := range names {
+		node := g.Nodes[name]
+		if err := errs[i]; err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// A target whose recipe makes no file
+				continue
+			}
+			return nil, err
+		}
+		digest := map[string]string{"sha256": sums[i]}
+		if node.File {
+			def.ResolvedDependencies = append(def.ResolvedDependencies, provenanceFile{URI: name, Digest: digest})
+		} else {
+			s.Subject = append(s.Subject, provenanceFile{Name: name, Digest: digest})
+		}
+	}
+
+	environ := m.environ()
+	if environ == nil {
+		environ = os.Environ()
+	}
+	values := make(map[string]string)
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		values[key] = value
+	}
+	for _, name := range env {
+		if value, ok := values[name]; ok {
+			if def.InternalParameters.Environment == nil {
+				def.InternalParameters.Environment = make(map[string]string)
+			}
+			def.InternalParameters.Environment[name] = value
+		}
+	}
+	s.Predicate.RunDetails.Builder.ID = ProvenanceBuildType
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	def.InternalParameters.Commands = p.commands
+	s.Predicate.RunDetails.Metadata.StartedOn = p.started.UTC()
+	s.Predicate.RunDetails.Metadata.FinishedOn = p.finished.UTC()
+	if p.finished.IsZero() {
+		s.Predicate.RunDetails.Metadata.FinishedOn = time.Now().UTC()
+	}
+	return json.MarshalIndent(&s, "", "  ")
+}
+
+// fileDigest returns the SHA-256 digest of a file as a provenance digest
+func (m *Makefile) fileDigest(name string) (map[string]string, error) {
+	sum, err := m.hashFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"sha256": sum}, nil
+}
+
+// dsseEnvelope is a signed statement, see
+// https://github.com/secure-systems-lab/dsse
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// SignStatement wraps a statement in a DSSE envelope signed with key, an
+// Ed25519, ECDSA or RSA private key as read by ParseSigningKey. The key id
+// of the signature is the SHA-256 digest of the DER encoded public key.
+func SignStatement(statement []byte, key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyID := sha256.Sum256(der)
+	const payloadType = "application/vnd.in-toto+json"
+	// The signature covers the pre-authentication encoding of the payload
+	pae := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(statement), statement))
+	var sig []byte
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = key.Sign(rand.Reader, pae, crypto.Hash(0))
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		digest := sha256.Sum256(pae)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("can't sign with a %T key", key.Public())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error signing the statement: %w", err)
+	}
+	return json.MarshalIndent(dsseEnvelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []dsseSignature{{KeyID: hex.EncodeToString(keyID[:]), Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, "", "  ")
+}
+
+// ParseSigningKey reads a PEM encoded private key: PKCS #8, or an EC or
+// RSA key in the older formats
+func ParseSigningKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("'%s' is not a private key", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("can't sign with a %T key", key)
+	}
+	return signer, nil
+}
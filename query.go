@@ -0,0 +1,353 @@
+package smmake
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query evaluates a query over the dependency graph of every target and
+// returns the nodes it selects, sorted, or the path in order for somepath.
+// A query is made of target and file names, glob patterns such as
+// 'src/*.go' and the functions
+//
+//	deps(x[, depth])     x and what it depends on
+//	rdeps(x[, depth])    x and what depends on it
+//	somepath(x, y)       a shortest path from a node of x to one of y
+//	allpaths(x, y)       every node on a path from x to y
+//	kind(k, x)           the nodes of x that are a target, phony or file
+//	filter(regexp, x)    the nodes of x whose name matches
+//
+// combined with x + y (union), x - y (except) and x ^ y (intersect), left
+// to right; parentheses group them, and names with spaces or operators are
+// double quoted. The targets to run for a set of changed files are
+// rdeps(a.go + b.go).
+func (m *Makefile) Query(expr string) ([]string, error) {
+	tokens, err := lexQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	g, err := m.Graph()
+	if err != nil {
+		return nil, err
+	}
+	q := &queryParser{m: m, g: g, tokens: tokens}
+	result, err := q.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := q.peek(); t != nil {
+		return nil, fmt.Errorf("query: unexpected '%s' at column %d", t.text, t.col)
+	}
+	return result, nil
+}
+
+// queryToken is a word, quoted name or punctuation of a query
+type queryToken struct {
+	text   string
+	quoted bool
+	col    int
+}
+
+// lexQuery splits a query into tokens. Words run up to white space or
+// punctuation, so 'foo-bar' is a name and only a lone '-' is an operator.
+func lexQuery(expr string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("(),+^", c) >= 0:
+			tokens = append(tokens, queryToken{text: string(c), col: i + 1})
+			i++
+		case c == '"':
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("query: unterminated '\"' at column %d", i+1)
+			}
+			tokens = append(tokens, queryToken{text: expr[i+1 : i+1+end], quoted: true, col: i + 1})
+			i += end + 2
+		default:
+			start := i
+			for i < len(expr) && strings.IndexByte(" \t\n(),+^\"", expr[i]) < 0 {
+				i++
+			}
+			tokens = append(tokens, queryToken{text: expr[start:i], col: start + 1})
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser evaluates a query while parsing it
+type queryParser struct {
+	m      *Makefile
+	g      *Graph
+	tokens []queryToken
+	pos    int
+}
+
+func (q *queryParser) peek() *queryToken {
+	if q.pos < len(q.tokens) {
+		return &q.tokens[q.pos]
+	}
+	return nil
+}
+
+// expect consumes the punctuation text or fails
+func (q *queryParser) expect(text string) error {
+	t := q.peek()
+	if t == nil {
+		return fmt.Errorf("query: expected '%s' at the end", text)
+	}
+	if t.quoted || t.text != text {
+		return fmt.Errorf("query: expected '%s' at column %d, found '%s'", text, t.col, t.text)
+	}
+	q.pos++
+	return nil
+}
+
+// queryFunctions are the functions of a query and how many arguments they
+// take
+var queryFunctions = map[string]int{"deps": 1, "rdeps": 1, "somepath": 2, "allpaths": 2, "kind": 2, "filter": 2}
+
+// queryOperators maps the set operators and their names to the operation
+var queryOperators = map[string]string{"+": "+", "union": "+", "-": "-", "except": "-", "^": "^", "intersect": "^"}
+
+func (q *queryParser) expr() ([]string, error) {
+	left, err := q.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := q.peek()
+		if t == nil || t.quoted || queryOperators[t.text] == "" {
+			return left, nil
+		}
+		op := queryOperators[t.text]
+		q.pos++
+		right, err := q.term()
+		if err != nil {
+			return nil, err
+		}
+		a, b := querySet(left), querySet(right)
+		result := make(map[string]bool)
+		switch op {
+		case "+":
+			for name := range a {
+				result[name] = true
+			}
+			for name := range b {
+				result[name] = true
+			}
+		case "-":
+			for name := range a {
+				if !b[name] {
+					result[name] = true
+				}
+			}
+		case "^":
+			for name := range a {
+				if b[name] {
+					result[name] = true
+				}
+			}
+		}
+		left = sortedKeys(result)
+	}
+}
+
+func (q *queryParser) term() ([]string, error) {
+	t := q.peek()
+	if t == nil {
+		return nil, fmt.Errorf("query: expected a name at the end")
+	}
+	if !t.quoted && t.text == "(" {
+		q.pos++
+		result, err := q.expr()
+		if err != nil {
+			return nil, err
+		}
+		return result, q.expect(")")
+	}
+	if !t.quoted && (strings.IndexByte("),+^", t.text[0]) >= 0 || t.text == "-") {
+		return nil, fmt.Errorf("query: expected a name at column %d, found '%s'", t.col, t.text)
+	}
+	q.pos++
+	if next := q.peek(); !t.quoted && next != nil && !next.quoted && next.text == "(" {
+		q.pos++
+		return q.call(t)
+	}
+	return q.name(t)
+}
+
+// name returns the node a word names, or the nodes a glob pattern matches
+func (q *queryParser) name(t *queryToken) ([]string, error) {
+	if _, ok := q.g.Nodes[t.text]; ok {
+		return []string{t.text}, nil
+	}
+	if !t.quoted && strings.ContainsAny(t.text, "*?[") {
+		if _, err := path.Match(t.text, ""); err != nil {
+			return nil, fmt.Errorf("query: bad pattern '%s' at column %d", t.text, t.col)
+		}
+		var matches []string
+		for _, name := range sortedKeys(q.g.Nodes) {
+			if matchGlob(t.text, name) {
+				matches = append(matches, name)
+			}
+		}
+		return matches, nil
+	}
+	return nil, q.m.unknownTargetError(t.text)
+}
+
+// call evaluates the arguments of the function fn and applies it
+func (q *queryParser) call(fn *queryToken) ([]string, error) {
+	want, ok := queryFunctions[fn.text]
+	if !ok {
+		return nil, fmt.Errorf("query: unknown function '%s' at column %d, known are %s", fn.text, fn.col, strings.Join(sortedKeys(queryFunctions), ", "))
+	}
+	// kind and filter take a word before the set
+	word := ""
+	if fn.text == "kind" || fn.text == "filter" {
+		t := q.peek()
+		if t == nil {
+			return nil, fmt.Errorf("query: %s takes %d arguments", fn.text, want)
+		}
+		word = t.text
+		q.pos++
+		if err := q.expect(","); err != nil {
+			return nil, err
+		}
+	}
+	var args [][]string
+	if word != "" {
+		args = append(args, nil)
+	}
+	depth := -1
+	for {
+		if t := q.peek(); len(args) == 1 && (fn.text == "deps" || fn.text == "rdeps") && t != nil && !t.quoted {
+			if n, err := strconv.Atoi(t.text); err == nil {
+				depth = n
+				q.pos++
+				break
+			}
+		}
+		arg, err := q.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if t := q.peek(); t == nil || t.quoted || t.text != "," {
+			break
+		}
+		q.pos++
+	}
+	if err := q.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf("query: %s at column %d takes %d arguments, got %d", fn.text, fn.col, want, len(args))
+	}
+
+	deps := func(name string) []string { return uniqueDeps(q.g.Nodes[name].Deps) }
+	var result []string
+	switch fn.text {
+	case "deps":
+		result = q.reach(args[0], depth, deps)
+	case "rdeps":
+		result = q.reach(args[0], depth, q.g.Dependents)
+	case "somepath":
+		result = q.somepath(args[0], args[1])
+	case "allpaths":
+		from := querySet(q.reach(args[0], -1, deps))
+		for _, name := range q.reach(args[1], -1, q.g.Dependents) {
+			if from[name] {
+				result = append(result, name)
+			}
+		}
+	case "kind":
+		if word != "file" && word != "phony" && word != "target" {
+			return nil, fmt.Errorf("query: unknown kind '%s', known are file, phony, target", word)
+		}
+		for _, name := range args[1] {
+			node := q.g.Nodes[name]
+			if word == "file" && node.File || word == "phony" && node.Phony || word == "target" && !node.File {
+				result = append(result, name)
+			}
+		}
+	case "filter":
+		re, err := regexp.Compile(word)
+		if err != nil {
+			return nil, fmt.Errorf("query: filter: %w", err)
+		}
+		for _, name := range args[1] {
+			if re.MatchString(name) {
+				result = append(result, name)
+			}
+		}
+	}
+	return result, nil
+}
+
+// reach returns start and the nodes next leads to from it, at most depth
+// steps away unless depth is negative, sorted
+func (q *queryParser) reach(start []string, depth int, next func(string) []string) []string {
+	seen := querySet(start)
+	frontier := start
+	for step := 0; len(frontier) > 0 && step != depth; step++ {
+		var following []string
+		for _, name := range frontier {
+			for _, n := range next(name) {
+				if !seen[n] {
+					seen[n] = true
+					following = append(following, n)
+				}
+			}
+		}
+		frontier = following
+	}
+	return sortedKeys(seen)
+}
+
+// somepath returns a shortest path of prerequisites from a node of from to
+// a node of to, or nothing if there is none
+func (q *queryParser) somepath(from, to []string) []string {
+	targets := querySet(to)
+	parent := make(map[string]string)
+	queue := append([]string(nil), from...)
+	sort.Strings(queue)
+	for _, name := range queue {
+		parent[name] = ""
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if targets[name] {
+			path := []string{name}
+			for p := parent[name]; p != ""; p = parent[p] {
+				path = append([]string{p}, path...)
+			}
+			return path
+		}
+		for _, dep := range uniqueDeps(q.g.Nodes[name].Deps) {
+			if _, ok := parent[dep]; !ok {
+				parent[dep] = name
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// querySet returns the names of a query result as a set
+func querySet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
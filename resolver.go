@@ -0,0 +1,26 @@
+package smmake
+
+// Resolver provides variables a Makefile doesn't define, for example from a
+// configuration service or computed on first use. It is consulted after the
+// Makefile's own variables and the environment. Resolve reports false for
+// variables it doesn't know, which are then left unexpanded.
+type Resolver interface {
+	Resolve(name string) (string, bool)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(name string) (string, bool)
+
+// Resolve calls f(name)
+func (f ResolverFunc) Resolve(name string) (string, bool) {
+	return f(name)
+}
+
+// MapResolver resolves variables from a map
+type MapResolver map[string]string
+
+// Resolve looks name up in the map
+func (r MapResolver) Resolve(name string) (string, bool) {
+	value, ok := r[name]
+	return value, ok
+}
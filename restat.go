@@ -0,0 +1,74 @@
+package smmake
+
+// isRestat reports whether .RESTAT lists a target, whose file is hashed
+// before and after its recipe runs so the targets depending on it are
+// pruned if it didn't change
+func (m *Makefile) isRestat(targetName string) bool {
+	return m.lists(".RESTAT", targetName)
+}
+
+// restatBefore returns the hash of the file of a .RESTAT target before its
+// recipe runs, "" if it isn't one or there is no file yet
+func (s *Session) restatBefore(targetName string) string {
+	m := s.m
+	if m.DryRun || !m.isRestat(targetName) {
+		return ""
+	}
+	sum, err := m.hashFile(targetName)
+	if err != nil {
+		return ""
+	}
+	return sum
+}
+
+// restatAfter hashes the file of a .RESTAT target again once its recipe
+// ran, and records it as unchanged if the recipe wrote what was there
+func (s *Session) restatAfter(targetName, before string) {
+	m := s.m
+	if before == "" {
+		return
+	}
+	s.stats.forget(targetName)
+	if after, err := m.hashFile(targetName); err != nil || after != before {
+		return
+	}
+	m.logf(LogVerbose, targetName, "'%s' didn't change, the targets depending on it may be pruned", targetName)
+	s.mutex.Lock()
+	s.unchanged[targetName] = true
+	s.mutex.Unlock()
+}
+
+// pruned reports whether a target wouldn't run but for prerequisites that
+// didn't change: at least one of them is a .RESTAT target whose recipe
+// left its file as it was, or a target pruned itself, and the others are
+// files it is up to date with. The target is then unchanged as well.
+func (s *Session) pruned(target *Target, targetName string, deps []string) bool {
+	m := s.m
+	if m.isPhony(targetName) {
+		return false
+	}
+	s.mutex.Lock()
+	var files []string
+	for _, dep := range deps {
+		if !s.unchanged[dep] {
+			files = append(files, dep)
+		}
+	}
+	s.mutex.Unlock()
+	if len(files) == len(deps) {
+		return false
+	}
+	for _, dep := range files {
+		if m.Targets[dep] != nil || m.findMatchingPatternRule(dep) != nil {
+			// it ran, or had nothing to run for a reason of its own
+			return false
+		}
+	}
+	if !s.stats.exists(m, targetName) || !m.fileUpToDate(targetName, files, s.stats) {
+		return false
+	}
+	s.mutex.Lock()
+	s.unchanged[targetName] = true
+	s.mutex.Unlock()
+	return true
+}
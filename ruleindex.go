@@ -0,0 +1,125 @@
+package smmake
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indexedSpecials are the special targets whose prerequisites the rule
+// index holds
+var indexedSpecials = [...]string{".PHONY", ".SILENT", ".WASM", ".RESTAT"}
+
+// ruleIndex finds the rules of a Makefile without going through all of
+// them, which adds up for tens of thousands of targets: the pattern rules
+// by the text after their '%', and the names listed in .PHONY, .SILENT,
+// .WASM and .RESTAT. It is built on first use, and again once targets are
+// added or replaced, prerequisites are added to the special targets, a
+// variable changes or IgnoreCase does. Targets written to Makefile.Targets
+// directly, rather than by the parser or the builder, are only noticed
+// when their number changes.
+type ruleIndex struct {
+	key indexKey
+	// patterns are the pattern rules by PatternTo, in lower case if
+	// IgnoreCase is set
+	patterns map[string][]*Target
+	// suffixLengths are the lengths of the PatternTo of the pattern rules
+	suffixLengths []int
+	// special are the names listed in each of indexedSpecials, in lower
+	// case if IgnoreCase is set
+	special [len(indexedSpecials)]map[string]bool
+}
+
+// indexKey tells whether an index is still the one of a Makefile
+type indexKey struct {
+	writes     uint64
+	targets    int
+	special    [len(indexedSpecials)]int
+	fold       bool
+	generation uint64
+}
+
+// indexMutex guards Makefile.index
+var indexMutex sync.Mutex
+
+func (m *Makefile) ruleIndex() *ruleIndex {
+	key := indexKey{writes: m.targetWrites, targets: len(m.Targets), fold: m.IgnoreCase, generation: m.expansionCache().current()}
+	for i, name := range indexedSpecials {
+		if t := m.Targets[name]; t != nil {
+			key.special[i] = len(t.Dependencies) + 1
+		}
+	}
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	if m.index == nil || m.index.key != key {
+		m.index = m.newRuleIndex(key)
+	}
+	return m.index
+}
+
+// setTarget adds or replaces the target of a name, for the rule index to
+// see
+func (m *Makefile) setTarget(name string, t *Target) {
+	m.Targets[name] = t
+	m.targetWrites++
+}
+
+func (m *Makefile) newRuleIndex(key indexKey) *ruleIndex {
+	idx := &ruleIndex{key: key, patterns: make(map[string][]*Target)}
+	lengths := make(map[int]bool)
+	for _, t := range m.Targets {
+		if !t.Pattern {
+			continue
+		}
+		suffix := m.indexName(t.PatternTo)
+		idx.patterns[suffix] = append(idx.patterns[suffix], t)
+		lengths[len(t.PatternTo)] = true
+	}
+	for n := range lengths {
+		idx.suffixLengths = append(idx.suffixLengths, n)
+	}
+	sort.Ints(idx.suffixLengths)
+	for i, name := range indexedSpecials {
+		idx.special[i] = make(map[string]bool)
+		if t := m.Targets[name]; t != nil {
+			for _, dep := range t.Dependencies {
+				idx.special[i][m.indexName(m.expandVariables(dep))] = true
+			}
+		}
+	}
+	return idx
+}
+
+// indexName is how the index spells a name, in lower case if IgnoreCase
+// is set
+func (m *Makefile) indexName(name string) string {
+	if m.IgnoreCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// lists reports whether the special target lists a name
+func (m *Makefile) lists(special, name string) bool {
+	idx := m.ruleIndex()
+	for i, s := range indexedSpecials {
+		if s == special {
+			return idx.special[i][m.indexName(name)]
+		}
+	}
+	return false
+}
+
+// patternRules returns the pattern rules whose text after the '%' ends
+// the name, which are the only ones that can match it
+func (m *Makefile) patternRules(name string) []*Target {
+	idx := m.ruleIndex()
+	var rules []*Target
+	for _, n := range idx.suffixLengths {
+		if n > len(name) {
+			break
+		}
+		rules = append(rules, idx.patterns[m.indexName(name[len(name)-n:])]...)
+	}
+	return rules
+}
@@ -0,0 +1,28 @@
+package smmake
+
+import "context"
+
+// DefaultMakefile is the Makefile Run reads, and the smmake command without
+// -f
+const DefaultMakefile = "Makefile"
+
+// Run parses the build file in the current directory, see FindBuildFile,
+// and builds the targets, "all" if none are given. Nothing is logged, only
+// the recipes write to stdout and stderr. It is meant for small programs
+// driving a build from the Go toolchain, e.g. a tools/gen/main.go calling
+// Run("generate") for a
+//
+//	//go:generate go run ./tools/gen
+//
+// line. The error is one of the typed errors, so a *RecipeError has the exit
+// code to exit with.
+func Run(targets ...string) error {
+	m, err := ParseMakefile(FindBuildFile(""))
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		targets = []string{"all"}
+	}
+	return m.Build(context.Background(), targets)
+}
@@ -0,0 +1,91 @@
+package smmake
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Runner runs the recipe lines of targets. Set Makefile.Runner to record
+// commands instead of running them, run them in a container or on another
+// machine, or mock them in tests.
+type Runner interface {
+	Run(ctx context.Context, cmd Command, env Env) (Result, error)
+}
+
+// Env is what a recipe line runs with
+type Env struct {
+	Target string
+	// Prerequisites are the prerequisites of the target, its inputs
+	Prerequisites []string
+	// Shell is the shell recipe lines are run through, empty to execute
+	// them directly
+	Shell string
+	// Dir is the directory to run in, empty for the current one
+	Dir string
+	// Environ holds KEY=VALUE pairs, nil to inherit the environment of
+	// smmake
+	Environ []string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Result is the outcome of a recipe line
+type Result struct {
+	ExitCode int
+	Duration time.Duration
+}
+
+// ExecRunner runs recipe lines as local processes. It is the Runner used
+// when Makefile.Runner is nil.
+type ExecRunner struct{}
+
+// Run executes cmd, through env.Shell if set and otherwise split at white
+// space into a program and its arguments. A non-zero exit status is
+// returned as an error along with the exit code.
+func (ExecRunner) Run(ctx context.Context, cmd Command, env Env) (Result, error) {
+	var command *exec.Cmd
+	if env.Shell != "" {
+		command = exec.CommandContext(ctx, env.Shell, shellFlag(env.Shell), cmd.Cmd)
+	} else {
+		parts := strings.Fields(cmd.Cmd)
+		if len(parts) == 0 {
+			return Result{}, nil
+		}
+		command = exec.CommandContext(ctx, parts[0], parts[1:]...)
+	}
+	command.Dir = env.Dir
+	command.Env = env.Environ
+	command.Stdout = env.Stdout
+	command.Stderr = env.Stderr
+
+	start := time.Now()
+	err := command.Run()
+	result := Result{Duration: time.Since(start)}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, err
+}
+
+// shellFlag returns the option that makes a shell run a single command
+func shellFlag(shell string) string {
+	switch shellName(shell) {
+	case "cmd":
+		return "/C"
+	case "powershell", "pwsh":
+		return "-Command"
+	}
+	return "-c"
+}
+
+// shellName returns the name of a shell program in lower case, without
+// its directory and extension, e.g. "cmd" for C:\Windows\System32\cmd.exe
+func shellName(shell string) string {
+	return strings.ToLower(strings.TrimSuffix(filepath.Base(shell), filepath.Ext(shell)))
+}
@@ -0,0 +1,222 @@
+package smmake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// A recipe line that reads "script:" starts a script: the rest of the recipe
+// is Starlark, a dialect of Python, kept as it is written instead of being
+// expanded. Besides the Starlark built-ins scripts can call
+//
+//	var(name, default=None)   the value of a variable
+//	env(name, default=None)   an environment variable of the recipe
+//	read_file(path)           the content of a file
+//	write_file(path, data)    write a file
+//	exists(path)              whether a file exists
+//	glob(pattern)             the files matching a pattern, like $(wildcard)
+//	run(command)              run a recipe line
+//	build(target, ...)        build other targets
+//
+// and read target and prerequisites. Paths are relative to Makefile.Dir.
+const scriptLine = "script:"
+
+// scriptOptions allow if and for statements outside of functions, which
+// Starlark files usually don't
+var scriptOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// summary returns how a recipe line is shown in logs and errors
+func (c Command) summary() string {
+	if !c.Script {
+		return c.Cmd
+	}
+	if n := strings.Count(c.Cmd, "\n") + 1; n > 1 {
+		return fmt.Sprintf("script (%d lines)", n)
+	}
+	return "script (1 line)"
+}
+
+// lines returns the recipe lines a command is written as
+func (c Command) lines() []string {
+	switch {
+	case c.Script:
+		return append([]string{scriptLine}, strings.Split(c.Cmd, "\n")...)
+	case c.Silent:
+		return []string{"@" + c.Cmd}
+	}
+	return []string{c.Cmd}
+}
+
+// scriptRunner runs the Starlark script of a target
+type scriptRunner struct {
+	s      *Session
+	target *Target
+}
+
+func (r scriptRunner) Run(ctx context.Context, cmd Command, env Env) (Result, error) {
+	start := time.Now()
+	thread := &starlark.Thread{
+		Name:  env.Target,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Fprintln(env.Stdout, msg) },
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	name := fmt.Sprintf("script of '%s'", env.Target)
+	_, err := starlark.ExecFileOptions(scriptOptions, thread, name, dedent(cmd.Cmd), r.predeclared(ctx, env))
+	return Result{Duration: time.Since(start)}, err
+}
+
+// predeclared returns the names scripts can use besides the built-ins
+func (r scriptRunner) predeclared(ctx context.Context, env Env) starlark.StringDict {
+	m := r.s.m
+	prereqs := make([]starlark.Value, len(env.Prerequisites))
+	for i, p := range env.Prerequisites {
+		prereqs[i] = starlark.String(p)
+	}
+	return starlark.StringDict{
+		"target":        starlark.String(env.Target),
+		"prerequisites": starlark.NewList(prereqs),
+		"var": starlark.NewBuiltin("var", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			var def starlark.Value = starlark.None
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "default?", &def); err != nil {
+				return nil, err
+			}
+			value, err := m.Expand("$(" + name + ")")
+			if err != nil {
+				return nil, err
+			}
+			if value == "" {
+				return def, nil
+			}
+			return starlark.String(value), nil
+		}),
+		"env": starlark.NewBuiltin("env", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			var def starlark.Value = starlark.None
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "default?", &def); err != nil {
+				return nil, err
+			}
+			environ := env.Environ
+			if environ == nil {
+				environ = os.Environ()
+			}
+			for _, kv := range environ {
+				if key, value, ok := strings.Cut(kv, "="); ok && key == name {
+					return starlark.String(value), nil
+				}
+			}
+			return def, nil
+		}),
+		"read_file": starlark.NewBuiltin("read_file", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+				return nil, err
+			}
+			data, err := os.ReadFile(longPath(m.path(path)))
+			if err != nil {
+				return nil, err
+			}
+			return starlark.String(data), nil
+		}),
+		"write_file": starlark.NewBuiltin("write_file", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path, data string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path, "data", &data); err != nil {
+				return nil, err
+			}
+			return starlark.None, os.WriteFile(longPath(m.path(path)), []byte(data), 0o644)
+		}),
+		"exists": starlark.NewBuiltin("exists", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+				return nil, err
+			}
+			_, err := m.stat(path)
+			return starlark.Bool(err == nil), nil
+		}),
+		"glob": starlark.NewBuiltin("glob", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var pattern string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern); err != nil {
+				return nil, err
+			}
+			var matches []starlark.Value
+			for _, match := range m.glob(pattern) {
+				matches = append(matches, starlark.String(match))
+			}
+			return starlark.NewList(matches), nil
+		}),
+		"run": starlark.NewBuiltin("run", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var command string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "command", &command); err != nil {
+				return nil, err
+			}
+			return starlark.None, r.s.runCommand(ctx, r.target, env.Target, Command{Cmd: command}, nil)
+		}),
+		"build": starlark.NewBuiltin("build", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(kwargs) > 0 {
+				return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+			}
+			var errs []error
+			for _, arg := range args {
+				name, ok := starlark.AsString(arg)
+				if !ok {
+					return nil, fmt.Errorf("%s: got %s, want string", b.Name(), arg.Type())
+				}
+				// The script's job slot is given back while it waits, so the
+				// targets it builds can run even with -j 1
+				r.s.releaseJob()
+				err := r.s.executeTarget(ctx, name, env.Target)
+				r.s.acquireJob()
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return starlark.None, errors.Join(errs...)
+		}),
+	}
+}
+
+// dedent removes the indentation all non-blank lines of a script share, so
+// its top level statements can be indented below the script: line
+func dedent(src string) string {
+	lines := strings.Split(src, "\n")
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, prefix)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,272 @@
+package smmake
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SecretBackend looks up the secrets of $(secret scheme:ref) references,
+// for the part of the reference after the scheme. A ref may end in
+// #field to select a field of a structured secret.
+type SecretBackend interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretBackendFunc adapts a function to the SecretBackend interface
+type SecretBackendFunc func(ctx context.Context, ref string) (string, error)
+
+// Secret calls f(ctx, ref)
+func (f SecretBackendFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	secretBackendsMutex sync.RWMutex
+	// secretBackends are the backends by scheme. vault, aws and keychain
+	// run the command line tools of their service, which bring their own
+	// authentication.
+	secretBackends = map[string]SecretBackend{
+		"env":      SecretBackendFunc(envSecret),
+		"vault":    SecretBackendFunc(vaultSecret),
+		"aws":      SecretBackendFunc(awsSecret),
+		"keychain": SecretBackendFunc(keychainSecret),
+	}
+)
+
+// RegisterSecretBackend makes backend resolve the references of scheme,
+// replacing any backend of the same scheme
+func RegisterSecretBackend(scheme string, backend SecretBackend) {
+	secretBackendsMutex.Lock()
+	defer secretBackendsMutex.Unlock()
+	secretBackends[scheme] = backend
+}
+
+func lookupSecretBackend(scheme string) (SecretBackend, bool) {
+	secretBackendsMutex.RLock()
+	defer secretBackendsMutex.RUnlock()
+	backend, ok := secretBackends[scheme]
+	return backend, ok
+}
+
+// secretMask replaces secrets in everything smmake prints
+const secretMask = "***"
+
+// secretPlaceholderPattern matches what $(secret) references expand to.
+// The secret is only looked up when a recipe line that holds the
+// placeholder runs, and is put in its place there and nowhere else.
+var secretPlaceholderPattern = regexp.MustCompile(`\*\*\*secret-(\d+)\*\*\*`)
+
+// secretStore holds the $(secret) references of a Makefile and the values
+// looked up for them
+type secretStore struct {
+	mutex  sync.Mutex
+	refs   []string
+	values map[int]string
+}
+
+// secretsMutex guards the creation of Makefile.secrets
+var secretsMutex sync.Mutex
+
+func (m *Makefile) secretStore() *secretStore {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	if m.secrets == nil {
+		m.secrets = &secretStore{values: make(map[int]string)}
+	}
+	return m.secrets
+}
+
+// secretPlaceholder returns the placeholder of a reference such as
+// vault:kv/ci/db#password
+func (m *Makefile) secretPlaceholder(ref string) (string, error) {
+	scheme, _, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", fmt.Errorf("secret '%s' has no backend, write it as scheme:ref", ref)
+	}
+	if _, ok := lookupSecretBackend(scheme); !ok {
+		return "", fmt.Errorf("unknown secret backend '%s'", scheme)
+	}
+	store := m.secretStore()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	index := len(store.refs)
+	for i, r := range store.refs {
+		if r == ref {
+			index = i
+		}
+	}
+	if index == len(store.refs) {
+		store.refs = append(store.refs, ref)
+	}
+	return fmt.Sprintf("***secret-%d***", index), nil
+}
+
+// revealSecrets puts the secrets in place of the placeholders of s,
+// looking them up the first time they are needed
+func (m *Makefile) revealSecrets(ctx context.Context, s string) (string, error) {
+	if m.secrets == nil || !strings.Contains(s, "***secret-") {
+		return s, nil
+	}
+	var err error
+	revealed := secretPlaceholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		index, _ := strconv.Atoi(secretPlaceholderPattern.FindStringSubmatch(placeholder)[1])
+		value, lookupErr := m.secrets.lookup(ctx, index)
+		if lookupErr != nil && err == nil {
+			err = lookupErr
+		}
+		return value
+	})
+	return revealed, err
+}
+
+func (st *secretStore) lookup(ctx context.Context, index int) (string, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if value, ok := st.values[index]; ok {
+		return value, nil
+	}
+	if index >= len(st.refs) {
+		return "", fmt.Errorf("unknown secret placeholder %d", index)
+	}
+	ref := st.refs[index]
+	scheme, rest, _ := strings.Cut(ref, ":")
+	backend, ok := lookupSecretBackend(scheme)
+	if !ok {
+		return "", fmt.Errorf("unknown secret backend '%s'", scheme)
+	}
+	value, err := backend.Secret(ctx, rest)
+	if err != nil {
+		return "", fmt.Errorf("error looking up secret '%s': %w", ref, err)
+	}
+	st.values[index] = value
+	return value, nil
+}
+
+// maskSecrets replaces the placeholders and the secrets looked up so far in
+// s by secretMask
+func (m *Makefile) maskSecrets(s string) string {
+	if m.secrets == nil {
+		return s
+	}
+	s = secretPlaceholderPattern.ReplaceAllString(s, secretMask)
+	m.secrets.mutex.Lock()
+	defer m.secrets.mutex.Unlock()
+	for _, value := range m.secrets.values {
+		if value != "" {
+			s = strings.ReplaceAll(s, value, secretMask)
+		}
+	}
+	return s
+}
+
+// maskingWriter masks the secrets in recipe output. A secret split across
+// two writes is not caught.
+type maskingWriter struct {
+	m *Makefile
+	w io.Writer
+}
+
+func (w maskingWriter) Write(data []byte) (int, error) {
+	masked := w.m.maskSecrets(string(data))
+	if _, err := io.WriteString(w.w, masked); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// splitField splits the #field off a secret reference
+func splitField(ref string) (string, string) {
+	name, field, _ := strings.Cut(ref, "#")
+	return name, field
+}
+
+// secretCommand runs a command line tool of a secret backend and returns
+// its output without the final newline
+func secretCommand(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// envSecret reads env:NAME from the environment, e.g. of a CI job
+func envSecret(_ context.Context, ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("%s is not set", ref)
+	}
+	return value, nil
+}
+
+// vaultSecret reads vault:PATH#field with the vault command, from the KV
+// secrets engine of either version. The field defaults to value.
+func vaultSecret(ctx context.Context, ref string) (string, error) {
+	path, field := splitField(ref)
+	if field == "" {
+		field = "value"
+	}
+	return secretCommand(ctx, "vault", "kv", "get", "-field="+field, path)
+}
+
+// awsSecret reads aws:ID#field from AWS Secrets Manager with the aws
+// command. Without a field the whole secret string is the value, with one
+// the secret string is a JSON object.
+func awsSecret(ctx context.Context, ref string) (string, error) {
+	id, field := splitField(ref)
+	text, err := secretCommand(ctx, "aws", "secretsmanager", "get-secret-value",
+		"--secret-id", id, "--query", "SecretString", "--output", "text")
+	if err != nil || field == "" {
+		return text, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return "", fmt.Errorf("secret '%s' is not a JSON object: %v", id, err)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret '%s' has no field '%s'", id, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// keychainSecret reads keychain:SERVICE#account from the keychain of macOS
+// or the Secret Service of Linux desktops
+func keychainSecret(ctx context.Context, ref string) (string, error) {
+	service, account := splitField(ref)
+	switch runtime.GOOS {
+	case "darwin":
+		args := []string{"find-generic-password", "-s", service, "-w"}
+		if account != "" {
+			args = append(args, "-a", account)
+		}
+		return secretCommand(ctx, "security", args...)
+	case "windows":
+		return "", fmt.Errorf("the keychain backend is not available on Windows, register a SecretBackend")
+	}
+	args := []string{"lookup", "service", service}
+	if account != "" {
+		args = append(args, "account", account)
+	}
+	return secretCommand(ctx, "secret-tool", args...)
+}
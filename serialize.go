@@ -0,0 +1,94 @@
+package smmake
+
+import (
+	"io"
+	"sort"
+
+	"smmake/ast"
+)
+
+// AST returns a syntax tree for the Makefile model: its variables, then its
+// rules in the order they were defined, with their "## text" descriptions
+// and "##@ Section" headings. Targets defined by the same rule are written
+// as one rule again.
+//
+// The model doesn't keep comments, and recipe lines are stored expanded, so
+// for round-tripping a Makefile without losing either, parse it with
+// ast.Parse, change the tree and print that instead.
+func (m *Makefile) AST() *ast.File {
+	file := &ast.File{Name: m.Filename}
+
+	var vars []*Variable
+	for _, v := range m.Variables {
+		if v.Origin != OriginCommandLine {
+			vars = append(vars, v)
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		if vars[i].Line != vars[j].Line {
+			return vars[i].Line < vars[j].Line
+		}
+		return vars[i].Name < vars[j].Name
+	})
+	for _, v := range vars {
+		file.AddAssignment(v.Name, "=", v.Value)
+	}
+
+	// Group the targets by the line of the rule that defined them. Targets
+	// added in code have no line and follow in name order.
+	lines := make(map[int][]*Target)
+	var unplaced [][]*Target
+	for _, name := range sortedKeys(m.Targets) {
+		t := m.Targets[name]
+		if t.Line == 0 {
+			unplaced = append(unplaced, []*Target{t})
+			continue
+		}
+		lines[t.Line] = append(lines[t.Line], t)
+	}
+	order := make([]int, 0, len(lines))
+	for line := range lines {
+		order = append(order, line)
+	}
+	sort.Ints(order)
+	rules := make([][]*Target, 0, len(order)+len(unplaced))
+	for _, line := range order {
+		rules = append(rules, lines[line])
+	}
+	rules = append(rules, unplaced...)
+
+	section := ""
+	for _, targets := range rules {
+		first := targets[0]
+		if first.Description != "" && first.Section != section {
+			section = first.Section
+			if len(file.Nodes) > 0 {
+				file.Nodes = append(file.Nodes, &ast.BlankLine{})
+			}
+			file.Nodes = append(file.Nodes, &ast.Comment{Text: "##@ " + section})
+		}
+
+		var names []string
+		for _, t := range targets {
+			names = append(names, t.Name)
+		}
+		var recipe []string
+		for _, cmd := range first.Commands {
+			recipe = append(recipe, cmd.lines()...)
+		}
+		rule := file.AddRule(names, first.Dependencies, recipe...)
+		if first.Description != "" {
+			rule.Comment = &ast.Comment{Text: "## " + first.Description}
+		}
+	}
+	return file
+}
+
+// Serialize writes the Makefile model as Makefile text, see AST for what is
+// kept.
+//
+// Parameters:
+//   - w: The writer the Makefile is written to.
+func (m *Makefile) Serialize(w io.Writer) error {
+	return ast.Fprint(w, m.AST())
+}
@@ -0,0 +1,556 @@
+package smmake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"smmake/internal/color"
+)
+
+// Session is one build of a Makefile: the targets it built, is building and
+// failed. The Makefile only holds what was parsed and the settings, so it can
+// be reused for any number of sessions, e.g. by a watch loop or a daemon.
+// Goals built in the same session share their prerequisites, which run once.
+type Session struct {
+	m *Makefile
+
+	mutex    sync.Mutex
+	executed map[string]bool
+	// running holds a channel for the targets being built, closed once they
+	// are done
+	running map[string]chan struct{}
+	// failed holds the error of every target that failed
+	failed map[string]error
+	// edges are the dependencies the executor followed, used to tell a
+	// cycle from a prerequisite shared by several targets
+	edges map[string][]string
+	// names is the fold index of the targets if IgnoreCase is set
+	names map[string]string
+	// stats caches the file info the build reads, see statCache
+	stats *statCache
+	// unchanged are the targets whose files are as they were before the
+	// build, see Session.pruned
+	unchanged map[string]bool
+	stopped   atomic.Bool
+	jobSlots  chan struct{}
+	// id is the BuildID of the events of the session
+	id uint64
+}
+
+// lastSessionID numbers the sessions, see Session.ID
+var lastSessionID atomic.Uint64
+
+// NewSession starts a build of m with nothing built yet. The session runs
+// with the settings m has when it is created, except for Jobs, and sees the
+// hooks and subscribers of m. The settings should not change while the
+// session is building.
+func (m *Makefile) NewSession() *Session {
+	m.eventBus()
+	s := &Session{
+		m:         m,
+		executed:  make(map[string]bool),
+		running:   make(map[string]chan struct{}),
+		failed:    make(map[string]error),
+		edges:     make(map[string][]string),
+		names:     m.foldIndex(),
+		stats:     newStatCache(),
+		unchanged: make(map[string]bool),
+		id:        lastSessionID.Add(1),
+	}
+	if m.Jobs > 0 {
+		s.jobSlots = make(chan struct{}, m.Jobs)
+	}
+	return s
+}
+
+// ID returns the number of the session, unique in the process, which its
+// events carry as their BuildID
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// now returns the EventInfo for an event of the session happening now
+func (s *Session) now() EventInfo {
+	return EventInfo{Time: time.Now(), BuildID: s.id}
+}
+
+// Build builds the goals in order. Targets the session built before, in
+// this call or an earlier one, are not built again, and the ones that
+// failed fail again. Subscribers see a BuildStarted event first and a
+// BuildFinished event last.
+//
+// Unless KeepGoing is set, no new recipes are started once a target failed,
+// and the remaining goals are not built. A dependency cycle in the graph of
+// any goal fails the build before a recipe runs, or only that goal if
+// KeepGoing is set. A failed or cancelled build runs the recipe of
+// .ON_ERROR or .ON_INTERRUPT before it returns, see cleanup.
+func (s *Session) Build(ctx context.Context, goals []string) error {
+	m := s.m
+	s.stopped.Store(false)
+
+	start := time.Now()
+	if shell, source := m.shellFor(nil); shell == "" {
+		m.logf(LogVerbose, "", "Running recipe lines without a shell (%s)", source)
+	} else {
+		m.logf(LogVerbose, "", "Running recipe lines through %s (%s)", shell, source)
+	}
+	m.bus.publish(BuildStarted{EventInfo: s.now(), Goals: goals})
+	var errs []error
+	cyclic := make(map[string]error)
+	for _, goal := range goals {
+		goal = normalizeName(goal)
+		if err := m.checkCycles(goal); err != nil && cyclic[goal] == nil {
+			cyclic[goal] = err
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 && !m.KeepGoing {
+		goals = nil
+	}
+	for _, goal := range goals {
+		goal = normalizeName(goal)
+		if cyclic[goal] != nil {
+			continue
+		}
+		m.logf(LogVerbose, goal, "Attempting to execute target: %s", color.Target(goal))
+		if err := s.executeTarget(ctx, goal, ""); err != nil {
+			errs = append(errs, err)
+			if !m.KeepGoing {
+				break
+			}
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		s.cleanup(ctx)
+	}
+	m.bus.publish(BuildFinished{EventInfo: s.now(), Duration: time.Since(start), Err: err})
+	return err
+}
+
+// Built reports whether the session built the target successfully
+func (s *Session) Built(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.executed[canonicalName(s.names, name)]
+}
+
+// Err returns the error the target failed with in this session, nil if it
+// didn't fail or wasn't reached
+func (s *Session) Err(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.failed[canonicalName(s.names, name)]
+}
+
+func (s *Session) executeTarget(ctx context.Context, targetName, parent string) error {
+	m := s.m
+	targetName = canonicalName(s.names, targetName)
+	s.mutex.Lock()
+	if parent != "" {
+		s.addEdge(parent, targetName)
+	}
+	if done, ok := s.running[targetName]; ok {
+		// Either a prerequisite shared with a target that is being built,
+		// or a cycle back to a target waiting for this one
+		if parent != "" {
+			if path := s.dependencyPath(targetName, parent); path != nil {
+				s.mutex.Unlock()
+				return m.cycleError(append([]string{parent}, path...))
+			}
+		}
+		s.mutex.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.failed[targetName]
+	}
+	if s.executed[targetName] {
+		s.mutex.Unlock()
+		m.logf(LogVerbose, targetName, "Target '%s' already built", color.Target(targetName))
+		return nil
+	}
+	if err, ok := s.failed[targetName]; ok {
+		s.mutex.Unlock()
+		return err
+	}
+	s.running[targetName] = make(chan struct{})
+	s.mutex.Unlock()
+	m.bus.publish(TargetQueued{EventInfo: s.now(), Target: targetName})
+
+	target := m.Targets[targetName]
+	if target == nil && targetName == "help" {
+		// Makefiles without their own help target get a generated one
+		m.PrintTargetHelp(os.Stdout)
+		return s.finishTarget(TargetEvent{Name: targetName})
+	}
+	if target == nil {
+		m.logf(LogVerbose, targetName, "No rule for '%s', trying pattern rules", color.Target(targetName))
+		// Check for pattern rules
+		if patternTarget := m.findMatchingPatternRule(targetName); patternTarget != nil {
+			m.logf(LogVerbose, targetName, "Using pattern rule '%s' for '%s'", patternTarget.Name, color.Target(targetName))
+			target = patternTarget
+		} else {
+			// Check if it's a file
+			if s.stats.exists(m, targetName) {
+				m.logf(LogVerbose, targetName, "File '%s' exists, nothing to do", targetName)
+				m.bus.publish(TargetSkipped{EventInfo: s.now(), Target: targetName, Reason: "file exists and has no rule"})
+				return s.finishTarget(TargetEvent{Name: targetName})
+			}
+			return s.finishTarget(TargetEvent{Name: targetName, Err: m.unknownTargetError(targetName)})
+		}
+	}
+
+	if m.assumed(m.AssumeOld, targetName) {
+		m.logf(LogVerbose, targetName, "Target '%s' is assumed to be old, its recipe doesn't run", color.Target(targetName))
+		m.bus.publish(TargetSkipped{EventInfo: s.now(), Target: targetName, Reason: "assumed to be old"})
+		return s.finishTarget(TargetEvent{Name: targetName, Target: target})
+	}
+
+	deps := target.Dependencies
+	if target.Pattern {
+		deps = instantiatePattern(target, targetName).Dependencies
+	}
+	if len(deps) > 0 {
+		m.logf(LogVerbose, targetName, "Target '%s' depends on %v", color.Target(targetName), deps)
+	}
+
+	// A target of "::" rules makes each of them on its own: the
+	// prerequisites of the first one now, those of the others right before
+	// their recipes
+	rules := []*DoubleColonRule{{Dependencies: deps, Commands: target.Commands}}
+	if target.DoubleColon && len(target.Rules) > 0 {
+		rules = target.Rules
+	}
+	if err := s.buildPrerequisites(ctx, targetName, rules[0].Dependencies); err != nil {
+		return s.finishTarget(TargetEvent{Name: targetName, Target: target, Err: err})
+	}
+
+	if s.pruned(target, targetName, deps) {
+		m.logf(LogVerbose, targetName, "Target '%s' is pruned, its prerequisites didn't change", color.Target(targetName))
+		m.bus.publish(TargetSkipped{EventInfo: s.now(), Target: targetName, Reason: "its prerequisites didn't change"})
+		return s.finishTarget(TargetEvent{Name: targetName, Target: target})
+	}
+	if kind := m.fileConflict(target, targetName, s.stats); kind != "" {
+		m.warnConflict(target, targetName, kind)
+		if m.Conflicts == ConflictFile && m.fileUpToDate(targetName, deps, s.stats) {
+			m.logf(LogVerbose, targetName, "The %s '%s' is up to date, its recipe doesn't run", kind, targetName)
+			m.bus.publish(TargetSkipped{EventInfo: s.now(), Target: targetName, Reason: "the existing " + kind + " is up to date"})
+			return s.finishTarget(TargetEvent{Name: targetName, Target: target})
+		}
+	}
+
+	m.logf(LogVerbose, targetName, "Building target '%s'", color.Target(targetName))
+	s.acquireJob()
+	defer s.releaseJob()
+
+	event := TargetEvent{Name: targetName, Target: target, Prerequisites: deps}
+	if target.DoubleColon && len(target.Rules) > 0 {
+		event.Prerequisites = nil
+		for _, rule := range rules {
+			event.Prerequisites = append(event.Prerequisites, rule.Dependencies...)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		event.Err = err
+		return s.finishTarget(event)
+	}
+	if s.stopped.Load() {
+		event.Err = ErrStopped
+		return s.finishTarget(event)
+	}
+	for _, h := range m.hooks {
+		if h.OnTargetStart == nil {
+			continue
+		}
+		if err := h.OnTargetStart(event); errors.Is(err, ErrSkipTarget) {
+			m.logf(LogVerbose, targetName, "Target '%s' skipped by a hook", color.Target(targetName))
+			m.bus.publish(TargetSkipped{EventInfo: s.now(), Target: targetName, Reason: "skipped by a hook"})
+			return s.finishTarget(event)
+		} else if err != nil {
+			event.Err = err
+			return s.finishTarget(event)
+		}
+	}
+
+	// Execute commands for this target
+	event.Ran = true
+	before := s.restatBefore(targetName)
+	start := time.Now()
+	m.bus.publish(TargetStarted{EventInfo: s.now(), Target: targetName})
+	if shell, source := m.shellFor(target); source == shellFromTarget {
+		m.logf(LogVerbose, targetName, "Target '%s' runs its recipe through %s", color.Target(targetName), shell)
+	}
+	for i, rule := range rules {
+		if i > 0 {
+			// the job slot is free while other targets are made
+			s.releaseJob()
+			err := s.buildPrerequisites(ctx, targetName, rule.Dependencies)
+			s.acquireJob()
+			if err != nil {
+				event.Duration, event.Err = time.Since(start), err
+				return s.finishTarget(event)
+			}
+		}
+		auto := s.automatic(target, targetName, rule.Dependencies)
+		for _, cmd := range rule.Commands {
+			if err := s.runCommand(ctx, target, targetName, cmd, auto); err != nil {
+				event.Duration, event.Err = time.Since(start), err
+				return s.finishTarget(event)
+			}
+		}
+	}
+	event.Duration = time.Since(start)
+	s.restatAfter(targetName, before)
+	m.madeWith(targetName, event.Prerequisites)
+
+	return s.finishTarget(event)
+}
+
+// buildPrerequisites makes the prerequisites of a target in parallel. The
+// error is the failure that stopped the build rather than the ones of the
+// targets it kept from starting.
+func (s *Session) buildPrerequisites(ctx context.Context, targetName string, deps []string) error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(deps))
+	for _, dep := range deps {
+		wg.Add(1)
+		go func(dep string) {
+			defer wg.Done()
+			if err := s.executeTarget(ctx, dep, targetName); err != nil {
+				errChan <- &DependencyError{Target: targetName, Dependency: dep, Err: err}
+			}
+		}(dep)
+	}
+	wg.Wait()
+	close(errChan)
+
+	var depErr error
+	for err := range errChan {
+		if depErr == nil || errors.Is(depErr, ErrStopped) {
+			depErr = err
+		}
+	}
+	return depErr
+}
+
+// runCommand executes a single recipe line of a target, made with the rule
+// target. The automatic variables of auto are filled in, unless it is nil
+// for the commands scripts run, which no parser expanded.
+func (s *Session) runCommand(ctx context.Context, target *Target, targetName string, cmd Command, auto *automatic) error {
+	m := s.m
+	if strings.TrimSpace(cmd.Cmd) == "" {
+		return nil
+	}
+	if auto != nil && !cmd.Script {
+		quoted := *auto
+		quoted.quote = m.recipeQuote(target, targetName, cmd)
+		cmd.Cmd, _ = expandRecipe(cmd.Cmd, &quoted)
+	}
+
+	event := CommandEvent{Target: targetName, Command: cmd}
+	for _, h := range m.hooks {
+		if h.OnCommandStart != nil {
+			h.OnCommandStart(event)
+		}
+	}
+	m.bus.publish(CommandStarted{EventInfo: s.now(), Target: targetName, Command: cmd})
+	if !m.DryRun && !m.isSilent(targetName, cmd) {
+		m.logf(LogInfo, targetName, "%s %s", color.Command("Executing:"), cmd.summary())
+	}
+
+	prereqs := target.Dependencies
+	if target.Pattern {
+		prereqs = instantiatePattern(target, targetName).Dependencies
+	}
+	shell, _ := m.shellFor(target)
+	env := Env{Target: targetName, Prerequisites: prereqs, Shell: shell, Dir: m.targetDir(target), Environ: m.targetEnviron(target), Stdout: os.Stdout, Stderr: os.Stderr}
+	if m.Output != nil {
+		w := m.Output(targetName)
+		env.Stdout, env.Stderr = w, w
+	}
+	// $(secret) references are looked up now, for the command that runs
+	// only, and masked in its output
+	run := cmd
+	if m.secrets != nil && !m.DryRun {
+		var err error
+		if run.Cmd, err = m.revealSecrets(ctx, cmd.Cmd); err != nil {
+			return s.failCommand(event, targetName, cmd, err)
+		}
+		for i, kv := range env.Environ {
+			if env.Environ[i], err = m.revealSecrets(ctx, kv); err != nil {
+				return s.failCommand(event, targetName, cmd, err)
+			}
+		}
+		env.Stdout, env.Stderr = maskingWriter{m, env.Stdout}, maskingWriter{m, env.Stderr}
+	}
+	if m.bus.active() {
+		env.Stdout = outputPublisher{bus: m.bus, info: s.now, w: env.Stdout, target: targetName}
+		env.Stderr = outputPublisher{bus: m.bus, info: s.now, w: env.Stderr, target: targetName, stderr: true}
+	}
+	runner := m.Runner
+	switch {
+	case cmd.Script:
+		runner = scriptRunner{s: s, target: target}
+	case isBuiltin(cmd):
+		runner = builtinRunner{}
+	case m.isWasm(targetName):
+		runner = WasmRunner{}
+	case runner == nil:
+		runner = ExecRunner{}
+	}
+
+	var result Result
+	var err error
+	switch {
+	case m.DryRun:
+		// Show what would run instead, silent lines included, like make -n
+		if cmd.Script {
+			fmt.Fprintln(env.Stdout, scriptLine)
+		}
+		fmt.Fprintln(env.Stdout, m.maskSecrets(cmd.Cmd))
+	default:
+		result, err = runner.Run(ctx, run, env)
+		if err != nil {
+			exitCode := result.ExitCode
+			if exitCode == 0 {
+				exitCode = -1
+			}
+			err = &RecipeError{Target: targetName, Command: m.maskSecrets(cmd.summary()), ExitCode: exitCode, Err: err}
+			m.logf(LogVerbose, targetName, "Failed: %s (%s)", cmd.summary(), result.Duration.Round(time.Millisecond))
+		} else {
+			m.logf(LogVerbose, targetName, "Finished: %s (%s)", cmd.summary(), result.Duration.Round(time.Millisecond))
+		}
+	}
+	event.Result, event.Err = result, err
+	for _, h := range m.hooks {
+		if h.OnCommandFinish != nil {
+			h.OnCommandFinish(event)
+		}
+	}
+	m.bus.publish(CommandFinished{EventInfo: s.now(), Target: targetName, Command: cmd, Result: result, Err: err})
+	return err
+}
+
+// automatic returns the automatic variables of the recipe of a target,
+// made with the rule target from the prerequisites deps. $? names the
+// prerequisites make would rebuild the target for: all of them if it is
+// phony or its file doesn't exist.
+func (s *Session) automatic(target *Target, targetName string, deps []string) *automatic {
+	m := s.m
+	auto := &automatic{target: targetName, prereqs: deps}
+	if target.Pattern {
+		auto.stem, _ = patternStem(target, targetName, true)
+	}
+	info, _, err := s.stats.fileInfo(m, targetName)
+	for _, dep := range uniqueDeps(deps) {
+		if err != nil || m.isPhony(targetName) || m.isPhony(dep) {
+			auto.newer = append(auto.newer, dep)
+			continue
+		}
+		if depInfo, _, depErr := s.stats.fileInfo(m, dep); depErr != nil || depInfo.ModTime().After(info.ModTime()) {
+			auto.newer = append(auto.newer, dep)
+		}
+	}
+	return auto
+}
+
+// failCommand ends a command that failed before it could run
+func (s *Session) failCommand(event CommandEvent, targetName string, cmd Command, err error) error {
+	event.Err = err
+	for _, h := range s.m.hooks {
+		if h.OnCommandFinish != nil {
+			h.OnCommandFinish(event)
+		}
+	}
+	s.m.bus.publish(CommandFinished{EventInfo: s.now(), Target: targetName, Command: cmd, Err: err})
+	return err
+}
+
+// acquireJob waits for a free job slot when the number of jobs is limited.
+// It is only called once a target's dependencies are done, so waiting
+// targets never hold a slot.
+func (s *Session) acquireJob() {
+	if s.jobSlots != nil {
+		s.jobSlots <- struct{}{}
+	}
+}
+
+func (s *Session) releaseJob() {
+	if s.jobSlots != nil {
+		<-s.jobSlots
+	}
+}
+
+// addEdge records that target depends on dep. Callers must hold the mutex.
+func (s *Session) addEdge(target, dep string) {
+	for _, d := range s.edges[target] {
+		if d == dep {
+			return
+		}
+	}
+	s.edges[target] = append(s.edges[target], dep)
+}
+
+// dependencyPath returns the targets from one target to another along the
+// followed dependencies, both included, or nil if there is no such path.
+// Callers must hold the mutex.
+func (s *Session) dependencyPath(from, to string) []string {
+	seen := make(map[string]bool)
+	var walk func(name string) []string
+	walk = func(name string) []string {
+		if name == to {
+			return []string{name}
+		}
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		for _, dep := range s.edges[name] {
+			if path := walk(dep); path != nil {
+				return append([]string{name}, path...)
+			}
+		}
+		return nil
+	}
+	return walk(from)
+}
+
+// finishTarget reports the outcome of a target to the finish hooks, then
+// records it and lets the targets waiting for it continue. It returns the
+// target's error unchanged.
+func (s *Session) finishTarget(e TargetEvent) error {
+	m := s.m
+	if e.Err != nil && !m.KeepGoing {
+		s.stopped.Store(true)
+	}
+	for _, h := range m.hooks {
+		if h.OnTargetFinish != nil {
+			h.OnTargetFinish(e)
+		}
+	}
+	m.bus.publish(TargetFinished{EventInfo: s.now(), Target: e.Name, Duration: e.Duration, Err: e.Err})
+	// the recipe may have written the target, or removed it
+	s.stats.forget(e.Name)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if e.Err != nil {
+		s.failed[e.Name] = e.Err
+	} else {
+		s.executed[e.Name] = true
+	}
+	close(s.running[e.Name])
+	delete(s.running, e.Name)
+	return e.Err
+}
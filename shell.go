@@ -0,0 +1,87 @@
+package smmake
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// NoShell as Makefile.Shell or a target's Shell executes recipe lines
+// directly, split at white space into a program and its arguments
+const NoShell = "none"
+
+var detectedShell struct {
+	once  sync.Once
+	shell string
+}
+
+// DetectShell returns the shell recipe lines run through when neither the
+// command line, the target nor the Makefile choose one: sh, or bash without
+// it, on Unix, and Git Bash, PowerShell or cmd on Windows, whichever is
+// installed first. It returns "" if there is none.
+func DetectShell() string {
+	detectedShell.once.Do(func() {
+		detectedShell.shell = detectShell()
+	})
+	return detectedShell.shell
+}
+
+func detectShell() string {
+	if runtime.GOOS != "windows" {
+		for _, name := range []string{"sh", "bash"} {
+			if path, err := exec.LookPath(name); err == nil {
+				return path
+			}
+		}
+		return ""
+	}
+	// Git Bash, but not the bash.exe of WSL that is on the PATH as well
+	candidates := []string{filepath.Join(os.Getenv("ProgramFiles"), "Git", "bin", "bash.exe")}
+	if git, err := exec.LookPath("git"); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(filepath.Dir(git)), "bin", "bash.exe"))
+	}
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	for _, name := range []string{"pwsh", "powershell", "cmd"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Where the shell of a target came from, see shellFor
+const (
+	shellFromCommandLine = "command line"
+	shellFromTarget      = "target"
+	shellFromVariable    = "SHELL variable"
+	shellDetected        = "detected"
+)
+
+// shellFor returns the shell the recipe of t runs through, nil t for the
+// Makefile's, and where it came from. Makefile.Shell, the --shell flag,
+// wins over the Shell of the target, which wins over a SHELL variable set
+// in the Makefile or on the command line (not one from the environment,
+// like in make), which wins over DetectShell. An empty shell executes
+// recipe lines directly.
+func (m *Makefile) shellFor(t *Target) (string, string) {
+	shell, source := m.Shell, shellFromCommandLine
+	switch {
+	case shell != "":
+	case t != nil && t.Shell != "":
+		shell, source = t.Shell, shellFromTarget
+	case m.Variables["SHELL"] != nil && m.Variables["SHELL"].Origin != OriginEnvironment:
+		shell, source = m.Variables["SHELL"].Value, shellFromVariable
+	default:
+		shell, source = DetectShell(), shellDetected
+	}
+	if shell == NoShell {
+		shell = ""
+	}
+	return shell, source
+}
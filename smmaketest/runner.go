@@ -0,0 +1,102 @@
+package smmaketest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"smmake"
+)
+
+// Call is a recipe line the Runner was asked to run
+type Call struct {
+	Target  string
+	Command string
+}
+
+// Runner is a smmake.Runner that records recipe lines instead of running
+// them. Lines succeed without output unless Respond or Do says otherwise.
+// It is safe for concurrent use.
+type Runner struct {
+	mutex    sync.Mutex
+	calls    []Call
+	handlers map[string]func(env smmake.Env) (int, error)
+}
+
+// Respond makes a recipe line print stdout and exit with exitCode, which
+// fails it unless it is zero
+func (r *Runner) Respond(command, stdout string, exitCode int) {
+	r.handle(command, func(env smmake.Env) (int, error) {
+		io.WriteString(env.Stdout, stdout)
+		return exitCode, nil
+	})
+}
+
+// Do makes a recipe line call fn, e.g. to add the files it writes to the
+// Harness. An error fails the line.
+func (r *Runner) Do(command string, fn func(env smmake.Env) error) {
+	r.handle(command, func(env smmake.Env) (int, error) {
+		return 0, fn(env)
+	})
+}
+
+func (r *Runner) handle(command string, fn func(env smmake.Env) (int, error)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[string]func(env smmake.Env) (int, error))
+	}
+	r.handlers[command] = fn
+}
+
+// Run records cmd and runs its handler, if any
+func (r *Runner) Run(_ context.Context, cmd smmake.Command, env smmake.Env) (smmake.Result, error) {
+	r.mutex.Lock()
+	r.calls = append(r.calls, Call{Target: env.Target, Command: cmd.Cmd})
+	fn := r.handlers[cmd.Cmd]
+	r.mutex.Unlock()
+
+	if fn == nil {
+		return smmake.Result{}, nil
+	}
+	exitCode, err := fn(env)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("exit status %d", exitCode)
+	}
+	return smmake.Result{ExitCode: exitCode}, err
+}
+
+// Calls returns the recorded recipe lines in the order they ran
+func (r *Runner) Calls() []Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Commands returns the recorded recipe lines without their targets
+func (r *Runner) Commands() []string {
+	var commands []string
+	for _, call := range r.Calls() {
+		commands = append(commands, call.Command)
+	}
+	return commands
+}
+
+// Reset forgets the recorded recipe lines, keeping the responses
+func (r *Runner) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = nil
+}
+
+// index returns the position of the first run of a recipe line, -1 if it
+// didn't run
+func (r *Runner) index(command string) int {
+	for i, c := range r.Commands() {
+		if c == command {
+			return i
+		}
+	}
+	return -1
+}
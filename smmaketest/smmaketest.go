@@ -0,0 +1,239 @@
+// Package smmaketest tests Makefiles the way Go code is tested: the Makefile
+// is parsed from a string, its files live in an fstest.MapFS, and recipe
+// lines are recorded by a fake Runner instead of being run.
+//
+// AssertRebuilds and AssertUpToDate check make's decision, as --why
+// reports it. smmake doesn't skip up to date targets yet, so a build runs
+// their recipes anyway; AssertRan and AssertTargetsRan check what it ran.
+//
+//	func TestBuild(t *testing.T) {
+//		h := smmaketest.New(t, "app: main.c\n\tcc -o app main.c\n", fstest.MapFS{
+//			"main.c": {Data: []byte("int main() { return 0; }")},
+//		})
+//		h.MustBuild("app")
+//		h.AssertRan("cc -o app main.c")
+//
+//		h.WriteFile("app", nil, time.Now())
+//		h.AssertUpToDate("app", "app")
+//		h.WriteFile("main.c", nil, time.Now().Add(time.Second))
+//		h.AssertRebuilds("app", "app")
+//	}
+package smmaketest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"smmake"
+)
+
+// Harness is a Makefile under test
+type Harness struct {
+	Makefile *smmake.Makefile
+	// Files are the files of the Makefile, where file targets are looked up
+	// and $(wildcard) globs
+	Files fstest.MapFS
+	// Runner records the recipe lines of the last build
+	Runner *Runner
+
+	t      testing.TB
+	mutex  sync.Mutex
+	output bytes.Buffer
+}
+
+// New parses a Makefile with the given files, which may be nil, failing the
+// test if it doesn't parse. Recipes run one at a time, so the recorded
+// commands are in an order the build could run them in.
+func New(t testing.TB, makefile string, files fstest.MapFS) *Harness {
+	t.Helper()
+	if files == nil {
+		files = fstest.MapFS{}
+	}
+	c := &smmake.ParseConfig{FS: files}
+	m, err := c.Parse(strings.NewReader(makefile), "Makefile")
+	if err != nil {
+		t.Fatalf("parsing Makefile: %v", err)
+	}
+	h := &Harness{Makefile: m, Files: files, Runner: &Runner{}, t: t}
+	m.Runner = h.Runner
+	m.Jobs = 1
+	m.Output = func(string) io.Writer { return lockedWriter{h} }
+	return h
+}
+
+// Output returns what the recipes of the last build printed
+func (h *Harness) Output() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.output.String()
+}
+
+// lockedWriter collects the output of recipes of several targets
+type lockedWriter struct {
+	h *Harness
+}
+
+func (w lockedWriter) Write(data []byte) (int, error) {
+	w.h.mutex.Lock()
+	defer w.h.mutex.Unlock()
+	return w.h.output.Write(data)
+}
+
+// WriteFile adds a file, or replaces it, with the given modification time
+func (h *Harness) WriteFile(name string, data []byte, modTime time.Time) {
+	h.Files[name] = &fstest.MapFile{Data: data, ModTime: modTime}
+}
+
+// Build builds the goals in a new session, see smmake.Makefile.Build. The
+// Runner and Output are reset first, so they only hold this build.
+func (h *Harness) Build(goals ...string) error {
+	h.Runner.Reset()
+	h.mutex.Lock()
+	h.output.Reset()
+	h.mutex.Unlock()
+	return h.Makefile.Build(context.Background(), goals)
+}
+
+// MustBuild builds the goals like Build and fails the test on error
+func (h *Harness) MustBuild(goals ...string) {
+	h.t.Helper()
+	if err := h.Build(goals...); err != nil {
+		h.t.Fatalf("building %s: %v", strings.Join(goals, " "), err)
+	}
+}
+
+// AssertRan checks that the last build ran exactly the given recipe lines,
+// in any order
+func (h *Harness) AssertRan(commands ...string) {
+	h.t.Helper()
+	got, want := sorted(h.Runner.Commands()), sorted(commands)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		h.t.Errorf("ran %s, want %s", quote(got), quote(want))
+	}
+}
+
+// AssertNotRan checks that the last build ran none of the recipe lines
+func (h *Harness) AssertNotRan(commands ...string) {
+	h.t.Helper()
+	for _, c := range commands {
+		if h.Runner.index(c) >= 0 {
+			h.t.Errorf("ran %q, want it not to run", c)
+		}
+	}
+}
+
+// AssertOrder checks that the last build ran the recipe lines in the given
+// order, possibly with others in between
+func (h *Harness) AssertOrder(commands ...string) {
+	h.t.Helper()
+	last := -1
+	for i, c := range commands {
+		at := h.Runner.index(c)
+		switch {
+		case at < 0:
+			h.t.Errorf("%q did not run", c)
+			return
+		case at < last:
+			h.t.Errorf("%q ran before %q", c, commands[i-1])
+			return
+		}
+		last = at
+	}
+}
+
+// AssertTargetsRan checks that the recipes of exactly the given targets ran
+// in the last build
+func (h *Harness) AssertTargetsRan(targets ...string) {
+	h.t.Helper()
+	seen := make(map[string]bool)
+	var got []string
+	for _, call := range h.Runner.Calls() {
+		if !seen[call.Target] {
+			seen[call.Target] = true
+			got = append(got, call.Target)
+		}
+	}
+	got, want := sorted(got), sorted(targets)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		h.t.Errorf("built %s, want %s", quote(got), quote(want))
+	}
+}
+
+// AssertRebuilds checks which targets make would rebuild for goal, given
+// the files as they are now: exactly the given ones, in any order. It
+// doesn't build anything, see PlanStep.Stale.
+func (h *Harness) AssertRebuilds(goal string, targets ...string) {
+	h.t.Helper()
+	got := h.decisions(goal, false)
+	if want := sorted(targets); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		h.t.Errorf("%s rebuilds %s, want %s", goal, quote(got), quote(want))
+	}
+}
+
+// AssertUpToDate checks that make would consider the given targets up to
+// date for goal, as their files are newer than their prerequisites. It is
+// advisory: a build still runs their recipes unless PlanStep.Skip is set.
+func (h *Harness) AssertUpToDate(goal string, targets ...string) {
+	h.t.Helper()
+	upToDate := make(map[string]bool)
+	for _, name := range h.decisions(goal, true) {
+		upToDate[name] = true
+	}
+	for _, name := range targets {
+		if !upToDate[name] {
+			h.t.Errorf("%s is not up to date for %s: %s", name, goal, h.reason(goal, name))
+		}
+	}
+}
+
+// decisions returns the targets of the plan for goal that are up to date,
+// or the ones that aren't, sorted
+func (h *Harness) decisions(goal string, upToDate bool) []string {
+	h.t.Helper()
+	plan, err := h.Makefile.Plan(goal)
+	if err != nil {
+		h.t.Fatalf("planning %s: %v", goal, err)
+	}
+	var names []string
+	for _, step := range plan.Steps {
+		if step.Stale != upToDate {
+			names = append(names, step.Target)
+		}
+	}
+	return sorted(names)
+}
+
+// reason explains the decision for a target, for failure messages
+func (h *Harness) reason(goal, name string) string {
+	plan, err := h.Makefile.Plan(goal)
+	if err != nil {
+		return err.Error()
+	}
+	for _, step := range plan.Steps {
+		if step.Target == name {
+			return step.Reason
+		}
+	}
+	return "it is not part of the build"
+}
+
+func sorted(names []string) []string {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	return names
+}
+
+func quote(names []string) string {
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return fmt.Sprintf("%q", names)
+}
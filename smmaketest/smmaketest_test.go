@@ -0,0 +1,92 @@
+package smmaketest_test
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"smmake"
+	"smmake/smmaketest"
+)
+
+// TestBuild is the example of the package documentation
+func TestBuild(t *testing.T) {
+	h := smmaketest.New(t, "app: main.c\n\tcc -o app main.c\n", fstest.MapFS{
+		"main.c": {Data: []byte("int main() { return 0; }")},
+	})
+	h.MustBuild("app")
+	h.AssertRan("cc -o app main.c")
+
+	h.WriteFile("app", nil, time.Now())
+	h.AssertUpToDate("app", "app")
+	h.WriteFile("main.c", nil, time.Now().Add(time.Second))
+	h.AssertRebuilds("app", "app")
+}
+
+func TestUpToDateStillRuns(t *testing.T) {
+	h := smmaketest.New(t, "app: main.c\n\tcc -o app main.c\n", fstest.MapFS{
+		"main.c": {ModTime: time.Now().Add(-time.Hour)},
+		"app":    {ModTime: time.Now()},
+	})
+	h.AssertUpToDate("app", "app")
+	h.MustBuild("app")
+	h.AssertTargetsRan("app")
+}
+
+func TestAssumeOldSkips(t *testing.T) {
+	h := smmaketest.New(t, "app: gen\n\tcc -o app gen.c\ngen:\n\tgenerate\n", nil)
+	h.Makefile.AssumeOld = []string{"app"}
+	h.MustBuild("app")
+	h.AssertRan()
+	h.AssertUpToDate("app", "app")
+}
+
+func TestAutomaticVariables(t *testing.T) {
+	h := smmaketest.New(t, "build/%.o: %.c util.h %.c\n\tcc -c $< -o $@ -I$(@D) # $* $^ $+ $$HOME\n", fstest.MapFS{
+		"main.c": {},
+		"util.h": {},
+	})
+	h.MustBuild("build/main.o")
+	h.AssertRan("cc -c main.c -o build/main.o -Ibuild # main main.c util.h main.c util.h main.c $HOME")
+}
+
+func TestDoubleColonRules(t *testing.T) {
+	h := smmaketest.New(t, "d:: a\n\techo first $^\nd:: b\n\techo second $^\na b:\n\tmake $@\n", nil)
+	h.MustBuild("d")
+	h.AssertOrder("make a", "echo first a", "make b", "echo second b")
+	h.AssertRan("make a", "echo first a", "make b", "echo second b")
+}
+
+func TestConflictingDirectoryWithNewerContents(t *testing.T) {
+	now := time.Now()
+	h := smmaketest.New(t, "docs: src\n\tgenerate docs\n", fstest.MapFS{
+		"docs":        {Mode: fs.ModeDir, ModTime: now.Add(-time.Minute)},
+		"src":         {Mode: fs.ModeDir, ModTime: now.Add(-time.Hour)},
+		"src/page.md": {ModTime: now},
+	})
+	h.Makefile.Conflicts = smmake.ConflictFile
+	h.Makefile.Dirs = smmake.DirContents
+	h.MustBuild("docs")
+	h.AssertRan("generate docs")
+
+	h.Files["docs"] = &fstest.MapFile{Mode: fs.ModeDir, ModTime: now.Add(time.Minute)}
+	h.MustBuild("docs")
+	h.AssertRan()
+}
+
+func TestDirectoryWithRemovedFile(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	h := smmaketest.New(t, "site: pages\n\tgenerate site\n", fstest.MapFS{
+		"pages":      {Mode: fs.ModeDir, ModTime: old},
+		"pages/a.md": {ModTime: old},
+		"pages/b.md": {ModTime: old},
+	})
+	h.Makefile.Dirs = smmake.DirContents
+	h.MustBuild("site")
+	h.WriteFile("site", nil, time.Now())
+	h.AssertUpToDate("site", "site")
+
+	delete(h.Files, "pages/b.md")
+	h.AssertRebuilds("site", "site")
+}
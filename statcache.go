@@ -0,0 +1,78 @@
+package smmake
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// statCache holds the file info of the files one build looks at, so a
+// prerequisite shared by many targets is read once however often its
+// modification time is compared. The files the build writes, its targets,
+// are forgotten once their recipe ran.
+type statCache struct {
+	mutex sync.Mutex
+	files map[string]statResult
+	// dirs are what is below the directories, see contents
+	dirs map[string]dirContents
+}
+
+type statResult struct {
+	info   fs.FileInfo
+	broken bool
+	err    error
+}
+
+func newStatCache() *statCache {
+	return &statCache{files: make(map[string]statResult), dirs: make(map[string]dirContents)}
+}
+
+// fileInfo is Makefile.fileInfo, read once per file. A nil cache reads the
+// file every time.
+func (c *statCache) fileInfo(m *Makefile, name string) (fs.FileInfo, bool, error) {
+	if c == nil {
+		return m.fileInfo(name)
+	}
+	c.mutex.Lock()
+	r, ok := c.files[name]
+	c.mutex.Unlock()
+	if ok {
+		return r.info, r.broken, r.err
+	}
+	r.info, r.broken, r.err = m.fileInfo(name)
+	c.mutex.Lock()
+	c.files[name] = r
+	c.mutex.Unlock()
+	return r.info, r.broken, r.err
+}
+
+// exists reports whether a file target exists, the way Makefile.stat does
+func (c *statCache) exists(m *Makefile, name string) bool {
+	_, broken, err := c.fileInfo(m, name)
+	return err == nil && !broken
+}
+
+// contents is Makefile.dirContents, walking each directory once
+func (c *statCache) contents(m *Makefile, dir string) dirContents {
+	c.mutex.Lock()
+	d, ok := c.dirs[dir]
+	c.mutex.Unlock()
+	if ok {
+		return d
+	}
+	d = m.dirContents(dir)
+	c.mutex.Lock()
+	c.dirs[dir] = d
+	c.mutex.Unlock()
+	return d
+}
+
+// forget drops what the cache knows of a file the build wrote
+func (c *statCache) forget(name string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.files, name)
+	delete(c.dirs, name)
+}
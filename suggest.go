@@ -0,0 +1,75 @@
+package smmake
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is how many close matches are offered for an unknown target
+const maxSuggestions = 3
+
+// unknownTargetError reports a missing target, suggesting the closest known
+// target names when there are any
+func (m *Makefile) unknownTargetError(name string) error {
+	return &UnknownTargetError{Name: name, Suggestions: m.suggestTargets(name)}
+}
+
+// suggestTargets returns the known targets within a small edit distance of
+// name, closest first
+func (m *Makefile) suggestTargets(name string) []string {
+	// Allow roughly one typo per three characters
+	limit := max(len(name)/3, 1)
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for target, t := range m.Targets {
+		if t.Pattern || strings.HasPrefix(target, ".") {
+			continue
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(target)); d <= limit {
+			candidates = append(candidates, candidate{target, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var result []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		result = append(result, candidates[i].name)
+	}
+	return result
+}
+
+// editDistance computes the Damerau-Levenshtein (optimal string alignment)
+// distance between a and b, so a swapped pair of letters counts as one edit
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
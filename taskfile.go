@@ -0,0 +1,230 @@
+package smmake
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// taskfileNames are the names go-task looks for, in its order
+var taskfileNames = []string{"Taskfile.yml", "taskfile.yml", "Taskfile.yaml", "taskfile.yaml"}
+
+// taskfile is the part of a Taskfile the importer understands and the
+// exporter writes
+type taskfile struct {
+	Version string                  `yaml:"version,omitempty"`
+	Env     map[string]any          `yaml:"env,omitempty"`
+	Vars    map[string]any          `yaml:"vars,omitempty"`
+	Tasks   map[string]taskfileTask `yaml:"tasks"`
+}
+
+type taskfileTask struct {
+	Desc      string         `yaml:"desc,omitempty"`
+	Summary   string         `yaml:"summary,omitempty"`
+	Deps      []taskfileDep  `yaml:"deps,omitempty"`
+	Sources   []string       `yaml:"sources,omitempty"`
+	Generates []string       `yaml:"generates,omitempty"`
+	Dir       string         `yaml:"dir,omitempty"`
+	Env       map[string]any `yaml:"env,omitempty"`
+	Vars      map[string]any `yaml:"vars,omitempty"`
+	Silent    bool           `yaml:"silent,omitempty"`
+	Cmds      []taskfileCmd  `yaml:"cmds,omitempty"`
+}
+
+// UnmarshalYAML also reads the short forms of a task, a command or a list
+// of commands
+func (t *taskfileTask) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		t.Cmds = []taskfileCmd{{Cmd: node.Value}}
+		return nil
+	case yaml.SequenceNode:
+		return node.Decode(&t.Cmds)
+	}
+	type plain taskfileTask
+	return node.Decode((*plain)(t))
+}
+
+// taskfileCmd is a shell command or a call of another task
+type taskfileCmd struct {
+	Cmd    string `yaml:"cmd,omitempty"`
+	Task   string `yaml:"task,omitempty"`
+	Silent bool   `yaml:"silent,omitempty"`
+}
+
+func (c *taskfileCmd) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		c.Cmd = node.Value
+		return nil
+	}
+	type plain taskfileCmd
+	return node.Decode((*plain)(c))
+}
+
+// MarshalYAML writes a plain command in the short form
+func (c taskfileCmd) MarshalYAML() (any, error) {
+	if c.Task == "" && !c.Silent {
+		return c.Cmd, nil
+	}
+	type plain taskfileCmd
+	return plain(c), nil
+}
+
+// taskfileDep is a task that runs before another, by name or {task: name}
+type taskfileDep struct {
+	Task string `yaml:"task"`
+}
+
+func (d *taskfileDep) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		d.Task = node.Value
+		return nil
+	}
+	type plain taskfileDep
+	return node.Decode((*plain)(d))
+}
+
+func (d taskfileDep) MarshalYAML() (any, error) {
+	return d.Task, nil
+}
+
+// taskfileTemplate matches the templates of plain variables, {{.NAME}}
+var taskfileTemplate = regexp.MustCompile(`{{\s*\.(\w+)\s*}}`)
+
+// importTaskfile adds a target for each task of the Taskfile in dir. Its
+// cmds become recipe lines run in the task's dir with its env, and its deps
+// and the other tasks its cmds call become prerequisites. {{.NAME}}
+// templates of string vars are filled in; other templates are left as
+// they are.
+func importTaskfile(m *Makefile, dir string) error {
+	var data []byte
+	err := fs.ErrNotExist
+	for _, name := range taskfileNames {
+		if data, err = m.readFile(path.Join(dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	var tf taskfile
+	if err := yaml.Unmarshal(data, &tf); err != nil {
+		return err
+	}
+
+	prefix := importPrefix("taskfile", dir)
+	for _, name := range sortedKeys(tf.Tasks) {
+		task := tf.Tasks[name]
+		b := m.importTarget(prefix+name, strings.TrimSuffix(prefix, ":"))
+		if b == nil {
+			continue
+		}
+		vars := taskfileVars(tf.Vars, task.Vars)
+		desc := task.Desc
+		if desc == "" {
+			desc, _, _ = strings.Cut(task.Summary, "\n")
+		}
+		b.Describe(desc)
+		b.t.Dir = task.Dir
+		if !path.IsAbs(task.Dir) {
+			b.t.Dir = path.Join(dir, task.Dir)
+		}
+		b.t.Env = append(taskfileEnv(tf.Env, vars), taskfileEnv(task.Env, vars)...)
+		for _, dep := range task.Deps {
+			b.Deps(prefix + dep.Task)
+		}
+		for _, cmd := range task.Cmds {
+			if cmd.Task != "" {
+				b.Deps(prefix + cmd.Task)
+				continue
+			}
+			line := fillTemplates(strings.TrimSpace(cmd.Cmd), vars)
+			b.t.Commands = append(b.t.Commands, Command{
+				Cmd:    line,
+				Silent: task.Silent || cmd.Silent,
+			})
+		}
+	}
+	return nil
+}
+
+// taskfileVars returns the string vars of a Taskfile and of one of its
+// tasks, which win. Dynamic vars, {sh: ...}, are left out.
+func taskfileVars(global, local map[string]any) map[string]string {
+	vars := make(map[string]string)
+	for _, scope := range []map[string]any{global, local} {
+		for name, value := range scope {
+			if _, dynamic := value.(map[string]any); !dynamic {
+				vars[name] = fmt.Sprint(value)
+			}
+		}
+	}
+	return vars
+}
+
+// taskfileEnv turns an env map into KEY=VALUE pairs, sorted by key
+func taskfileEnv(env map[string]any, vars map[string]string) []string {
+	var pairs []string
+	for _, key := range sortedKeys(env) {
+		if _, dynamic := env[key].(map[string]any); dynamic {
+			continue
+		}
+		pairs = append(pairs, key+"="+fillTemplates(fmt.Sprint(env[key]), vars))
+	}
+	return pairs
+}
+
+// fillTemplates replaces the {{.NAME}} templates of known vars
+func fillTemplates(s string, vars map[string]string) string {
+	return taskfileTemplate.ReplaceAllStringFunc(s, func(t string) string {
+		if value, ok := vars[taskfileTemplate.FindStringSubmatch(t)[1]]; ok {
+			return value
+		}
+		return t
+	})
+}
+
+// exportTaskfile writes a version 3 Taskfile with a task for each target.
+// Targets that are files only run when their sources are newer, like in
+// make, through sources and generates.
+func exportTaskfile(m *Makefile, w io.Writer, roots []string) ([]string, error) {
+	targets, warnings, err := m.exportTargets(roots)
+	if err != nil {
+		return nil, err
+	}
+	tf := taskfile{Version: "3", Vars: make(map[string]any), Tasks: make(map[string]taskfileTask)}
+	for _, v := range m.exportVariables() {
+		tf.Vars[v.Name] = v.Value
+	}
+	for _, t := range targets {
+		task := taskfileTask{Desc: t.Description, Dir: t.Dir, Env: t.environ()}
+		for _, need := range t.Needs {
+			task.Deps = append(task.Deps, taskfileDep{Task: need})
+		}
+		if !t.Phony {
+			task.Sources = t.Sources
+			task.Generates = []string{t.Name}
+		}
+		for _, line := range t.Lines {
+			if strings.Contains(line.Cmd, "{{") {
+				warnings = append(warnings, fmt.Sprintf("target '%s': '{{' in a recipe line starts a template in a Taskfile", t.Name))
+			}
+			task.Cmds = append(task.Cmds, taskfileCmd{Cmd: line.Cmd, Silent: line.Silent})
+		}
+		tf.Tasks[t.Name] = task
+	}
+
+	fmt.Fprintf(w, "# Generated by 'smmake export taskfile' from %s\n", m.Filename)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(tf); err != nil {
+		return nil, err
+	}
+	return warnings, enc.Close()
+}
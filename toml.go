@@ -0,0 +1,303 @@
+package smmake
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML decodes the TOML $(data) and datafile read: tables, arrays of
+// tables, dotted keys, strings, numbers, booleans, arrays and inline
+// tables. Dates and times are kept as strings.
+func parseTOML(src string) (map[string]any, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := make(map[string]any)
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			return root, nil
+		}
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], "[["):
+			p.pos += 2
+			keys, err := p.keys("]]")
+			if err != nil {
+				return nil, err
+			}
+			parent, err := p.table(root, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			last := keys[len(keys)-1]
+			list, _ := parent[last].([]any)
+			table = make(map[string]any)
+			parent[last] = append(list, table)
+		case p.src[p.pos] == '[':
+			p.pos++
+			keys, err := p.keys("]")
+			if err != nil {
+				return nil, err
+			}
+			if table, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		default:
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+		}
+		p.skipSpace(false)
+		if p.pos < len(p.src) && p.src[p.pos] != '\n' {
+			return nil, p.errorf("expected the end of the line")
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+// skipSpace skips blanks and comments, and newlines too if asked to
+func (p *tomlParser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// table returns the table at keys below root, creating it if needed. An
+// array of tables stands for its last table.
+func (p *tomlParser) table(root map[string]any, keys []string) (map[string]any, error) {
+	t := root
+	for _, key := range keys {
+		switch v := t[key].(type) {
+		case nil:
+			next := make(map[string]any)
+			t[key] = next
+			t = next
+		case map[string]any:
+			t = v
+		case []any:
+			last, ok := v[len(v)-1].(map[string]any)
+			if !ok {
+				return nil, p.errorf("'%s' is not a table", key)
+			}
+			t = last
+		default:
+			return nil, p.errorf("'%s' is not a table", key)
+		}
+	}
+	return t, nil
+}
+
+// keys reads a dotted key up to end
+func (p *tomlParser) keys(end string) ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace(false)
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		p.skipSpace(false)
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], end):
+			p.pos += len(end)
+			return keys, nil
+		case p.pos < len(p.src) && p.src[p.pos] == '.':
+			p.pos++
+		default:
+			return nil, p.errorf("expected '.' or '%s' after key '%s'", end, key)
+		}
+	}
+}
+
+func (p *tomlParser) key() (string, error) {
+	if p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\'') {
+		return p.str()
+	}
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c != '_' && c != '-' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a key")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// keyValue reads "key = value" into t
+func (p *tomlParser) keyValue(t map[string]any) error {
+	keys, err := p.keys("=")
+	if err != nil {
+		return err
+	}
+	if t, err = p.table(t, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, defined := t[last]; defined {
+		return p.errorf("'%s' is defined twice", last)
+	}
+	p.skipSpace(false)
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	t[last] = v
+	return nil
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected a value")
+	}
+	switch c := p.src[p.pos]; c {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		p.pos++
+		list := []any{}
+		for {
+			p.skipSpace(true)
+			if p.pos < len(p.src) && p.src[p.pos] == ']' {
+				p.pos++
+				return list, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skipSpace(true)
+			if p.pos < len(p.src) && p.src[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+				return nil, p.errorf("expected ',' or ']' in array")
+			}
+		}
+	case '{':
+		p.pos++
+		t := make(map[string]any)
+		for {
+			p.skipSpace(false)
+			if p.pos < len(p.src) && p.src[p.pos] == '}' {
+				p.pos++
+				return t, nil
+			}
+			if err := p.keyValue(t); err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if p.pos < len(p.src) && p.src[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.src) || p.src[p.pos] != '}' {
+				return nil, p.errorf("expected ',' or '}' in inline table")
+			}
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(",]}#\n\r", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	text := strings.TrimSpace(p.src[start:p.pos])
+	p.pos = start + len(text)
+	switch text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, p.errorf("expected a value")
+	}
+	number := strings.ReplaceAll(text, "_", "")
+	if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	if text[0] >= '0' && text[0] <= '9' {
+		return text, nil // a date or time
+	}
+	return nil, p.errorf("invalid value '%s'", text)
+}
+
+// str reads a basic, literal or multi-line string
+func (p *tomlParser) str() (string, error) {
+	quote := p.src[p.pos : p.pos+1]
+	if strings.HasPrefix(p.src[p.pos:], quote+quote+quote) {
+		quote += quote + quote
+	}
+	p.pos += len(quote)
+	end := strings.Index(p.src[p.pos:], quote)
+	if quote == `"` || quote == `"""` {
+		// skip escaped quotes
+		for end > 0 && p.src[p.pos+end-1] == '\\' {
+			next := strings.Index(p.src[p.pos+end+1:], quote)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += next + 1
+		}
+	}
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	text := p.src[p.pos : p.pos+end]
+	p.pos += end + len(quote)
+	p.line += strings.Count(text, "\n")
+	if len(quote) == 3 {
+		text = strings.TrimPrefix(strings.TrimPrefix(text, "\r"), "\n")
+	}
+	if quote[0] == '\'' {
+		return text, nil
+	}
+	// the escapes of TOML are the ones of Go, once bare quotes and
+	// newlines are escaped too
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && i+1 < len(text):
+			quoted.WriteString(text[i : i+2])
+			i++
+		case c == '"':
+			quoted.WriteString(`\"`)
+		case c == '\n':
+			quoted.WriteString(`\n`)
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+	unquoted, err := strconv.Unquote(quoted.String())
+	if err != nil {
+		return "", p.errorf("invalid string: %v", err)
+	}
+	return unquoted, nil
+}
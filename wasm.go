@@ -0,0 +1,112 @@
+package smmake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// WasmRunner runs recipe lines as WebAssembly modules with WASI, for build
+// steps that behave the same on every platform. It is used for the targets
+// listed in .WASM, whose recipe lines are a module and its arguments:
+//
+//	.WASM: gen.go
+//	gen.go: api.json
+//		tools/gen.wasm api.json gen.go
+//
+// The module only sees the directories of the prerequisites, read-only, and
+// the directory of the target, writable, at the same paths relative to the
+// Makefile, which is its working directory. Directories are the unit, so a
+// target next to the Makefile, like a phony one, sees all of its directory.
+// The module gets no environment but Env.Environ and can't start processes.
+type WasmRunner struct{}
+
+// Run runs the module named by the first word of cmd
+func (WasmRunner) Run(ctx context.Context, cmd Command, env Env) (Result, error) {
+	args := strings.Fields(cmd.Cmd)
+	if len(args) == 0 {
+		return Result{}, nil
+	}
+	start := time.Now()
+	code, err := os.ReadFile(longPath(hostPath(env.Dir, args[0])))
+	if err != nil {
+		return Result{}, fmt.Errorf("error reading module: %w", err)
+	}
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	config := wazero.NewModuleConfig().
+		WithArgs(args...).
+		WithStdout(env.Stdout).
+		WithStderr(env.Stderr).
+		WithFSConfig(wasmMounts(env))
+	for _, kv := range env.Environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && key != "PWD" {
+			config = config.WithEnv(key, value)
+		}
+	}
+	// Relative paths are resolved against PWD by WASI libcs, and the
+	// mounts put the Makefile's directory at the root
+	config = config.WithEnv("PWD", "/")
+
+	_, err = runtime.InstantiateWithConfig(ctx, code, config)
+	result := Result{Duration: time.Since(start)}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = int(exitErr.ExitCode())
+	}
+	return result, err
+}
+
+// wasmMounts gives a module the directories of the prerequisites of a
+// target, read-only, and the directory of the target itself, writable
+func wasmMounts(env Env) wazero.FSConfig {
+	writable := filepath.Dir(env.Target)
+	fsConfig := wazero.NewFSConfig().WithDirMount(hostPath(env.Dir, writable), guestPath(writable))
+	mounted := map[string]bool{writable: true}
+	for _, prereq := range env.Prerequisites {
+		dir := filepath.Dir(prereq)
+		if mounted[dir] {
+			continue
+		}
+		mounted[dir] = true
+		fsConfig = fsConfig.WithReadOnlyDirMount(hostPath(env.Dir, dir), guestPath(dir))
+	}
+	return fsConfig
+}
+
+// hostPath returns where a path relative to the Makefile is for the host
+func hostPath(dir, name string) string {
+	if dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// guestPath returns where a directory relative to the Makefile is mounted
+// for a module
+func guestPath(dir string) string {
+	switch {
+	case dir == ".":
+		return "/"
+	case filepath.IsAbs(dir):
+		return filepath.ToSlash(dir)
+	}
+	return "/" + filepath.ToSlash(dir)
+}
+
+// isWasm reports whether .WASM lists a target, so its recipe lines are run
+// by WasmRunner
+func (m *Makefile) isWasm(targetName string) bool {
+	return m.lists(".WASM", targetName)
+}
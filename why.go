@@ -0,0 +1,219 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"smmake/internal/color"
+)
+
+// decision is the outcome of checking a single target for a rebuild
+type decision struct {
+	Rebuild bool
+	Reason  string
+	Err     bool // the target can't be made
+}
+
+// ExplainTarget prints the chain of decisions that lead to the target's
+// recipe running or not: whether it is phony, missing, or older than one of
+// its prerequisites. Prerequisites are explained below the target that
+// needs them, each one only the first time it is reached.
+//
+// Parameters:
+//   - w: The writer the explanation is printed to.
+//   - name: The target to explain.
+func (m *Makefile) ExplainTarget(w io.Writer, name string) error {
+	nodes, err := m.BuildGraph(name)
+	if err != nil {
+		return err
+	}
+
+	decisions := make(map[string]*decision)
+	decide := m.deciderWith(nodes, decisions, newStatCache())
+	decide(name)
+
+	upToDate := false
+	printed := make(map[string]bool)
+	var print func(name, indent string)
+	print = func(name, indent string) {
+		d := decisions[name]
+		if printed[name] {
+			fmt.Fprintf(w, "%s%s: see above\n", indent, color.Target(name))
+			return
+		}
+		printed[name] = true
+
+		verdict := "up to date"
+		switch {
+		case d.Err:
+			verdict = color.Error("cannot be made")
+		case d.Rebuild:
+			verdict = color.Warning("runs")
+		case nodes[name].File:
+			verdict = "no recipe"
+		default:
+			upToDate = true
+		}
+		fmt.Fprintf(w, "%s%s: %s, %s\n", indent, color.Target(name), verdict, d.Reason)
+		for _, dep := range nodes[name].Deps {
+			print(dep, indent+"  ")
+		}
+	}
+	print(name, "")
+
+	if upToDate {
+		fmt.Fprintln(w, "\nsmmake doesn't skip up to date targets yet, their recipes run as well.")
+	}
+	return nil
+}
+
+// decider returns a function deciding the nodes of a graph, each one once
+func (m *Makefile) decider(nodes map[string]*GraphNode) func(name string) *decision {
+	return m.deciderWith(nodes, make(map[string]*decision), newStatCache())
+}
+
+// deciderWith is decider, keeping the decisions in the given map and the
+// file info in stats, so every file is read once for all the decisions.
+// Names that aren't in nodes are decided as files without a rule.
+func (m *Makefile) deciderWith(nodes map[string]*GraphNode, decisions map[string]*decision, stats *statCache) func(name string) *decision {
+	var decide func(name string) *decision
+	decide = func(name string) *decision {
+		if d, ok := decisions[name]; ok {
+			if d == nil {
+				return &decision{Reason: "is part of a dependency cycle", Err: true}
+			}
+			return d
+		}
+		decisions[name] = nil
+		node := nodes[name]
+		if node == nil {
+			node = &GraphNode{Name: name, File: true}
+		}
+		d := m.decide(node, decide, stats)
+		decisions[name] = d
+		return d
+	}
+	return decide
+}
+
+// decide checks whether the recipe of a node has to run, given the
+// decisions for its prerequisites, with the file info in stats
+func (m *Makefile) decide(node *GraphNode, decide func(string) *decision, stats *statCache) *decision {
+	info, broken, statErr := stats.fileInfo(m, node.Name)
+	var skew time.Duration
+	if statErr == nil && !broken {
+		if skew = time.Until(info.ModTime()); skew > 0 {
+			// like make, as the clocks of an NFS server and its clients
+			// drift apart
+			m.logf(LogWarn, "", "file '%s' has modification time %s in the future", node.Name, skew.Round(time.Millisecond))
+		}
+	}
+
+	if node.File {
+		switch {
+		case statErr != nil:
+			return &decision{Reason: "there is no rule to make it and no such file", Err: true}
+		case broken:
+			return &decision{Reason: "there is no rule to make it and it is a broken symbolic link", Err: true}
+		}
+		return &decision{Reason: "the file exists and there is no rule for it"}
+	}
+
+	// Prerequisites are decided first, as they are built first
+	var failed, assumed, rebuilt, future, changed, newer []string
+	for _, dep := range node.Deps {
+		d := decide(dep)
+		if d.Err {
+			failed = append(failed, dep)
+			continue
+		}
+		if m.assumed(m.AssumeOld, dep) {
+			continue
+		}
+		if m.assumed(m.AssumeNew, dep) {
+			assumed = append(assumed, dep)
+			continue
+		}
+		if d.Rebuild {
+			rebuilt = append(rebuilt, dep)
+			continue
+		}
+		depInfo, _, err := stats.fileInfo(m, dep)
+		if err != nil {
+			continue
+		}
+		if m.FutureOutOfDate && time.Until(depInfo.ModTime()) > 0 {
+			future = append(future, dep)
+			continue
+		}
+		depTime := depInfo.ModTime()
+		if depInfo.IsDir() {
+			if m.Dirs == DirOrderOnly {
+				continue
+			}
+			contents := stats.contents(m, dep)
+			if sum, ok := m.madeWithDir(node.Name, dep); ok && sum != contents.sum {
+				changed = append(changed, dep)
+				continue
+			}
+			depTime = contents.newest
+		}
+		// Times are compared to the nanosecond, as precise as the file
+		// system keeps them
+		if statErr == nil && !broken && depTime.After(info.ModTime()) {
+			newer = append(newer, fmt.Sprintf("'%s' (%s vs %s)", dep,
+				depTime.Format(modTimeLayout), info.ModTime().Format(modTimeLayout)))
+		}
+	}
+
+	conflict := m.fileConflict(m.Targets[node.Name], node.Name, stats)
+	switch {
+	case len(failed) > 0:
+		return &decision{Reason: "prerequisite " + quoteList(failed) + " cannot be made", Err: true}
+	case m.assumed(m.AssumeOld, node.Name):
+		return &decision{Reason: "it is assumed to be old (-o) and isn't made"}
+	case node.Phony:
+		return &decision{Rebuild: true, Reason: "it is phony and always runs"}
+	case conflict != "" && m.Conflicts == ConflictRule:
+		return &decision{Rebuild: true, Reason: fmt.Sprintf("there is a %s named '%s', but the rule wins as if it were .PHONY", conflict, node.Name)}
+	case statErr != nil:
+		return &decision{Rebuild: true, Reason: fmt.Sprintf("the file '%s' does not exist", node.Name)}
+	case broken:
+		return &decision{Rebuild: true, Reason: fmt.Sprintf("'%s' is a broken symbolic link", node.Name)}
+	case len(assumed) > 0:
+		return &decision{Rebuild: true, Reason: "prerequisite " + quoteList(assumed) + " is assumed to be new (-W)"}
+	case len(rebuilt) > 0:
+		return &decision{Rebuild: true, Reason: "prerequisite " + quoteList(rebuilt) + " runs first"}
+	case m.FutureOutOfDate && skew > 0:
+		return &decision{Rebuild: true, Reason: fmt.Sprintf("its modification time is %s in the future", skew.Round(time.Millisecond))}
+	case len(future) > 0:
+		return &decision{Rebuild: true, Reason: "prerequisite " + quoteList(future) + " has a modification time in the future"}
+	case len(changed) > 0:
+		return &decision{Rebuild: true, Reason: "the contents of directory " + quoteList(changed) + " changed since it was made"}
+	case len(newer) > 0:
+		return &decision{Rebuild: true, Reason: "prerequisite " + strings.Join(newer, ", ") + " is newer"}
+	}
+	return &decision{Reason: "the file is newer than all of its prerequisites"}
+}
+
+// assumed reports whether names, AssumeOld or AssumeNew, has a file
+func (m *Makefile) assumed(names []string, name string) bool {
+	name = m.indexName(name)
+	for _, n := range names {
+		if m.indexName(normalizeName(n)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// modTimeLayout shows modification times with as many fractional digits as
+// they have, so files written within the same second still tell apart
+const modTimeLayout = "2006-01-02 15:04:05.999999999"
+
+// quoteList joins names as 'a', 'b'
+func quoteList(names []string) string {
+	return "'" + strings.Join(names, "', '") + "'"
+}
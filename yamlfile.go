@@ -0,0 +1,178 @@
+package smmake
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"smmake/ast"
+)
+
+// BuildFiles are the build files looked for when none is named, in order:
+//
+//	variables:
+//	  GOFLAGS: -trimpath
+//	targets:
+//	  build:
+//	    description: Build the application
+//	    deps: [generate]
+//	    commands:
+//	      - go build $(GOFLAGS) ./...
+//
+// smmake.yaml and smmake.json hold the same model as a Makefile, see
+// ParseConfig.ParseYAML, and so does build.ninja, see ParseConfig.ParseNinja.
+var BuildFiles = []string{DefaultMakefile, "smmake.yaml", "smmake.yml", "smmake.json", "build.ninja"}
+
+// FindBuildFile returns the first of BuildFiles in dir, "" for the current
+// directory, or DefaultMakefile if there is none
+func FindBuildFile(dir string) string {
+	for _, name := range BuildFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return name
+		}
+	}
+	return DefaultMakefile
+}
+
+// IsYAMLBuildFile reports whether a build file is read by ParseYAML rather
+// than as a Makefile, from its extension
+func IsYAMLBuildFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// yamlFile is a smmake.yaml. Targets stay nodes, so they are added in the
+// order they are written and know their line.
+type yamlFile struct {
+	Variables yaml.Node         `yaml:"variables"`
+	Env       map[string]string `yaml:"env"`
+	Targets   yaml.Node         `yaml:"targets"`
+}
+
+type yamlTarget struct {
+	Description string            `yaml:"description"`
+	Section     string            `yaml:"section"`
+	Deps        yamlList          `yaml:"deps"`
+	Commands    []yamlCommand     `yaml:"commands"`
+	Env         map[string]string `yaml:"env"`
+	Dir         string            `yaml:"dir"`
+	Shell       string            `yaml:"shell"`
+	Phony       bool              `yaml:"phony"`
+	Restat      bool              `yaml:"restat"`
+}
+
+// yamlList is a list of strings that may be written as a single string
+type yamlList []string
+
+func (l *yamlList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = strings.Fields(node.Value)
+		return nil
+	}
+	return node.Decode((*[]string)(l))
+}
+
+// yamlCommand is a recipe line, or a Starlark script written as
+// {script: SOURCE}
+type yamlCommand struct {
+	Line   string
+	Script string
+}
+
+func (c *yamlCommand) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		c.Line = node.Value
+		return nil
+	}
+	var script struct {
+		Script string `yaml:"script"`
+	}
+	if err := node.Decode(&script); err != nil {
+		return err
+	}
+	c.Script = script.Script
+	return nil
+}
+
+// ParseYAML reads a build file in the YAML dialect, or its JSON form. Its
+// variables, targets and their prerequisites become the same model a
+// Makefile does: variables are recursive, the command line overriding them,
+// recipe lines are expanded when they are read and a leading '@' keeps one
+// from being echoed.
+func (c *ParseConfig) ParseYAML(r io.Reader, name string) (*Makefile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &ParseError{Filename: name, Err: err}
+	}
+	if data, err = ast.Source(data); err != nil {
+		return nil, &ParseError{Filename: name, Err: fmt.Errorf("%s: %w", name, err)}
+	}
+	var file yamlFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, &ParseError{Filename: name, Err: err}
+	}
+
+	for _, node := range []*yaml.Node{&file.Variables, &file.Targets} {
+		if node.Kind != 0 && node.Kind != yaml.MappingNode {
+			return nil, &ParseError{Filename: name, Err: fmt.Errorf("line %d: want a mapping of names", node.Line)}
+		}
+	}
+
+	m := c.FromAST(&ast.File{Name: name})
+	for i := 0; i+1 < len(file.Variables.Content); i += 2 {
+		key, value := file.Variables.Content[i], file.Variables.Content[i+1]
+		if v := m.Variables[key.Value]; v != nil && v.Origin == OriginCommandLine {
+			continue
+		}
+		var words yamlList
+		if err := value.Decode(&words); err != nil {
+			return nil, &ParseError{Filename: name, Err: fmt.Errorf("variable '%s': %w", key.Value, err)}
+		}
+		text := value.Value
+		if value.Kind == yaml.SequenceNode {
+			text = strings.Join(words, " ")
+		}
+		m.setVariable(&Variable{Name: key.Value, Value: text, Origin: OriginMakefile, Line: key.Line, Recursive: true})
+	}
+	for _, key := range sortedKeys(file.Env) {
+		m.Env = append(m.Env, key+"="+m.expandVariables(file.Env[key]))
+	}
+
+	for i := 0; i+1 < len(file.Targets.Content); i += 2 {
+		key, value := file.Targets.Content[i], file.Targets.Content[i+1]
+		var t yamlTarget
+		if err := value.Decode(&t); err != nil {
+			return nil, &ParseError{Filename: name, Err: fmt.Errorf("target '%s': %w", key.Value, err)}
+		}
+		b := m.Target(m.expandVariables(key.Value))
+		b.t.Line = key.Line
+		b.t.Description, b.t.Section, b.t.Dir, b.t.Shell = t.Description, t.Section, t.Dir, t.Shell
+		for _, dep := range t.Deps {
+			b.Deps(strings.Fields(m.expandVariables(dep))...)
+		}
+		for _, key := range sortedKeys(t.Env) {
+			b.t.Env = append(b.t.Env, key+"="+m.expandVariables(t.Env[key]))
+		}
+		for _, cmd := range t.Commands {
+			if cmd.Script != "" {
+				b.t.Commands = append(b.t.Commands, Command{Cmd: strings.TrimRight(cmd.Script, "\n"), Script: true})
+				continue
+			}
+			b.Cmd(cmd.Line)
+		}
+		if t.Phony {
+			b.Phony()
+		}
+		if t.Restat {
+			b.Restat()
+		}
+	}
+	return m, nil
+}